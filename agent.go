@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Agent serves an HTTP API running experiments on this host. Experiments
+// loading the same resource class never overlap: a second one is rejected,
+// or queued until the first ends.
+type Agent struct {
+	quota      Quota  // Caps applied to every experiment on top of the request's own
+	onConflict string // reject or queue, for requests that don't choose
+	commands   bool   // Whether requests may start -run-cmd, only on a guarded API
+	mu         sync.Mutex
+	running    []*agentRun
+	queued     []*agentRun // Started in order as the experiments they conflict with end
+	lastID     int
+	stopped    bool // Set when the agent shuts down, queued experiments no longer start
+	last       int  // Exit code of the last finished experiment
+}
+
+// agentStatus is the JSON body returned by the status endpoint. Config, Status
+// and Deadline are those of the oldest running experiment.
+type agentStatus struct {
+	Running     bool               `json:"running"`
+	Config      *Config            `json:"config,omitempty"`
+	Status      *ResourceStatus    `json:"status,omitempty"`
+	Deadline    *time.Time         `json:"deadline,omitempty"`
+	LastExit    int                `json:"last_exit"`
+	Experiments []experimentStatus `json:"experiments,omitempty"` // Every running experiment
+	Queued      []experimentStatus `json:"queued,omitempty"`      // Experiments waiting for conflicting ones to end
+}
+
+// agentCommand serves the agent API until interrupted
+func agentCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	listen := fs.String("listen", ":7070", "Address to serve the agent API on")
+	api := fs.String("api", "", "Serve the API on unix:///path, a unix socket guarded by its file permissions, or on the socket systemd passes with socket activation (systemd), instead of -listen")
+	apiGroup := fs.String("api-group", "", "Group that may connect to the -api unix socket besides its owner")
+	debug := fs.Bool("debug", false, "Serve pprof under /debug/pprof/ and expvar under /debug/vars")
+	coordinatorURL := fs.String("coordinator", "", "Register with the coordinator at this base URL")
+	advertise := fs.String("advertise", "", "Base URL the coordinator reaches this agent on (default http or https://<hostname><listen>)")
+	name := fs.String("name", "", "Name to register with the coordinator (default hostname)")
+	labels := fs.String("labels", "", "Labels to register with the coordinator, e.g. zone=a,rack=3")
+	sec := bindAPIFlags(fs, true, true)
+	agent := &Agent{}
+	fs.StringVar(&agent.onConflict, "on-conflict", "reject", "What to do with an experiment loading a resource class a running one loads: reject it or queue it until that one ends (requests choose with on_conflict)")
+	bindFlags(fs, &agent.quota)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if !slices.Contains(conflictPolicies, agent.onConflict) {
+		return exitCodeFor(fmt.Errorf("invalid -on-conflict %q (expected reject or queue)", agent.onConflict))
+	}
+	if err := sec.validate(); err != nil {
+		return exitCodeFor(err)
+	}
+	ln, err := apiListener(*api, *apiGroup)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	agent.commands = sec.guarded(ln)
+	address := *listen
+	if ln != nil {
+		address = ln.Addr().String()
+		// Also a socket passed by systemd may be a unix socket
+		if ln.Addr().Network() == "unix" && *coordinatorURL != "" && *advertise == "" {
+			ln.Close()
+			return exitCodeFor(fmt.Errorf("the coordinator can't reach a unix socket, set -advertise to an address it can"))
+		}
+	}
+
+	handler := agent.Handler()
+	if *debug {
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		registerDebug(mux)
+		expvar.Publish("agent", expvar.Func(func() interface{} { return agent.status() }))
+		handler = mux
+	}
+	server := &http.Server{Addr: *listen, Handler: handler}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *coordinatorURL != "" {
+		info, err := agentInfo(*name, *advertise, sec.scheme(), address, *labels)
+		if err != nil {
+			return exitCodeFor(err)
+		}
+		client, err := sec.client(10 * time.Second)
+		if err != nil {
+			return exitCodeFor(err)
+		}
+		go registerWithCoordinator(ctx, client, *coordinatorURL, info)
+	}
+
+	sigChan := notifySignals()
+	go func() {
+		sig := <-sigChan
+		fmt.Printf("Received signal %v, stopping agent...\n", sig)
+		cancel()
+		agent.stopAll(sig)
+		server.Close()
+	}()
+
+	fmt.Printf("Agent listening on %s\n", address)
+	if err := sec.serve(server, ln, "agent"); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Agent failed: %v\n", err)
+		return 1
+	}
+	agent.wait()
+	return 0
+}
+
+// Handler returns the agent's HTTP handler. With several experiments running
+// the others take the id of theirs.
+//
+//	POST /run?owner=team-a&on_conflict=queue  start an experiment; the body is a JSON object of flag values
+//	POST /stop   stop the running experiment, or remove a queued one
+//	POST /extend?by=10m  move the end of the running experiment (negative to shorten)
+//	POST /end    ramp the running experiment down and end it
+//	POST /resources?disable=file&enable=cpu  turn consumers of the running experiment off or back on
+//	POST /targets?cpu=90&memory=%2B500  set or move (+/-) the CPU and memory targets of the running experiment
+//	POST /pause  release all load of the running experiment; POST /resume takes it back up
+//	GET  /status report the running experiment
+//	GET  /time   report the agent's wall clock, used to check clock offsets before synchronized starts
+//	GET  /capabilities  report the build, registered consumers and the privileges and mechanisms of the host, as version -json
+func (a *Agent) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", a.handleRun)
+	mux.HandleFunc("/stop", a.handleStop)
+	mux.HandleFunc("/extend", a.handleExtend)
+	mux.HandleFunc("/end", a.handleEnd)
+	mux.HandleFunc("/resources", a.handleResources)
+	mux.HandleFunc("/targets", a.handleTargets)
+	mux.HandleFunc("/pause", a.handlePause)
+	mux.HandleFunc("/resume", a.handlePause)
+	mux.HandleFunc("/status", a.handleStatus)
+	mux.HandleFunc("/time", handleTime)
+	mux.HandleFunc("/capabilities", handleCapabilities)
+	return mux
+}
+
+func (a *Agent) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config, err := configFromJSON(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if config.User != "" {
+		// Dropping root would leave the agent unable to run privileged experiments
+		http.Error(w, "-user is not supported by the agent, run the agent itself as that user", http.StatusBadRequest)
+		return
+	}
+	if config.Netns != "" || config.Unshare != "" || config.TargetContainer != "" || config.SystemdRun {
+		http.Error(w, "-netns, -unshare, -target-container and -systemd-run are not supported by the agent, run the agent itself inside the namespaces or scope", http.StatusBadRequest)
+		return
+	}
+	if config.RunCmd != "" && !a.commands {
+		// Anyone reaching the agent could run commands on the host
+		http.Error(w, "-run-cmd needs an agent started with -api-token or on a unix socket", http.StatusForbidden)
+		return
+	}
+	config.Quota = config.Quota.tighten(a.quota)
+	onConflict := r.URL.Query().Get("on_conflict")
+	if onConflict == "" {
+		onConflict = a.onConflict
+	}
+	if !slices.Contains(conflictPolicies, onConflict) {
+		http.Error(w, fmt.Sprintf("invalid on_conflict %q (expected reject or queue)", onConflict), http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastID++
+	run := &agentRun{id: a.lastID, owner: r.URL.Query().Get("owner"), classes: resourceClasses(config), config: config}
+	reply := map[string]interface{}{"id": run.id}
+	// Queued experiments go first, or a stream of small ones could starve them
+	if other := run.conflict(append(slices.Clone(a.running), a.queued...)); other != nil {
+		conflict := run.describeConflict(other)
+		if onConflict == "reject" {
+			log.Printf("Rejected experiment %d: %s", run.id, conflict)
+			http.Error(w, conflict, http.StatusConflict)
+			return
+		}
+		log.Printf("Queued experiment %d: %s", run.id, conflict)
+		a.queued = append(a.queued, run)
+		reply["queued"], reply["conflict"] = true, conflict
+	} else {
+		a.start(run)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(reply)
+}
+
+// start runs an experiment, starting the queued ones it held up when it ends.
+// a.mu is held.
+func (a *Agent) start(run *agentRun) {
+	printStartup(run.config)
+	run.rm = NewResourceMock(run.config)
+	run.stop = make(chan os.Signal, 1)
+	a.running = append(a.running, run)
+	go func() {
+		code := run.rm.Run(run.stop)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.running = slices.DeleteFunc(a.running, func(other *agentRun) bool { return other == run })
+		a.last = code
+		a.startQueued()
+	}()
+}
+
+// startQueued starts the queued experiments that conflict with no running
+// one nor one queued before them. a.mu is held.
+func (a *Agent) startQueued() {
+	var waiting []*agentRun
+	for _, run := range a.queued {
+		if a.stopped || run.conflict(append(slices.Clone(a.running), waiting...)) != nil {
+			waiting = append(waiting, run)
+			continue
+		}
+		log.Printf("Starting queued experiment %d", run.id)
+		a.start(run)
+	}
+	a.queued = waiting
+}
+
+// selected returns the running experiment a request addresses with its id
+// parameter, which may be left out while a single experiment runs. It
+// replies with the error when there is none.
+func (a *Agent) selected(w http.ResponseWriter, r *http.Request) *ResourceMock {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id := r.URL.Query().Get("id")
+	for _, run := range a.running {
+		if id == strconv.Itoa(run.id) || id == "" && len(a.running) == 1 {
+			return run.rm
+		}
+	}
+	switch {
+	case id != "":
+		http.Error(w, fmt.Sprintf("no experiment %s is running", id), http.StatusNotFound)
+	case len(a.running) > 1:
+		http.Error(w, fmt.Sprintf("%d experiments are running, choose one with id", len(a.running)), http.StatusBadRequest)
+	default:
+		http.Error(w, "no experiment is running", http.StatusNotFound)
+	}
+	return nil
+}
+
+func (a *Agent) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Queued experiments are just dropped
+	a.mu.Lock()
+	id := r.URL.Query().Get("id")
+	for i, run := range a.queued {
+		if id == strconv.Itoa(run.id) {
+			a.queued = slices.Delete(a.queued, i, i+1)
+			a.mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+	a.mu.Unlock()
+	rm := a.selected(w, r)
+	if rm == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, run := range a.running {
+		if run.rm == rm {
+			select {
+			case run.stop <- syscall.SIGTERM:
+			default:
+			}
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *Agent) handleExtend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, err := parseExtend(r.URL.Query().Get("by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.adjustCurrent(w, r, func(rm *ResourceMock) time.Time { return rm.Extend(d) })
+}
+
+func (a *Agent) handleEnd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.adjustCurrent(w, r, (*ResourceMock).EndGraceful)
+}
+
+func (a *Agent) handleResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rm := a.selected(w, r)
+	if rm == nil {
+		return
+	}
+	toggles := []struct {
+		param   string
+		enabled bool
+	}{{"disable", false}, {"enable", true}}
+	// Apply nothing unless every name is valid
+	for _, toggle := range toggles {
+		for _, resource := range strings.Split(r.URL.Query().Get(toggle.param), ",") {
+			if err := rm.checkToggle(resource); resource != "" && err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	for _, toggle := range toggles {
+		for _, resource := range strings.Split(r.URL.Query().Get(toggle.param), ",") {
+			if resource != "" {
+				rm.SetEnabled(resource, toggle.enabled)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"disabled": rm.Disabled()})
+}
+
+func (a *Agent) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rm := a.selected(w, r)
+	if rm == nil {
+		return
+	}
+	for _, resource := range []string{"cpu", "memory"} {
+		change := r.URL.Query().Get(resource)
+		if change == "" {
+			continue
+		}
+		// An unescaped + in a query decodes to a space
+		if strings.HasPrefix(change, " ") {
+			change = "+" + strings.TrimSpace(change)
+		}
+		if _, err := rm.AdjustTarget(resource, change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	cpu, memoryMB := rm.currentTargets()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]float64{"cpu": cpu, "memory_mb": float64(memoryMB)})
+}
+
+func (a *Agent) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rm := a.selected(w, r)
+	if rm == nil {
+		return
+	}
+	paused := r.URL.Path == "/pause"
+	rm.SetPaused(paused)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"paused": paused})
+}
+
+// adjustCurrent moves the deadline of the running experiment and replies with
+// the new deadline, or that the end is secret
+func (a *Agent) adjustCurrent(w http.ResponseWriter, r *http.Request, adjust func(rm *ResourceMock) time.Time) {
+	rm := a.selected(w, r)
+	if rm == nil {
+		return
+	}
+	deadline := adjust(rm)
+	w.Header().Set("Content-Type", "application/json")
+	if rm.config.hidesEnd() {
+		json.NewEncoder(w).Encode(map[string]bool{"end_hidden": true})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]time.Time{"deadline": deadline})
+}
+
+func (a *Agent) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.status())
+}
+
+func handleTime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]time.Time{"time": time.Now()})
+}
+
+// handleCapabilities reports what the binary and this host support, so
+// orchestrators can check before dispatching a scenario
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(probeHost(cgroupMount))
+}
+
+// status reports the running and queued experiments
+func (a *Agent) status() agentStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := agentStatus{LastExit: a.last}
+	if len(a.running) > 0 {
+		rm := a.running[0].rm
+		config, resourceStatus := rm.config, rm.Status()
+		if config.hidesEnd() {
+			// The seed would redraw the duration from the range
+			config.Duration, config.Seed = 0, 0
+		}
+		status.Running = true
+		status.Config = &config
+		status.Status = &resourceStatus
+		status.Deadline = rm.publicDeadline()
+	}
+	for _, run := range a.running {
+		status.Experiments = append(status.Experiments, run.status())
+	}
+	for _, run := range a.queued {
+		status.Queued = append(status.Queued, run.status())
+	}
+	return status
+}
+
+// stopAll drops the queued experiments and signals the running ones to stop
+func (a *Agent) stopAll(sig os.Signal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped, a.queued = true, nil
+	for _, run := range a.running {
+		select {
+		case run.stop <- sig:
+		default:
+		}
+	}
+}
+
+// wait blocks until the running experiments have cleaned up
+func (a *Agent) wait() {
+	a.mu.Lock()
+	running := slices.Clone(a.running)
+	a.mu.Unlock()
+	for _, run := range running {
+		run.rm.Cleanup()
+	}
+}
+
+// configFromJSON builds a validated Config from a JSON object of flag values,
+// using the same names and defaults as the command line
+func configFromJSON(r io.Reader) (Config, error) {
+	values, err := decodeSettings(r)
+	if err != nil {
+		return Config{}, fmt.Errorf("parse request: %w", err)
+	}
+	if err := checkExperimentSettings(values); err != nil {
+		return Config{}, err
+	}
+	config, err := configFromSettings(values)
+	if err != nil {
+		return Config{}, err
+	}
+	log.Printf("Agent accepted experiment: %+v", config)
+	return config, nil
+}
+
+// processSettings configure the output of the whole process, which the agent
+// opens for itself and not per experiment
+var processSettings = []string{"output", "artifacts", "artifacts-tar"}
+
+// checkExperimentSettings rejects settings an experiment on an agent can't have
+func checkExperimentSettings(values map[string]string) error {
+	for _, name := range processSettings {
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("setting %q is not supported by the agent, it applies to the whole process and not to one experiment", name)
+		}
+	}
+	return nil
+}
+
+// configFromSettings builds a validated Config from flag values keyed by flag name
+func configFromSettings(values map[string]string) (Config, error) {
+	var config Config
+	fs := flag.NewFlagSet("settings", flag.ContinueOnError)
+	bindFlags(fs, &config)
+
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return Config{}, fmt.Errorf("unknown setting %q", name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return Config{}, fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+		}
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// controlCommand adjusts the experiment running on an agent
+func controlCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	agentURL := fs.String("agent", "http://localhost:7070", "Base URL of the agent, or unix:///path of its -api socket")
+	extend := fs.String("extend", "", "Move the end of the running experiment, e.g. 10m or -5m")
+	endGraceful := fs.Bool("end-now-graceful", false, "Ramp the running experiment down over its -rampdown and end it")
+	disable := fs.String("disable", "", "Turn these consumers of the running experiment off, e.g. file,memory; the others keep running")
+	enable := fs.String("enable", "", "Turn these consumers of the running experiment back on")
+	id := fs.Int("id", 0, "Experiment to adjust while the agent runs several, from its status")
+	sec := bindAPIFlags(fs, false, true)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	base := sec.baseURL(*agentURL)
+	client, err := sec.client(30 * time.Second)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+
+	var path string
+	switch {
+	case *extend != "" && *endGraceful:
+		return exitCodeFor(fmt.Errorf("-extend and -end-now-graceful are mutually exclusive"))
+	case (*disable != "" || *enable != "") && (*extend != "" || *endGraceful):
+		return exitCodeFor(fmt.Errorf("-disable and -enable can't be combined with -extend or -end-now-graceful"))
+	case *disable != "" || *enable != "":
+		path = "/resources?disable=" + url.QueryEscape(*disable) + "&enable=" + url.QueryEscape(*enable)
+	case *extend != "":
+		if _, err := parseExtend(*extend); err != nil {
+			return exitCodeFor(err)
+		}
+		path = "/extend?by=" + url.QueryEscape(*extend)
+	case *endGraceful:
+		path = "/end"
+	default:
+		return exitCodeFor(fmt.Errorf("one of -extend, -end-now-graceful, -disable or -enable is required"))
+	}
+
+	if *id > 0 {
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		path += separator + "id=" + strconv.Itoa(*id)
+	}
+
+	resp, err := client.Post(base+path, "", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Control failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Control failed: %s: %s", resp.Status, body)
+		return 1
+	}
+	var reply struct {
+		Deadline  *time.Time `json:"deadline"`
+		EndHidden bool       `json:"end_hidden"`
+		Disabled  []string   `json:"disabled"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "Control failed: %v\n", err)
+		return 1
+	}
+	switch {
+	case reply.Deadline != nil:
+		fmt.Printf("Experiment now ends at %s\n", reply.Deadline.Format(time.RFC3339))
+	case reply.EndHidden:
+		fmt.Println("Experiment end moved, the new end is secret")
+	case len(reply.Disabled) > 0:
+		fmt.Printf("Disabled consumers: %s\n", strings.Join(reply.Disabled, ", "))
+	default:
+		fmt.Println("All consumers enabled")
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// apiSecurity holds the token and TLS settings of the agent and coordinator APIs
+// and of the commands calling them. One token is shared by the whole fleet.
+type apiSecurity struct {
+	Token   string
+	TLSCert string
+	TLSKey  string
+	CA      string
+	socket  string // Unix socket clients connect to, set by baseURL
+}
+
+// bindAPIFlags registers the API security flags. Servers get the certificate
+// and key, clients the CA to verify them. The token defaults to
+// OUTAGEMOCK_API_TOKEN so it needn't show up in the process list; the
+// variable is read once the flags are parsed so help doesn't print it.
+func bindAPIFlags(fs *flag.FlagSet, server, client bool) *apiSecurity {
+	sec := &apiSecurity{}
+	fs.StringVar(&sec.Token, "api-token", "", "Bearer token required by and sent to the agent and coordinator APIs (default $"+envName("api-token")+")")
+	if server {
+		fs.StringVar(&sec.TLSCert, "api-tls-cert", "", "PEM certificate to serve the API over HTTPS with")
+		fs.StringVar(&sec.TLSKey, "api-tls-key", "", "PEM private key of -api-tls-cert")
+	}
+	if client {
+		fs.StringVar(&sec.CA, "api-ca", "", "PEM CA certificates to verify HTTPS APIs with instead of the system roots")
+	}
+	return sec
+}
+
+// tokenFromEnv falls back to the token of the environment without -api-token
+func (s *apiSecurity) tokenFromEnv() {
+	if s.Token == "" {
+		s.Token = os.Getenv(envName("api-token"))
+	}
+}
+
+// validate checks that certificate and key are given together and loadable
+func (s *apiSecurity) validate() error {
+	s.tokenFromEnv()
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		return fmt.Errorf("-api-tls-cert and -api-tls-key must be given together")
+	}
+	if s.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey); err != nil {
+			return fmt.Errorf("loading API certificate: %w", err)
+		}
+	}
+	return nil
+}
+
+// scheme returns the URL scheme the API is served with
+func (s *apiSecurity) scheme() string {
+	if s.TLSCert != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// wrap requires the token on every request to handler
+func (s *apiSecurity) wrap(handler http.Handler) http.Handler {
+	if s.Token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="outagemock"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// guarded reports whether only trusted callers reach the API served on ln:
+// it takes a token, or a unix socket's permissions guard it
+func (s *apiSecurity) guarded(ln net.Listener) bool {
+	return s.Token != "" || ln != nil && ln.Addr().Network() == "unix"
+}
+
+// serve serves the API on ln, or on the server's address when ln is nil, over
+// HTTPS when a certificate is configured. It warns about an unguarded API,
+// which lets anyone reaching it load the host.
+func (s *apiSecurity) serve(server *http.Server, ln net.Listener, what string) error {
+	if !s.guarded(ln) {
+		log.Printf("The %s API is unauthenticated, set -api-token before exposing it on a shared network", what)
+	}
+	server.Handler = s.wrap(server.Handler)
+	switch {
+	case ln == nil && s.TLSCert != "":
+		return server.ListenAndServeTLS(s.TLSCert, s.TLSKey)
+	case ln == nil:
+		return server.ListenAndServe()
+	case s.TLSCert != "":
+		return server.ServeTLS(ln, s.TLSCert, s.TLSKey)
+	}
+	return server.Serve(ln)
+}
+
+// baseURL returns the URL to send API requests to. A unix:///path URL makes
+// the clients created afterwards connect to that socket.
+func (s *apiSecurity) baseURL(api string) string {
+	if path, ok := strings.CutPrefix(api, "unix://"); ok {
+		s.socket = path
+		return "http://localhost"
+	}
+	return strings.TrimSuffix(api, "/")
+}
+
+// client returns an HTTP client that sends the token and trusts -api-ca
+func (s *apiSecurity) client(timeout time.Duration) (*http.Client, error) {
+	s.tokenFromEnv()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.CA != "" {
+		pem, err := os.ReadFile(s.CA)
+		if err != nil {
+			return nil, fmt.Errorf("reading API CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.CA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if s.socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", s.socket)
+		}
+	}
+	return &http.Client{Timeout: timeout, Transport: &tokenTransport{token: s.Token, base: transport}}, nil
+}
+
+// tokenTransport adds the bearer token to every request
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor systemd passes sockets on
+const listenFdsStart = 3
+
+// apiListener opens the listener of the agent's -api: unix:///path serves on
+// a unix socket only its owner and -api-group may connect to, and systemd
+// takes the socket passed by systemd socket activation (ListenStream= of a
+// .socket unit). It returns nil to serve on -listen.
+func apiListener(api, group string) (net.Listener, error) {
+	switch {
+	case api == "":
+		return nil, nil
+	case api == "systemd":
+		return activatedListener()
+	case strings.HasPrefix(api, "unix://"):
+		return unixListener(strings.TrimPrefix(api, "unix://"), group)
+	}
+	return nil, fmt.Errorf("invalid -api %q (expected unix:///path or systemd)", api)
+}
+
+// activatedListener returns the first socket passed by systemd
+func activatedListener() (net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || fds < 1 {
+		return nil, fmt.Errorf("-api systemd needs a socket passed by systemd socket activation (LISTEN_PID and LISTEN_FDS)")
+	}
+	// Experiments started by the agent, such as -run-cmd, must not see the socket
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	syscall.CloseOnExec(listenFdsStart)
+	f := os.NewFile(listenFdsStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// unixListener listens on a unix socket at path, readable and writable by
+// its owner, and by group when given. A socket left behind by a crashed agent
+// is replaced; one an agent still serves on is not.
+func unixListener(path, group string) (net.Listener, error) {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("invalid -api-group %q: %v", group, err)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another agent serves on %s", path)
+		}
+		os.Remove(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// Created accessible to the owner only, so no one connects before the group is set
+	old := umask(0177)
+	ln, err := net.Listen("unix", path)
+	umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		if err := os.Chmod(path, 0660); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// artifactsRunEnv hands the bundle of the run to the copies of this process
+// started for -systemd-run and namespaces, so they add to it
+const artifactsRunEnv = "OUTAGEMOCK_ARTIFACTS_RUN"
+
+// artifactBundle is the directory of one run under -artifacts. It collects
+// the output of the run like a sink, reports are added as runs finish.
+type artifactBundle struct {
+	dir     string
+	pack    bool       // Pack into dir.tar.gz on exit, -artifacts-tar
+	log     fileOutput // output.log: text, messages and log lines
+	samples jsonOutput // status.jsonl: the status samples
+}
+
+// openArtifacts creates the bundle of the run and writes the effective config into it
+func openArtifacts(config Config) (*artifactBundle, error) {
+	b := &artifactBundle{dir: os.Getenv(artifactsRunEnv), pack: config.ArtifactsTar && !inNamespaceChild()}
+	if b.dir == "" {
+		host, _ := os.Hostname()
+		parent := expandHome(config.Artifacts)
+		err := os.MkdirAll(parent, 0755)
+		if err == nil {
+			// Every run gets a bundle of its own, never one merged with another run's
+			b.dir, err = createUnique(filepath.Join(parent, runID(time.Now())+"-"+host), func(dir string) error {
+				return os.Mkdir(dir, 0755)
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("create -artifacts directory: %w", err)
+		}
+		data, _ := json.MarshalIndent(config, "", "  ")
+		if err := os.WriteFile(filepath.Join(b.dir, "config.json"), append(data, '\n'), 0644); err != nil {
+			return nil, fmt.Errorf("write effective config: %w", err)
+		}
+		os.Setenv(artifactsRunEnv, b.dir)
+	}
+
+	open := func(name string) (*os.File, error) {
+		return os.OpenFile(filepath.Join(b.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+	f, err := open("output.log")
+	if err != nil {
+		return nil, fmt.Errorf("open -artifacts log: %w", err)
+	}
+	b.log = fileOutput{f}
+	if f, err = open("status.jsonl"); err != nil {
+		b.log.Close()
+		return nil, fmt.Errorf("open -artifacts status samples: %w", err)
+	}
+	b.samples = jsonOutput{f}
+	return b, nil
+}
+
+func (b *artifactBundle) Emit(r OutputRecord) {
+	b.log.Emit(r)
+	if r.Kind == outputData && r.Msg == "status" {
+		b.samples.Emit(r)
+	}
+}
+
+func (b *artifactBundle) Close() error {
+	err := b.log.Close()
+	if err2 := b.samples.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// currentArtifacts returns the directory of the bundle, empty without -artifacts
+func currentArtifacts() string {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if artifacts == nil {
+		return ""
+	}
+	return artifacts.dir
+}
+
+// closeArtifacts closes the bundle at exit and packs it with -artifacts-tar
+func closeArtifacts() {
+	outputMu.Lock()
+	b := artifacts
+	artifacts = nil
+	outputMu.Unlock()
+	if b == nil {
+		return
+	}
+	b.Close()
+	if !b.pack {
+		messagef("Run artifacts in %s\n", b.dir)
+		return
+	}
+	path := b.dir + ".tar.gz"
+	if err := packDir(b.dir, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to pack run artifacts, left them in %s: %v\n", b.dir, err)
+		return
+	}
+	os.RemoveAll(b.dir)
+	messagef("Run artifacts packed into %s\n", path)
+}
+
+// saveArtifacts adds the report of the finished run, and its JUnit report,
+// to the bundle. Scenario lanes add one report each.
+func (rm *ResourceMock) saveArtifacts(code int) {
+	dir := currentArtifacts()
+	if dir == "" {
+		return
+	}
+	name := "report"
+	if rm.config.lane != "" {
+		name += "-" + rm.config.lane
+	}
+	data, err := json.MarshalIndent(rm.runRecord(code), "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, name+".json"), append(data, '\n'), 0644)
+	}
+	if err == nil && rm.config.JUnit != "" {
+		if data, err = os.ReadFile(rm.config.JUnit); err == nil {
+			err = os.WriteFile(filepath.Join(dir, name+".junit.xml"), data, 0644)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save run artifacts: %v\n", err)
+	}
+}
+
+// packDir writes the directory into a gzipped tar at path, under its own name
+func packDir(dir, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	base := filepath.Dir(dir)
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if header.Name, err = filepath.Rel(base, file); err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(header.Name)
+		if err := tw.WriteHeader(header); err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
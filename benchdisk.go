@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	benchSeqBlock  = 1 << 20   // Bytes per sequential request
+	benchRandBlock = 4 << 10   // Bytes per random request
+	benchRunSize   = 256 << 20 // Size of the benchmark file with -bench-disk
+	benchRunTime   = 2 * time.Second
+)
+
+// DiskBench is the measured capacity of the filesystem holding the work file
+type DiskBench struct {
+	Path          string  `json:"path"`
+	SeqWriteMBps  float64 `json:"seq_write_mbps"`
+	SeqReadMBps   float64 `json:"seq_read_mbps"`
+	RandWriteIOPS float64 `json:"rand_write_iops"`
+	RandReadIOPS  float64 `json:"rand_read_iops"`
+	Direct        bool    `json:"direct"` // Measured with O_DIRECT; otherwise through the page cache with synchronous writes
+}
+
+// benchPath returns the benchmark file next to the work file, carrying the
+// suffix so cleanup removes one left behind
+func benchPath(filePath string) string {
+	return strings.TrimSuffix(filePath, fileSuffix) + "_bench" + fileSuffix
+}
+
+// benchDisk measures sequential and random throughput of the filesystem of
+// filePath on a preallocated file of size bytes, spending at most limit on
+// each of the four tests. Requests bypass the page cache with O_DIRECT where
+// the filesystem supports it, so the results reflect the storage.
+func benchDisk(ctx context.Context, filePath string, size int64, limit time.Duration) (DiskBench, error) {
+	path := benchPath(filePath)
+	result := DiskBench{Path: path, Direct: true}
+	size = size / benchSeqBlock * benchSeqBlock
+	if size < benchSeqBlock {
+		return result, fmt.Errorf("benchmark size must be at least 1M")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC|oDirect, 0600)
+	if errors.Is(err, syscall.EINVAL) {
+		// tmpfs and some FUSE filesystems have no direct IO
+		result.Direct = false
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC|oDsync, 0600)
+	}
+	if err != nil {
+		return result, err
+	}
+	defer os.Remove(path)
+	defer file.Close()
+
+	// Reserve the blocks up front so the write test measures data, not allocation
+	if err := fallocate(file, size); err != nil && err != syscall.EOPNOTSUPP {
+		return result, fmt.Errorf("preallocate %d MB: %w", size>>20, err)
+	}
+
+	// Direct IO needs page aligned buffers, which mmap provides
+	buf, err := mmap(nil, 0, benchSeqBlock, 0)
+	if err != nil {
+		return result, err
+	}
+	defer munmap(buf)
+	rand.Read(buf)
+
+	// Each test runs until it covered its range or used up its time
+	seq := func(op func([]byte, int64) (int, error), length int64) (float64, int64, error) {
+		start, done := time.Now(), int64(0)
+		for done < length && time.Since(start) < limit && ctx.Err() == nil {
+			n, err := op(buf, done)
+			done += int64(n)
+			if err != nil {
+				return 0, done, err
+			}
+		}
+		return float64(done) / (1 << 20) / time.Since(start).Seconds(), done, nil
+	}
+	random := func(op func([]byte, int64) (int, error), length int64) (float64, error) {
+		start, count := time.Now(), 0
+		for time.Since(start) < limit && ctx.Err() == nil {
+			if _, err := op(buf[:benchRandBlock], mrand.Int63n(length/benchRandBlock)*benchRandBlock); err != nil {
+				return 0, err
+			}
+			count++
+		}
+		return float64(count) / time.Since(start).Seconds(), nil
+	}
+
+	// Reads stay within what was written, unwritten extents read as zeros without touching the storage
+	var written int64
+	if result.SeqWriteMBps, written, err = seq(file.WriteAt, size); err != nil {
+		return result, fmt.Errorf("sequential write: %w", err)
+	}
+	if written < benchSeqBlock {
+		return result, ctx.Err()
+	}
+	dropCache(file, 0, size)
+	if result.SeqReadMBps, _, err = seq(file.ReadAt, written); err != nil {
+		return result, fmt.Errorf("sequential read: %w", err)
+	}
+	if result.RandWriteIOPS, err = random(file.WriteAt, written); err != nil {
+		return result, fmt.Errorf("random write: %w", err)
+	}
+	dropCache(file, 0, size)
+	if result.RandReadIOPS, err = random(file.ReadAt, written); err != nil {
+		return result, fmt.Errorf("random read: %w", err)
+	}
+	return result, ctx.Err()
+}
+
+// print writes the benchmark results, relating them to the file growth the run needs
+func (b DiskBench) print(w io.Writer, config Config) {
+	mode := "direct IO"
+	if !b.Direct {
+		mode = "page cache, synchronous writes"
+	}
+	fmt.Fprintf(w, "Disk benchmark of %s (%s):\n", b.Path, mode)
+	fmt.Fprintf(w, "  Sequential: write %.1f MB/s, read %.1f MB/s\n", b.SeqWriteMBps, b.SeqReadMBps)
+	fmt.Fprintf(w, "  Random 4K:  write %.0f IOPS, read %.0f IOPS\n", b.RandWriteIOPS, b.RandReadIOPS)
+	if config.FileSizeMB > 0 && config.RampupTime > 0 && b.SeqWriteMBps > 0 {
+		needed := float64(config.FileSizeMB) / config.RampupTime.Seconds()
+		fmt.Fprintf(w, "  File growth during rampup needs %.1f MB/s, %.0f%% of sequential write\n", needed, 100*needed/b.SeqWriteMBps)
+	}
+}
+
+// runDiskBench measures the filesystem of the work file before the run and
+// publishes the result in the status. The run's duration starts after the
+// benchmark. It reports false when a signal on stop cancelled the run; a
+// failed benchmark doesn't stop it.
+func (rm *ResourceMock) runDiskBench(stop <-chan os.Signal) bool {
+	rm.deadlineTimer.Stop()
+	ctx, cancel := context.WithCancel(rm.ctx)
+	defer cancel()
+	signalled, finished := make(chan os.Signal, 1), make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case sig := <-stop:
+			signalled <- sig
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	messagef("Benchmarking disk of %s...\n", rm.filePath)
+	result, err := benchDisk(ctx, rm.filePath, benchRunSize, benchRunTime)
+	cancel()
+	<-finished
+	select {
+	case sig := <-signalled:
+		messagef("Received signal %v before start, shutting down...\n", sig)
+		return false
+	default:
+	}
+	rm.startDeadline()
+	if err != nil {
+		log.Printf("Disk benchmark failed: %v", err)
+		return true
+	}
+	rm.statusMu.Lock()
+	rm.resourceStatus.DiskBench = &result
+	rm.statusMu.Unlock()
+	result.print(textOutput, rm.config)
+	return true
+}
+
+// benchDiskCommand measures the throughput of the filesystem holding -fpath
+func benchDiskCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	filePath := fs.String("fpath", "outagemock_temp_file", "Path of the work file whose filesystem is measured")
+	size := fs.String("size", "256M", "Size of the benchmark file")
+	limit := fs.Duration("time", benchRunTime, "Longest time spent on each of the four tests")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	bytes, err := parseByteSize(*size)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	if *limit <= 0 {
+		return exitCodeFor(fmt.Errorf("Benchmark time must be positive"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := notifySignals()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	result, err := benchDisk(ctx, *filePath, bytes, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(data))
+		return 0
+	}
+	result.print(os.Stdout, Config{})
+	return 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	canaryInterval = 10 * time.Millisecond // The canary task runs at 100 Hz
+	canaryWork     = time.Millisecond      // Duration of the task's busy part on the idle host
+	canaryMin      = 10 * time.Microsecond // Lower bound of the first histogram bucket
+	canaryGrowth   = 1.05                  // Ratio of consecutive bucket bounds, bounding the quantile error to 5%
+	canaryBuckets  = 300                   // Buckets up to about 23s, slower tasks land in the last one
+)
+
+// CanaryStats is the latency distribution of the canary task with -canary,
+// measured from the tick the task was due until it completed
+type CanaryStats struct {
+	Samples int64   `json:"samples"`
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	Missed  int64   `json:"missed"` // Ticks dropped because the previous task was still running
+}
+
+// canary is a latency-sensitive victim co-located with the load: a fixed
+// amount of CPU work plus a system call, run at 100 Hz
+type canary struct {
+	iterations int // Work iterations taking canaryWork on the idle host
+
+	mu      sync.Mutex
+	buckets [canaryBuckets]int64
+	samples int64
+	max     time.Duration
+	missed  int64
+}
+
+// newCanary calibrates the canary's work to canaryWork before the load starts
+func newCanary() *canary {
+	const probe = 100000
+	spin := cpuKernels[cpuWorkloadInt]()
+	best := time.Duration(math.MaxInt64)
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		spin(i, probe)
+		if d := time.Since(start); d < best {
+			best = d
+		}
+	}
+	return &canary{iterations: int(math.Max(1, float64(probe)*float64(canaryWork)/float64(best)))}
+}
+
+// record adds the latency of one run of the task
+func (c *canary) record(latency time.Duration) {
+	bucket := 0
+	if latency > canaryMin {
+		bucket = int(math.Ceil(math.Log(float64(latency)/float64(canaryMin)) / math.Log(canaryGrowth)))
+	}
+	if bucket >= canaryBuckets {
+		bucket = canaryBuckets - 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buckets[bucket]++
+	c.samples++
+	if latency > c.max {
+		c.max = latency
+	}
+}
+
+// stats returns the quantiles of the recorded latencies, each the upper bound of its bucket
+func (c *canary) stats() CanaryStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	quantile := func(q float64) float64 {
+		rank := int64(math.Ceil(q * float64(c.samples)))
+		seen := int64(0)
+		for i, n := range c.buckets {
+			if seen += n; seen >= rank && n > 0 {
+				bound := time.Duration(float64(canaryMin) * math.Pow(canaryGrowth, float64(i)))
+				if bound > c.max {
+					bound = c.max
+				}
+				return ms(bound)
+			}
+		}
+		return ms(c.max)
+	}
+	return CanaryStats{
+		Samples: c.samples,
+		P50Ms:   quantile(0.5),
+		P90Ms:   quantile(0.9),
+		P99Ms:   quantile(0.99),
+		MaxMs:   ms(c.max),
+		Missed:  c.missed,
+	}
+}
+
+// runCanary runs the canary task every canaryInterval and publishes its latency distribution
+func (rm *ResourceMock) runCanary() {
+	defer rm.wg.Done()
+	defer nameThread("om-canary")()
+
+	c := rm.canary
+	spin := cpuKernels[cpuWorkloadInt]()
+	ticker := time.NewTicker(canaryInterval)
+	defer ticker.Stop()
+	published, count := time.Now(), 0
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case due := <-ticker.C:
+			// The ticker drops ticks while the task runs late
+			if late := time.Since(due); late > canaryInterval {
+				c.mu.Lock()
+				c.missed += int64(late / canaryInterval)
+				c.mu.Unlock()
+			}
+			count = spin(count, c.iterations)
+			syscall.Getppid()
+			c.record(time.Since(due))
+		}
+
+		if time.Since(published) >= time.Second {
+			stats := c.stats()
+			rm.statusMu.Lock()
+			rm.resourceStatus.Canary = &stats
+			rm.statusMu.Unlock()
+			published = time.Now()
+		}
+	}
+}
+
+// printCanaryReport prints the latency distribution of the canary task
+func (rm *ResourceMock) printCanaryReport() {
+	if rm.canary == nil {
+		return
+	}
+	stats := rm.canary.stats()
+	if stats.Samples == 0 {
+		return
+	}
+	textf("Canary (%v task at 100 Hz): p50 %.2f ms, p90 %.2f ms, p99 %.2f ms, max %.2f ms over %d runs, %d missed\n",
+		canaryWork, stats.P50Ms, stats.P90Ms, stats.P99Ms, stats.MaxMs, stats.Samples, stats.Missed)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// clampFraction is the share of the free capacity -clamp lowers a target to,
+// leaving the rest to the host
+const clampFraction = 0.9
+
+// capacity is what the host has free for the targets of a run
+type capacity struct {
+	cores    float64 // Usable cores, the cgroup CPU quota or all cores
+	memoryMB int64   // MemAvailable, or less under a cgroup memory limit
+	diskMB   int64   // Free space of the filesystem holding the work file
+}
+
+// hostCapacity measures the free capacity for a run writing its work file at filePath
+func hostCapacity(filePath string) (capacity, error) {
+	free := capacity{cores: float64(runtime.NumCPU())}
+	if quota := cgroupCPUQuota(); quota > 0 && quota < free.cores {
+		free.cores = quota
+	}
+
+	info, err := readMemInfo()
+	if err != nil {
+		return capacity{}, err
+	}
+	free.memoryMB = info["MemAvailable"] / 1024
+	if headroom, ok := cgroupMemoryHeadroomMB(); ok && headroom < free.memoryMB {
+		free.memoryMB = headroom
+	}
+
+	_, _, avail, err := diskSpace(filepath.Dir(filePath))
+	if err != nil {
+		return capacity{}, err
+	}
+	free.diskMB = avail >> 20
+	// A resumed run's file already holds part of the target
+	if fi, err := os.Stat(filePath); err == nil {
+		free.diskMB += fi.Size() >> 20
+	}
+	return free, nil
+}
+
+// cgroupMemoryHeadroomMB returns how much more memory the cgroup v2 limit of
+// this process allows, if it has one
+func cgroupMemoryHeadroomMB() (int64, bool) {
+	_, v2Path := ownCgroupPaths()
+	for _, dir := range candidateDirs(cgroupMount, v2Path) {
+		limit, err := os.ReadFile(filepath.Join(dir, "memory.max"))
+		if err != nil {
+			continue
+		}
+		limitBytes, err := strconv.ParseInt(strings.TrimSpace(string(limit)), 10, 64)
+		if err != nil {
+			return 0, false // "max"
+		}
+		current, err := os.ReadFile(filepath.Join(dir, "memory.current"))
+		if err != nil {
+			return 0, false
+		}
+		used, _ := strconv.ParseInt(strings.TrimSpace(string(current)), 10, 64)
+		return (limitBytes - used) >> 20, true
+	}
+	return 0, false
+}
+
+// checkCapacity compares the targets with the free capacity of the host
+// before anything is consumed. Targets beyond it fail the run with what the
+// host has, or with -clamp are lowered to clampFraction of it and recorded
+// in the status. With a timeline, e.g. from -emulate or a recording, its peak
+// is checked and clamping scales all of its points. The peak of -cow-fork
+// counts as memory, clamping shrinks its area.
+func (rm *ResourceMock) checkCapacity() error {
+	c := &rm.config
+	peak := TimelinePoint{CPUPercent: c.CPUPercent, MemoryMB: c.MemoryMB, FileSizeMB: c.FileSizeMB}
+	if len(rm.timeline) > 0 {
+		p := rm.timeline.Peak()
+		peak.CPUPercent = math.Max(peak.CPUPercent, p.CPUPercent)
+		peak.MemoryMB = max(peak.MemoryMB, p.MemoryMB)
+		peak.FileSizeMB = max(peak.FileSizeMB, p.FileSizeMB)
+	}
+	// The area of -cow-fork and the copies of its children count as memory
+	cowMB := c.capMemory(c.cowFork.peakMB())
+	if peak.CPUPercent <= 0 && peak.MemoryMB <= 0 && peak.FileSizeMB <= 0 && cowMB <= 0 {
+		return nil
+	}
+	free, err := hostCapacity(rm.filePath)
+	if err != nil {
+		log.Printf("Skipping the capacity check: %v", err)
+		return nil
+	}
+
+	var problems, clamped []string
+	cpuScale, memoryScale, fileScale := 1.0, 1.0, 1.0
+	// Percent of the host for -cpu-of host, of the quota for -cpu-of limit
+	basis := float64(runtime.NumCPU())
+	if c.CPUOf == cpuOfLimit {
+		basis = free.cores
+	}
+	if cores := c.capCPU(peak.CPUPercent) * basis / 100; cores > free.cores+0.01 {
+		safe := math.Floor(free.cores*clampFraction/basis*1000) / 10
+		if c.Clamp {
+			clamped = append(clamped, fmt.Sprintf("cpu %.1f%% -> %.1f%%", peak.CPUPercent, safe))
+			c.CPUPercent = math.Min(c.CPUPercent, safe)
+			cpuScale = safe / peak.CPUPercent
+		} else {
+			problems = append(problems, fmt.Sprintf("CPU target %.1f%% needs %.2f cores, only %s are usable", peak.CPUPercent, cores, formatCores(free.cores)))
+		}
+	}
+	if mb := c.capMemory(peak.MemoryMB) + cowMB; mb > free.memoryMB {
+		safe := int64(float64(free.memoryMB) * clampFraction)
+		if c.Clamp {
+			// Memory target and copy-on-write area shrink alike
+			factor := float64(safe) / float64(mb)
+			clamped = append(clamped, fmt.Sprintf("memory %d -> %d MB", mb, safe))
+			if peak.MemoryMB > 0 {
+				target := int64(float64(c.capMemory(peak.MemoryMB)) * factor)
+				c.MemoryMB = min(c.MemoryMB, target)
+				memoryScale = float64(target) / float64(peak.MemoryMB)
+			}
+			if cowMB > 0 {
+				c.cowFork = c.cowFork.scaled(factor)
+			}
+		} else {
+			problems = append(problems, fmt.Sprintf("memory target %d MB exceeds the %d MB available", mb, free.memoryMB))
+		}
+	}
+	if mb := c.capFile(peak.FileSizeMB); mb > free.diskMB {
+		safe := int64(float64(free.diskMB) * clampFraction)
+		if c.Clamp {
+			clamped = append(clamped, fmt.Sprintf("file %d -> %d MB", peak.FileSizeMB, safe))
+			c.FileSizeMB = min(c.FileSizeMB, safe)
+			fileScale = float64(safe) / float64(peak.FileSizeMB)
+		} else {
+			problems = append(problems, fmt.Sprintf("file target %d MB exceeds the %d MB free on %s", mb, free.diskMB, filepath.Dir(rm.filePath)))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s; lower the targets or use -clamp to cut them to %.0f%% of the free capacity", strings.Join(problems, "; "), clampFraction*100)
+	}
+
+	if len(clamped) > 0 {
+		log.Printf("Targets clamped to the host's capacity: %s", strings.Join(clamped, ", "))
+		if len(rm.timeline) > 0 {
+			rm.timeline = rm.timeline.scale(cpuScale, memoryScale, fileScale)
+		}
+		rm.targets.mu.Lock()
+		rm.targets.cpu, rm.targets.memoryMB, rm.targets.fileMB = c.CPUPercent, c.MemoryMB, c.FileSizeMB
+		rm.targets.mu.Unlock()
+		rm.statusMu.Lock()
+		rm.resourceStatus.Clamped = clamped
+		rm.statusMu.Unlock()
+	}
+	return nil
+}
+
+// scale returns a copy of the timeline with the targets of each resource multiplied by a factor
+func (t Timeline) scale(cpu, memory, file float64) Timeline {
+	scaled := make(Timeline, len(t))
+	for i, p := range t {
+		p.CPUPercent *= cpu
+		p.MemoryMB = int64(float64(p.MemoryMB) * memory)
+		p.FileSizeMB = int64(float64(p.FileSizeMB) * file)
+		scaled[i] = p
+	}
+	return scaled
+}
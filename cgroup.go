@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cgroup is a cgroup v2 directory created or controlled by this process
+type cgroup struct {
+	path   string
+	origin string // cgroup this process was moved out of, relative to the root ("" if not moved)
+	root   string
+}
+
+// ioMaxLine matches one io.max limit, e.g. "8:0 rbps=10485760 wbps=10485760"
+var ioMaxLine = regexp.MustCompile(`^\d+:\d+( (rbps|wbps|riops|wiops)=(\d+|max))+$`)
+
+// parseIOThrottle splits a ";" separated list of io.max limits and checks each of them
+func parseIOThrottle(s string) ([]string, error) {
+	var lines []string
+	if s == "" {
+		return nil, nil
+	}
+	for _, line := range strings.Split(s, ";") {
+		line = strings.Join(strings.Fields(line), " ")
+		if !ioMaxLine.MatchString(line) {
+			return nil, fmt.Errorf("invalid io throttle %q (expected e.g. \"8:0 rbps=10485760 wbps=10485760\")", line)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// createCgroup creates a child cgroup of root with the given controllers enabled
+func createCgroup(root, name string, controllers ...string) (*cgroup, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 hierarchy: %w", root, err)
+	}
+	for _, controller := range controllers {
+		// The controller may already be enabled, only a failing limit write is fatal
+		if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			log.Printf("Failed to enable %s controller in %s: %v", controller, root, err)
+		}
+	}
+	path := filepath.Join(root, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	return &cgroup{path: path, root: root}, nil
+}
+
+// set writes value to a control file of the cgroup
+func (cg *cgroup) set(file, value string) error {
+	if err := os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("write %s %q: %w", file, value, err)
+	}
+	return nil
+}
+
+// addSelf moves this process into the cgroup, remembering where it came from
+func (cg *cgroup) addSelf() error {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			cg.origin = strings.TrimPrefix(line, "0::")
+		}
+	}
+	return cg.set("cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+// leave moves this process back to its original cgroup if needed
+func (cg *cgroup) leave() {
+	if cg.origin != "" {
+		back := filepath.Join(cg.root, cg.origin, "cgroup.procs")
+		if err := os.WriteFile(back, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			log.Printf("Failed to leave cgroup %s: %v", cg.path, err)
+		}
+	}
+}
+
+// remove moves this process back to its original cgroup if needed and deletes the cgroup.
+// Other processes must have exited before.
+func (cg *cgroup) remove() {
+	cg.leave()
+	if err := os.Remove(cg.path); err != nil {
+		log.Printf("Failed to remove cgroup %s: %v", cg.path, err)
+	}
+}
+
+// setupIOThrottle creates a cgroup limited by -io-throttle. The -run-cmd child is
+// started inside it; without a child this process itself is throttled.
+func (rm *ResourceMock) setupIOThrottle() error {
+	cg, err := createCgroup(rm.config.CgroupRoot, fmt.Sprintf("outagemock-%d", os.Getpid()), "io")
+	if err != nil {
+		return err
+	}
+	for _, line := range rm.config.ioThrottle {
+		if err := cg.set("io.max", line); err != nil {
+			cg.remove()
+			return err
+		}
+	}
+	if rm.config.RunCmd == "" {
+		if err := cg.addSelf(); err != nil {
+			cg.remove()
+			return err
+		}
+	}
+	log.Printf("Throttling IO in cgroup %s: %s", cg.path, strings.Join(rm.config.ioThrottle, "; "))
+	rm.cgroup = cg
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// childStopTimeout is how long the child command gets to exit after SIGTERM before it is killed
+const childStopTimeout = 5 * time.Second
+
+// parseClockSkew parses a signed offset like "+3m" or "-90s"
+func parseClockSkew(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	skew, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock skew %q: %v", s, err)
+	}
+	return skew, nil
+}
+
+// faketimeSpec returns the FAKETIME value for libfaketime: a relative offset in
+// seconds, followed by a clock rate when time should run faster or slower
+func faketimeSpec(skew time.Duration, rate float64) string {
+	spec := fmt.Sprintf("%+d", int64(skew/time.Second))
+	if rate > 0 && rate != 1 {
+		spec += " x" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+	return spec
+}
+
+// startChild launches -run-cmd through the shell, under libfaketime when a clock skew
+// or clock rate is configured. The child is stopped when the run cleans up.
+func (rm *ResourceMock) startChild() error {
+	cmd := shellCommand(rm.config.RunCmd)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if rm.config.clockSkew != 0 || (rm.config.ClockRate > 0 && rm.config.ClockRate != 1) {
+		preload := rm.config.FaketimeLib
+		if existing := os.Getenv("LD_PRELOAD"); existing != "" {
+			preload += ":" + existing
+		}
+		cmd.Env = append(cmd.Env,
+			"LD_PRELOAD="+preload,
+			"FAKETIME="+faketimeSpec(rm.config.clockSkew, rm.config.ClockRate),
+			"FAKETIME_DONT_FAKE_MONOTONIC=1",
+		)
+	}
+	if rm.config.User != "" {
+		// Started before privileges are dropped so it can still join the cgroup
+		as := rm.config.runAs
+		runAsUser(cmd, as.uid, as.gid)
+	}
+	// The cgroup of -memory-high is meant for the memory workers only
+	if rm.cgroup != nil && len(rm.config.ioThrottle) > 0 {
+		dir, err := os.Open(rm.cgroup.path)
+		if err != nil {
+			return fmt.Errorf("open cgroup: %w", err)
+		}
+		defer dir.Close()
+		useCgroupFD(cmd.SysProcAttr, int(dir.Fd()))
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %q: %w", rm.config.RunCmd, err)
+	}
+	log.Printf("Started child %q (pid %d, clock skew %v)", rm.config.RunCmd, cmd.Process.Pid, rm.config.clockSkew)
+
+	rm.child = cmd
+	rm.childDone = make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		log.Printf("Child %q exited: %v", rm.config.RunCmd, exitDescription(err))
+		close(rm.childDone)
+	}()
+	return nil
+}
+
+// stopChild terminates the child's process group, killing it if it does not exit in time
+func (rm *ResourceMock) stopChild() {
+	if rm.child == nil {
+		return
+	}
+	signalProcessGroup(rm.child.Process, false)
+	select {
+	case <-rm.childDone:
+	case <-time.After(childStopTimeout):
+		signalProcessGroup(rm.child.Process, true)
+		<-rm.childDone
+	}
+}
+
+// exitDescription describes the result of waiting for a child
+func exitDescription(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return strings.TrimSpace(err.Error())
+}
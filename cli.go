@@ -0,0 +1,415 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// command is a subcommand of the outagemock CLI
+type command struct {
+	name      string
+	summary   string
+	run       func(cmd *command, args []string) int
+	fs        *flag.FlagSet // Created by flagSet
+	helpGroup string        // Only flag group shown in the help, see help
+	quiet     bool          // Discard the help, see flags
+}
+
+// commands lists all subcommands in the order they are shown in help
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", summary: "Consume CPU, memory and disk for a duration (default when no command is given)", run: runCommand},
+		{name: "plan", summary: "Print the effective configuration and target timeline without consuming anything", run: planCommand},
+		{name: "agent", summary: "Serve an HTTP API that starts, stops and reports experiments on this host", run: agentCommand},
+		{name: "coordinator", summary: "Track registered agents and start experiments on a seeded random fraction of them", run: coordinatorCommand},
+		{name: "fleet", summary: "Start an experiment on a percentage of the agents registered with a coordinator", run: fleetCommand},
+		{name: "ssh", summary: "Copy this binary to SSH hosts, run an experiment there and clean up, without an agent", run: sshCommand},
+		{name: "control", summary: "Extend, shorten or gracefully end the experiment running on an agent", run: controlCommand},
+		{name: "record", summary: "Record host CPU and memory usage into a timeline file", run: recordCommand},
+		{name: "replay", summary: "Consume resources following a recorded timeline file", run: replayCommand},
+		{name: "scenario", summary: "Run the named lanes of a scenario file concurrently", run: scenarioCommand},
+		{name: "test", summary: "Run a scenario as an integration test of its assertions and guardrails, with a JUnit report", run: testCommand},
+		{name: "validate", summary: "Check JSON scenario and config files, reporting every problem with its line and column", run: validateCommand},
+		{name: "proxy", summary: "Run a TCP proxy adding latency, jitter, bandwidth caps and connection resets", run: proxyCommand},
+		{name: "http-proxy", summary: "Run an HTTP reverse proxy injecting errors, delays, truncated bodies and resets", run: httpProxyCommand},
+		{name: "bench-disk", summary: "Measure sequential and random throughput of the filesystem holding -fpath", run: benchDiskCommand},
+		{name: "selftest", summary: "Check with short CPU, memory and file bursts that this host reaches the targets, and list the usable fault mechanisms", run: selftestCommand},
+		{name: "history", summary: "List the runs saved with -history or diff two of them (history diff RUN1 RUN2)", run: historyCommand},
+		{name: "cleanup", summary: "Remove work files left behind by interrupted runs", run: cleanupCommand},
+		{name: "version", summary: "Print the version, the compiled-in consumers and what this host permits; -json for orchestrators", run: versionCommand},
+		{name: "completion", summary: "Print the shell completion script for bash, zsh or fish", run: completionCommand},
+		{name: "help", summary: "Show help for a command, or one group of its flags (help run memory)", run: helpCommand},
+	}
+}
+
+// dispatch runs the subcommand named by the first argument and returns the exit code.
+// Bare flags are treated as an alias for the run command.
+func dispatch(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runCommand(lookupCommand("run"), args)
+	}
+	cmd := lookupCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printCommands(os.Stderr)
+		return exitUsage
+	}
+	return cmd.run(cmd, args[1:])
+}
+
+// lookupCommand returns the command with the given name, or nil
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// printCommands writes the list of commands
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: outagemock <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'outagemock help <command>' for the flags of a command.")
+}
+
+// flagSet creates the command's flag set with generated help text
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: outagemock %s [flags]\n\n%s\n\n", cmd.name, cmd.summary)
+		printFlagGroups(out, fs, cmd.helpGroup)
+		if cmd.name == "run" && cmd.helpGroup == "" {
+			fmt.Fprintln(out)
+			printCommands(out)
+		}
+	}
+	if cmd.quiet {
+		fs.SetOutput(io.Discard)
+	}
+	cmd.fs = fs
+	return fs
+}
+
+// flags returns the flags of cmd, found like help does by parsing -h
+func (cmd *command) flags() []*flag.Flag {
+	probe := *cmd
+	probe.quiet = true
+	probe.run(&probe, []string{"-h"})
+	var flags []*flag.Flag
+	if probe.fs != nil {
+		probe.fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	}
+	return flags
+}
+
+// configCommand holds a flag set bound to a Config together with the
+// shared -config and -print-effective-config options
+type configCommand struct {
+	fs          *flag.FlagSet
+	config      Config
+	configPath  string
+	printConfig bool
+	sources     map[string]string
+}
+
+// newConfigCommand creates a flag set for cmd with all Config flags bound
+func newConfigCommand(cmd *command) *configCommand {
+	c := &configCommand{fs: cmd.flagSet()}
+	bindFlags(c.fs, &c.config)
+	c.fs.StringVar(&c.configPath, "config", "", "JSON config file with flag values, overridden by environment and flags")
+	c.fs.BoolVar(&c.printConfig, "print-effective-config", false, "Print the merged configuration and exit")
+	return c
+}
+
+// parse parses args, merges environment and config file values and validates the result.
+// It returns false when the effective configuration was printed instead.
+func (c *configCommand) parse(args []string) (bool, error) {
+	if err := c.fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	// Fill unset flags from OUTAGEMOCK_* environment variables and the config file
+	if c.configPath == "" {
+		c.configPath = os.Getenv(envName("config"))
+	}
+	sources, err := applySources(c.fs, c.configPath)
+	if err != nil {
+		return false, fmt.Errorf("loading configuration: %w", err)
+	}
+	c.sources = sources
+	c.config.applyContainerDefaults(sources)
+	if c.printConfig {
+		printEffectiveConfig(os.Stdout, c.fs, sources)
+		return false, nil
+	}
+	return true, c.config.validate()
+}
+
+// exitCodeFor reports a parse error and returns the exit code to use
+func exitCodeFor(err error) int {
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	return 0
+}
+
+// notifySignals returns a channel receiving the signals that end a run
+func notifySignals() chan os.Signal {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	return sigChan
+}
+
+// notifyRunSignals returns a channel receiving the signals that end a run,
+// SIGUSR1 to extend it and SIGUSR2 to end it gracefully. SIGQUIT always stops
+// at once, even with -graceful-rampdown.
+func notifyRunSignals() chan os.Signal {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, sigExtend, sigEnd)
+	return sigChan
+}
+
+// printStartup prints the configuration a run starts with
+func printStartup(config Config) {
+	shown := config
+	if config.hidesEnd() {
+		shown.Duration, shown.Seed = 0, 0
+	}
+	emitData("starting resource mock", map[string]interface{}{"config": shown})
+	textf("Starting resource mock with:")
+	textf("  CPU: %.1f%% (rampup: %v)", config.CPUPercent, config.RampupTime)
+	textf("  Memory: %d MB (rampup: %v)", config.MemoryMB, config.RampupTime)
+	textf("  File: %d MB at %s (rampup: %v)", config.FileSizeMB, config.FilePath, config.RampupTime)
+	if config.hidesEnd() {
+		// The seed would give the drawn duration away, both are reported when the run ends
+		if config.Markov != "" {
+			textf("  Markov: %d state visits of %s", len(config.markovVisits), config.Markov)
+		}
+		textf("  Duration: drawn from %s, hidden until the end (-reveal-duration shows it)", config.durationRange)
+		return
+	}
+	if config.durationRange != "" {
+		textf("  Duration drawn from %s (seed: %d)", config.durationRange, config.Seed)
+	}
+	if config.Markov != "" {
+		textf("  Markov: %d state visits of %s (seed: %d)", len(config.markovVisits), config.Markov, config.Seed)
+	}
+	if config.timeScale != 0 && config.timeScale != 1 {
+		textf("  Duration: %v on a clock %gx real time, %v of wall time", config.Duration, config.timeScale, time.Duration(float64(config.Duration)/config.timeScale))
+		return
+	}
+	textf("  Duration: %v", config.Duration)
+}
+
+// runCommand consumes resources as configured by flags
+func runCommand(cmd *command, args []string) int {
+	c := newConfigCommand(cmd)
+	ok, err := c.parse(args)
+	if !ok || err != nil {
+		return exitCodeFor(err)
+	}
+	if err := openOutput(c.config); err != nil {
+		return exitCodeFor(err)
+	}
+
+	// Start over inside the systemd scope, namespaces are entered from there
+	if c.config.SystemdRun && !inSystemdScope() {
+		return exitCodeFor(runInSystemdScope(c.config))
+	}
+
+	if c.config.TargetContainer != "" {
+		if err := c.config.resolveTargetContainer(); err != nil {
+			return exitCodeFor(err)
+		}
+	}
+
+	// Generate the load from a copy of this command inside the namespaces
+	if c.config.Netns != "" || c.config.unshare != 0 {
+		if !inNamespaceChild() {
+			return runInNamespaces(c.config)
+		}
+		if c.config.unshare&cloneNewNS != 0 {
+			if err := makeMountsPrivate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to make mounts private: %v\n", err)
+				return 1
+			}
+		}
+		if c.config.unshare&cloneNewNet != 0 {
+			if err := bringUpLoopback(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to bring up loopback: %v\n", err)
+			}
+		}
+	}
+
+	// Fill every matching mount in a lane of its own next to the rest of the load
+	if c.config.FillAllMounts != "" {
+		lanes, err := fillMountLanes(c)
+		if err != nil {
+			return exitCodeFor(err)
+		}
+		return runLanes(lanes, notifyRunSignals())
+	}
+
+	printStartup(c.config)
+	rm := NewResourceMock(c.config)
+	stop := notifyRunSignals()
+	// Let an operator at the keyboard steer the run
+	if !c.config.NoConsole && !c.config.ContainerMode && foregroundTerminal(os.Stdin) {
+		go rm.runConsole(os.Stdin, os.Stdout, stop)
+	}
+	return rm.Run(stop)
+}
+
+// planCommand prints the effective configuration and the targets over time
+func planCommand(cmd *command, args []string) int {
+	c := newConfigCommand(cmd)
+	ok, err := c.parse(args)
+	if !ok || err != nil {
+		return exitCodeFor(err)
+	}
+
+	printEffectiveConfig(os.Stdout, c.fs, c.sources)
+	fmt.Println()
+	printPlan(os.Stdout, NewResourceMock(c.config))
+	return 0
+}
+
+// printPlan writes the targets at the start, during rampup and at the end of the run
+func printPlan(w io.Writer, rm *ResourceMock) {
+	config := rm.config
+	var offsets []time.Duration
+	if rm.timeline != nil {
+		for _, point := range rm.timeline {
+			offsets = append(offsets, point.Offset)
+		}
+	} else {
+		const steps = 4
+		for i := 0; i <= steps && config.RampupTime > 0; i++ {
+			offsets = append(offsets, config.RampupTime*time.Duration(i)/steps)
+		}
+	}
+	// Derived targets change their delay after their source
+	for _, c := range config.correlations {
+		for _, offset := range offsets {
+			if c.Delay > 0 && !slices.Contains(offsets, offset+c.Delay) {
+				offsets = append(offsets, offset+c.Delay)
+			}
+		}
+	}
+	slices.Sort(offsets)
+	if len(offsets) == 0 || offsets[len(offsets)-1] < config.Duration {
+		offsets = append(offsets, config.Duration)
+	}
+
+	fmt.Fprintln(w, "Planned targets:")
+	fmt.Fprintf(w, "  %-10s %-8s %-12s %-12s\n", "Offset", "CPU %", "Memory (MB)", "File (MB)")
+	for _, offset := range offsets {
+		if offset > config.Duration {
+			break
+		}
+		fmt.Fprintf(w, "  %-10s %-8.1f %-12d %-12d\n", offset,
+			config.capCPU(rm.cpuTargetAt(offset)), config.capMemory(rm.memoryTargetAt(offset)), config.capFile(rm.fileTargetAt(offset)))
+	}
+}
+
+// cleanupCommand removes work files left behind by runs that could not clean up
+func cleanupCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dirs := fs.String("dir", ".", "Comma separated directories to search for work files")
+	filePath := fs.String("fpath", "", "Exact file path given to a previous run (the safety suffix is added)")
+	dryRun := fs.Bool("dry-run", false, "Only list the files that would be removed")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+
+	var paths []string
+	if *filePath != "" {
+		path := strings.TrimSuffix(*filePath, fileSuffix) + fileSuffix
+		paths = append(paths, path, treeRoot(path), stateFile(path))
+	} else {
+		for _, dir := range strings.Split(*dirs, ",") {
+			matches, err := filepath.Glob(filepath.Join(strings.TrimSpace(dir), "*"+fileSuffix))
+			if err != nil {
+				return exitCodeFor(err)
+			}
+			paths = append(paths, matches...)
+		}
+	}
+
+	failed := false
+	for _, path := range paths {
+		if *dryRun {
+			fmt.Printf("Would remove %s\n", path)
+			continue
+		}
+		// Directory trees of -tree carry the suffix on their root
+		if err := removeWorkPath(path); err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", path, err)
+				failed = true
+			}
+			continue
+		}
+		fmt.Printf("Removed %s\n", path)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// removeWorkPath removes a work file, or a whole tree when path is a -tree root
+func removeWorkPath(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.RemoveAll(path)
+	}
+	return os.Remove(path)
+}
+
+// helpCommand prints the list of commands or the flags of one command
+func helpCommand(cmd *command, args []string) int {
+	if len(args) == 0 {
+		printCommands(os.Stdout)
+		return 0
+	}
+	target := lookupCommand(args[0])
+	if target == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return exitUsage
+	}
+	if len(args) > 1 {
+		if lookupFlagGroup(args[1]) == nil {
+			fmt.Fprintf(os.Stderr, "unknown flag group %q (groups: %s)\n", args[1], strings.Join(flagGroupNames(), ", "))
+			return exitUsage
+		}
+		target.helpGroup = args[1]
+	}
+	// Every command prints its usage and returns flag.ErrHelp for -h
+	return target.run(target, []string{"-h"})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock is the time a run follows: rampups, timelines, phases, the deadline
+// and the status are measured on it instead of the wall clock, so tests can
+// move time forward and -time-scale can rehearse a run faster than real time
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// Wall returns the wall time passing while d passes on the clock, for timers
+	Wall(d time.Duration) time.Duration
+	// NewTicker ticks every d on the clock
+	NewTicker(d time.Duration) *Ticker
+}
+
+// Ticker delivers the ticks of a Clock on C, like time.Ticker
+type Ticker struct {
+	C    <-chan time.Time
+	stop func()
+}
+
+// Stop turns the ticker off
+func (t *Ticker) Stop() {
+	t.stop()
+}
+
+// wallTicker ticks every d of wall time
+func wallTicker(d time.Duration) *Ticker {
+	ticker := time.NewTicker(d)
+	return &Ticker{C: ticker.C, stop: ticker.Stop}
+}
+
+// wallClock is the real time
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                     { return time.Now() }
+func (wallClock) Since(t time.Time) time.Duration    { return time.Since(t) }
+func (wallClock) Wall(d time.Duration) time.Duration { return d }
+func (wallClock) NewTicker(d time.Duration) *Ticker  { return wallTicker(d) }
+
+// scaledClock runs scale times faster than the wall clock since its start
+type scaledClock struct {
+	start time.Time
+	scale float64
+}
+
+func (c scaledClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.start)) * c.scale))
+}
+
+func (c scaledClock) Since(t time.Time) time.Duration { return c.Now().Sub(t) }
+
+func (c scaledClock) Wall(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.scale)
+}
+
+func (c scaledClock) NewTicker(d time.Duration) *Ticker {
+	return wallTicker(max(time.Millisecond, c.Wall(d)))
+}
+
+// newClock returns the wall clock, or a clock running scale times faster from now
+func newClock(scale float64) Clock {
+	if scale == 1 || scale == 0 { // Zero in configs that weren't validated
+		return wallClock{}
+	}
+	return scaledClock{start: time.Now(), scale: scale}
+}
+
+// parseTimeScale parses -time-scale, e.g. 10x or 0.5x
+func parseTimeScale(s string) (float64, error) {
+	scale, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || scale <= 0 {
+		return 0, fmt.Errorf("invalid -time-scale %q (expected a positive factor, e.g. 10x)", s)
+	}
+	return scale, nil
+}
+
+// useClock makes the run follow clock, re-arming the deadline on it. It must
+// be called before Run.
+func (rm *ResourceMock) useClock(clock Clock) {
+	rm.deadlineTimer.Stop()
+	rm.clock = clock
+	rm.startDeadline()
+}
+
+// scaleLanes makes every lane of a scenario follow one clock running scale
+// times faster than real time, the -time-scale of the scenario and test
+// commands. An empty scale leaves the lanes on their own -time-scale.
+func scaleLanes(lanes []lane, scale string) error {
+	if scale == "" {
+		return nil
+	}
+	factor, err := parseTimeScale(scale)
+	if err != nil {
+		return err
+	}
+	clock := newClock(factor)
+	for _, l := range lanes {
+		if factor != 1 && (l.rm.config.Resume || !l.rm.config.startAt.IsZero()) {
+			return fmt.Errorf("lane %s: -time-scale can't be combined with -resume or -start-at", l.name)
+		}
+		l.rm.config.timeScale = factor
+		l.rm.useClock(clock)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// completionFlag is a flag offered by shell completion
+type completionFlag struct {
+	name   string
+	usage  string   // First part of the usage, short enough for a completion menu
+	bool   bool     // Takes no value
+	values []string // Values offered after the flag, from the values tag of Config
+}
+
+// completionCommand prints a completion script for the shell named by the argument
+func completionCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: outagemock completion bash|zsh|fish\n\n%s\n\n", cmd.summary)
+		fmt.Fprintln(out, "  bash: source <(outagemock completion bash), or save it in /etc/bash_completion.d/outagemock")
+		fmt.Fprintln(out, "  zsh:  source <(outagemock completion zsh), or save it as _outagemock in a directory of $fpath")
+		fmt.Fprintln(out, "  fish: outagemock completion fish > ~/.config/fish/completions/outagemock.fish")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	flags := completionFlags()
+	switch fs.Arg(0) {
+	case "bash":
+		writeBashCompletion(os.Stdout, flags)
+	case "zsh":
+		writeZshCompletion(os.Stdout, flags)
+	case "fish":
+		writeFishCompletion(os.Stdout, flags)
+	default:
+		return exitCodeFor(fmt.Errorf("unsupported shell %q (supported: bash, zsh, fish)", fs.Arg(0)))
+	}
+	return 0
+}
+
+// completionFlags returns the flags of every command by command name
+func completionFlags() map[string][]completionFlag {
+	flags := make(map[string][]completionFlag)
+	for _, cmd := range commands {
+		if cmd.name == "help" {
+			continue
+		}
+		for _, f := range cmd.flags() {
+			c := completionFlag{name: f.Name, usage: shortUsage(f.Usage)}
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				c.bool = true
+			}
+			if field, ok := settingField(f.Name); ok && field.Tag.Get("values") != "" {
+				c.values = strings.Split(field.Tag.Get("values"), ",")
+			}
+			flags[cmd.name] = append(flags[cmd.name], c)
+		}
+	}
+	return flags
+}
+
+// shortUsage cuts a usage text at its first clause, at most 80 characters
+func shortUsage(usage string) string {
+	for _, sep := range []string{"; ", ", e.g.", " (", ": "} {
+		if i := strings.Index(usage, sep); i > 0 {
+			usage = usage[:i]
+		}
+	}
+	if len(usage) > 80 {
+		usage = strings.TrimSpace(usage[:77]) + "..."
+	}
+	return usage
+}
+
+// commandNames returns the names of the commands
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.name
+	}
+	return names
+}
+
+// valueFlags returns the flags offering values, once each
+func valueFlags(flags map[string][]completionFlag) []completionFlag {
+	var list []completionFlag
+	seen := make(map[string]bool)
+	for _, cmd := range commands {
+		for _, f := range flags[cmd.name] {
+			if len(f.values) > 0 && !seen[f.name] {
+				seen[f.name] = true
+				list = append(list, f)
+			}
+		}
+	}
+	return list
+}
+
+func writeBashCompletion(w io.Writer, flags map[string][]completionFlag) {
+	fmt.Fprint(w, `# bash completion for outagemock
+# Load it with: source <(outagemock completion bash)
+_outagemock() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=run
+	if [[ $COMP_CWORD -gt 1 && ${COMP_WORDS[1]} != -* ]]; then
+		cmd=${COMP_WORDS[1]}
+	fi
+	case $prev in
+`)
+	for _, f := range valueFlags(flags) {
+		fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.name, strings.Join(f.values, " "))
+	}
+	fmt.Fprintf(w, `	esac
+	if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+		COMPREPLY=($(compgen -W %[1]q -- "$cur"))
+		return
+	fi
+	case $cmd:$COMP_CWORD in
+	help:2) COMPREPLY=($(compgen -W %[1]q -- "$cur")); return ;;
+	help:3) COMPREPLY=($(compgen -W %[2]q -- "$cur")); return ;;
+	completion:2) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")); return ;;
+	esac
+	if [[ $cur == -* ]]; then
+		case $cmd in
+`, strings.Join(commandNames(), " "), strings.Join(flagGroupNames(), " "))
+	for _, cmd := range commands {
+		if len(flags[cmd.name]) == 0 {
+			continue
+		}
+		names := make([]string, len(flags[cmd.name]))
+		for i, f := range flags[cmd.name] {
+			names[i] = "-" + f.name
+		}
+		fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", cmd.name, strings.Join(names, " "))
+	}
+	fmt.Fprint(w, `		esac
+		return
+	fi
+	# Other flag values and arguments are mostly files
+	COMPREPLY=($(compgen -f -- "$cur"))
+}
+complete -o filenames -F _outagemock outagemock
+`)
+}
+
+func writeZshCompletion(w io.Writer, flags map[string][]completionFlag) {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
+	fmt.Fprint(w, `#compdef outagemock
+# zsh completion for outagemock
+# Load it with: source <(outagemock completion zsh), or save it as _outagemock in a directory of $fpath
+_outagemock() {
+	local cmd=run
+	if (( CURRENT > 2 )) && [[ $words[2] != -* ]]; then
+		cmd=$words[2]
+	fi
+	local -a commands=(
+`)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\t\t%s\n", quote(cmd.name+":"+shortUsage(cmd.summary)))
+	}
+	fmt.Fprint(w, "\t)\n\tcase $words[CURRENT-1] in\n")
+	for _, f := range valueFlags(flags) {
+		fmt.Fprintf(w, "\t-%s) compadd -- %s; return ;;\n", f.name, strings.Join(f.values, " "))
+	}
+	fmt.Fprintf(w, `	esac
+	if (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then
+		_describe command commands
+		return
+	fi
+	case $cmd:$CURRENT in
+	help:3) _describe command commands; return ;;
+	help:4) compadd -- %s; return ;;
+	completion:3) compadd -- bash zsh fish; return ;;
+	esac
+	if [[ $PREFIX == -* ]]; then
+		local -a flags
+		case $cmd in
+`, strings.Join(flagGroupNames(), " "))
+	for _, cmd := range commands {
+		if len(flags[cmd.name]) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t\t%s) flags=(\n", cmd.name)
+		for _, f := range flags[cmd.name] {
+			fmt.Fprintf(w, "\t\t\t%s\n", quote("-"+f.name+":"+f.usage))
+		}
+		fmt.Fprint(w, "\t\t) ;;\n")
+	}
+	fmt.Fprint(w, `		esac
+		_describe flag flags
+		return
+	fi
+	_files
+}
+if [[ $funcstack[1] == _outagemock ]]; then
+	_outagemock "$@"
+else
+	compdef _outagemock outagemock
+fi
+`)
+}
+
+func writeFishCompletion(w io.Writer, flags map[string][]completionFlag) {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	}
+	fmt.Fprint(w, "# fish completion for outagemock\n# Load it with: outagemock completion fish | source\n")
+	fmt.Fprint(w, "complete -c outagemock -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c outagemock -n __fish_use_subcommand -a %s -d %s\n", cmd.name, quote(shortUsage(cmd.summary)))
+	}
+	fmt.Fprintf(w, "complete -c outagemock -n '__fish_seen_subcommand_from help' -a %s\n", quote(strings.Join(append(commandNames(), flagGroupNames()...), " ")))
+	fmt.Fprint(w, "complete -c outagemock -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n")
+
+	// A flag shared by several commands is listed once for all of them
+	var order []completionFlag
+	owners := make(map[string][]string)
+	key := func(f completionFlag) string { return fmt.Sprintf("%s %t %s", f.name, f.bool, f.usage) }
+	for _, cmd := range commands {
+		for _, f := range flags[cmd.name] {
+			if owners[key(f)] == nil {
+				order = append(order, f)
+			}
+			owners[key(f)] = append(owners[key(f)], cmd.name)
+		}
+	}
+	for _, f := range order {
+		owner := owners[key(f)]
+		condition := "__fish_seen_subcommand_from " + strings.Join(owner, " ")
+		if owner[0] == "run" {
+			// The flags of run also apply without a command
+			condition = "__fish_use_subcommand; or " + condition
+		}
+		fmt.Fprintf(w, "complete -c outagemock -n %s -o %s", quote(condition), f.name)
+		switch {
+		case len(f.values) > 0:
+			fmt.Fprintf(w, " -x -a %s", quote(strings.Join(f.values, " ")))
+		case !f.bool:
+			fmt.Fprint(w, " -r -F")
+		}
+		fmt.Fprintf(w, " -d %s\n", quote(f.usage))
+	}
+}
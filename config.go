@@ -0,0 +1,947 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the configuration for the resource mock.
+// Fields with a flag tag are exposed as command line flags by bindFlags;
+// the default and usage tags provide the flag's default value and help text,
+// the group tag its section of the help and the values tag the values shell
+// completion offers.
+type Config struct {
+	CPUPercent        float64       `flag:"cpu" default:"0" group:"cpu" usage:"CPU usage percentage (0-100)"`
+	CPUCores          float64       `flag:"cpu-cores-used" default:"0" group:"cpu" usage:"CPU target in cores used, as in Kubernetes requests and limits, e.g. 2.5 for two busy cores and one at half; instead of -cpu"`
+	CPUOf             string        `flag:"cpu-of" default:"host" group:"cpu" values:"host,limit" usage:"What -cpu is a percentage of: host (all cores) or limit (the cgroup CPU quota)"`
+	CPUWorkload       string        `flag:"cpu-workload" default:"int" group:"cpu" values:"int,float,avx,crypto,memcpy,branchy" usage:"Instruction mix of the CPU workers: int, float, avx, crypto, memcpy or branchy"`
+	Emulate           string        `flag:"emulate" group:"shape" values:"jvm-gc,batch-etl,cache-warmup" usage:"Follow the load of an application archetype, shaped with the -cpu, -memory and -fsize targets as peaks: jvm-gc (sawtooth memory with CPU spikes at each collection), batch-etl (CPU bursts with the file growing in steps) or cache-warmup (memory filling fast then slower while CPU settles)"`
+	Markov            string        `flag:"markov" group:"shape" usage:"Move between the load states of this JSON file (not YAML) at random, with dwell times and transition weights per state, for days-long background variation in soak tests; seeded with -seed"`
+	Correlate         string        `flag:"correlate" group:"shape" usage:"Derive targets from other targets like causal chains of real incidents, e.g. memory=cpu*40+512,file=cpu@30s*20: memory follows CPU at 40 MB per percent above 512 MB, the file follows CPU 30s later"`
+	FollowQuery       string        `flag:"follow-query" group:"shape" usage:"Move the CPU target along the result of this PromQL query, e.g. 'rate(app_requests_total[1m])', to mirror the traffic shape of production; -cpu is the ceiling"`
+	FollowScale       string        `flag:"follow-scale" default:"1%" group:"shape" usage:"CPU percent per unit of the -follow-query result, e.g. 0.1% turns 500 requests/s into 50% CPU"`
+	FollowURL         string        `flag:"follow-url" default:"http://localhost:9090" group:"shape" usage:"Prometheus server -follow-query runs against"`
+	FollowInterval    time.Duration `flag:"follow-interval" default:"15s" group:"shape" usage:"How often -follow-query runs"`
+	Thermal           bool          `flag:"thermal" default:"false" group:"cpu" usage:"Heat the CPU package: CPU workers run the avx workload and report core temperatures from hwmon; -cpu defaults to 100"`
+	Governor          string        `flag:"governor" group:"cpu" usage:"Set this cpufreq governor on every core for the run, e.g. performance, and restore the previous governors afterwards, so CPU percentages mean the same across hosts"`
+	RaiseProcs        bool          `flag:"raise-gomaxprocs" default:"false" group:"cpu" usage:"Raise GOMAXPROCS to the usable cores so CPU workers can load the whole host or quota"`
+	LoadAvg           float64       `flag:"loadavg" default:"0" group:"kernel" usage:"Hold the host's 1-minute load average at this value with spinning and IO blocked workers, e.g. 8"`
+	LoadAvgMix        string        `flag:"loadavg-mix" default:"100%" group:"kernel" usage:"Share of the -loadavg workers that are runnable; the rest sit in uninterruptible sleep (D state) on synchronous writes next to -fpath"`
+	PSI               string        `flag:"psi" unit:"list" group:"kernel" usage:"Hold pressure stall information (PSI) of the host at some-stall percentages instead of utilization, e.g. cpu=30,io=20; repeat to add resources (-psi cpu=30 -psi io=20)"`
+	Runqueue          string        `flag:"runqueue" group:"kernel" usage:"Keep this many runnable threads per core, e.g. 3x, so procs_running and run queue depths show contention beyond 100% CPU"`
+	Softirq           string        `flag:"softirq" group:"kernel" usage:"Drive softirq CPU instead of user CPU with events per second: net (loopback UDP packets) and timers (timerfd expirations), e.g. net=200000,timers=20000"`
+	MemoryMB          int64         `flag:"memory" default:"0" group:"memory" usage:"Memory size in MB"`
+	MemPrefault       string        `flag:"mem-prefault" default:"write" group:"memory" values:"write,read,MAP_POPULATE" usage:"How memory pages are faulted in: write (resident), read (fast reservation on the zero page) or MAP_POPULATE"`
+	CoWFork           string        `flag:"cow-fork" group:"memory" usage:"Write an area, fork children sharing it copy-on-write and let them dirty its pages during rampup, so every write duplicates a page and memory grows with the children like preforked workers, e.g. size=1G,children=8,write=50%"`
+	Mlock             bool          `flag:"mlock" default:"false" group:"memory" usage:"Lock the allocated memory with mlock so it is unevictable and can't be swapped or reclaimed, like database buffer pools (raises RLIMIT_MEMLOCK, which needs root or CAP_IPC_LOCK)"`
+	MemoryHigh        string        `flag:"memory-high" group:"memory" usage:"Hold the memory in a cgroup whose memory.high is this share of -memory above what the process holds at the start, e.g. 90%, so the kernel throttles and reclaims the memory workers approaching the target and memory pressure (PSI) builds up; requires cgroup v2"`
+	MemFileBacked     string        `flag:"mem-filebacked" group:"memory" usage:"Map this share of the memory target from temporary files next to -fpath, e.g. 30%, so it is page cache (Cached, RssFile) that reclaim can write back and drop, the rest anonymous memory (AnonPages, RssAnon)"`
+	MemVariance       string        `flag:"mem-variance" group:"memory" usage:"Let RSS oscillate this much around the memory target by evicting and re-touching a rotating subset of blocks, e.g. 10%"`
+	MemVariancePeriod time.Duration `flag:"mem-variance-period" default:"1m" group:"memory" usage:"Period of the -mem-variance oscillation"`
+	FaultRate         string        `flag:"fault-rate" group:"memory" usage:"Emulate demand paging: fault in pages of an untouched -fault-region at this rate, e.g. 5000/s, starting over once all are resident"`
+	FaultRegionMB     int64         `flag:"fault-region" default:"1G" unit:"size" group:"memory" usage:"Size of the mapping -fault-rate faults pages in from, with unit"`
+	LLCThrash         string        `flag:"llc-thrash" group:"kernel" usage:"Pollute the last-level cache by chasing pointers through a working set larger than it, lowering the IPC of co-located workloads with little CPU time, e.g. size=32MB,threads=2,duty=50% (size defaults to twice the cache)"`
+	TLBPressure       string        `flag:"tlb-pressure" group:"kernel" usage:"Stress the TLB and page tables: map a large sparse address space, touch one page per 2M and read them in random order, e.g. span=64G,pages=20000,threads=2,duty=50% (pages default to one per 2M of the span)"`
+	Kmem              string        `flag:"kmem" group:"kernel" usage:"Pressure kernel slab caches to test kernel memory alerts, e.g. dentries=2000000,epoll=1000,timers=10000 (negative dentries next to -fpath, epoll instances, armed timerfds)"`
+	UnixObjects       string        `flag:"unix-objects" group:"kernel" usage:"Hold open unix IPC objects in a directory next to -fpath to test tools enumerating sockets and /proc, e.g. sockets=50000,pairs=10000,fifos=20000"`
+	LogFlood          string        `flag:"log-flood" group:"kernel" usage:"Append realistic log lines to a file at a target rate to stress log shippers, disks and log alerts, e.g. path=/var/log/app.log,rate=10MB/min,format=json (formats: json, text, access)"`
+	InjectLog         string        `flag:"inject-log" group:"kernel" usage:"Emit synthetic syslog or journald entries at a rate to test alerts on log patterns, e.g. rate=5/s,priority=err,tag=payments,target=journald (targets: syslog, journald, udp://host:514, tcp://host:514)"`
+	InjectLogMessage  string        `flag:"inject-log-message" default:"payment {seq} failed on {host}: upstream timeout after {rand}ms" group:"kernel" usage:"Template of the -inject-log entries; {seq}, {host}, {time} and {rand} are replaced"`
+	FileSizeMB        int64         `flag:"fsize" default:"0" unit:"size" group:"file" usage:"File size with unit (e.g., 100M, 1.5G, 500K, 2T)"`
+	WriteMBps         float64       `flag:"write-mbps" default:"0" group:"file" usage:"Cap the growth of the file in MB/s, 0 to write as fast as the rampup schedule needs"`
+	FileRate          string        `flag:"file-rate" group:"file" usage:"Pace file writes with a token bucket instead of the rampup, so the file grows toward -fsize at this rate, e.g. \"50MB/s burst=200MB\" (burst defaults to one second); with rampup the file follows -rampup and the rate only caps its growth, e.g. 100MB/s,rampup"`
+	VerifyFile        time.Duration `flag:"verify-file" default:"0s" group:"file" usage:"Every this often read back a region of the file past the page cache and compare it with the checksums of what was written, reporting silent corruption"`
+	CorruptFile       string        `flag:"corrupt-file" group:"file" usage:"Once rampup is over flip a byte in this share of the file's MB blocks, e.g. 1%, to test scrubbers and checksumming storage"`
+	BenchDisk         bool          `flag:"bench-disk" default:"false" group:"file" usage:"Measure sequential and random throughput of the -fpath filesystem before the run and include it in the status and report"`
+	FillAllMounts     string        `flag:"fill-all-mounts" group:"file" usage:"Fill every mount point matching a glob toward a usage percentage concurrently, one status row each, e.g. pattern=/data*,fill=90%,/data3=70% (run command only, instead of -fsize)"`
+	FileContent       string        `flag:"file-content" default:"pattern" group:"file" values:"zeros,pattern,random" usage:"Data written to the file: zeros, pattern, random (incompressible) or compressible:<ratio>, e.g. compressible:3"`
+	Tree              string        `flag:"tree" group:"file" usage:"Also create a directory tree of small files next to -fpath, e.g. depth=5,fanout=10,file-size=4K (files per directory default to fanout)"`
+	FilePath          string        `flag:"fpath" default:"outagemock_temp_file" group:"file" usage:"File path"`
+	Duration          time.Duration `flag:"duration" default:"30s" unit:"range" group:"timing" usage:"Running duration, or a range like 20m±10m (also 20m+-10m) to end at a random time drawn with -seed"`
+	DurationSpread    time.Duration // Half the range of -duration 20m±10m, drawn into Duration by validate
+	RampupTime        time.Duration `flag:"rampup" default:"10s" group:"timing" usage:"Rampup time to reach target CPU and memory"`
+	Rampdown          time.Duration `flag:"rampdown" default:"10s" group:"timing" usage:"Time to ramp targets down to zero when ending gracefully (SIGUSR2)"`
+	GracefulRampdown  time.Duration `flag:"graceful-rampdown" default:"0s" group:"timing" usage:"On SIGTERM ramp targets down over this long before cleaning up instead of stopping at once (SIGQUIT always stops at once); defaults to -rampdown with -container-mode"`
+	ExtendStep        time.Duration `flag:"extend-step" default:"10m" group:"timing" usage:"Time added to the run on SIGUSR1"`
+	StartAt           string        `flag:"start-at" group:"timing" usage:"Wall-clock time to start at, RFC 3339 e.g. 2024-05-01T12:00:00.250Z, so several hosts ramp together"`
+	TimeScale         string        `flag:"time-scale" default:"1x" group:"timing" usage:"Run the timeline this many times faster than real time, e.g. 10x to rehearse a 30m scenario in 3m; durations, rampups and timeline offsets stay as written"`
+	Jitter            string        `flag:"jitter" group:"timing" usage:"Randomize the rampup, duration, rampdowns and timeline points by up to this share each, e.g. 10%, so repeated runs don't cross alert thresholds at the same second"`
+	Seed              int64         `flag:"seed" default:"0" group:"timing" usage:"Seed of -jitter, -markov and a -duration range (0 = random, logged so the run can be repeated)"`
+	RevealDuration    bool          `flag:"reveal-duration" default:"false" group:"timing" usage:"Print the duration drawn from a -duration range and its seed at the start and count down to the end (hidden by default so responders can't predict the end)"`
+	Quota
+	S3Config
+	NetOptions
+	Clamp             bool          `flag:"clamp" default:"false" group:"safety" usage:"Lower CPU, memory and file targets beyond the host's free capacity to 90% of it instead of failing before the start"`
+	Strict            bool          `flag:"strict" default:"false" group:"safety" usage:"Abort with exit code 3 when memory or file can't reach 95% of target"`
+	OnConsumerError   string        `flag:"on-consumer-error" default:"continue" group:"safety" values:"continue,abort,degrade" usage:"What the run does when a consumer gives up: continue with the others, abort at once with exit code 9, or degrade: finish with the others and exit with code 10"`
+	CIEvents          bool          `flag:"ci-events" default:"false" group:"reporting" usage:"Print progress lines like ::outagemock::phase=rampup pct=50 for CI systems"`
+	JUnit             string        `flag:"junit" group:"reporting" usage:"Write a JUnit XML summary of the run to this file"`
+	Canary            bool          `flag:"canary" default:"false" group:"reporting" usage:"Run a latency-sensitive canary task (1ms of CPU work and a system call at 100 Hz) next to the load and report its latency distribution"`
+	Pushgateway       string        `flag:"pushgateway" group:"reporting" usage:"Push summary metrics of the run (peaks, achieved fractions of the targets, duration, exit code) to this Prometheus Pushgateway on exit, e.g. http://pushgateway:9091"`
+	History           string        `flag:"history" group:"reporting" usage:"Save the report of the run (environment, configuration, peaks and final status) as JSON in this directory, e.g. ~/.outagemock/history, for the history command"`
+	Artifacts         string        `flag:"artifacts" group:"reporting" usage:"Collect the effective config, status samples, report and log of the run in a directory per run under this directory, e.g. ./runs/, for post-incident reviews"`
+	ArtifactsTar      bool          `flag:"artifacts-tar" default:"false" group:"reporting" usage:"Pack the -artifacts directory of the run into a .tar.gz on exit"`
+	SelfOverhead      bool          `flag:"self-overhead" default:"false" group:"reporting" usage:"Measure this process's CPU usage and report it against the CPU target"`
+	NoColor           bool          `flag:"no-color" default:"false" group:"reporting" usage:"Don't color degraded, throttled or disabled cells of the status table (also with NO_COLOR set)"`
+	ASCII             bool          `flag:"ascii" default:"false" group:"reporting" usage:"Draw the status table with +, - and | for terminals and fonts without box drawing characters"`
+	NoConsole         bool          `flag:"no-console" default:"false" group:"reporting" usage:"Don't read commands from stdin even when it is a terminal"`
+	Output            string        `flag:"output" default:"console" group:"reporting" values:"console,json,syslog,null" usage:"Where the output of the run goes, a comma separated fan-out of console, file:PATH (text), json[:PATH] (JSON lines, default stderr), syslog[:TAG] and null; json with -container-mode"`
+	ContainerMode     bool          `flag:"container-mode" default:"false" group:"reporting" usage:"Run as a container entrypoint: JSON logs, files under /tmp, SIGTERM ramps down within -grace-period"`
+	GracePeriod       time.Duration `flag:"grace-period" default:"30s" group:"reporting" usage:"Termination grace period of the container; with -container-mode the rampdown fits into it"`
+	ProtectHost       string        `flag:"protect-host" group:"safety" usage:"Back off while the host crosses limits, e.g. cpu=95,mem-free=1GB,load=2x"`
+	RunCmd            string        `flag:"run-cmd" group:"faults" usage:"Shell command run as a child for the duration of the experiment"`
+	ClockSkew         string        `flag:"clock-skew" group:"faults" usage:"Offset of the child's clock, e.g. +3m or -90s (requires libfaketime)"`
+	ClockRate         float64       `flag:"clock-rate" default:"1" group:"faults" usage:"Speed of the child's clock, e.g. 1.01 to drift 1% fast (requires libfaketime)"`
+	FaketimeLib       string        `flag:"faketime-lib" default:"/usr/lib/x86_64-linux-gnu/faketime/libfaketime.so.1" group:"faults" usage:"libfaketime library preloaded into the child for clock skew"`
+	IOThrottle        string        `flag:"io-throttle" group:"isolation" usage:"cgroup io.max limits for -run-cmd (or this process), e.g. \"8:0 rbps=10485760 wbps=10485760\"; separate devices with ;"`
+	CgroupRoot        string        `flag:"cgroup-root" default:"/sys/fs/cgroup" group:"isolation" usage:"cgroup v2 directory new cgroups are created in"`
+	FreezeCgroup      string        `flag:"freeze-cgroup" group:"faults" usage:"cgroup directory to freeze and thaw in duty cycles, e.g. /sys/fs/cgroup/app"`
+	Freeze            string        `flag:"freeze" default:"on=2s,off=8s" group:"faults" usage:"Freeze duty cycle for -freeze-cgroup"`
+	FuseMount         string        `flag:"fuse-mount" group:"faults" usage:"Mount a FUSE filesystem here that serves -fuse-backing with injected latency, errors and hangs (requires root or /dev/fuse access)"`
+	FuseBacking       string        `flag:"fuse-backing" group:"faults" usage:"Directory served through -fuse-mount, e.g. an NFS mount"`
+	FuseLatency       time.Duration `flag:"fuse-latency" default:"0s" group:"faults" usage:"Latency added to every operation on -fuse-mount"`
+	FuseErrorRate     string        `flag:"fuse-error-rate" group:"faults" usage:"Percentage of operations on -fuse-mount that fail with EIO, e.g. 5%"`
+	FuseErrorBurst    time.Duration `flag:"fuse-error-burst" default:"0s" group:"faults" usage:"After an injected error, fail every operation on -fuse-mount for this long"`
+	FuseHang          string        `flag:"fuse-hang" group:"faults" usage:"Hang every operation on -fuse-mount in duty cycles like a lost NFS server, e.g. on=10s,off=50s"`
+	DMDevice          string        `flag:"dm-device" group:"faults" usage:"Map this spare block device, e.g. a test disk or loop device, at /dev/mapper/-dm-name through device mapper with injected latency and error windows, for a genuinely slow or flaky disk (requires root and dmsetup); point the workload at the mapping"`
+	DMName            string        `flag:"dm-name" default:"outagemock" group:"faults" usage:"Name of the -dm-device mapping under /dev/mapper; scenario lanes append their name"`
+	DMDelay           time.Duration `flag:"dm-delay" default:"0s" group:"faults" usage:"Latency dm-delay adds to every read and write through the -dm-device mapping"`
+	DMErrors          string        `flag:"dm-errors" group:"faults" usage:"Fail every read and write through the -dm-device mapping with dm-flakey in duty cycles like a dying disk, e.g. on=10s,off=50s"`
+	ConnTarget        string        `flag:"conn-target" group:"network" usage:"Open idle connections to this service, tcp://host:port, http://host:port/path or h3://host:port/path (HTTP completes one keep-alive request first, h3 over QUIC)"`
+	ConnCount         int           `flag:"conn-count" default:"0" group:"network" usage:"Connections held open to -conn-target after rampup"`
+	ConnChurn         string        `flag:"conn-churn" group:"network" usage:"Drop and reopen connections to -conn-target in waves like a load balancer failover, e.g. every=30s,drop=50%,pause=2s"`
+	ConnPayload       string        `flag:"conn-payload" group:"network" values:"http-get,redis-ping,postgres-startup" usage:"Protocol exchange each connection to -conn-target completes before idling, so L7 proxies and IDS rules see real traffic: http-get, redis-ping or postgres-startup (default http-get for http:// targets, none for tcp://)"`
+	Netns             string        `flag:"netns" group:"isolation" usage:"Run the load inside this network namespace: a name from \"ip netns add\", pid:<pid> of a process such as a container, or a path; the controller stays in the host namespace"`
+	Unshare           string        `flag:"unshare" group:"isolation" usage:"Run the load inside new namespaces, comma separated from mount, net, pid, ipc, uts, e.g. mount so -fuse-mount is invisible to the host"`
+	SystemdRun        bool          `flag:"systemd-run" default:"false" group:"isolation" usage:"Run inside a transient systemd scope limited by -systemd-properties, which systemd stops with everything in it when the run ends or crashes"`
+	SystemdProperties string        `flag:"systemd-properties" group:"isolation" usage:"Properties of the -systemd-run scope, comma separated, e.g. CPUQuota=200%,MemoryMax=2G"`
+	TargetContainer   string        `flag:"target-container" group:"isolation" usage:"Scope -container-faults to this Docker container (name or id), resolving its cgroup and network namespace through the Docker API"`
+	ContainerFaults   string        `flag:"container-faults" default:"cpu,net" group:"faults" usage:"Faults scoped to -target-container: cpu (the load runs in its cgroup, under its limits), net (the load runs in its network namespace), freeze (-freeze cycles on its cgroup)"`
+	ContainerSocket   string        `flag:"container-socket" default:"/var/run/docker.sock" group:"isolation" usage:"Docker Engine API socket used by -target-container; Podman's compatible socket works too"`
+	User              string        `flag:"user" group:"isolation" usage:"Drop root to this user (and optional :group) after privileged setup such as -io-throttle, e.g. nobody; -run-cmd runs as this user too"`
+	Resume            bool          `flag:"resume" default:"false" group:"safety" usage:"Continue a crashed run with the same -fpath on its recorded timeline, adopting its work file, instead of cleaning up after it"`
+	HeartbeatFile     string        `flag:"heartbeat-file" group:"reporting" usage:"Rewrite this file with the status JSON every -heartbeat-interval, so watchers can detect a dead run from a stale time"`
+	HeartbeatURL      string        `flag:"heartbeat-url" group:"reporting" usage:"POST the status JSON to this URL every -heartbeat-interval"`
+	HeartbeatInterval time.Duration `flag:"heartbeat-interval" default:"1s" group:"reporting" usage:"Interval of -heartbeat-file and -heartbeat-url"`
+	StatusFile        string        `flag:"status-file" group:"reporting" usage:"Atomically rewrite this file with the latest status JSON at every status update, e.g. /run/outagemock/status.json, for pollers on hosts without listening sockets"`
+	ConsumerTargets   string        `flag:"consumer" group:"shape" usage:"Targets of consumers registered with consumer.Register, comma separated in each consumer's unit, e.g. gpu=80"`
+	SignalStorm       string        `flag:"signal-storm" group:"faults" usage:"Flood a process with signals to test its handlers and EINTR handling, e.g. pid=1234,signal=SIGUSR1,rate=100/s (at most 10000/s)"`
+
+	hostLimits        HostLimits         // Parsed from ProtectHost
+	freezeCycle       FreezeCycle        // Parsed from Freeze
+	fuseHang          FreezeCycle        // Parsed from FuseHang, On is the hang
+	dmErrors          FreezeCycle        // Parsed from DMErrors, On is the error window
+	signalStorm       SignalStorm        // Parsed from SignalStorm
+	runAs             runAs              // Parsed from User
+	unshare           uintptr            // Parsed from Unshare, clone flags
+	containerFaults   map[string]bool    // Parsed from ContainerFaults
+	systemdProperties []string           // Parsed from SystemdProperties
+	consumerTargets   map[string]float64 // Parsed from ConsumerTargets
+	containerCgroup   string             // Cgroup of TargetContainer the load joins, set by resolveTargetContainer
+	connTarget        *url.URL           // Parsed from ConnTarget
+	connChurn         ConnChurn          // Parsed from ConnChurn
+	connPayload       string             // ConnPayload, or http-get for http:// targets
+	jitter            float64            // Parsed from Jitter, a fraction
+	durationRange     string             // Duration as given when drawn from a range
+	fuseErrorRate     float64            // Parsed from FuseErrorRate, as a fraction of operations
+	clockSkew         time.Duration      // Parsed from ClockSkew
+	startAt           time.Time          // Parsed from StartAt, zero to start at once
+	timeScale         float64            // Parsed from TimeScale
+	generated         Timeline           // Built from Emulate or Markov
+	markovVisits      []markovVisit      // States of Markov over the run
+	correlations      []Correlation      // Parsed from Correlate
+	followScale       float64            // CPU percent per unit of the FollowQuery result
+	outputs           []string           // Sinks of Output
+	memVariance       float64            // Parsed from MemVariance, as a fraction of the target
+	memFileBacked     float64            // Parsed from MemFileBacked, as a fraction of the target
+	memoryHigh        float64            // Parsed from MemoryHigh, as a fraction of the target
+	corruptFile       float64            // Parsed from CorruptFile, as a fraction of the file's blocks
+	fileContent       fileContent        // Parsed from FileContent
+	tree              TreeSpec           // Parsed from Tree
+	mountFill         MountFill          // Parsed from FillAllMounts
+	fileRate          FileRate           // Parsed from FileRate
+	faultRate         float64            // Parsed from FaultRate, in pages per second
+	kmem              KmemSpec           // Parsed from Kmem
+	llcThrash         LLCThrash          // Parsed from LLCThrash
+	tlbPressure       TLBPressure        // Parsed from TLBPressure
+	cowFork           CoWFork            // Parsed from CoWFork
+	unixObjects       UnixSpec           // Parsed from UnixObjects
+	logFlood          LogFlood           // Parsed from LogFlood
+	logInject         LogInject          // Parsed from InjectLog
+	softirq           SoftirqSpec        // Parsed from Softirq
+	runqueue          float64            // Parsed from Runqueue, runnable threads per core
+	loadMix           float64            // Parsed from LoadAvgMix, as a fraction of the workers
+	psiTargets        map[string]float64 // Parsed from PSI, the some percentage of each resource
+
+	s3UploadBps   int64    // Parsed from S3UploadRate
+	s3DownloadBps int64    // Parsed from S3DownloadRate
+	s3ObjectSize  int64    // Parsed from S3ObjectSize
+	ioThrottle    []string // Parsed from IOThrottle, one io.max line per device
+	lane          string   // Name of the scenario lane, which reports through the scenario's table
+}
+
+// fileSuffix is appended to every work file path so cleanup can only ever remove files created by this tool
+const fileSuffix = "_outagemock_test.data"
+
+// sizeValue is a flag.Value for sizes with units, stored in MB
+type sizeValue struct {
+	mb  *int64
+	raw string
+}
+
+func (v *sizeValue) String() string {
+	if v.mb == nil {
+		return ""
+	}
+	return v.raw
+}
+
+func (v *sizeValue) Set(s string) error {
+	mb, err := parseFileSize(s)
+	if err != nil {
+		return err
+	}
+	*v.mb = mb
+	v.raw = s
+	return nil
+}
+
+// durationRangeValue is a flag.Value for a duration or a range like 20m±10m,
+// stored as the center and half the range
+type durationRangeValue struct {
+	d, spread *time.Duration
+}
+
+func (v *durationRangeValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	if *v.spread == 0 {
+		return v.d.String()
+	}
+	return fmt.Sprintf("%v±%v", *v.d, *v.spread)
+}
+
+func (v *durationRangeValue) Set(s string) error {
+	center, spread, ok := strings.Cut(s, "±")
+	if !ok {
+		center, spread, ok = strings.Cut(s, "+-")
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(center))
+	if err != nil {
+		return err
+	}
+	var half time.Duration
+	if ok {
+		if half, err = time.ParseDuration(strings.TrimSpace(spread)); err != nil {
+			return err
+		}
+		if half < 0 || half >= d {
+			return fmt.Errorf("the range %s must be non-negative and shorter than %s", spread, center)
+		}
+	}
+	*v.d, *v.spread = d, half
+	return nil
+}
+
+// listValue is a flag.Value for a comma separated list that repeated flags
+// extend, e.g. -psi cpu=30 -psi io=20
+type listValue struct {
+	s *string
+}
+
+func (v *listValue) String() string {
+	if v.s == nil {
+		return ""
+	}
+	return *v.s
+}
+
+func (v *listValue) Set(s string) error {
+	if *v.s != "" {
+		s = *v.s + "," + s
+	}
+	*v.s = s
+	return nil
+}
+
+// bindFlags registers a flag for every tagged field of the struct pointed to by target
+func bindFlags(fs *flag.FlagSet, target interface{}) {
+	value := reflect.ValueOf(target).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		// Flags of embedded structs are promoted like their fields
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindFlags(fs, value.Field(i).Addr().Interface())
+			continue
+		}
+		name := field.Tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		usage := field.Tag.Get("usage")
+
+		switch ptr := value.Field(i).Addr().Interface().(type) {
+		case *time.Duration:
+			if field.Tag.Get("unit") == "range" {
+				// The half range goes into the field named like this one with a Spread suffix
+				spread := value.FieldByName(field.Name + "Spread").Addr().Interface().(*time.Duration)
+				fs.Var(&durationRangeValue{d: ptr, spread: spread}, name, usage)
+			} else {
+				fs.DurationVar(ptr, name, 0, usage)
+			}
+		case *float64:
+			fs.Float64Var(ptr, name, 0, usage)
+		case *int64:
+			if field.Tag.Get("unit") == "size" {
+				fs.Var(&sizeValue{mb: ptr}, name, usage)
+			} else {
+				fs.Int64Var(ptr, name, 0, usage)
+			}
+		case *int:
+			fs.IntVar(ptr, name, 0, usage)
+		case *string:
+			if field.Tag.Get("unit") == "list" {
+				fs.Var(&listValue{s: ptr}, name, usage)
+			} else {
+				fs.StringVar(ptr, name, "", usage)
+			}
+		case *bool:
+			fs.BoolVar(ptr, name, false, usage)
+		default:
+			panic(fmt.Sprintf("bindFlags: unsupported type %s for field %s", field.Type, field.Name))
+		}
+
+		if def, ok := field.Tag.Lookup("default"); ok {
+			f := fs.Lookup(name)
+			if err := f.Value.Set(def); err != nil {
+				panic(fmt.Sprintf("bindFlags: invalid default %q for %s: %v", def, name, err))
+			}
+			f.DefValue = def
+		}
+	}
+}
+
+// hidesEnd reports whether the end of the run is kept secret, which it is for
+// a -duration range unless -reveal-duration is set
+func (c Config) hidesEnd() bool {
+	return c.durationRange != "" && !c.RevealDuration
+}
+
+// validate checks the configuration and finalizes derived values
+func (c *Config) validate() error {
+	if c.DurationSpread > 0 {
+		// Drawn once, validating again keeps the drawn duration
+		if c.Seed == 0 {
+			c.Seed = time.Now().UnixNano()
+		}
+		c.durationRange = fmt.Sprintf("%v±%v", c.Duration, c.DurationSpread)
+		offset := rand.New(rand.NewSource(c.Seed)).Int63n(int64(2*c.DurationSpread) + 1)
+		c.Duration = (c.Duration - c.DurationSpread + time.Duration(offset)).Round(time.Millisecond)
+		c.DurationSpread = 0
+	}
+	if c.CPUCores != 0 {
+		cores := float64(runtime.NumCPU())
+		percent := c.CPUCores / cores * 100
+		switch {
+		case c.CPUPercent > 0 && c.CPUPercent != percent:
+			return fmt.Errorf("-cpu and -cpu-cores-used are mutually exclusive")
+		case c.CPUCores < 0 || c.CPUCores > cores:
+			return fmt.Errorf("-cpu-cores-used must be between 0 and the %.0f cores of the host", cores)
+		case c.CPUOf != cpuOfHost:
+			return fmt.Errorf("-cpu-cores-used counts cores, -cpu-of doesn't apply to it")
+		}
+		c.CPUPercent = percent
+	}
+	if c.Thermal {
+		// -cpu-workload int is the default, anything else was asked for
+		if c.CPUWorkload != cpuWorkloadInt && c.CPUWorkload != cpuWorkloadAVX {
+			return fmt.Errorf("-thermal runs the avx workload, it can't be combined with -cpu-workload %s", c.CPUWorkload)
+		}
+		c.CPUWorkload = cpuWorkloadAVX
+		if c.CPUPercent == 0 {
+			c.CPUPercent = 100
+		}
+	}
+	if c.Jitter != "" {
+		var err error
+		if c.jitter, err = parsePercent(c.Jitter); err != nil {
+			return fmt.Errorf("invalid -jitter: %v", err)
+		}
+		if c.jitter >= 1 {
+			return fmt.Errorf("-jitter must be below 100%%")
+		}
+	}
+	if err := c.applyEmulate(); err != nil {
+		return err
+	}
+	if err := c.applyMarkov(); err != nil {
+		return err
+	}
+	if err := c.applyCorrelations(); err != nil {
+		return err
+	}
+	if c.FollowQuery != "" {
+		var err error
+		if c.followScale, err = parseFollowScale(c.FollowScale); err != nil {
+			return err
+		}
+		switch {
+		case c.CPUPercent <= 0:
+			return fmt.Errorf("-follow-query requires -cpu, the ceiling of the followed target")
+		case c.FollowInterval <= 0:
+			return fmt.Errorf("-follow-interval must be positive")
+		case c.Emulate != "" || c.Markov != "":
+			return fmt.Errorf("-follow-query is mutually exclusive with -emulate and -markov")
+		}
+		for _, corr := range c.correlations {
+			if corr.Target == "cpu" {
+				return fmt.Errorf("-follow-query and -correlate cpu=... both set the CPU target")
+			}
+		}
+	}
+	if _, ok := cpuKernels[c.CPUWorkload]; !ok {
+		return fmt.Errorf("invalid -cpu-workload %q (supported: %s)", c.CPUWorkload, strings.Join(cpuWorkloadNames(), ", "))
+	}
+	if c.CPUPercent < 0 || c.CPUPercent > 100 {
+		return fmt.Errorf("CPU percentage must be between 0 and 100")
+	}
+	if c.CPUOf != cpuOfHost && c.CPUOf != cpuOfLimit {
+		return fmt.Errorf("invalid -cpu-of %q (supported: host, limit)", c.CPUOf)
+	}
+	switch c.OnConsumerError {
+	case consumerErrorContinue, consumerErrorAbort, consumerErrorDegrade:
+	default:
+		return fmt.Errorf("invalid -on-consumer-error %q (supported: continue, abort, degrade)", c.OnConsumerError)
+	}
+	if c.LoadAvg < 0 {
+		return fmt.Errorf("Load average must be non-negative")
+	}
+	if c.MemoryMB < 0 {
+		return fmt.Errorf("Memory size must be non-negative")
+	}
+	if !validPrefault(c.MemPrefault) {
+		return fmt.Errorf("invalid -mem-prefault %q (supported: write, read, MAP_POPULATE)", c.MemPrefault)
+	}
+	if c.FaultRate != "" {
+		var err error
+		if c.faultRate, err = parseFaultRate(c.FaultRate); err != nil {
+			return err
+		}
+		if c.FaultRegionMB <= 0 {
+			return fmt.Errorf("Fault region must be at least 1M")
+		}
+	}
+	if c.LLCThrash != "" {
+		var err error
+		if c.llcThrash, err = parseLLCThrash(c.LLCThrash); err != nil {
+			return err
+		}
+	}
+	if c.TLBPressure != "" {
+		var err error
+		if c.tlbPressure, err = parseTLBPressure(c.TLBPressure); err != nil {
+			return err
+		}
+	}
+	if c.CoWFork != "" {
+		var err error
+		if c.cowFork, err = parseCoWFork(c.CoWFork); err != nil {
+			return err
+		}
+		if c.MaxMemoryMB > 0 && c.cowFork.Size > c.MaxMemoryMB<<20 {
+			return fmt.Errorf("-cow-fork size %d MB exceeds -max-memory %d MB", c.cowFork.Size>>20, c.MaxMemoryMB)
+		}
+	}
+	if c.Kmem != "" {
+		var err error
+		if c.kmem, err = parseKmemSpec(c.Kmem); err != nil {
+			return err
+		}
+	}
+	if c.UnixObjects != "" {
+		var err error
+		if c.unixObjects, err = parseUnixSpec(c.UnixObjects); err != nil {
+			return err
+		}
+	}
+	if c.LogFlood != "" {
+		var err error
+		if c.logFlood, err = parseLogFlood(c.LogFlood); err != nil {
+			return err
+		}
+	}
+	if c.Runqueue != "" {
+		var err error
+		if c.runqueue, err = parseRunqueue(c.Runqueue); err != nil {
+			return err
+		}
+	}
+	if c.Softirq != "" {
+		var err error
+		if c.softirq, err = parseSoftirqSpec(c.Softirq); err != nil {
+			return err
+		}
+	}
+	if c.InjectLog != "" {
+		var err error
+		if c.logInject, err = parseLogInject(c.InjectLog); err != nil {
+			return err
+		}
+	}
+	if c.FileSizeMB < 0 {
+		return fmt.Errorf("File size must be non-negative")
+	}
+	if c.WriteMBps < 0 {
+		return fmt.Errorf("Write rate must be non-negative")
+	}
+	if c.FileRate != "" {
+		var err error
+		if c.fileRate, err = parseFileRate(c.FileRate); err != nil {
+			return err
+		}
+		if c.WriteMBps > 0 {
+			return fmt.Errorf("-file-rate and -write-mbps are mutually exclusive")
+		}
+	}
+	if c.VerifyFile < 0 {
+		return fmt.Errorf("File verification interval must be non-negative")
+	}
+	if (c.VerifyFile > 0 || c.CorruptFile != "") && c.FileSizeMB == 0 {
+		return fmt.Errorf("-verify-file and -corrupt-file require -fsize")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("Duration must be positive")
+	}
+	if c.MaxCPUPercent < 0 || c.MaxMemoryMB < 0 || c.MaxFileSizeMB < 0 {
+		return fmt.Errorf("Quota caps must be non-negative")
+	}
+	if c.Rampdown < 0 || c.GracefulRampdown < 0 {
+		return fmt.Errorf("Rampdown must be non-negative")
+	}
+
+	var err error
+	if c.outputs, err = parseOutput(c.Output); err != nil {
+		return err
+	}
+	if c.ArtifactsTar && c.Artifacts == "" {
+		return fmt.Errorf("-artifacts-tar requires -artifacts")
+	}
+	if c.hostLimits, err = parseHostLimits(c.ProtectHost); err != nil {
+		return err
+	}
+	if c.clockSkew, err = parseClockSkew(c.ClockSkew); err != nil {
+		return err
+	}
+	if c.ioThrottle, err = parseIOThrottle(c.IOThrottle); err != nil {
+		return err
+	}
+	if c.startAt, err = parseStartAt(c.StartAt); err != nil {
+		return err
+	}
+	if c.timeScale, err = parseTimeScale(c.TimeScale); err != nil {
+		return err
+	}
+	if c.timeScale != 1 && (c.Resume || !c.startAt.IsZero()) {
+		// Both follow the wall clock of other runs
+		return fmt.Errorf("-time-scale can't be combined with -resume or -start-at")
+	}
+	if c.fileContent, err = parseFileContent(c.FileContent); err != nil {
+		return err
+	}
+	if c.tree, err = parseTreeSpec(c.Tree); err != nil {
+		return err
+	}
+	if c.FillAllMounts != "" {
+		if c.mountFill, err = parseMountFill(c.FillAllMounts); err != nil {
+			return err
+		}
+		if c.FileSizeMB > 0 {
+			return fmt.Errorf("-fill-all-mounts and -fsize are mutually exclusive")
+		}
+	}
+	if err := c.S3Config.parse(c); err != nil {
+		return err
+	}
+	if c.loadMix, err = parsePercent(c.LoadAvgMix); err != nil {
+		return fmt.Errorf("invalid -loadavg-mix: %v", err)
+	}
+	if c.CorruptFile != "" {
+		if c.corruptFile, err = parsePercent(c.CorruptFile); err != nil {
+			return fmt.Errorf("invalid -corrupt-file: %v", err)
+		}
+	}
+	if c.Mlock && c.MemVariance != "" {
+		return fmt.Errorf("-mlock and -mem-variance are mutually exclusive, locked pages can't be evicted")
+	}
+	if c.MemoryHigh != "" {
+		if c.memoryHigh, err = parsePercent(c.MemoryHigh); err != nil {
+			return fmt.Errorf("invalid -memory-high: %v", err)
+		}
+		switch {
+		case c.MemoryMB <= 0:
+			return fmt.Errorf("-memory-high requires -memory")
+		case len(c.ioThrottle) > 0:
+			return fmt.Errorf("-memory-high can't be combined with -io-throttle, both create the cgroup of the run")
+		case c.SystemdRun:
+			return fmt.Errorf("-memory-high can't be combined with -systemd-run, the load would leave the scope")
+		}
+	}
+	if c.PSI != "" {
+		if c.psiTargets, err = parsePSITargets(c.PSI); err != nil {
+			return err
+		}
+	}
+	if c.MemFileBacked != "" {
+		if c.memFileBacked, err = parsePercent(c.MemFileBacked); err != nil {
+			return fmt.Errorf("invalid -mem-filebacked: %v", err)
+		}
+		if c.memFileBacked > 0 && runtime.GOOS == "windows" {
+			return fmt.Errorf("-mem-filebacked is not supported on Windows, where the memory workers can't map files")
+		}
+	}
+	if c.MemVariance != "" {
+		if c.memVariance, err = parsePercent(c.MemVariance); err != nil {
+			return fmt.Errorf("invalid -mem-variance: %v", err)
+		}
+		if c.MemVariancePeriod <= 0 {
+			return fmt.Errorf("Memory variance period must be positive")
+		}
+	}
+	if c.FreezeCgroup != "" {
+		if c.freezeCycle, err = parseFreezeCycle(c.Freeze); err != nil {
+			return err
+		}
+	}
+	if c.FuseMount != "" {
+		if c.FuseBacking == "" {
+			return fmt.Errorf("-fuse-mount requires -fuse-backing")
+		}
+		if c.FuseErrorRate != "" {
+			if c.fuseErrorRate, err = parsePercent(c.FuseErrorRate); err != nil {
+				return fmt.Errorf("invalid -fuse-error-rate: %v", err)
+			}
+		}
+		if c.FuseHang != "" {
+			if c.fuseHang, err = parseFreezeCycle(c.FuseHang); err != nil {
+				return err
+			}
+		}
+		if c.FuseLatency < 0 || c.FuseErrorBurst < 0 {
+			return fmt.Errorf("FUSE latency and error burst must be non-negative")
+		}
+	}
+	if c.DMDevice != "" {
+		if c.DMName == "" || strings.ContainsAny(c.DMName, "/ ") {
+			return fmt.Errorf("invalid -dm-name %q", c.DMName)
+		}
+		if c.DMErrors != "" {
+			if c.dmErrors, err = parseFreezeCycle(c.DMErrors); err != nil {
+				return err
+			}
+		}
+		if c.DMDelay < 0 {
+			return fmt.Errorf("-dm-delay must be non-negative")
+		}
+	} else if c.DMDelay != 0 || c.DMErrors != "" {
+		return fmt.Errorf("-dm-delay and -dm-errors require -dm-device")
+	}
+	if c.ConnCount < 0 {
+		return fmt.Errorf("Connection count must be non-negative")
+	}
+	if (c.ConnCount > 0 || c.ConnChurn != "" || c.ConnPayload != "") && c.ConnTarget == "" {
+		return fmt.Errorf("-conn-count, -conn-churn and -conn-payload require -conn-target")
+	}
+	if err := c.NetOptions.validate(); err != nil {
+		return err
+	}
+	if c.ConnTarget != "" {
+		if c.connTarget, err = parseConnTarget(c.ConnTarget); err != nil {
+			return err
+		}
+		if err := c.checkHost(c.connTarget.Hostname()); err != nil {
+			return fmt.Errorf("invalid -conn-target: %v", err)
+		}
+		if c.connPayload, err = parseConnPayload(c.ConnPayload, c.connTarget); err != nil {
+			return err
+		}
+	}
+	if c.ConnChurn != "" {
+		if c.connChurn, err = parseConnChurn(c.ConnChurn); err != nil {
+			return err
+		}
+	}
+	if (c.HeartbeatFile != "" || c.HeartbeatURL != "") && c.HeartbeatInterval <= 0 {
+		return fmt.Errorf("Heartbeat interval must be positive")
+	}
+	if c.unshare, err = parseUnshare(c.Unshare); err != nil {
+		return err
+	}
+	if c.Netns != "" && c.unshare&cloneNewNet != 0 {
+		return fmt.Errorf("-netns and -unshare net are mutually exclusive")
+	}
+	if c.systemdProperties, err = parseSystemdProperties(c.SystemdProperties); err != nil {
+		return err
+	}
+	if len(c.systemdProperties) > 0 && !c.SystemdRun {
+		return fmt.Errorf("-systemd-properties requires -systemd-run")
+	}
+	if c.TargetContainer != "" {
+		if c.containerFaults, err = parseContainerFaults(c.ContainerFaults); err != nil {
+			return err
+		}
+		if c.containerFaults["net"] && (c.Netns != "" || c.unshare&cloneNewNet != 0) {
+			return fmt.Errorf("-container-faults net can't be combined with -netns or -unshare net")
+		}
+		if c.containerFaults["freeze"] && c.FreezeCgroup != "" {
+			return fmt.Errorf("-container-faults freeze can't be combined with -freeze-cgroup")
+		}
+		if c.containerFaults["cpu"] && len(c.ioThrottle) > 0 && c.RunCmd == "" {
+			return fmt.Errorf("-container-faults cpu can't be combined with -io-throttle without -run-cmd, both move this process")
+		}
+		if c.containerFaults["cpu"] && c.MemoryHigh != "" {
+			return fmt.Errorf("-container-faults cpu can't be combined with -memory-high, both move this process")
+		}
+		if c.containerFaults["cpu"] && c.SystemdRun {
+			return fmt.Errorf("-container-faults cpu can't be combined with -systemd-run, the load would leave the scope")
+		}
+	}
+	if c.User != "" {
+		if c.runAs, err = parseRunAs(c.User); err != nil {
+			return err
+		}
+		if err := c.checkRunAs(); err != nil {
+			return err
+		}
+	}
+	if c.SignalStorm != "" {
+		if c.signalStorm, err = parseSignalStorm(c.SignalStorm); err != nil {
+			return err
+		}
+	}
+	if c.consumerTargets, err = parseConsumerTargets(c.ConsumerTargets); err != nil {
+		return err
+	}
+	if c.ClockRate < 0 {
+		return fmt.Errorf("Clock rate must be non-negative")
+	}
+	if (c.clockSkew != 0 || (c.ClockRate > 0 && c.ClockRate != 1)) && c.RunCmd == "" {
+		return fmt.Errorf("-clock-skew and -clock-rate require -run-cmd")
+	}
+
+	// Ensure file path has the safety suffix
+	if c.FilePath != "" && !strings.HasSuffix(c.FilePath, fileSuffix) {
+		c.FilePath = c.FilePath + fileSuffix
+	}
+	return nil
+}
+
+// envPrefix is prepended to the upper-cased flag name to form its environment variable,
+// e.g. -cpu is read from OUTAGEMOCK_CPU and -print-effective-config from OUTAGEMOCK_PRINT_EFFECTIVE_CONFIG
+const envPrefix = "OUTAGEMOCK_"
+
+// Sources a flag value can come from, in increasing order of precedence
+const (
+	sourceDefault = "default"
+	sourceConfig  = "config"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// envName returns the environment variable name for a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applySources fills flags that were not given on the command line from the
+// environment and then from the config file, so that flag > env > config.
+// It returns where each flag's value came from.
+func applySources(fs *flag.FlagSet, configPath string) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = sourceDefault
+	})
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = sourceFlag
+	})
+
+	if configPath != "" {
+		values, err := readConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			if fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("config %s: unknown setting %q", configPath, name)
+			}
+			if sources[name] == sourceFlag {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("config %s: invalid value %q for %s: %v", configPath, value, name, err)
+			}
+			sources[name] = sourceConfig
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || sources[f.Name] == sourceFlag || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+			return
+		}
+		sources[f.Name] = sourceEnv
+	})
+	return sources, err
+}
+
+// readConfigFile reads a JSON object mapping flag names to values, e.g. {"cpu": 50, "fsize": "1G"}
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	defer file.Close()
+
+	values, err := decodeSettings(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// decodeSettings decodes a JSON object of flag values into their string form
+func decodeSettings(r io.Reader) (map[string]string, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		values[name] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// printEffectiveConfig writes every flag with its merged value and source
+func printEffectiveConfig(w io.Writer, fs *flag.FlagSet, sources map[string]string) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Effective configuration:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-24s %-32s (%s)\n", name, fs.Lookup(name).Value.String(), sources[name])
+	}
+}
+
+// parseFileSize parses a file size string with units (B, K, M, G, T) into MB
+// Examples: "100M", "1.5G", "500K", "2T"
+func parseFileSize(sizeStr string) (int64, error) {
+	totalBytes, err := parseByteSize(sizeStr)
+	if err != nil {
+		return 0, err
+	}
+
+	// Convert to MB for internal use
+	return totalBytes / (1024 * 1024), nil
+}
+
+// rateUnits are the time units of rates in seconds
+var rateUnits = map[string]float64{"s": 1, "sec": 1, "min": 60, "m": 60, "h": 3600}
+
+// parseRate parses an amount per time unit into the amount per second.
+// parseAmount parses the amount before the slash, example shows a valid rate.
+func parseRate(s, example string, parseAmount func(string) (float64, error)) (float64, error) {
+	amount, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	per := rateUnits[unit]
+	n, err := parseAmount(amount)
+	if !ok || err != nil || n <= 0 || per == 0 {
+		return 0, fmt.Errorf("invalid rate %q (expected e.g. %s)", s, example)
+	}
+	return n / per, nil
+}
+
+// parseByteRate parses a data rate like 10MB/min, 500K/s or 1G/h into bytes per second
+func parseByteRate(s string) (float64, error) {
+	return parseRate(s, "10MB/min, 500K/s or 1G/h", func(amount string) (float64, error) {
+		bytes, err := parseByteSize(amount)
+		return float64(bytes), err
+	})
+}
+
+// parseEventRate parses an event rate like 5/s, 100/min or 10/h into events per second
+func parseEventRate(s string) (float64, error) {
+	return parseRate(s, "5/s or 100/min", func(count string) (float64, error) {
+		return strconv.ParseFloat(count, 64)
+	})
+}
+
+// parseByteSize parses a size string with units (B, K, M, G, T) into bytes.
+// A trailing B after the unit is accepted, e.g. "1GB".
+func parseByteSize(sizeStr string) (int64, error) {
+	if sizeStr == "" {
+		return 0, nil
+	}
+
+	// Regular expression to match number and unit
+	re := regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([BKMGTP]?)B?$`)
+	matches := re.FindStringSubmatch(strings.ToUpper(sizeStr))
+
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("invalid file size format: %s (expected format: number + unit, e.g., 100M, 1.5G)", sizeStr)
+	}
+
+	// Parse the numeric part
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number in file size: %s", matches[1])
+	}
+
+	// Get the unit (default to B if not specified)
+	unit := matches[2]
+	if unit == "" {
+		unit = "B"
+	}
+
+	// Convert to bytes based on unit
+	var multiplier float64
+	switch unit {
+	case "B":
+		multiplier = 1
+	case "K":
+		multiplier = 1024
+	case "M":
+		multiplier = 1024 * 1024
+	case "G":
+		multiplier = 1024 * 1024 * 1024
+	case "T":
+		multiplier = 1024 * 1024 * 1024 * 1024
+	default:
+		return 0, fmt.Errorf("unsupported unit: %s (supported: B, K, M, G, T)", unit)
+	}
+
+	// Calculate total bytes
+	return int64(value * multiplier), nil
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplySourcesPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"cpu": 10, "memory": 100, "fsize": "1G"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OUTAGEMOCK_CPU", "20")
+	t.Setenv("OUTAGEMOCK_MEMORY", "200")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cpu := fs.Float64("cpu", 0, "")
+	memory := fs.Int64("memory", 0, "")
+	fsize := fs.String("fsize", "0", "")
+	duration := fs.String("duration", "30s", "")
+	if err := fs.Parse([]string{"-cpu", "30"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := applySources(fs, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cpu != 30 || sources["cpu"] != sourceFlag {
+		t.Errorf("cpu = %v (%s), want 30 from flag", *cpu, sources["cpu"])
+	}
+	if *memory != 200 || sources["memory"] != sourceEnv {
+		t.Errorf("memory = %v (%s), want 200 from env", *memory, sources["memory"])
+	}
+	if *fsize != "1G" || sources["fsize"] != sourceConfig {
+		t.Errorf("fsize = %v (%s), want 1G from config", *fsize, sources["fsize"])
+	}
+	if *duration != "30s" || sources["duration"] != sourceDefault {
+		t.Errorf("duration = %v (%s), want 30s default", *duration, sources["duration"])
+	}
+}
+
+func TestApplySourcesUnknownConfigKey(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"cpus": 10}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Float64("cpu", 0, "")
+	if _, err := applySources(fs, configPath); err == nil {
+		t.Error("expected error for unknown config key")
+	}
+}
+
+func TestBindFlagsEmbeddedQuota(t *testing.T) {
+	var config Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	bindFlags(fs, &config)
+	if err := fs.Parse([]string{"-cpu", "80", "-max-cpu", "50", "-max-fsize", "1G"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxFileSizeMB != 1024 {
+		t.Errorf("max-fsize = %d MB, want 1024", config.MaxFileSizeMB)
+	}
+	if got := config.capCPU(config.CPUPercent); got != 50 {
+		t.Errorf("capped CPU = %v, want 50", got)
+	}
+	tightened := config.Quota.tighten(Quota{MaxCPUPercent: 70, MaxMemoryMB: 100})
+	if tightened.MaxCPUPercent != 50 || tightened.MaxMemoryMB != 100 || tightened.MaxFileSizeMB != 1024 {
+		t.Errorf("tighten = %+v", tightened)
+	}
+}
+
+// TestCapThreads checks that -max-cpu bounds the threads spinning on their own
+// cores, as -runqueue and -loadavg run them
+func TestCapThreads(t *testing.T) {
+	c, err := configFromSettings(map[string]string{"runqueue": "3x", "max-cpu": "50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.capThreads(24, 8); got != 4 {
+		t.Errorf("capThreads(24, 8) at -max-cpu 50 = %d, want 4", got)
+	}
+	if got := c.capThreads(3, 8); got != 3 {
+		t.Errorf("capThreads(3, 8) at -max-cpu 50 = %d, want 3", got)
+	}
+	if got := (Quota{}).capThreads(24, 8); got != 24 {
+		t.Errorf("capThreads(24, 8) without a cap = %d, want 24", got)
+	}
+}
+
+// TestFlagGroups checks that every Config flag is shown in a group of the help
+// and that the examples of the groups parse
+func TestFlagGroups(t *testing.T) {
+	var config Config
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	bindFlags(fs, &config)
+	fs.VisitAll(func(f *flag.Flag) {
+		if lookupFlagGroup(flagGroupOf(f)) == nil {
+			t.Errorf("flag -%s has no known group", f.Name)
+		}
+	})
+	for _, group := range flagGroups {
+		for _, example := range group.examples {
+			if err := fs.Parse(strings.Fields(example)[1:]); err != nil {
+				t.Errorf("example %q of %s: %v", example, group.name, err)
+			}
+		}
+	}
+}
+
+func TestValidateFilePositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	scenario := "{\n  \"lanes\": [\n    {\"name\": \"a\", \"settings\": {\"cpuu\": 10, \"fsize\": \"2Q\"}}\n  ]\n}\n"
+	if err := os.WriteFile(path, []byte(scenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if n := validateFile(&out, path, nil); n != 2 {
+		t.Fatalf("validateFile found %d problems, want 2:\n%s", n, out.String())
+	}
+	for _, want := range []string{path + ":3:32: unknown setting", path + ":3:53: invalid value"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestValidateYAML checks that YAML files are rejected as such rather than
+// reported as JSON syntax errors
+func TestValidateYAML(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"scenario.yaml": `{"lanes": [{"name": "a", "settings": {"cpu": 10}}]}`,
+		"scenario.json": "lanes:\n  - name: a\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		if n := validateFile(&out, path, nil); n != 1 || !strings.Contains(out.String(), "JSON only") {
+			t.Errorf("validateFile(%s) found %d problems: %s", name, n, out.String())
+		}
+	}
+}
+
+// TestLoadScenarioYAML checks that scenario and test reject YAML scenarios
+func TestLoadScenarioYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.yml")
+	if err := os.WriteFile(path, []byte("lanes:\n  - name: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadScenario(path, nil, nil); err == nil || !strings.Contains(err.Error(), "JSON only") {
+		t.Errorf("loadScenario(%s) = %v, want a JSON only error", path, err)
+	}
+}
+
+func TestExpandScenario(t *testing.T) {
+	vars := scenarioVars{"CPU": "40", "MSG": `say "hi" \ now`}
+	data := []byte(`{"cpu": ${CPU}, "run-cmd": "echo ${MSG}", "fpath": "${DIR:-/tmp}/x"}`)
+	expanded, err := expandScenario(data, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(expanded, &got); err != nil {
+		t.Fatalf("expanded %s: %v", expanded, err)
+	}
+	if got["cpu"] != 40.0 || got["run-cmd"] != `echo say "hi" \ now` || got["fpath"] != "/tmp/x" {
+		t.Errorf("expanded to %v", got)
+	}
+}
+
+func TestSampleSettings(t *testing.T) {
+	draw := func(seed int64) map[string]string {
+		values := map[string]string{"cpu": "normal(70,10)", "memory": "uniform(2G,6G)", "fsize": "uniform(1G,1G)", "duration": "uniform(1m,2m)", "rampup": "5s"}
+		if _, err := sampleSettings(values, rand.New(rand.NewSource(seed))); err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+	values := draw(7)
+	if again := draw(7); !reflect.DeepEqual(values, again) {
+		t.Errorf("seed 7 drew %v, then %v", values, again)
+	}
+	if memory, _ := strconv.Atoi(values["memory"]); memory < 2048 || memory > 6144 {
+		t.Errorf("memory = %s, want MB between 2G and 6G", values["memory"])
+	}
+	if values["fsize"] != "1024M" || values["rampup"] != "5s" {
+		t.Errorf("fsize = %s, rampup = %s, want 1024M and 5s", values["fsize"], values["rampup"])
+	}
+	if _, err := configFromSettings(values); err != nil {
+		t.Errorf("drawn settings are invalid: %v", err)
+	}
+	if _, err := sampleSettings(map[string]string{"user": "uniform(1,2)"}, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("drawing a string setting succeeded")
+	}
+}
+
+func TestSampleTimeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timeline.jsonl")
+	points := `{"offset_ns":0,"cpu":"normal(95,10)","memory_mb":"uniform(1G,1G)"}
+{"offset_ns":60000000000,"cpu":"normal(95,10)","file_mb":10}
+`
+	if err := os.WriteFile(path, []byte(points), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(1); seed <= 50; seed++ {
+		timeline, samples, err := loadSampledTimeline(path, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range timeline {
+			if p.CPUPercent < 0 || p.CPUPercent > 100 {
+				t.Fatalf("seed %d drew cpu %g, want it clamped to 0-100", seed, p.CPUPercent)
+			}
+		}
+		if timeline[0].MemoryMB != 1024 || timeline[1].FileSizeMB != 10 || len(samples) != 3 {
+			t.Fatalf("seed %d: points %+v, samples %v", seed, timeline, samples)
+		}
+	}
+	if _, err := loadTimeline(path); err == nil {
+		t.Error("a timeline with distributions loaded outside a scenario")
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want float64
+	}{
+		{"60/min", 1}, {"5/s", 5}, {"3600/h", 1}, {"0/s", -1}, {"5", -1}, {"5/day", -1}, {"x/s", -1},
+	} {
+		got, err := parseEventRate(tc.s)
+		if tc.want < 0 && err == nil || tc.want >= 0 && (err != nil || got != tc.want) {
+			t.Errorf("parseEventRate(%q) = %g, %v, want %g", tc.s, got, err, tc.want)
+		}
+	}
+	if got, err := parseByteRate("10MB/min"); err != nil || got != 10<<20/60.0 {
+		t.Errorf("parseByteRate(10MB/min) = %g, %v", got, err)
+	}
+	for _, tc := range []struct {
+		s    string
+		want FileRate
+	}{
+		{"50MB/s burst=200MB", FileRate{Rate: 50 << 20, Burst: 200 << 20}},
+		{"60M/min", FileRate{Rate: 1 << 20, Burst: 1 << 20}},
+		{"1M,rampup", FileRate{Rate: 1 << 20, Burst: 1 << 20, Rampup: true}},
+	} {
+		if got, err := parseFileRate(tc.s); err != nil || got != tc.want {
+			t.Errorf("parseFileRate(%q) = %+v, %v, want %+v", tc.s, got, err, tc.want)
+		}
+	}
+	for _, s := range []string{"rampup", "burst=1M", "50MB/s,cap"} {
+		if _, err := parseFileRate(s); err == nil {
+			t.Errorf("parseFileRate(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseSignalStormOwnProcess(t *testing.T) {
+	child := exec.Command("sleep", "10")
+	if err := child.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer child.Process.Kill()
+	for _, pid := range []int{os.Getpid(), child.Process.Pid} {
+		if _, err := parseSignalStorm("pid=" + strconv.Itoa(pid)); err == nil {
+			t.Errorf("parseSignalStorm accepted pid %d of this process or its child", pid)
+		}
+	}
+	if _, err := parseSignalStorm("pid=" + strconv.Itoa(os.Getppid())); err != nil && os.Getppid() > 1 {
+		t.Errorf("parseSignalStorm rejected the parent: %v", err)
+	}
+}
+
+func TestLoadModesAreFlags(t *testing.T) {
+	var config Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	bindFlags(fs, &config)
+	for _, name := range loadModes {
+		if fs.Lookup(name) == nil {
+			t.Errorf("load mode %q is not a flag", name)
+		}
+	}
+}
+
+func TestLoadMarkovChain(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, data string
+		ok         bool
+	}{
+		{"valid", `{"initial":"quiet","transition":"30s","states":{"quiet":{"cpu":10,"dwell":"1m-2m","next":{"busy":1}},"busy":{"cpu":90,"memory_mb":512,"dwell":"30s","next":{"quiet":1}}}}`, true},
+		{"yaml", "initial: quiet\nstates:\n  quiet: {cpu: 10}\n", false},
+		{"unknown field", `{"initial":"quiet","states":{"quiet":{"cpu":10,"dwell":"1m","next":{"quiet":1},"mem":1}}}`, false},
+		{"unknown initial", `{"initial":"idle","states":{"quiet":{"dwell":"1m","next":{"quiet":1}}}}`, false},
+		{"bad transition", `{"initial":"quiet","transition":"soon","states":{"quiet":{"dwell":"1m","next":{"quiet":1}}}}`, false},
+		{"cpu above 100", `{"initial":"quiet","states":{"quiet":{"cpu":101,"dwell":"1m","next":{"quiet":1}}}}`, false},
+		{"reversed dwell", `{"initial":"quiet","states":{"quiet":{"dwell":"2m-1m","next":{"quiet":1}}}}`, false},
+		{"no dwell", `{"initial":"quiet","states":{"quiet":{"next":{"quiet":1}}}}`, false},
+		{"unknown next", `{"initial":"quiet","states":{"quiet":{"dwell":"1m","next":{"busy":1}}}}`, false},
+		{"negative weight", `{"initial":"quiet","states":{"quiet":{"dwell":"1m","next":{"quiet":-1}}}}`, false},
+		{"zero weights", `{"initial":"quiet","states":{"quiet":{"dwell":"1m","next":{"quiet":0}}}}`, false},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "-")+".json")
+		if err := os.WriteFile(path, []byte(tc.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		chain, err := loadMarkovChain(path)
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: loadMarkovChain error %v, want ok %v", tc.name, err, tc.ok)
+		}
+		if tc.ok && (chain.States["quiet"].minDwell != time.Minute || chain.States["quiet"].maxDwell != 2*time.Minute) {
+			t.Errorf("%s: dwell of quiet %v-%v, want 1m0s-2m0s", tc.name, chain.States["quiet"].minDwell, chain.States["quiet"].maxDwell)
+		}
+	}
+}
+
+func TestMarkovWalk(t *testing.T) {
+	chain := MarkovChain{Initial: "quiet", Transition: "10s", States: map[string]MarkovState{
+		"quiet": {CPUPercent: 10, minDwell: time.Minute, maxDwell: 2 * time.Minute, Next: map[string]float64{"busy": 1}, next: []string{"busy"}},
+		"busy":  {CPUPercent: 90, MemoryMB: 512, minDwell: 30 * time.Second, maxDwell: 30 * time.Second, Next: map[string]float64{"quiet": 1}, next: []string{"quiet"}},
+	}}
+	visits, timeline := chain.walk(time.Hour, rand.New(rand.NewSource(1)))
+	if len(visits) < 2 || visits[0] != (markovVisit{0, "quiet"}) {
+		t.Fatalf("visits start with %+v, want quiet at 0", visits)
+	}
+	for i := 1; i < len(visits); i++ {
+		prev, visit := visits[i-1], visits[i]
+		if want := chain.States[prev.State].next[0]; visit.State != want {
+			t.Errorf("visit %d is %s after %s, want %s", i, visit.State, prev.State, want)
+		}
+		state := chain.States[prev.State]
+		if dwell := visit.Offset - prev.Offset; dwell < state.minDwell || dwell > state.maxDwell {
+			t.Errorf("visit %d of %s lasted %v, outside %v-%v", i-1, prev.State, dwell, state.minDwell, state.maxDwell)
+		}
+	}
+	if last := visits[len(visits)-1].Offset; last >= time.Hour {
+		t.Errorf("last visit starts at %v, after the run", last)
+	}
+	if peak := timeline.Peak(); peak.CPUPercent != 90 || peak.MemoryMB != 512 {
+		t.Errorf("timeline peak %+v, want cpu 90 and memory 512", peak)
+	}
+	again, _ := chain.walk(time.Hour, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(visits, again) {
+		t.Errorf("the same seed walked %v and %v", visits, again)
+	}
+}
+
+func TestParseCoWFork(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want CoWFork
+		ok   bool
+	}{
+		{"children=8", CoWFork{Size: 256 << 20, Children: 8, Write: 1}, true},
+		{"size=1G,children=2,write=50%", CoWFork{Size: 1 << 30, Children: 2, Write: 0.5}, true},
+		{"size=4K", CoWFork{Size: 4 << 10, Children: 4, Write: 1}, true},
+		{"size=1K", CoWFork{}, false},
+		{"children=0", CoWFork{}, false},
+		{"children=257", CoWFork{}, false},
+		{"write=0%", CoWFork{}, false},
+		{"write=150%", CoWFork{}, false},
+		{"forks=2", CoWFork{}, false},
+		{"size", CoWFork{}, false},
+	} {
+		got, err := parseCoWFork(tc.s)
+		if tc.ok != (err == nil) || got != tc.want {
+			t.Errorf("parseCoWFork(%q) = %+v, %v, want %+v, ok %v", tc.s, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseLLCThrash(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want LLCThrash
+		ok   bool
+	}{
+		{"size=32MB,threads=2,duty=50%", LLCThrash{Size: 32 << 20, Threads: 2, Duty: 0.5}, true},
+		{"size=1M,duty=100", LLCThrash{Size: 1 << 20, Threads: 1, Duty: 1}, true},
+		{"size=64", LLCThrash{}, false},
+		{"size=1M,threads=0", LLCThrash{}, false},
+		{"size=1M,duty=0%", LLCThrash{}, false},
+		{"size=1M,duty=101%", LLCThrash{}, false},
+		{"size=1M,ways=8", LLCThrash{}, false},
+	} {
+		got, err := parseLLCThrash(tc.s)
+		if tc.ok != (err == nil) || got != tc.want {
+			t.Errorf("parseLLCThrash(%q) = %+v, %v, want %+v, ok %v", tc.s, got, err, tc.want, tc.ok)
+		}
+	}
+	if got, err := parseLLCThrash("threads=1"); err != nil || got.Size != 2*lastLevelCacheBytes() {
+		t.Errorf("parseLLCThrash without size = %+v, %v, want twice the last-level cache", got, err)
+	}
+}
+
+func TestParseTLBPressure(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want TLBPressure
+		ok   bool
+	}{
+		{"span=64G", TLBPressure{Span: 64 << 30, Pages: 32768, Threads: 1, Duty: 1}, true},
+		{"span=1G,pages=100,threads=2,duty=50%", TLBPressure{Span: 1 << 30, Pages: 100, Threads: 2, Duty: 0.5}, true},
+		{"span=1M", TLBPressure{}, false},
+		{"span=4M,pages=3", TLBPressure{}, false},
+		{"span=1G,threads=0", TLBPressure{}, false},
+		{"span=1G,duty=0", TLBPressure{}, false},
+		{"span=1G,huge=1", TLBPressure{}, false},
+	} {
+		got, err := parseTLBPressure(tc.s)
+		if tc.ok != (err == nil) || got != tc.want {
+			t.Errorf("parseTLBPressure(%q) = %+v, %v, want %+v, ok %v", tc.s, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestDurationRangeValue(t *testing.T) {
+	for _, tc := range []struct {
+		s              string
+		d, spread      time.Duration
+		ok             bool
+		formattedAgain string
+	}{
+		{"30s", 30 * time.Second, 0, true, "30s"},
+		{"20m±10m", 20 * time.Minute, 10 * time.Minute, true, "20m0s±10m0s"},
+		{"20m+-10m", 20 * time.Minute, 10 * time.Minute, true, "20m0s±10m0s"},
+		{"20m ± 5m", 20 * time.Minute, 5 * time.Minute, true, "20m0s±5m0s"},
+		{"20m±20m", 0, 0, false, ""},
+		{"20m±-1m", 0, 0, false, ""},
+		{"20m±soon", 0, 0, false, ""},
+		{"later", 0, 0, false, ""},
+	} {
+		var d, spread time.Duration
+		v := &durationRangeValue{d: &d, spread: &spread}
+		err := v.Set(tc.s)
+		if tc.ok != (err == nil) {
+			t.Errorf("Set(%q) error %v, want ok %v", tc.s, err, tc.ok)
+			continue
+		}
+		if tc.ok && (d != tc.d || spread != tc.spread || v.String() != tc.formattedAgain) {
+			t.Errorf("Set(%q) = %v±%v formatted %q, want %v±%v formatted %q", tc.s, d, spread, v.String(), tc.d, tc.spread, tc.formattedAgain)
+		}
+	}
+
+	// validate draws within the range and keeps the draw when validating again
+	c, err := configFromSettings(map[string]string{"duration": "20m±10m", "seed": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawn := c.Duration
+	if drawn < 10*time.Minute || drawn > 30*time.Minute || c.durationRange != "20m0s±10m0s" {
+		t.Errorf("drew %v from %s, want 10m-30m from 20m0s±10m0s", drawn, c.durationRange)
+	}
+	if err := c.validate(); err != nil || c.Duration != drawn {
+		t.Errorf("validating again moved the duration from %v to %v: %v", drawn, c.Duration, err)
+	}
+}
+
+func TestParseCorrelations(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []Correlation
+	}{
+		{"memory=cpu*40+512", []Correlation{{Target: "memory", Source: "cpu", Scale: 40, Offset: 512}}},
+		{"file=cpu@30s*20", []Correlation{{Target: "file", Source: "cpu", Delay: 30 * time.Second, Scale: 20}}},
+		{"cpu=memory-10, file=memory", []Correlation{
+			{Target: "cpu", Source: "memory", Scale: 1, Offset: -10},
+			{Target: "file", Source: "memory", Scale: 1},
+		}},
+		{"memory=memory*2", nil},
+		{"memory=cpu,memory=file", nil},
+		{"memory=cpu,cpu=file", nil},
+		{"memory=cpu@soon", nil},
+		{"memory=disk*2", nil},
+		{"memory", nil},
+	} {
+		got, err := parseCorrelations(tc.s)
+		if (tc.want == nil) != (err != nil) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseCorrelations(%q) = %+v, %v, want %+v", tc.s, got, err, tc.want)
+		}
+	}
+	c := Correlation{Target: "cpu", Source: "memory", Scale: 0.1, Offset: -5}
+	for source, want := range map[float64]float64{0: 0, 100: 5, 2000: 100} {
+		if got := c.value(source); got != want {
+			t.Errorf("cpu derived from memory %g is %g, want %g", source, got, want)
+		}
+	}
+}
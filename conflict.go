@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// conflictPolicies are the values of -on-conflict and the on_conflict parameter of /run
+var conflictPolicies = []string{"reject", "queue"}
+
+// agentRun is an experiment of the agent, running or queued
+type agentRun struct {
+	id      int
+	owner   string   // Who asked for it, from the owner parameter of /run
+	classes []string // Resource classes it loads, see resourceClasses
+	config  Config
+	rm      *ResourceMock // Set once started
+	stop    chan os.Signal
+}
+
+// experimentStatus describes an experiment of the agent in its status
+type experimentStatus struct {
+	ID       int        `json:"id"`
+	Owner    string     `json:"owner,omitempty"`
+	Classes  []string   `json:"classes"`
+	Deadline *time.Time `json:"deadline,omitempty"` // Running experiments only
+}
+
+// resourceClasses returns the resource classes an experiment loads.
+// Experiments sharing a class would skew each other's results, so the agent
+// doesn't run them at the same time; consumers registered with -consumer are
+// classes of their own. Experiments with a cgroup of the run share the class
+// cgroup: -memory-high and -io-throttle move the whole agent into it.
+func resourceClasses(c Config) []string {
+	var classes []string
+	add := func(class string, loaded bool) {
+		if loaded {
+			classes = append(classes, class)
+		}
+	}
+	add("cpu", c.CPUPercent > 0 || c.LoadAvg > 0 || c.Runqueue != "" || c.Softirq != "" || c.Governor != "" || c.LLCThrash != "" || c.psiTargets["cpu"] > 0)
+	add("memory", c.MemoryMB > 0 || c.FaultRate != "" || c.TLBPressure != "" || c.CoWFork != "" || c.MemoryHigh != "")
+	add("disk", c.FileSizeMB > 0 || c.Tree != "" || c.FillAllMounts != "" || c.BenchDisk || c.IOThrottle != "" || c.LogFlood != "" || c.FuseMount != "" || c.DMDevice != "" || c.psiTargets["io"] > 0)
+	add("network", c.S3Endpoint != "" || c.ConnTarget != "")
+	add("kernel", c.Kmem != "" || c.UnixObjects != "")
+	add("logs", c.InjectLog != "")
+	add("signals", c.SignalStorm != "")
+	add("freeze", c.FreezeCgroup != "")
+	add("cgroup", c.IOThrottle != "" || c.MemoryHigh != "")
+	for _, name := range consumerNames(c.consumerTargets) {
+		add(name, true)
+	}
+	return classes
+}
+
+// overlap returns the classes of run that other loads too
+func (run *agentRun) overlap(other *agentRun) []string {
+	var shared []string
+	for _, class := range run.classes {
+		if slices.Contains(other.classes, class) {
+			shared = append(shared, class)
+		}
+	}
+	return shared
+}
+
+// conflict returns the first of runs sharing a class with run
+func (run *agentRun) conflict(runs []*agentRun) *agentRun {
+	for _, other := range runs {
+		if len(run.overlap(other)) > 0 {
+			return other
+		}
+	}
+	return nil
+}
+
+// describeConflict explains why run can't start alongside other
+func (run *agentRun) describeConflict(other *agentRun) string {
+	owner := ""
+	if other.owner != "" {
+		owner = " of " + other.owner
+	}
+	s := fmt.Sprintf("conflicts with experiment %d%s on %s", other.id, owner, strings.Join(run.overlap(other), ", "))
+	if other.rm == nil {
+		s += ", which is queued"
+	} else if deadline := other.rm.publicDeadline(); deadline != nil {
+		s += " until " + deadline.Format(time.TimeOnly)
+	}
+	return s
+}
+
+// status describes the experiment
+func (run *agentRun) status() experimentStatus {
+	status := experimentStatus{ID: run.id, Owner: run.owner, Classes: run.classes}
+	if run.rm != nil {
+		status.Deadline = run.rm.publicDeadline()
+	}
+	return status
+}
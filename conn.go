@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dials started per tick of the connection pool, so a reconnect wave arrives as
+// a burst without one tick spawning thousands of goroutines
+const maxDialsPerTick = 256
+
+// ConnChurn describes the waves of -conn-churn: every Every, Drop of the held
+// connections are closed and reopened together after Pause
+type ConnChurn struct {
+	Every time.Duration
+	Drop  float64 // Fraction of the held connections
+	Pause time.Duration
+}
+
+// parseConnChurn parses a list like "every=30s,drop=50%,pause=2s".
+// drop defaults to 100% and pause to 0, reconnecting at once.
+func parseConnChurn(s string) (ConnChurn, error) {
+	churn := ConnChurn{Drop: 1}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return ConnChurn{}, fmt.Errorf("invalid connection churn setting %q (expected key=value)", item)
+		}
+		var err error
+		switch key {
+		case "every":
+			churn.Every, err = time.ParseDuration(value)
+		case "drop":
+			churn.Drop, err = parsePercent(value)
+		case "pause":
+			churn.Pause, err = time.ParseDuration(value)
+		default:
+			return ConnChurn{}, fmt.Errorf("unknown connection churn setting %q (supported: every, drop, pause)", key)
+		}
+		if err != nil {
+			return ConnChurn{}, fmt.Errorf("invalid connection churn setting %q: %v", item, err)
+		}
+	}
+	if churn.Every <= 0 || churn.Pause < 0 || churn.Pause >= churn.Every {
+		return ConnChurn{}, fmt.Errorf("invalid connection churn %q: every must be positive and longer than pause", s)
+	}
+	return churn, nil
+}
+
+// parseConnTarget parses -conn-target: tcp://host:port, http://host:port/path,
+// h3://host:port/path or a bare host:port
+func parseConnTarget(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "tcp://" + s
+	}
+	target, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -conn-target: %v", err)
+	}
+	defaultPorts := map[string]string{"tcp": "", "http": "80", "h3": "443"}
+	port, ok := defaultPorts[target.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("invalid -conn-target %q (supported: tcp://host:port, http://host:port/path, h3://host:port/path)", s)
+	}
+	if target.Port() == "" {
+		if port == "" {
+			return nil, fmt.Errorf("invalid -conn-target %q: a port is required", s)
+		}
+		target.Host = net.JoinHostPort(target.Hostname(), port)
+	}
+	return target, nil
+}
+
+// connPayload completes a protocol exchange on a new connection to target,
+// leaving it idle in the state a real client would
+type connPayload func(conn net.Conn, target *url.URL) error
+
+// connPayloads are the values of -conn-payload
+var connPayloads = map[string]connPayload{
+	"http-get":         httpGet,
+	"redis-ping":       redisPing,
+	"postgres-startup": postgresStartup,
+}
+
+// parseConnPayload checks -conn-payload against the scheme of target. http://
+// and h3:// targets always speak HTTP, which is their default.
+func parseConnPayload(name string, target *url.URL) (string, error) {
+	if target.Scheme == "http" || target.Scheme == "h3" {
+		if name != "" && name != "http-get" {
+			return "", fmt.Errorf("-conn-payload %s doesn't match the %s:// -conn-target, use tcp:// for it", name, target.Scheme)
+		}
+		return "http-get", nil
+	}
+	if _, ok := connPayloads[name]; name != "" && !ok {
+		var names []string
+		for name := range connPayloads {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("invalid -conn-payload %q (supported: %s)", name, strings.Join(names, ", "))
+	}
+	return name, nil
+}
+
+// connPool holds idle connections to -conn-target
+type connPool struct {
+	rm      *ResourceMock
+	target  *url.URL
+	payload connPayload // Exchange completed before a connection idles, if any
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	dialing int
+	failed  int64
+	closed  bool
+	lastErr time.Time // When a dial failure was last logged
+}
+
+// consumeConnections opens connections to -conn-target and holds them idle to
+// occupy server workers. The number held grows linearly during rampup and is
+// scaled by host protection and rampdown. With -conn-churn, waves of them are
+// dropped and reopened together like clients failing over between load balancers.
+func (rm *ResourceMock) consumeConnections() {
+	defer rm.wg.Done()
+
+	pool := &connPool{rm: rm, target: rm.config.connTarget, payload: connPayloads[rm.config.connPayload], conns: make(map[net.Conn]struct{})}
+	churn := rm.config.connChurn
+	if pool.payload != nil {
+		log.Printf("Holding up to %d connections to %s, each completing %s first", rm.config.ConnCount, pool.target.Host, rm.config.connPayload)
+	} else {
+		log.Printf("Holding up to %d connections to %s", rm.config.ConnCount, pool.target.Host)
+	}
+
+	var dials sync.WaitGroup
+	defer func() {
+		dials.Wait()
+		pool.closeAll()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	nextWave := rm.rampupStart.Add(churn.Every)
+	held := 0 // Connections kept closed until the end of the wave's pause
+	var reopenAt time.Time
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+			now := rm.clock.Now()
+			if churn.Every > 0 && !now.Before(nextWave) {
+				held = pool.drop(churn.Drop)
+				reopenAt = now.Add(churn.Pause)
+				nextWave = nextWave.Add(churn.Every)
+				log.Printf("Dropped %d connections to %s, reopening them in %v", held, pool.target.Host, churn.Pause)
+			}
+			if !now.Before(reopenAt) {
+				held = 0
+			}
+
+			want := int(math.Round(float64(rm.config.ConnCount)*rm.rampIntensity("conns"))) - held
+
+			open, failed := pool.resize(want)
+			for i := 0; i < open; i++ {
+				dials.Add(1)
+				go func() {
+					defer dials.Done()
+					pool.dial()
+				}()
+			}
+
+			rm.statusMu.Lock()
+			rm.resourceStatus.ConnsOpen = int64(pool.size())
+			rm.resourceStatus.ConnsFailed = failed
+			rm.statusMu.Unlock()
+		}
+	}
+}
+
+// resize closes connections above want and returns how many dials to start to reach it
+func (p *connPool) resize(want int) (open int, failed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		if len(p.conns)+p.dialing <= want {
+			break
+		}
+		delete(p.conns, conn)
+		conn.Close()
+	}
+	open = want - len(p.conns) - p.dialing
+	if open > maxDialsPerTick {
+		open = maxDialsPerTick
+	}
+	if open < 0 {
+		open = 0
+	}
+	p.dialing += open
+	return open, p.failed
+}
+
+// drop closes a fraction of the held connections and returns how many it closed
+func (p *connPool) drop(fraction float64) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := int(math.Round(float64(len(p.conns)) * fraction))
+	dropped := 0
+	// Map iteration order picks a random subset
+	for conn := range p.conns {
+		if dropped == count {
+			break
+		}
+		delete(p.conns, conn)
+		conn.Close()
+		dropped++
+	}
+	return dropped
+}
+
+// size returns the number of connections held
+func (p *connPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// dial opens one connection and holds it until it is closed by either side
+func (p *connPool) dial() {
+	conn, err := p.connect()
+
+	p.mu.Lock()
+	p.dialing--
+	if err != nil {
+		p.failed++
+		// Failures come in bursts when the target is down, log one per second
+		if time.Since(p.lastErr) > time.Second && p.rm.ctx.Err() == nil {
+			p.lastErr = time.Now()
+			log.Printf("Failed to connect to %s: %v", p.target.Host, err)
+		}
+		p.mu.Unlock()
+		return
+	}
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.conns[conn] = struct{}{}
+	p.mu.Unlock()
+
+	// The server closing an idle connection frees its slot for a new one
+	go func() {
+		io.Copy(io.Discard, conn)
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+}
+
+// connect dials the target and completes the payload exchange, if any.
+// h3:// targets complete their GET over QUIC instead.
+func (p *connPool) connect() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(p.rm.ctx, 5*time.Second)
+	defer cancel()
+	if p.target.Scheme == "h3" {
+		return dialH3(ctx, p.rm.config.dial, p.target)
+	}
+	conn, err := p.rm.config.dial(ctx, "tcp", p.target.Host)
+	if err != nil || p.payload == nil {
+		return conn, err
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := p.payload(conn, p.target); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpGet completes a keep-alive request so the connection sits idle in the
+// server's keep-alive pool. tcp:// targets get the root path.
+func httpGet(conn net.Conn, target *url.URL) error {
+	u := *target
+	u.Scheme = "http"
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("User-Agent", "outagemock")
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.Close {
+		return fmt.Errorf("server does not keep connections alive")
+	}
+	return nil
+}
+
+// redisPing sends PING as a RESP array, like client libraries do, and reads
+// the reply. An error reply such as NOAUTH still came from a Redis server.
+func redisPing(conn net.Conn, target *url.URL) error {
+	if _, err := io.WriteString(conn, "*1\r\n$4\r\nPING\r\n"); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read PING reply: %w", err)
+	}
+	if !strings.HasPrefix(reply, "+") && !strings.HasPrefix(reply, "-") {
+		return fmt.Errorf("unexpected PING reply %q", strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// postgresStartup sends a protocol 3.0 startup message for user and database
+// outagemock and reads the first reply. The server then waits for the
+// password, or for a query under trust authentication, until its
+// authentication_timeout closes the connection.
+func postgresStartup(conn net.Conn, target *url.URL) error {
+	params := "user\x00outagemock\x00database\x00outagemock\x00application_name\x00outagemock\x00\x00"
+	msg := binary.BigEndian.AppendUint32(nil, uint32(8+len(params)))
+	msg = binary.BigEndian.AppendUint32(msg, 3<<16)
+	if _, err := conn.Write(append(msg, params...)); err != nil {
+		return err
+	}
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("read startup reply: %w", err)
+	}
+	switch header[0] {
+	case 'R': // Authentication request
+		return nil
+	case 'E':
+		// Fields of a type byte and a string each, M holding the message
+		body := make([]byte, min(binary.BigEndian.Uint32(header[1:])-4, 4096))
+		io.ReadFull(conn, body)
+		for _, field := range bytes.Split(body, []byte{0}) {
+			if len(field) > 1 && field[0] == 'M' {
+				return fmt.Errorf("server refused the startup message: %s", field[1:])
+			}
+		}
+		return fmt.Errorf("server refused the startup message")
+	}
+	return fmt.Errorf("unexpected startup reply type %q", header[0])
+}
+
+// closeAll closes every held connection and refuses new ones
+func (p *connPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// consoleHelp lists the commands of the interactive console
+const consoleHelp = `Commands:
+  cpu 90, cpu +10           set or move the CPU target in percent
+  mem 2048, mem -500        set or move the memory target in MB
+  pause, resume             release all load, and take it back up
+  disable file,memory       turn consumers off; enable turns them back on
+  extend 10m, extend -5m    move the end of the run
+  status                    print the current status
+  end                       ramp down over -rampdown and end
+  stop                      stop at once
+  help                      print this help`
+
+// runConsole lets an operator at the keyboard steer the run by typing
+// commands, the same operations the agent API offers. stop receives SIGTERM
+// for the stop command. It returns when in is closed; a read pending at the
+// end of the run is abandoned with the process.
+func (rm *ResourceMock) runConsole(in io.Reader, out io.Writer, stop chan<- os.Signal) {
+	fmt.Fprintln(out, "Type commands to steer the run, help lists them")
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if rm.ctx.Err() != nil {
+			return
+		}
+		reply, err := rm.consoleCommand(scanner.Text(), stop)
+		if err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		} else if reply != "" {
+			fmt.Fprintln(out, reply)
+		}
+	}
+}
+
+// consoleCommand executes one line typed into the console and returns what to print
+func (rm *ResourceMock) consoleCommand(line string, stop chan<- os.Signal) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	name, args := strings.ToLower(fields[0]), fields[1:]
+	want := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s takes %d argument(s), help lists the commands", name, n)
+		}
+		return nil
+	}
+
+	switch name {
+	case "cpu", "mem", "memory":
+		if err := want(1); err != nil {
+			return "", err
+		}
+		target, err := rm.AdjustTarget(name, args[0])
+		if err != nil {
+			return "", err
+		}
+		if name == "cpu" {
+			return fmt.Sprintf("CPU target %.1f%%", target), nil
+		}
+		return fmt.Sprintf("Memory target %.0f MB", target), nil
+	case "pause", "resume":
+		if err := want(0); err != nil {
+			return "", err
+		}
+		rm.SetPaused(name == "pause")
+		return "", nil
+	case "disable", "enable":
+		if err := want(1); err != nil {
+			return "", err
+		}
+		resources := strings.Split(args[0], ",")
+		for _, resource := range resources {
+			if err := rm.checkToggle(resource); err != nil {
+				return "", err
+			}
+		}
+		for _, resource := range resources {
+			rm.SetEnabled(resource, name == "enable")
+		}
+		return "", nil
+	case "extend":
+		if err := want(1); err != nil {
+			return "", err
+		}
+		d, err := parseExtend(args[0])
+		if err != nil {
+			return "", err
+		}
+		deadline := rm.Extend(d)
+		if rm.config.hidesEnd() {
+			return "Run end moved by " + d.String(), nil
+		}
+		return "Run now ends at " + deadline.Format(time.TimeOnly), nil
+	case "status":
+		return rm.consoleStatus(), nil
+	case "end":
+		deadline := rm.EndGraceful()
+		if rm.config.hidesEnd() {
+			return "Ramping down", nil
+		}
+		return "Ramping down, ending at " + deadline.Format(time.TimeOnly), nil
+	case "stop":
+		select {
+		case stop <- syscall.SIGTERM:
+		default:
+		}
+		return "", nil
+	case "help", "?":
+		return consoleHelp, nil
+	default:
+		return "", fmt.Errorf("unknown command %q, help lists the commands", name)
+	}
+}
+
+// consoleStatus summarizes the run for the status command
+func (rm *ResourceMock) consoleStatus() string {
+	status := rm.Status()
+	cpu, memoryMB := rm.currentTargets()
+	var parts []string
+	if rm.config.CPUPercent > 0 {
+		parts = append(parts, fmt.Sprintf("CPU %.1f%% (target %.1f%%)", status.CPUPercent, cpu))
+	}
+	if rm.config.MemoryMB > 0 {
+		parts = append(parts, fmt.Sprintf("memory %d MB (target %d MB)", status.MemoryActualMB, memoryMB))
+	}
+	if rm.config.FileSizeMB > 0 {
+		parts = append(parts, fmt.Sprintf("file %d/%d MB", status.FileActualMB, status.FileTargetMB))
+	}
+	if status.Throttle < 1 {
+		parts = append(parts, fmt.Sprintf("throttled to %.0f%%", status.Throttle*100))
+	}
+	if status.Paused {
+		parts = append(parts, "paused")
+	}
+	if len(status.Disabled) > 0 {
+		parts = append(parts, "disabled: "+strings.Join(status.Disabled, ","))
+	}
+	if !rm.config.hidesEnd() {
+		parts = append(parts, "ends at "+rm.Deadline().Format(time.TimeOnly))
+	}
+	lines := []string{strings.Join(parts, ", ")}
+	// The last 2 minutes, a bar per sample up to the full target
+	for _, r := range trendResources {
+		if line, ok := status.Sparklines[r.name]; ok {
+			lines = append(lines, fmt.Sprintf("  %-7s%s", r.name, line))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
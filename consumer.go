@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"outagemock/consumer"
+)
+
+// consumerTick is how often registered consumers get a new target
+const consumerTick = 100 * time.Millisecond
+
+// parseConsumerTargets parses -consumer targets like "gpu=80,fpga=50" for registered consumers
+func parseConsumerTargets(s string) (map[string]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	targets := map[string]float64{}
+	for _, item := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid consumer target %q (expected <consumer>=<target>)", item)
+		}
+		if _, ok := consumer.Lookup(name); !ok {
+			registered := strings.Join(consumer.Names(), ", ")
+			if registered == "" {
+				registered = "none"
+			}
+			return nil, fmt.Errorf("unknown consumer %q (registered: %s)", name, registered)
+		}
+		target, err := strconv.ParseFloat(value, 64)
+		if err != nil || target < 0 {
+			return nil, fmt.Errorf("invalid target %q for consumer %s", value, name)
+		}
+		targets[name] = target
+	}
+	return targets, nil
+}
+
+// consumerNames returns the consumers of -consumer in order
+func consumerNames(targets map[string]float64) []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runConsumers starts the consumers of -consumer and feeds them their targets until the run ends
+func (rm *ResourceMock) runConsumers() {
+	defer rm.wg.Done()
+
+	running := map[string]consumer.Consumer{}
+	for _, name := range consumerNames(rm.config.consumerTargets) {
+		factory, _ := consumer.Lookup(name)
+		c, err := factory()
+		if err == nil {
+			err = c.Start(rm.ctx)
+		}
+		if err != nil {
+			rm.markDegraded(name, err)
+			continue
+		}
+		running[name] = c
+	}
+	defer func() {
+		for name, c := range running {
+			c.Stop()
+			log.Printf("Stopped consumer %s", name)
+		}
+	}()
+
+	ticker := rm.clock.NewTicker(consumerTick)
+	defer ticker.Stop()
+	reported := map[string]bool{} // Consumers that gave up, reported once
+	for {
+		statuses := make(map[string]consumer.Status, len(running))
+		for name, c := range running {
+			c.SetTarget(rm.config.consumerTargets[name] * rm.rampIntensity(name))
+			statuses[name] = c.Status()
+			if statuses[name].Degraded && !reported[name] {
+				reported[name] = true
+				rm.markDegraded(name, fmt.Errorf("consumer %s gave up", name))
+			}
+		}
+		rm.statusMu.Lock()
+		rm.resourceStatus.Consumers = statuses
+		rm.statusMu.Unlock()
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// builtinConsumer is the CPU, memory or file load of the run as a Consumer,
+// so the run starts them like the consumers of -consumer. They shape their
+// targets themselves, following rampup, timelines and correlations, so
+// SetTarget moves the target they ramp toward, like AdjustTarget; they end
+// with the context of the run.
+type builtinConsumer struct {
+	rm   *ResourceMock
+	name string
+}
+
+// builtinConsumers returns the built-in loads the run has targets for
+func (rm *ResourceMock) builtinConsumers() []consumer.Consumer {
+	var loads []consumer.Consumer
+	for _, load := range []struct {
+		name   string
+		active bool
+	}{
+		{"memory", rm.config.MemoryMB > 0},
+		{"file", rm.config.FileSizeMB > 0},
+		{"cpu", rm.config.CPUPercent > 0},
+	} {
+		if load.active {
+			loads = append(loads, &builtinConsumer{rm: rm, name: load.name})
+		}
+	}
+	return loads
+}
+
+func (b *builtinConsumer) Start(ctx context.Context) error {
+	b.rm.wg.Add(1)
+	switch b.name {
+	case "cpu":
+		go b.rm.consumeCPU()
+	case "memory":
+		go b.rm.consumeMemory()
+	case "file":
+		go b.rm.consumeFile()
+	}
+	return nil
+}
+
+func (b *builtinConsumer) SetTarget(target float64) {
+	t := &b.rm.targets
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch b.name {
+	case "cpu":
+		t.cpu = target
+		b.rm.startBurnSupervisor(target)
+	case "memory":
+		t.memoryMB = int64(target)
+	case "file":
+		t.fileMB = int64(target)
+	}
+}
+
+func (b *builtinConsumer) Status() consumer.Status {
+	status := b.rm.Status()
+	switch b.name {
+	case "cpu":
+		actual := 0.0
+		for _, percent := range status.WorkerCPU {
+			actual += percent
+		}
+		return consumer.Status{Unit: "%", Target: status.CPUPercent, Actual: actual / b.rm.cpuPool.basis}
+	case "memory":
+		return consumer.Status{Unit: "MB", Target: float64(status.MemoryTargetMB), Actual: float64(status.MemoryActualMB), Degraded: status.MemoryDegraded}
+	default:
+		return consumer.Status{Unit: "MB", Target: float64(status.FileTargetMB), Actual: float64(status.FileActualMB), Degraded: status.FileDegraded}
+	}
+}
+
+func (b *builtinConsumer) Stop() {}
@@ -0,0 +1,85 @@
+// Package consumer is the extension point of outagemock for new kinds of
+// load, e.g. on a GPU or an FPGA. A consumer implements Consumer and
+// registers a factory under its name from an init function; -consumer
+// name=target then drives it with the same rampup, host protection and
+// rampdown as the built-in CPU, memory and file load, which implement
+// Consumer too.
+//
+// Consumers living outside of outagemock are linked in with a blank import
+// from a file of package main, usually behind a build tag like gpu.go:
+//
+//	//go:build fpga
+//
+//	package main
+//
+//	import _ "example.com/fpga/outagemock"
+package consumer
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Consumer generates one kind of load. The run drives it on its own
+// schedule: SetTarget receives the target of -consumer ramped up, throttled
+// by host protection and ramped down like every other resource, in the
+// consumer's own unit. The built-in consumers ramp their load themselves, their
+// SetTarget takes the configured target like the /target API.
+type Consumer interface {
+	// Start prepares the load; it is called once before the first SetTarget
+	Start(ctx context.Context) error
+	// SetTarget adjusts the load, it must not block
+	SetTarget(target float64)
+	// Status reports the load for the display and /status
+	Status() Status
+	// Stop releases everything the consumer holds
+	Stop()
+}
+
+// Status is the load reported by a Consumer
+type Status struct {
+	Unit     string  `json:"unit"`
+	Target   float64 `json:"target"`
+	Actual   float64 `json:"actual"`
+	Degraded bool    `json:"degraded,omitempty"` // The consumer gave up before reaching the target
+}
+
+// Factory creates a consumer for a run
+type Factory func() (Consumer, error)
+
+var (
+	mu        sync.Mutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a consumer available to -consumer under name. It is meant
+// to be called from init functions and panics on duplicate names.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("consumer: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Lookup returns the factory registered under name
+func Lookup(name string) (Factory, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names returns the names of the registered consumers, sorted
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+const (
+	containerFilePath      = "/tmp/outagemock_temp_file" // Default -fpath with -container-mode
+	containerCleanupMargin = 5 * time.Second             // Part of the grace period kept for cleanup
+)
+
+// applyContainerDefaults adjusts defaults for running as a container entrypoint:
+// JSON logs, work files on the ephemeral /tmp and a rampdown that fits into
+// the termination grace period. sources tells which settings were left at their default.
+func (c *Config) applyContainerDefaults(sources map[string]string) {
+	if !c.ContainerMode {
+		return
+	}
+	if sources["output"] == sourceDefault {
+		c.Output = "json"
+	}
+	if sources["fpath"] == sourceDefault {
+		c.FilePath = containerFilePath
+	}
+	limit := c.GracePeriod - containerCleanupMargin
+	if limit < 0 {
+		limit = 0
+	}
+	if c.Rampdown > limit {
+		c.Rampdown = limit
+	}
+	if c.GracefulRampdown > limit {
+		c.GracefulRampdown = limit
+	}
+}
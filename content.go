@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes of -file-content
+const (
+	contentZeros        = "zeros"        // All zero bytes, compressed or deduplicated away by most filesystems
+	contentPattern      = "pattern"      // A repeating byte sequence, highly compressible
+	contentRandom       = "random"       // Fresh random bytes for every chunk, incompressible
+	contentCompressible = "compressible" // Random and zero bytes mixed for a compression ratio
+)
+
+// fileContent fills the buffers written to the work file
+type fileContent struct {
+	mode   string
+	ratio  float64 // Targeted compression ratio of compressible content
+	rng    *rand.Rand
+	filled bool // Static content has been written to the buffer
+}
+
+// parseFileContent parses -file-content: zeros, pattern, random or compressible:<ratio>
+func parseFileContent(s string) (fileContent, error) {
+	mode, arg, hasArg := strings.Cut(s, ":")
+	content := fileContent{mode: mode}
+	switch mode {
+	case contentZeros, contentPattern, contentRandom:
+		if hasArg {
+			return fileContent{}, fmt.Errorf("-file-content %s takes no ratio", mode)
+		}
+	case contentCompressible:
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 1 {
+			return fileContent{}, fmt.Errorf("invalid compression ratio %q (expected compressible:<ratio of at least 1>, e.g. compressible:3)", arg)
+		}
+		content.ratio = ratio
+	default:
+		return fileContent{}, fmt.Errorf("invalid -file-content %q (supported: zeros, pattern, random, compressible:<ratio>)", s)
+	}
+	return content, nil
+}
+
+// forWriter returns a copy for a parallel writer with its own random source,
+// so writers neither share state nor write identical random data
+func (c fileContent) forWriter(i int) fileContent {
+	c.rng = rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+	c.filled = false
+	return c
+}
+
+// fill prepares buf for the next write. Random content is regenerated for every
+// chunk so that neither compression nor deduplication can shrink the file.
+func (c *fileContent) fill(buf []byte) {
+	if c.filled {
+		return
+	}
+	if c.rng == nil {
+		c.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	switch c.mode {
+	case contentZeros:
+		clear(buf)
+		c.filled = true
+	case contentRandom:
+		c.rng.Read(buf)
+	case contentCompressible:
+		// Each page holds 1/ratio random bytes followed by zeros, which compress to almost nothing
+		random := int(float64(PageBytes) / c.ratio)
+		for page := 0; page < len(buf); page += PageBytes {
+			end := page + PageBytes
+			if end > len(buf) {
+				end = len(buf)
+			}
+			split := page + random
+			if split > end {
+				split = end
+			}
+			c.rng.Read(buf[page:split])
+			clear(buf[split:end])
+		}
+	default:
+		for i := range buf {
+			buf[i] = byte(i % 256)
+		}
+		c.filled = true
+	}
+}
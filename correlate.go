@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Correlation derives the target of one resource from the target of another,
+// as Scale*source+Offset, Delay after it
+type Correlation struct {
+	Target string // cpu, memory or file
+	Source string
+	Delay  time.Duration
+	Scale  float64
+	Offset float64 // In the unit of Target: percent or MB
+}
+
+// correlationPattern matches one -correlate item like memory=cpu@30s*40+512
+var correlationPattern = regexp.MustCompile(`^(cpu|memory|file)=(cpu|memory|file)(?:@([^*+-]+))?(?:\*([0-9.]+))?([+-][0-9.]+)?$`)
+
+// parseCorrelations parses -correlate, a list like
+// "memory=cpu*40+512,file=cpu@30s*20". Derived resources can't be sources,
+// so the relationships never form a cycle.
+func parseCorrelations(s string) ([]Correlation, error) {
+	var correlations []Correlation
+	derived := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ReplaceAll(item, " ", "")
+		m := correlationPattern.FindStringSubmatch(item)
+		if m == nil {
+			return nil, fmt.Errorf("invalid -correlate item %q (expected e.g. memory=cpu*40+512 or file=cpu@30s*20)", item)
+		}
+		c := Correlation{Target: m[1], Source: m[2], Scale: 1}
+		var err error
+		if m[3] != "" {
+			if c.Delay, err = time.ParseDuration(m[3]); err != nil {
+				return nil, fmt.Errorf("invalid -correlate item %q: %v", item, err)
+			}
+		}
+		if m[4] != "" {
+			if c.Scale, err = strconv.ParseFloat(m[4], 64); err != nil {
+				return nil, fmt.Errorf("invalid -correlate item %q: %v", item, err)
+			}
+		}
+		if m[5] != "" {
+			if c.Offset, err = strconv.ParseFloat(m[5], 64); err != nil {
+				return nil, fmt.Errorf("invalid -correlate item %q: %v", item, err)
+			}
+		}
+		if c.Target == c.Source || derived[c.Target] {
+			return nil, fmt.Errorf("invalid -correlate item %q: each resource can be derived once, from another one", item)
+		}
+		derived[c.Target] = true
+		correlations = append(correlations, c)
+	}
+	for _, c := range correlations {
+		if derived[c.Source] {
+			return nil, fmt.Errorf("invalid -correlate: %s is derived, it can't be the source of %s", c.Source, c.Target)
+		}
+	}
+	return correlations, nil
+}
+
+// value returns the target derived from the source target
+func (c Correlation) value(source float64) float64 {
+	value := math.Max(c.Scale*source+c.Offset, 0)
+	if c.Target == "cpu" {
+		value = math.Min(value, 100)
+	}
+	return value
+}
+
+// applyCorrelations sets the targets of the derived resources from the peaks
+// of their sources, so their consumers run
+func (c *Config) applyCorrelations() error {
+	if c.Correlate == "" {
+		c.correlations = nil
+		return nil
+	}
+	var err error
+	if c.correlations, err = parseCorrelations(c.Correlate); err != nil {
+		return err
+	}
+	peaks := map[string]float64{"cpu": c.CPUPercent, "memory": float64(c.MemoryMB), "file": float64(c.FileSizeMB)}
+	for _, corr := range c.correlations {
+		peak := corr.value(peaks[corr.Source])
+		switch corr.Target {
+		case "cpu":
+			c.CPUPercent = math.Round(peak*10) / 10
+		case "memory":
+			c.MemoryMB = int64(peak)
+		case "file":
+			c.FileSizeMB = int64(peak)
+		}
+	}
+	return nil
+}
+
+// correlatedTarget returns the target of resource after elapsed when
+// -correlate derives it, following its source Delay behind
+func (rm *ResourceMock) correlatedTarget(resource string, elapsed time.Duration) (float64, bool) {
+	for _, c := range rm.config.correlations {
+		if c.Target != resource {
+			continue
+		}
+		at := max(elapsed-c.Delay, 0)
+		var source float64
+		switch c.Source {
+		case "cpu":
+			source = rm.cpuTargetAt(at)
+		case "memory":
+			source = float64(rm.memoryTargetAt(at))
+		case "file":
+			source = float64(rm.fileTargetAt(at))
+		}
+		return c.value(source), true
+	}
+	return 0, false
+}
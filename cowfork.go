@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// CoWFork is the area -cow-fork shares with forked children, parsed from
+// e.g. size=1G,children=8,write=50%
+type CoWFork struct {
+	Size     int64   // Bytes written by this process before forking
+	Children int     // Children sharing the area copy-on-write
+	Write    float64 // Fraction of the area each child dirties at full intensity
+}
+
+// parseCoWFork parses -cow-fork
+func parseCoWFork(s string) (CoWFork, error) {
+	spec := CoWFork{Size: 256 << 20, Children: 4, Write: 1}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return CoWFork{}, fmt.Errorf("invalid -cow-fork setting %q (expected key=value)", item)
+		}
+		var err error
+		switch key {
+		case "size":
+			spec.Size, err = parseByteSize(value)
+		case "children":
+			spec.Children, err = strconv.Atoi(value)
+		case "write":
+			spec.Write, err = parsePercent(value)
+		default:
+			return CoWFork{}, fmt.Errorf("unknown -cow-fork setting %q (supported: size, children, write)", key)
+		}
+		if err != nil {
+			return CoWFork{}, fmt.Errorf("invalid -cow-fork setting %q: %v", item, err)
+		}
+	}
+	if spec.Size < PageBytes || spec.Children < 1 || spec.Children > PageBytes/int(unsafe.Sizeof(cowSlot{})) || spec.Write <= 0 {
+		return CoWFork{}, fmt.Errorf("invalid -cow-fork %q: size must be at least 4K, children between 1 and %d and write above 0%%", s, PageBytes/int(unsafe.Sizeof(cowSlot{})))
+	}
+	return spec, nil
+}
+
+// peakMB returns the memory the area and the copies of all children take at full intensity
+func (s CoWFork) peakMB() int64 {
+	return int64(float64(s.Size)*(1+float64(s.Children)*s.Write)) >> 20
+}
+
+// scaled returns the spec with the area shrunk by a factor, which shrinks the
+// copies of the children alike
+func (s CoWFork) scaled(factor float64) CoWFork {
+	s.Size = max(PageBytes, int64(float64(s.Size)*factor)/PageBytes*PageBytes)
+	return s
+}
+
+// describeWaitStatus says how a child ended
+func describeWaitStatus(ws syscall.WaitStatus) string {
+	if ws.Signaled() {
+		return "killed by " + ws.Signal().String()
+	}
+	return fmt.Sprintf("exit status %d", ws.ExitStatus())
+}
+
+// cowSlot is the part of the shared control page of one child: the parent
+// sets want, the child reports done
+type cowSlot struct {
+	want uint64 // Pages to have dirtied
+	done uint64 // Pages dirtied, private copies the child holds
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// sigSetmask is the how of rt_sigprocmask replacing the blocked signals
+const sigSetmask = 2
+
+// cowPoll is how often the -cow-fork children check how many pages to dirty
+var cowPoll = syscall.NsecToTimespec(int64(10 * time.Millisecond))
+
+// forkCoWChild forks a child of this process that dirties pages of area as
+// its slot asks. The child is a bare copy of the calling thread without the
+// Go runtime, it only runs cowChild until it is killed or this process exits.
+func forkCoWChild(area []byte, slot *cowSlot) (int, error) {
+	base, pages, ppid := unsafe.Pointer(&area[0]), uintptr(len(area)/PageBytes), uintptr(syscall.Getpid())
+
+	// Like syscall.ForkExec, with signals blocked so none reaches the
+	// handlers of the runtime in the child
+	syscall.ForkLock.Lock()
+	runtime.LockOSThread()
+	all, old := ^uint64(0), uint64(0)
+	syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigSetmask, uintptr(unsafe.Pointer(&all)), uintptr(unsafe.Pointer(&old)), 8, 0, 0)
+	pid, _, errno := syscall.RawSyscall6(syscall.SYS_CLONE, uintptr(syscall.SIGCHLD), 0, 0, 0, 0, 0)
+	if errno == 0 && pid == 0 {
+		cowChild(base, pages, slot, ppid)
+	}
+	syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigSetmask, uintptr(unsafe.Pointer(&old)), 0, 8, 0, 0)
+	runtime.UnlockOSThread()
+	syscall.ForkLock.Unlock()
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pid), nil
+}
+
+// cowChild is the forked child. It must not grow its stack or call into the
+// runtime, only raw system calls are safe.
+//
+//go:nosplit
+//go:norace
+func cowChild(base unsafe.Pointer, pages uintptr, slot *cowSlot, ppid uintptr) {
+	done := uintptr(0)
+	for {
+		if p, _, _ := syscall.RawSyscall(syscall.SYS_GETPPID, 0, 0, 0); p != ppid {
+			syscall.RawSyscall(syscall.SYS_EXIT_GROUP, 0, 0, 0)
+		}
+		want := uintptr(atomic.LoadUint64(&slot.want))
+		if want > pages {
+			want = pages
+		}
+		for ; done < want; done++ {
+			// Every write to a shared page makes the kernel copy it
+			*(*byte)(unsafe.Add(base, done*PageBytes)) ^= 0xff
+		}
+		if done > want {
+			syscall.RawSyscall(syscall.SYS_MADVISE, uintptr(unsafe.Add(base, want*PageBytes)), (done-want)*PageBytes, syscall.MADV_DONTNEED)
+			done = want
+		}
+		atomic.StoreUint64(&slot.done, uint64(done))
+		syscall.RawSyscall(syscall.SYS_NANOSLEEP, uintptr(unsafe.Pointer(&cowPoll)), 0, 0)
+	}
+}
+
+// forkCoW writes an area and forks children sharing it copy-on-write, like
+// a preforking server. The children dirty a growing share of the pages, so
+// every write duplicates a page and the memory of the host grows with the
+// children, not with the area. Host protection and rampdown let the children
+// drop their copies again.
+func (rm *ResourceMock) forkCoW() {
+	defer rm.wg.Done()
+
+	spec := rm.config.cowFork
+	// The quota of an agent experiment is only known now
+	if max := rm.config.MaxMemoryMB; max > 0 && spec.Size > max<<20 {
+		rm.markDegraded("cow", fmt.Errorf("the %d MB area exceeds the memory cap of %d MB", spec.Size>>20, max))
+		return
+	}
+	area, err := syscall.Mmap(-1, 0, int(spec.Size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		rm.markDegraded("cow", fmt.Errorf("map %d MB: %w", spec.Size>>20, err))
+		return
+	}
+	defer syscall.Munmap(area)
+	for i := 0; i < len(area); i += PageBytes {
+		area[i] = 1
+	}
+	control, err := syscall.Mmap(-1, 0, PageBytes, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_ANON)
+	if err != nil {
+		rm.markDegraded("cow", fmt.Errorf("map control page: %w", err))
+		return
+	}
+	defer syscall.Munmap(control)
+	slots := unsafe.Slice((*cowSlot)(unsafe.Pointer(&control[0])), spec.Children)
+
+	alive := make(map[int]*cowSlot)
+	defer func() {
+		for pid := range alive {
+			syscall.Kill(pid, syscall.SIGKILL)
+			syscall.Wait4(pid, nil, 0, nil)
+		}
+	}()
+	for i := range slots {
+		pid, err := forkCoWChild(area, &slots[i])
+		if err != nil {
+			rm.markDegraded("cow", fmt.Errorf("fork child %d: %w", i, err))
+			return
+		}
+		alive[pid] = &slots[i]
+	}
+	pages := len(area) / PageBytes
+	log.Printf("Forked %d children sharing %d MB copy-on-write, each dirtying up to %.0f%% of it", spec.Children, spec.Size>>20, spec.Write*100)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			want := rm.config.capCoW(spec, uint64(float64(pages)*spec.Write*rm.rampIntensity("cow")))
+			for _, slot := range alive {
+				atomic.StoreUint64(&slot.want, want)
+			}
+			if now.Sub(last) < time.Second {
+				continue
+			}
+			last = now
+
+			// The OOM killer is the likely end of a child
+			var ws syscall.WaitStatus
+			for pid := range alive {
+				if p, _ := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); p == pid {
+					delete(alive, pid)
+					log.Printf("Copy-on-write child %d ended: %v", pid, describeWaitStatus(ws))
+				}
+			}
+			var copied uint64
+			for _, slot := range alive {
+				copied += atomic.LoadUint64(&slot.done)
+			}
+			rm.statusMu.Lock()
+			rm.resourceStatus.CoWChildren = len(alive)
+			rm.resourceStatus.CoWCopiedMB = int64(copied * PageBytes >> 20)
+			rm.statusMu.Unlock()
+			if len(alive) == 0 {
+				rm.markDegraded("cow", fmt.Errorf("all %d copy-on-write children ended", spec.Children))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// forkCoW degrades, the children are forked with raw Linux system calls
+func (rm *ResourceMock) forkCoW() {
+	defer rm.wg.Done()
+	rm.markDegraded("cow", fmt.Errorf("-cow-fork is %w", errNotLinux))
+}
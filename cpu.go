@@ -1,24 +1,39 @@
 package main
 
 import (
-	"runtime"
+	"log"
+	"math"
+	"sync/atomic"
 	"time"
 )
 
-// getCurrentCPUUsage calculates current CPU usage based on rampup progress
+// getCurrentCPUUsage calculates current CPU usage based on rampup progress,
+// limited by the quota and scaled by host protection and rampdown
 func (rm *ResourceMock) getCurrentCPUUsage() float64 {
-	elapsed := time.Since(rm.rampupStart)
+	return rm.config.capCPU(rm.cpuTargetAt(rm.clock.Since(rm.rampupStart))) * rm.resourceScale("cpu")
+}
+
+// cpuTargetAt calculates the CPU target after elapsed time of the run
+func (rm *ResourceMock) cpuTargetAt(elapsed time.Duration) float64 {
+	if target, ok := rm.correlatedTarget("cpu", elapsed); ok {
+		return target
+	}
+	// Replayed timelines replace the linear rampup
+	if rm.timeline != nil {
+		return rm.timeline.At(elapsed).CPUPercent
+	}
 
 	// If rampup time is 0 or elapsed time exceeds rampup time, use target values
+	target, _ := rm.currentTargets()
 	if rm.config.RampupTime <= 0 || elapsed >= rm.config.RampupTime {
-		return rm.config.CPUPercent
+		return target
 	}
 
 	// Calculate rampup progress (0.0 to 1.0)
 	progress := float64(elapsed) / float64(rm.config.RampupTime)
 
 	// Linear interpolation from 0 to target
-	return progress * rm.config.CPUPercent
+	return progress * target
 }
 
 // consumeCPU simulates CPU usage across multiple cores
@@ -29,32 +44,65 @@ func (rm *ResourceMock) consumeCPU() {
 		return
 	}
 
-	numCPU := runtime.NumCPU()
-	//fmt.Printf("Starting CPU consumption (rampup to %.1f%% across %d cores)\n", rm.config.CPUPercent, numCPU)
+	// Lift GOMAXPROCS for the workers until the run ends
+	if raise := rm.cpuPool.raise; raise > 0 {
+		adjustProcs(raise, "-raise-gomaxprocs")
+		rm.wg.Add(1)
+		go func() {
+			defer rm.wg.Done()
+			<-rm.ctx.Done()
+			adjustProcs(-raise, "-raise-gomaxprocs")
+		}()
+	}
+
+	numWorkers := rm.cpuPool.workers
+	//fmt.Printf("Starting CPU consumption (rampup to %.1f%% across %d cores)\n", rm.config.CPUPercent, numWorkers)
+	rm.cpuPool.warnUnreachable(rm.config.capCPU(rm.config.CPUPercent))
+	if rm.config.CPUWorkload == cpuWorkloadAVX && !hasFMA {
+		log.Printf("This CPU has no 256-bit FMA, the avx workload runs %s instead", thermalKernelName())
+	}
 
-	// Start one goroutine per CPU core
-	for i := 0; i < numCPU; i++ {
+	rm.startBurnSupervisor(rm.config.CPUPercent)
+
+	// Start one goroutine per usable core
+	rm.workerStats = newWorkerStats(numWorkers)
+	for i := 0; i < numWorkers; i++ {
 		rm.wg.Add(1)
 		go rm.cpuWorker(i)
 	}
+
+	// Measure what every worker achieves
+	rm.wg.Add(1)
+	go rm.trackWorkerCPU()
 }
 
 // cpuWorker simulates CPU usage on a single core
 func (rm *ResourceMock) cpuWorker(coreID int) int {
 	defer rm.wg.Done()
 
+	// Keep the worker on one named thread so its CPU time can be read per thread
+	defer nameThread("om-cpu-%d", coreID)()
+	rm.workerStats.register(coreID, gettid())
+	spin := rm.newCPUKernel()
+
 	workDuration := time.Duration(0)
 	sleepDuration := time.Duration(0)
 	count := 0
-	currentCPUPercent := rm.getCurrentCPUUsage()
+	currentCPUPercent := rm.cpuPool.workerShare(coreID, rm.getCurrentCPUUsage())
 
 	for {
 		select {
 		case <-rm.ctx.Done():
 			return count
 		default:
-			// Get current target CPU usage
-			currentCPUPercent = rm.getCurrentCPUUsage()
+			// Get current target CPU usage of this worker
+			currentCPUPercent = rm.cpuPool.workerShare(coreID, rm.getCurrentCPUUsage())
+
+			// Above the threshold spin on the supervisor's flag without timing calls
+			if currentCPUPercent >= cpuBurnThreshold && rm.burnSupervised.Load() {
+				count = rm.burnCycle(spin, count)
+				continue
+			}
 
 			// Calculate work and sleep time based on current CPU percentage
 			// For 30% CPU: work for 6ms, sleep for 14ms in a 20ms cycle
@@ -64,10 +112,7 @@ func (rm *ResourceMock) cpuWorker(coreID int) int {
 			// Do CPU-intensive work for the calculated duration
 			workStart := time.Now()
 			for time.Since(workStart) <= workDuration {
-				// CPU-intensive work
-				for i := 0; i < 10000; i++ {
-					count += (i*count + i + count) / 13
-				}
+				count = spin(count, 10000)
 			}
 
 			// Sleep for the remaining time to achieve target CPU usage
@@ -77,3 +122,100 @@ func (rm *ResourceMock) cpuWorker(coreID int) int {
 		}
 	}
 }
+
+const (
+	cpuBurnThreshold = 90.0                   // CPU target in percent from which workers use the burn loop
+	cpuBurnPeriod    = 100 * time.Millisecond // Duty cycle period of the burn supervisor
+	cpuBurnCheck     = 1024                   // Work iterations between checks of the burn flag
+
+	cpuBurnGain          = 0.3 // Share of the measured error corrected after every period
+	cpuBurnMaxCorrection = 0.2 // Largest correction of the busy fraction
+)
+
+// burnState is shared by the burn supervisor and the CPU workers
+type burnState struct {
+	burning atomic.Bool  // Workers spin while set
+	pause   atomic.Int64 // Time workers sleep once burning stops, in nanoseconds
+}
+
+// startBurnSupervisor starts the burn supervisor once the CPU target reaches
+// cpuBurnThreshold, at the start or when AdjustTarget raises it. High targets
+// are driven by the supervisor instead of per-worker timing, workers filling
+// whole cores just spin.
+func (rm *ResourceMock) startBurnSupervisor(percent float64) {
+	if rm.cpuPool.cores || rm.cpuPool.workerPercent(rm.config.capCPU(percent)) < cpuBurnThreshold || rm.ctx.Err() != nil {
+		return
+	}
+	rm.burnStart.Do(func() {
+		rm.wg.Add(1)
+		go rm.burnSupervisor()
+	})
+}
+
+// burnSupervisor drives high CPU targets with a coarse duty cycle: it sets the
+// burn flag for the busy part of each period and clears it for the rest, so the
+// workers' hot loop only loads an atomic flag. The busy fraction is corrected by
+// the measured CPU time of the process to absorb scheduling delays. At 100% the
+// flag is never cleared.
+func (rm *ResourceMock) burnSupervisor() {
+	defer rm.wg.Done()
+	defer nameThread("om-burn")()
+	rm.burnSupervised.Store(true)
+	defer func() {
+		rm.burnSupervised.Store(false)
+		rm.burn.burning.Store(false)
+	}()
+
+	correction := 0.0
+	prevCPU, prevTime := processCPUTime(), time.Now()
+	for rm.ctx.Err() == nil {
+		percent := rm.cpuPool.workerPercent(rm.getCurrentCPUUsage())
+		if percent < cpuBurnThreshold {
+			rm.burn.burning.Store(false)
+			correction = 0
+			if !sleepCtx(rm.ctx, cpuBurnPeriod) {
+				return
+			}
+			prevCPU, prevTime = processCPUTime(), time.Now()
+			continue
+		}
+
+		fraction := math.Max(0, math.Min(1, percent/100+correction))
+		if percent >= 100 {
+			fraction = 1
+		}
+		busy := time.Duration(float64(cpuBurnPeriod) * fraction)
+		rm.burn.pause.Store(int64(cpuBurnPeriod - busy))
+		rm.burn.burning.Store(true)
+		if !sleepCtx(rm.ctx, busy) {
+			return
+		}
+		if busy < cpuBurnPeriod {
+			rm.burn.burning.Store(false)
+			if !sleepCtx(rm.ctx, cpuBurnPeriod-busy) {
+				return
+			}
+		}
+
+		// Integrate the error between the target and the measured usage of the period
+		cpu, now := processCPUTime(), time.Now()
+		measured := 100 * float64(cpu-prevCPU) / float64(now.Sub(prevTime)) / float64(rm.cpuPool.workers)
+		prevCPU, prevTime = cpu, now
+		correction += cpuBurnGain * (percent - measured) / 100
+		correction = math.Max(-cpuBurnMaxCorrection, math.Min(cpuBurnMaxCorrection, correction))
+	}
+}
+
+// burnCycle spins while the burn flag is set and then sleeps for the idle part of the period
+func (rm *ResourceMock) burnCycle(spin func(count, n int) int, count int) int {
+	for rm.burn.burning.Load() {
+		count = spin(count, cpuBurnCheck)
+	}
+	pause := time.Duration(rm.burn.pause.Load())
+	if pause <= 0 {
+		// The supervisor has not started the next burn yet
+		pause = time.Millisecond
+	}
+	sleepCtx(rm.ctx, pause)
+	return count
+}
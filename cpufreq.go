@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cpufreqGlob matches the cpufreq directories of the online cores
+const cpufreqGlob = "/sys/devices/system/cpu/cpu[0-9]*/cpufreq"
+
+// readSysInt reads a sysfs file holding one integer
+func readSysInt(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n, err == nil
+}
+
+// coreFrequencies returns the current and maximum frequency of every core in
+// MHz. Without cpufreq, e.g. in most VMs, the current frequencies come from
+// /proc/cpuinfo and the maximums are 0.
+func coreFrequencies() (cur, max []float64) {
+	dirs, _ := filepath.Glob(cpufreqGlob)
+	for _, dir := range dirs {
+		// cpufreq reports kHz
+		khz, ok := readSysInt(filepath.Join(dir, "scaling_cur_freq"))
+		if !ok {
+			continue
+		}
+		maxKHz, _ := readSysInt(filepath.Join(dir, "cpuinfo_max_freq"))
+		cur = append(cur, float64(khz)/1000)
+		max = append(max, float64(maxKHz)/1000)
+	}
+	if len(cur) > 0 {
+		return cur, max
+	}
+
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return nil, nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "cpu MHz" {
+			continue
+		}
+		if mhz, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			cur = append(cur, mhz)
+			max = append(max, 0)
+		}
+	}
+	return cur, max
+}
+
+// setGovernor switches every core to the -governor cpufreq governor and
+// remembers the previous ones so restoreGovernors can put them back
+func (rm *ResourceMock) setGovernor() error {
+	dirs, _ := filepath.Glob(cpufreqGlob)
+	if len(dirs) == 0 {
+		return fmt.Errorf("no cpufreq support in /sys/devices/system/cpu")
+	}
+	available, _ := os.ReadFile(filepath.Join(dirs[0], "scaling_available_governors"))
+	if len(available) > 0 && !strings.Contains(" "+strings.TrimSpace(string(available))+" ", " "+rm.config.Governor+" ") {
+		return fmt.Errorf("governor %q is not available (available: %s)", rm.config.Governor, strings.TrimSpace(string(available)))
+	}
+
+	rm.governors = map[string]string{}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "scaling_governor")
+		previous, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(rm.config.Governor), 0644); err != nil {
+			return err
+		}
+		rm.governors[path] = strings.TrimSpace(string(previous))
+	}
+	log.Printf("Set the %s cpufreq governor on %d cores", rm.config.Governor, len(dirs))
+	return nil
+}
+
+// restoreGovernors puts back the cpufreq governors -governor replaced
+func restoreGovernors(governors map[string]string) {
+	restored := 0
+	for path, governor := range governors {
+		if err := os.WriteFile(path, []byte(governor), 0644); err != nil {
+			log.Printf("Failed to restore cpufreq governor %s in %s: %v", governor, path, err)
+			continue
+		}
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("Restored the cpufreq governors of %d cores", restored)
+	}
+}
+
+// currentGovernors summarizes the governors of the cores, e.g. "powersave" or "performance/powersave"
+func currentGovernors() string {
+	dirs, _ := filepath.Glob(cpufreqGlob)
+	seen := map[string]bool{}
+	var names []string
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, "scaling_governor"))
+		if name := strings.TrimSpace(string(data)); err == nil && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, "/")
+}
+
+// monitorCPUFreq samples the core frequencies during the run, since frequency
+// scaling quietly changes what a CPU percentage means, and reports how far
+// the cores ran below their maximum at the end
+func (rm *ResourceMock) monitorCPUFreq() {
+	defer rm.wg.Done()
+
+	if cur, _ := coreFrequencies(); len(cur) == 0 {
+		log.Printf("No CPU frequency information in cpufreq or /proc/cpuinfo")
+		return
+	}
+	governor := currentGovernors()
+	startThrottles, canThrottle := thermalThrottleCount()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	sumMHz, sumPct, lowest, samples := 0.0, 0.0, 0.0, 0
+	for {
+		cur, max := coreFrequencies()
+		avgMHz, avgPct, scaled := 0.0, 0.0, 0
+		for i, mhz := range cur {
+			avgMHz += mhz / float64(len(cur))
+			if max[i] > 0 {
+				avgPct += mhz / max[i] * 100
+				scaled++
+			}
+			if lowest == 0 || mhz < lowest {
+				lowest = mhz
+			}
+		}
+		if scaled > 0 {
+			avgPct /= float64(scaled)
+		}
+		if len(cur) > 0 {
+			sumMHz += avgMHz
+			sumPct += avgPct
+			samples++
+		}
+		rm.statusMu.Lock()
+		rm.resourceStatus.CPUFreqMHz = avgMHz
+		rm.resourceStatus.CPUFreqPct = avgPct
+		rm.statusMu.Unlock()
+
+		select {
+		case <-rm.ctx.Done():
+			if samples == 0 {
+				return
+			}
+			report := fmt.Sprintf("CPU frequency: average %.0f MHz, lowest core %.0f MHz", sumMHz/float64(samples), lowest)
+			if sumPct > 0 {
+				report += fmt.Sprintf(", %.0f%% of the maximum", sumPct/float64(samples))
+			}
+			if governor != "" {
+				report += ", governor " + governor
+			}
+			if throttles, _ := thermalThrottleCount(); canThrottle {
+				report += fmt.Sprintf(", thermal throttling events: %d", throttles-startThrottles)
+			}
+			log.Print(report)
+			return
+		case <-ticker.C:
+		}
+	}
+}
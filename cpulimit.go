@@ -0,0 +1,198 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// What -cpu is a percentage of
+const (
+	cpuOfHost  = "host"  // All cores of the host
+	cpuOfLimit = "limit" // The cgroup CPU quota of this process
+)
+
+// cgroupMount is where the cgroup hierarchies are mounted
+const cgroupMount = "/sys/fs/cgroup"
+
+// cpuPool sizes the CPU workers from the cores this process can actually use
+type cpuPool struct {
+	basis   float64 // Cores -cpu is a percentage of
+	workers int     // Worker goroutines, each locked to its own thread
+	cores   bool    // Workers fill whole cores one after the other, with -cpu-cores-used
+	raise   int     // Threads the workers add to GOMAXPROCS while they run, with -raise-gomaxprocs
+}
+
+// newCPUPool sizes the worker pool from GOMAXPROCS and the cgroup CPU quota.
+// Spawning more workers than GOMAXPROCS only makes them fight for the same
+// threads and miss their duty cycle, so with raise the pool plans to lift
+// GOMAXPROCS to the usable cores for whole-host load. GOMAXPROCS itself is
+// only changed by the running workers, see consumeCPU.
+func newCPUPool(config Config) cpuPool {
+	usable := float64(runtime.NumCPU())
+	quota := cgroupCPUQuota()
+	if quota > 0 && quota < usable {
+		usable = quota
+	}
+
+	pool := cpuPool{basis: float64(runtime.NumCPU()), workers: int(math.Ceil(usable))}
+	procs := runtime.GOMAXPROCS(0)
+	if config.RaiseProcs && procs < pool.workers {
+		pool.raise = pool.workers - procs
+	}
+	if config.CPUOf == cpuOfLimit {
+		pool.basis = usable
+	}
+	// A worker for every started core is enough when the target is in cores
+	if config.CPUCores > 0 {
+		pool.cores = true
+		pool.workers = min(pool.workers, int(math.Ceil(config.CPUCores)))
+	}
+	if pool.workers > procs+pool.raise {
+		pool.workers = procs + pool.raise
+	}
+	return pool
+}
+
+// procsMu serializes the GOMAXPROCS changes of adjustProcs
+var procsMu sync.Mutex
+
+// adjustProcs adds n threads to GOMAXPROCS for the blocked or spinning
+// threads of a load, or with a negative n takes them away again at its end.
+// Loads add and take their own threads, so they can run at the same time.
+func adjustProcs(n int, load string) {
+	if n == 0 {
+		return
+	}
+	procsMu.Lock()
+	defer procsMu.Unlock()
+	previous := runtime.GOMAXPROCS(0)
+	runtime.GOMAXPROCS(max(1, previous+n))
+	if n > 0 {
+		log.Printf("Raising GOMAXPROCS from %d to %d for %s", previous, previous+n, load)
+	}
+}
+
+// workerPercent converts a CPU target of the basis into the duty cycle of every worker in percent
+func (p cpuPool) workerPercent(percent float64) float64 {
+	return math.Min(100, percent*p.basis/float64(p.workers))
+}
+
+// workerShare converts a CPU target of the basis into the duty cycle of worker
+// id in percent. Workers share the target evenly, except with -cpu-cores-used:
+// then they run flat out one after the other and the last one runs the
+// fraction of a core.
+func (p cpuPool) workerShare(id int, percent float64) float64 {
+	if !p.cores {
+		return p.workerPercent(percent)
+	}
+	return math.Max(0, math.Min(100, percent*p.basis-float64(id)*100))
+}
+
+// workerShares returns the duty cycle of every worker for a CPU target of the basis
+func (p cpuPool) workerShares(percent float64) []float64 {
+	shares := make([]float64, p.workers)
+	for i := range shares {
+		shares[i] = p.workerShare(i, percent)
+	}
+	return shares
+}
+
+// warnUnreachable reports a CPU target the workers can't deliver
+func (p cpuPool) warnUnreachable(percent float64) {
+	if needed := percent * p.basis / 100; needed > float64(p.workers)+0.01 {
+		log.Printf("CPU target %.1f%% needs %.2f cores but only %d workers can run (GOMAXPROCS %d, usable cores %s); try -raise-gomaxprocs or -cpu-of limit",
+			percent, needed, p.workers, runtime.GOMAXPROCS(0), formatCores(cgroupCPUQuota()))
+	}
+}
+
+// formatCores formats a CPU quota in cores, 0 meaning unlimited
+func formatCores(cores float64) string {
+	if cores <= 0 {
+		return strconv.Itoa(runtime.NumCPU())
+	}
+	return strconv.FormatFloat(cores, 'f', 2, 64)
+}
+
+// cgroupCPUQuota returns the CPU quota of this process's cgroup in cores, or 0 when unlimited
+func cgroupCPUQuota() float64 {
+	v1Path, v2Path := ownCgroupPaths()
+
+	// cgroup v2: cpu.max holds "<quota> <period>" or "max <period>"
+	for _, dir := range candidateDirs(cgroupMount, v2Path) {
+		data, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		return quotaCores(fields[0], fields[1])
+	}
+
+	// cgroup v1: cpu.cfs_quota_us is -1 when unlimited
+	for _, dir := range candidateDirs(filepath.Join(cgroupMount, "cpu"), v1Path) {
+		quota, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			continue
+		}
+		return quotaCores(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0
+}
+
+// quotaCores divides a CFS quota by its period, 0 for unlimited or unparsable values
+func quotaCores(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// ownCgroupPaths returns this process's cgroup of the v1 cpu controller and of the v2 hierarchy
+func ownCgroupPaths() (v1, v2 string) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", ""
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Lines are "<id>:<controllers>:<path>", the v2 hierarchy has id 0 and no controllers
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			v2 = parts[2]
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "cpu" {
+				v1 = parts[2]
+			}
+		}
+	}
+	return v1, v2
+}
+
+// candidateDirs returns the cgroup directory of path under mount, followed by the
+// mount itself, which is the own cgroup inside a container's cgroup namespace
+func candidateDirs(mount, path string) []string {
+	if path == "" || path == "/" {
+		return []string{mount}
+	}
+	return []string{filepath.Join(mount, path), mount}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/aes"
+	"sort"
+)
+
+// CPU workloads selectable with -cpu-workload, each a different instruction mix
+const (
+	cpuWorkloadInt     = "int"     // Integer multiply and divide
+	cpuWorkloadFloat   = "float"   // Scalar floating point multiply-adds
+	cpuWorkloadAVX     = "avx"     // 256-bit FMA, the most power per cycle
+	cpuWorkloadCrypto  = "crypto"  // AES block encryption, with AES-NI where available
+	cpuWorkloadMemcpy  = "memcpy"  // Copies through buffers larger than the L2 cache
+	cpuWorkloadBranchy = "branchy" // Unpredictable branches that defeat the branch predictor
+)
+
+// cpuMemcpyBytes is the size of each of the two buffers a memcpy worker copies
+// between. Together they overflow the L2 cache of every core, but they fit
+// in many L3 caches, so the workload loads the cache hierarchy rather than DRAM.
+const cpuMemcpyBytes = 4 << 20
+
+// cpuMemcpyStep is the bytes copied per iteration of the memcpy workload
+const cpuMemcpyStep = 256
+
+// cpuKernels create the busy work of one CPU worker for each workload. The
+// work does n iterations and folds its result into count so the compiler
+// can't drop it; iterations take a few nanoseconds so the workers can check
+// their timing often.
+var cpuKernels = map[string]func() func(count, n int) int{
+	cpuWorkloadInt: func() func(count, n int) int {
+		return func(count, n int) int {
+			for i := 0; i < n; i++ {
+				count += (i*count + i + count) / 13
+			}
+			return count
+		}
+	},
+	cpuWorkloadFloat: func() func(count, n int) int {
+		return func(count, n int) int {
+			return count + int(scalarKernel(n))
+		}
+	},
+	cpuWorkloadAVX: func() func(count, n int) int {
+		return func(count, n int) int {
+			return count + int(thermalKernel(n))
+		}
+	},
+	cpuWorkloadCrypto: func() func(count, n int) int {
+		block, _ := aes.NewCipher(make([]byte, 16))
+		buf := make([]byte, aes.BlockSize)
+		return func(count, n int) int {
+			for i := 0; i < n; i++ {
+				block.Encrypt(buf, buf)
+			}
+			return count + int(buf[0])
+		}
+	},
+	cpuWorkloadMemcpy: func() func(count, n int) int {
+		src, dst := make([]byte, cpuMemcpyBytes), make([]byte, cpuMemcpyBytes)
+		offset := 0
+		return func(count, n int) int {
+			for i := 0; i < n; i++ {
+				copy(dst[offset:offset+cpuMemcpyStep], src[offset:offset+cpuMemcpyStep])
+				if offset += cpuMemcpyStep; offset == cpuMemcpyBytes {
+					offset = 0
+					src, dst = dst, src
+				}
+			}
+			return count + int(dst[offset])
+		}
+	},
+	cpuWorkloadBranchy: func() func(count, n int) int {
+		x := uint64(88172645463325252)
+		return func(count, n int) int {
+			for i := 0; i < n; i++ {
+				// xorshift gives the branches no pattern to learn
+				x ^= x << 13
+				x ^= x >> 7
+				x ^= x << 17
+				switch x & 3 {
+				case 0:
+					count++
+				case 1:
+					count ^= i
+				case 2:
+					if x&4 != 0 {
+						count += 3
+					}
+				default:
+					count--
+				}
+			}
+			return count
+		}
+	},
+}
+
+// cpuWorkloadNames returns the supported -cpu-workload values
+func cpuWorkloadNames() []string {
+	var names []string
+	for name := range cpuKernels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newCPUKernel returns the busy work of one CPU worker for the configured workload
+func (rm *ResourceMock) newCPUKernel() func(count, n int) int {
+	if kernel, ok := cpuKernels[rm.config.CPUWorkload]; ok {
+		return kernel()
+	}
+	return cpuKernels[cpuWorkloadInt]()
+}
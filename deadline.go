@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// startLateTolerance is how long after -start-at a run is still accepted
+const startLateTolerance = time.Second
+
+// startDeadline arms the timer that ends the run config.Duration after its start
+func (rm *ResourceMock) startDeadline() {
+	start := rm.clock.Now()
+	if rm.config.startAt.After(start) {
+		start = rm.config.startAt
+	}
+	rm.deadline = start.Add(rm.config.Duration)
+	rm.deadlineTimer = time.AfterFunc(rm.clock.Wall(rm.deadline.Sub(start)), rm.cancel)
+}
+
+// parseStartAt parses -start-at, rejecting times that have already passed
+// since the run could no longer start together with the other hosts
+func parseStartAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -start-at %q (expected RFC 3339, e.g. 2024-05-01T12:00:00Z)", s)
+	}
+	if late := time.Since(t); late > startLateTolerance {
+		return time.Time{}, fmt.Errorf("-start-at %s has already passed by %v", s, late.Round(time.Millisecond))
+	}
+	return t, nil
+}
+
+// waitForStart blocks until -start-at and reports false when a signal on stop
+// cancels the run before it started
+func (rm *ResourceMock) waitForStart(stop <-chan os.Signal) bool {
+	wait := time.Until(rm.config.startAt)
+	if wait <= 0 {
+		return true
+	}
+	messagef("Waiting %v to start at %s\n", wait.Round(time.Millisecond), rm.config.startAt.Format(time.RFC3339Nano))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case sig := <-stop:
+		messagef("Received signal %v before start, shutting down...\n", sig)
+		return false
+	}
+}
+
+// Deadline returns the wall time the run is currently scheduled to end
+func (rm *ResourceMock) Deadline() time.Time {
+	rm.deadlineMu.Lock()
+	defer rm.deadlineMu.Unlock()
+	return rm.wallTime(rm.deadline)
+}
+
+// publicDeadline returns the deadline to report, or nil while the end of the
+// run is secret
+func (rm *ResourceMock) publicDeadline() *time.Time {
+	if rm.config.hidesEnd() {
+		return nil
+	}
+	deadline := rm.Deadline()
+	return &deadline
+}
+
+// wallTime converts a time on the run's clock into the wall time it passes at,
+// which is what people and other hosts see
+func (rm *ResourceMock) wallTime(t time.Time) time.Time {
+	return time.Now().Add(rm.clock.Wall(t.Sub(rm.clock.Now())))
+}
+
+// Extend moves the end of the run by d, which may be negative to shorten it.
+// A deadline in the past ends the run immediately. It returns the new deadline
+// in wall time.
+func (rm *ResourceMock) Extend(d time.Duration) time.Time {
+	defer rm.saveState() // Deferred first so it runs after the unlock
+	rm.deadlineMu.Lock()
+	defer rm.deadlineMu.Unlock()
+	if !rm.rampdownStart.IsZero() {
+		// Already ramping down to a graceful end
+		return rm.wallTime(rm.deadline)
+	}
+	rm.deadline = rm.deadline.Add(d)
+	if remaining := -rm.clock.Since(rm.deadline); remaining > 0 {
+		rm.deadlineTimer.Reset(rm.clock.Wall(remaining))
+	} else {
+		rm.deadline = rm.clock.Now()
+		rm.deadlineTimer.Reset(0)
+	}
+	if rm.config.hidesEnd() {
+		log.Printf("Run deadline moved by %v", d)
+	} else {
+		log.Printf("Run deadline moved by %v to %s", d, rm.wallTime(rm.deadline).Format(time.RFC3339))
+	}
+	return rm.wallTime(rm.deadline)
+}
+
+// EndGraceful ramps all targets down to zero over -rampdown and then ends the run
+func (rm *ResourceMock) EndGraceful() time.Time {
+	return rm.rampDown(rm.config.Rampdown)
+}
+
+// rampDown ramps all targets down to zero over d and then ends the run. Once
+// ramping down the end is fixed, later calls return the current deadline. The
+// deadline is returned in wall time.
+func (rm *ResourceMock) rampDown(d time.Duration) time.Time {
+	defer rm.saveState() // Deferred first so it runs after the unlock
+	rm.deadlineMu.Lock()
+	defer rm.deadlineMu.Unlock()
+	if !rm.rampdownStart.IsZero() {
+		return rm.wallTime(rm.deadline)
+	}
+	rm.rampdownStart = rm.clock.Now()
+	rm.deadline = rm.rampdownStart.Add(d)
+	rm.deadlineTimer.Reset(rm.clock.Wall(d))
+	log.Printf("Ending gracefully, ramping down over %v", d)
+	return rm.wallTime(rm.deadline)
+}
+
+// gracefulRampdown returns how long SIGTERM ramps the targets down before the
+// run ends: -graceful-rampdown, or -rampdown within a container's termination
+// grace period
+func (rm *ResourceMock) gracefulRampdown() time.Duration {
+	if rm.config.GracefulRampdown == 0 && rm.config.ContainerMode {
+		return rm.config.Rampdown
+	}
+	return rm.config.GracefulRampdown
+}
+
+// rampingDown reports whether EndGraceful has started
+func (rm *ResourceMock) rampingDown() bool {
+	rm.deadlineMu.Lock()
+	defer rm.deadlineMu.Unlock()
+	return !rm.rampdownStart.IsZero()
+}
+
+// rampdownFactor returns the fraction of the targets still applied while ramping down, from 1 to 0
+func (rm *ResourceMock) rampdownFactor() float64 {
+	rm.deadlineMu.Lock()
+	start, end := rm.rampdownStart, rm.deadline
+	rm.deadlineMu.Unlock()
+	if start.IsZero() {
+		return 1
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return math.Max(0, 1-float64(rm.clock.Since(start))/float64(end.Sub(start)))
+}
+
+// targetScale returns the factor applied to every target by host protection and rampdown
+func (rm *ResourceMock) targetScale() float64 {
+	return rm.throttle.Load() * rm.rampdownFactor()
+}
+
+// rampIntensity returns the share of a resource's target to apply now, growing
+// linearly during rampup and scaled by host protection and rampdown
+func (rm *ResourceMock) rampIntensity(resource string) float64 {
+	progress := 1.0
+	if elapsed := rm.clock.Since(rm.rampupStart); rm.config.RampupTime > 0 && elapsed < rm.config.RampupTime {
+		progress = float64(elapsed) / float64(rm.config.RampupTime)
+	}
+	return progress * rm.resourceScale(resource)
+}
+
+// parseExtend parses the duration of an extend request, e.g. "10m" or "-5m"
+func parseExtend(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid extension %q: %v", s, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// registerDebug serves net/http/pprof under /debug/pprof/ and expvar under /debug/vars
+func registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+}
+
+// overheadStats accumulates intended and measured CPU usage of this process
+type overheadStats struct {
+	samples     int
+	intendedSum float64
+	measuredSum float64
+}
+
+// trackSelfOverhead measures this process's CPU usage as a percentage of the
+// cores -cpu refers to and compares it with the CPU target, publishing the measurement in the status
+func (rm *ResourceMock) trackSelfOverhead() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	prevCPU, prevTime := processCPUTime(), time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			cpu := processCPUTime()
+			measured := 100 * float64(cpu-prevCPU) / float64(now.Sub(prevTime)) / rm.cpuPool.basis
+			prevCPU, prevTime = cpu, now
+
+			intended := rm.getCurrentCPUUsage()
+			rm.statusMu.Lock()
+			rm.resourceStatus.MeasuredCPU = measured
+			rm.overhead.samples++
+			rm.overhead.intendedSum += intended
+			rm.overhead.measuredSum += measured
+			rm.statusMu.Unlock()
+		}
+	}
+}
+
+// printSelfOverhead reports the average intended and measured CPU usage of the run
+func (rm *ResourceMock) printSelfOverhead() {
+	rm.statusMu.Lock()
+	stats := rm.overhead
+	rm.statusMu.Unlock()
+	if stats.samples == 0 {
+		return
+	}
+	intended := stats.intendedSum / float64(stats.samples)
+	measured := stats.measuredSum / float64(stats.samples)
+	textf("Self overhead: intended CPU %.2f%%, measured %.2f%%, difference %+.2f%% over %d samples\n",
+		intended, measured, measured-intended, stats.samples)
+}
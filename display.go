@@ -2,34 +2,113 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"outagemock/consumer"
 )
 
 // DisplayManager manages the console display for resource monitoring
 type DisplayManager struct {
 	config        *Config
+	clock         Clock
 	rampupStart   time.Time
 	displayTicker *time.Ticker
 	stopChan      chan bool
+	table         *table
 }
 
 // ResourceStatus holds current status of all resources
 type ResourceStatus struct {
-	CPUPercent     float64
-	MemoryTargetMB int64
-	MemoryActualMB int64
-	FileTargetMB   int64
-	FileActualMB   int64
+	CPUPercent       float64                    `json:"cpu_percent"`
+	MemoryTargetMB   int64                      `json:"memory_target_mb"`
+	MemoryActualMB   int64                      `json:"memory_actual_mb"`
+	MemoryHighMB     int64                      `json:"memory_high_mb,omitempty"`     // memory.high of the cgroup with -memory-high
+	MemoryHighEvents int64                      `json:"memory_high_events,omitempty"` // Times the cgroup went over memory.high and was throttled
+	MemoryFileMB     int64                      `json:"memory_file_mb,omitempty"`     // Part of MemoryActualMB mapped from files with -mem-filebacked
+	FileTargetMB     int64                      `json:"file_target_mb"`
+	FileActualMB     int64                      `json:"file_actual_mb"`
+	MemoryDegraded   bool                       `json:"memory_degraded"`                // Memory allocation gave up before reaching the target
+	FileDegraded     bool                       `json:"file_degraded"`                  // File growth gave up before reaching the target
+	Degraded         []string                   `json:"degraded,omitempty"`             // Consumers that gave up, in the order they failed
+	Throttle         float64                    `json:"throttle"`                       // Factor applied to targets by host protection (1 = none)
+	Clamped          []string                   `json:"clamped,omitempty"`              // Targets lowered to the host's capacity with -clamp, e.g. "memory 65536 -> 28800 MB"
+	Phase            string                     `json:"phase,omitempty"`                // rampup, steady or rampdown
+	RemainingSec     float64                    `json:"remaining_sec"`                  // Time left until the run ends
+	NextPhase        string                     `json:"next_phase,omitempty"`           // steady, point N of a replayed timeline, or end
+	NextPhaseSec     float64                    `json:"next_phase_sec,omitempty"`       // Time until NextPhase starts
+	Pressure         *Pressure                  `json:"pressure,omitempty"`             // Pressure stall information of the host, on kernels with PSI
+	MarkovState      string                     `json:"markov_state,omitempty"`         // State of -markov the run is in
+	FollowValue      float64                    `json:"follow_value,omitempty"`         // Latest result of -follow-query
+	PSI              map[string]PSIStatus       `json:"psi,omitempty"`                  // Progress of the -psi targets by resource
+	DiskFault        string                     `json:"disk_fault,omitempty"`           // Fault the -dm-device mapping injects now: none, delay 120ms or errors
+	Sparklines       map[string]string          `json:"sparklines,omitempty"`           // Achieved cpu, memory and file load of the last 2 minutes, a bar per sample up to the full target
+	MeasuredCPU      float64                    `json:"measured_cpu_percent,omitempty"` // CPU used by this process with -self-overhead
+	WorkerCPU        []float64                  `json:"worker_cpu_percent,omitempty"`   // Utilization of each CPU worker in percent of one core
+	UnderDelivering  []int                      `json:"under_delivering,omitempty"`     // CPU workers staying well below the target
+	TreeEntries      int64                      `json:"tree_entries,omitempty"`         // Directories and files created with -tree
+	TreeDegraded     bool                       `json:"tree_degraded,omitempty"`        // Tree creation gave up before completing
+	S3UploadMBps     float64                    `json:"s3_upload_mbps,omitempty"`       // Measured upload rate with -s3-endpoint
+	S3DownloadMBps   float64                    `json:"s3_download_mbps,omitempty"`     // Measured download rate with -s3-endpoint
+	S3Throttled      int64                      `json:"s3_throttled,omitempty"`         // Requests the endpoint rejected with 429 or 503
+	ConnsOpen        int64                      `json:"conns_open,omitempty"`           // Connections held to -conn-target
+	ConnsFailed      int64                      `json:"conns_failed,omitempty"`         // Connection attempts to -conn-target that failed
+	SignalsSent      int64                      `json:"signals_sent,omitempty"`         // Signals sent with -signal-storm
+	Consumers        map[string]consumer.Status `json:"consumers,omitempty"`            // Registered consumers run with -consumer
+	CPUFreqMHz       float64                    `json:"cpu_freq_mhz,omitempty"`         // Average current core frequency
+	CPUFreqPct       float64                    `json:"cpu_freq_pct,omitempty"`         // Average current core frequency as a percentage of the maximum, with cpufreq
+	CPUTempC         float64                    `json:"cpu_temp_c,omitempty"`           // Hottest CPU temperature with -thermal
+	ThermalThrottles int64                      `json:"thermal_throttles,omitempty"`    // Thermal throttling events of the cores since the start with -thermal
+	FileVerifiedMB   int64                      `json:"file_verified_mb,omitempty"`     // MB blocks of the file read back with -verify-file
+	FileMismatches   int64                      `json:"file_mismatches,omitempty"`      // Blocks read back with a different checksum than written
+	FileCorrupted    int64                      `json:"file_corrupted,omitempty"`       // Blocks flipped with -corrupt-file
+	PagesFaulted     int64                      `json:"pages_faulted,omitempty"`        // Pages faulted in with -fault-rate
+	MinorFaultRate   float64                    `json:"minor_faults_per_sec,omitempty"` // Minor page faults of the process per second with -fault-rate
+	LoadAvg          float64                    `json:"load_avg,omitempty"`             // 1-minute load average of the host with -loadavg
+	LoadRunnable     int                        `json:"load_runnable,omitempty"`        // Spinning -loadavg workers
+	LoadBlocked      int                        `json:"load_blocked,omitempty"`         // -loadavg workers blocked in disk IO
+	ProcsRunning     int                        `json:"procs_running,omitempty"`        // Runnable tasks on the host with -runqueue
+	RunqueueThreads  int                        `json:"runqueue_threads,omitempty"`     // Spinning -runqueue threads
+	RunqueueDepth    float64                    `json:"runqueue_depth,omitempty"`       // Runnable tasks per core with -runqueue
+	SoftirqPct       float64                    `json:"softirq_pct,omitempty"`          // Host CPU time in softirqs with -softirq
+	SoftirqPPS       float64                    `json:"softirq_pps,omitempty"`          // Loopback packets sent per second with -softirq
+	SoftirqTimerHz   float64                    `json:"softirq_timer_hz,omitempty"`     // timerfd expirations per second with -softirq
+	KmemDentries     int64                      `json:"kmem_dentries,omitempty"`        // Negative dentries created with -kmem
+	KmemEpoll        int64                      `json:"kmem_epoll,omitempty"`           // epoll instances held with -kmem
+	KmemTimers       int64                      `json:"kmem_timers,omitempty"`          // Armed timerfds held with -kmem
+	SlabMB           int64                      `json:"slab_mb,omitempty"`              // Kernel slab memory of the host with -kmem
+	SUnreclaimMB     int64                      `json:"sunreclaim_mb,omitempty"`        // Unreclaimable part of SlabMB (SUnreclaim)
+	LLCWorkingSetMB  int64                      `json:"llc_working_set_mb,omitempty"`   // Working set chased with -llc-thrash
+	LLCLoadsPerSec   float64                    `json:"llc_loads_per_sec,omitempty"`    // Dependent loads of the -llc-thrash threads per second
+	LLCLoadNs        float64                    `json:"llc_load_ns,omitempty"`          // Average latency of those loads, near DRAM latency when they miss the cache
+	TLBPages         int64                      `json:"tlb_pages,omitempty"`            // Pages touched with -tlb-pressure, one page table page each
+	TLBAccessNs      float64                    `json:"tlb_access_ns,omitempty"`        // Average latency of their accesses, including the page walk
+	CoWChildren      int                        `json:"cow_children,omitempty"`         // Live children of -cow-fork
+	CoWCopiedMB      int64                      `json:"cow_copied_mb,omitempty"`        // Pages the -cow-fork children duplicated by writing
+	PageTablesMB     int64                      `json:"page_tables_mb,omitempty"`       // Page table memory of the host with -tlb-pressure
+	UnixSockets      int64                      `json:"unix_sockets,omitempty"`         // Listening unix sockets held with -unix-objects
+	UnixPairs        int64                      `json:"unix_pairs,omitempty"`           // Unix socket pairs held with -unix-objects
+	FIFOs            int64                      `json:"fifos,omitempty"`                // Named pipes held open with -unix-objects
+	LogFloodBytes    int64                      `json:"log_flood_bytes,omitempty"`      // Log lines appended with -log-flood
+	LogsInjected     int64                      `json:"logs_injected,omitempty"`        // Entries sent with -inject-log
+	Paused           bool                       `json:"paused,omitempty"`               // Every consumer released with SetPaused
+	Disabled         []string                   `json:"disabled,omitempty"`             // Consumers turned off through the API
+	DiskBench        *DiskBench                 `json:"disk_bench,omitempty"`           // Filesystem throughput measured with -bench-disk
+	Canary           *CanaryStats               `json:"canary,omitempty"`               // Latency of the canary task with -canary
 }
 
 // NewDisplayManager creates a new display manager
-func NewDisplayManager(config *Config, rampupStart time.Time) *DisplayManager {
+func NewDisplayManager(config *Config, clock Clock, rampupStart time.Time) *DisplayManager {
 	return &DisplayManager{
 		config:      config,
+		clock:       clock,
 		rampupStart: rampupStart,
 		stopChan:    make(chan bool),
+		table:       newTable(config, statusColumns),
 	}
 }
 
@@ -39,7 +118,7 @@ func (dm *DisplayManager) Start() {
 
 	// Show startup parameters and header
 	dm.showStartupParameters()
-	dm.showHeader()
+	dm.table.printHeader()
 
 	go dm.updateLoop()
 }
@@ -54,61 +133,60 @@ func (dm *DisplayManager) Stop() {
 
 // UpdateStatus updates the resource status and triggers display refresh
 func (dm *DisplayManager) UpdateStatus(status ResourceStatus) {
+	emitData("status", map[string]interface{}{
+		"elapsed": dm.clock.Since(dm.rampupStart).Round(time.Second).String(),
+		"status":  status,
+	})
 	dm.showStatus(status)
 }
 
 // clearScreen clears the terminal screen
 func (dm *DisplayManager) clearScreen() {
-	fmt.Print("\033[2J\033[H")
+	textf("\033[2J\033[H")
 }
 
 // showStartupParameters displays the startup configuration
 func (dm *DisplayManager) showStartupParameters() {
-	fmt.Println("╔══════════════════════════════════════════════════════════════════════════════╗")
-	fmt.Println("║                           OUTAGE MOCK - RESOURCE MONITOR                     ║")
-	fmt.Println("╠══════════════════════════════════════════════════════════════════════════════╣")
-
-	// CPU Configuration
-	if dm.config.CPUPercent > 0 {
-		fmt.Printf("║ CPU Target: %-64s ║\n", fmt.Sprintf("%.1f%% (across %d cores)", dm.config.CPUPercent, runtime.NumCPU()))
+	lines := []string{}
+	if dm.config.CPUCores > 0 {
+		lines = append(lines, fmt.Sprintf("CPU Target: %g cores (%.1f%% of %d cores)", dm.config.CPUCores, dm.config.CPUPercent, runtime.NumCPU()))
+	} else if dm.config.CPUPercent > 0 {
+		lines = append(lines, fmt.Sprintf("CPU Target: %.1f%% (across %d cores)", dm.config.CPUPercent, runtime.NumCPU()))
 	} else {
-		fmt.Printf("║ CPU Target: %-64s ║\n", "Disabled")
+		lines = append(lines, "CPU Target: Disabled")
 	}
-
-	// Memory Configuration
 	if dm.config.MemoryMB > 0 {
-		fmt.Printf("║ Memory Target: %-60s  ║\n", fmt.Sprintf("%d MB", dm.config.MemoryMB))
+		lines = append(lines, fmt.Sprintf("Memory Target: %d MB", dm.config.MemoryMB))
 	} else {
-		fmt.Printf("║ Memory Target: %-60s  ║\n", "Disabled")
+		lines = append(lines, "Memory Target: Disabled")
 	}
-
-	// File Configuration
 	if dm.config.FileSizeMB > 0 {
-		fileInfo := fmt.Sprintf("%d MB (path: %s)", dm.config.FileSizeMB, dm.config.FilePath)
-		fmt.Printf("║ File Target: %-63s ║\n", fileInfo)
+		lines = append(lines, fmt.Sprintf("File Target: %d MB (path: %s)", dm.config.FileSizeMB, dm.config.FilePath))
 	} else {
-		fmt.Printf("║ File Target: %-63s ║\n", "Disabled")
+		lines = append(lines, "File Target: Disabled")
 	}
-
-	// Duration and Rampup
-	durationInfo := fmt.Sprintf("%s, Rampup: %s", dm.config.Duration, dm.config.RampupTime)
-	fmt.Printf("║ Duration: %-66s ║\n", durationInfo)
-
-	fmt.Println("╚══════════════════════════════════════════════════════════════════════════════╝")
-	fmt.Println()
-}
-
-// showHeader displays the column headers
-func (dm *DisplayManager) showHeader() {
-	fmt.Println("┌──────────────────────────────────────────────────────────────────────────────┐")
-	fmt.Println("│ Time    │ CPU % │ Memory (MB)       │ File (MB)         │ Progress           │")
-	fmt.Println("│         │       │ Target/Actual     │ Target/Actual     │                    │")
-	fmt.Println("├──────────────────────────────────────────────────────────────────────────────┤")
+	for _, name := range consumerNames(dm.config.consumerTargets) {
+		lines = append(lines, fmt.Sprintf("%s Target: %g", name, dm.config.consumerTargets[name]))
+	}
+	duration := dm.config.Duration.String()
+	if dm.config.hidesEnd() {
+		duration = dm.config.durationRange + " (hidden)"
+	}
+	lines = append(lines, fmt.Sprintf("Duration: %s, Rampup: %s", duration, dm.config.RampupTime))
+	dm.table.printBox("OUTAGE MOCK - RESOURCE MONITOR", lines)
 }
 
 // showStatus displays the current resource status
 func (dm *DisplayManager) showStatus(status ResourceStatus) {
-	elapsed := time.Since(dm.rampupStart)
+	cells := dm.statusCells(status)
+
+	// Display status on a new line (like logs)
+	dm.table.printRow(cells)
+}
+
+// statusCells formats the cells of a status row, one per column of statusColumns
+func (dm *DisplayManager) statusCells(status ResourceStatus) []string {
+	elapsed := dm.clock.Since(dm.rampupStart)
 	elapsedStr := fmt.Sprintf("%02d:%02d", int(elapsed.Minutes()), int(elapsed.Seconds())%60)
 
 	// Calculate progress percentage
@@ -120,28 +198,63 @@ func (dm *DisplayManager) showStatus(status ResourceStatus) {
 		}
 	}
 	progressStr := fmt.Sprintf("%.1f%%", progress*100)
+	if status.Throttle < 1 {
+		progressStr += fmt.Sprintf(" T%.0f%%", status.Throttle*100)
+	}
+	if status.CPUTempC > 0 {
+		progressStr += fmt.Sprintf(" %.0fC", status.CPUTempC)
+	}
 
 	// Format CPU
 	cpuStr := "N/A"
 	if dm.config.CPUPercent > 0 {
 		cpuStr = fmt.Sprintf("%.1f", status.CPUPercent)
+		if len(status.UnderDelivering) > 0 {
+			cpuStr += "!"
+		}
+		if status.isDisabled("cpu") {
+			cpuStr = "off"
+		}
 	}
 
 	// Format Memory
 	memStr := "N/A"
 	if dm.config.MemoryMB > 0 {
 		memStr = fmt.Sprintf("%d/%d", status.MemoryTargetMB, status.MemoryActualMB)
+		if status.MemoryDegraded {
+			memStr += " !"
+		}
+		if status.MemoryHighMB > 0 && status.Pressure != nil {
+			// Stalled share of the time, the throttling memory.high causes
+			memStr += fmt.Sprintf(" P%.0f%%", status.Pressure.MemorySome)
+		}
+		if status.isDisabled("memory") {
+			memStr += " off"
+		}
 	}
 
 	// Format File
 	fileStr := "N/A"
 	if dm.config.FileSizeMB > 0 {
 		fileStr = fmt.Sprintf("%d/%d", status.FileTargetMB, status.FileActualMB)
+		if status.FileDegraded {
+			fileStr += " !"
+		}
+		if status.isDisabled("file") {
+			fileStr += " off"
+		}
 	}
 
-	// Display status on a new line (like logs)
-	fmt.Printf("│ %-7s │ %-5s │ %-17s │ %-17s │ %-18s │\n",
-		elapsedStr, cpuStr, memStr, fileStr, progressStr)
+	// Count down to the end and the next phase
+	leftStr, nextStr := shortDuration(time.Duration(status.RemainingSec*float64(time.Second))), ""
+	if dm.config.hidesEnd() {
+		leftStr = "?"
+	}
+	if status.NextPhase != "" {
+		nextStr = status.NextPhase + " " + shortDuration(time.Duration(status.NextPhaseSec*float64(time.Second)))
+	}
+
+	return []string{elapsedStr, cpuStr, memStr, fileStr, progressStr, leftStr, nextStr, trendCell(status.Sparklines)}
 }
 
 // updateLoop handles periodic display updates
@@ -176,3 +289,253 @@ func formatFilePath(path string) string {
 	}
 	return path
 }
+
+// column is a column of a status table
+type column struct {
+	key    string // Name in plain lines
+	title  string
+	sub    string // Second header line
+	width  int    // Width the column gets on an 80 column terminal, 0 for an optional column
+	growth int    // Most the column grows on wider terminals, the width of an optional column
+}
+
+// statusColumns are the columns of the status table
+var statusColumns = []column{
+	{"time", "Time", "", 5, 2},
+	{"cpu", "CPU %", "", 5, 2},
+	{"memory", "Memory (MB)", "Target/Actual", 13, 8},
+	{"file", "File (MB)", "Target/Actual", 13, 8},
+	{"progress", "Progress", "", 8, 12},
+	{"left", "Left", "", 5, 3},
+	{"next", "Next", "", 9, 8},
+	{"trend", "Trend", "", 0, len(trendResources)*(trendRowSamples+2) - 1},
+}
+
+// ANSI colors of table cells
+const (
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// table prints status rows fitted to the width of the terminal. When stdout
+// is not a terminal, e.g. in log files, rows are plain key=value lines
+// without box drawing, padding or colors.
+type table struct {
+	columns []column
+	plain   bool // stdout is not a terminal
+	ascii   bool // Box drawing with +, - and | for terminals without Unicode
+	color   bool
+	widths  []int // Widths of the last printed header
+}
+
+// newTable creates a table of columns styled by -ascii and -no-color
+func newTable(config *Config, columns []column) *table {
+	tty := isTerminal(os.Stdout)
+	return &table{
+		columns: columns,
+		plain:   !tty,
+		ascii:   config.ASCII,
+		color:   tty && !config.NoColor && os.Getenv("NO_COLOR") == "",
+	}
+}
+
+// terminalWidth returns the columns of the terminal on stdout, or of
+// $COLUMNS, or 80
+func terminalWidth() int {
+	if cols := terminalColumns(os.Stdout); cols > 0 {
+		return cols
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return 80
+}
+
+// fitWidths sizes the columns for the terminal: wider terminals first show
+// the optional columns that fit, then give the columns up to their growth,
+// narrower ones shrink the widest columns down to 3 characters each. Hidden
+// columns get a width of -1.
+func (t *table) fitWidths() []int {
+	// Cells are separated by " │ " and framed by "│ " and " │"
+	avail := terminalWidth() - 1
+	widths := make([]int, len(t.columns))
+	used := 0
+	for i, c := range t.columns {
+		if c.width > 0 {
+			widths[i] = c.width
+			used += c.width + 3
+		}
+	}
+	for i, c := range t.columns {
+		if c.width == 0 {
+			widths[i] = -1
+			if used+c.growth+3 <= avail {
+				widths[i] = c.growth
+				used += c.growth + 3
+			}
+		}
+	}
+	for grown := true; used < avail && grown; {
+		grown = false
+		for i, c := range t.columns {
+			if used < avail && c.width > 0 && widths[i] < c.width+c.growth {
+				widths[i]++
+				used++
+				grown = true
+			}
+		}
+	}
+	for used > avail {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= 3 {
+			break
+		}
+		widths[widest]--
+		used--
+	}
+	return widths
+}
+
+// fit truncates s to width characters, marking the cut, and pads it
+func (t *table) fit(s string, width int) string {
+	if t.ascii {
+		s = asciiSparks.Replace(s)
+	}
+	runes := []rune(s)
+	if len(runes) > width {
+		if t.ascii || width < 2 {
+			runes = append(runes[:max(0, width-1)], '~')
+		} else {
+			runes = append(runes[:width-1], '…')
+		}
+	}
+	return string(runes) + strings.Repeat(" ", width-len(runes))
+}
+
+// colorize highlights degraded cells red and disabled or throttled ones yellow
+func (t *table) colorize(cell string) string {
+	if !t.color {
+		return cell
+	}
+	trimmed := strings.TrimSpace(cell)
+	switch {
+	case strings.HasSuffix(trimmed, "!"):
+		return colorRed + cell + colorReset
+	case strings.HasSuffix(trimmed, "off") || strings.Contains(trimmed, " T"):
+		return colorYellow + cell + colorReset
+	}
+	return cell
+}
+
+// rule draws a horizontal line with the given corner and junction characters
+func (t *table) rule(widths []int, left, mid, right string) string {
+	line, parts := "─", []string{}
+	if t.ascii {
+		line, left, mid, right = "-", "+", "+", "+"
+	}
+	for _, w := range widths {
+		if w >= 0 {
+			parts = append(parts, strings.Repeat(line, w+2))
+		}
+	}
+	return left + strings.Join(parts, mid) + right
+}
+
+// line joins cells fitted to widths between vertical bars
+func (t *table) line(widths []int, cells []string, color bool) string {
+	bar := "│"
+	if t.ascii {
+		bar = "|"
+	}
+	parts := []string{}
+	for i, w := range widths {
+		if w < 0 {
+			continue
+		}
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+		cell = t.fit(cell, w)
+		if color {
+			cell = t.colorize(cell)
+		}
+		parts = append(parts, cell)
+	}
+	return bar + " " + strings.Join(parts, " "+bar+" ") + " " + bar
+}
+
+// printHeader prints the column titles fitted to the current terminal width
+func (t *table) printHeader() {
+	if t.plain {
+		return
+	}
+	t.widths = t.fitWidths()
+	titles, subs := make([]string, len(t.columns)), make([]string, len(t.columns))
+	hasSubs := false
+	for i, c := range t.columns {
+		titles[i], subs[i] = c.title, c.sub
+		hasSubs = hasSubs || c.sub != ""
+	}
+	textf("%s", t.rule(t.widths, "┌", "┬", "┐"))
+	textf("%s", t.line(t.widths, titles, false))
+	if hasSubs {
+		textf("%s", t.line(t.widths, subs, false))
+	}
+	textf("%s", t.rule(t.widths, "├", "┼", "┤"))
+}
+
+// printRow prints a row of cells, repeating the header when the terminal was resized
+func (t *table) printRow(cells []string) {
+	if t.plain {
+		// Optional columns only fit on terminals
+		pairs := []string{}
+		for i, c := range t.columns {
+			if c.width == 0 {
+				continue
+			}
+			value := ""
+			if i < len(cells) {
+				value = cells[i]
+			}
+			if strings.Contains(value, " ") {
+				value = strconv.Quote(value)
+			}
+			pairs = append(pairs, c.key+"="+value)
+		}
+		textf("%s", strings.Join(pairs, " "))
+		return
+	}
+	if !slices.Equal(t.widths, t.fitWidths()) {
+		t.printHeader()
+	}
+	textf("%s", t.line(t.widths, cells, true))
+}
+
+// printBox prints a title and lines framed to the terminal width, at most 80 columns
+func (t *table) printBox(title string, lines []string) {
+	if t.plain {
+		textf("%s", title)
+		for _, line := range lines {
+			textf("%s", "  "+line)
+		}
+		textf("")
+		return
+	}
+	width := []int{min(terminalWidth(), 80) - 4}
+	pad := max(0, width[0]-len([]rune(title))) / 2
+	textf("%s", t.rule(width, "┌", "", "┐"))
+	textf("%s", t.line(width, []string{strings.Repeat(" ", pad) + title}, false))
+	textf("%s", t.rule(width, "├", "", "┤"))
+	for _, line := range lines {
+		textf("%s", t.line(width, []string{line}, false))
+	}
+	textf("%s", t.rule(width, "└", "", "┘"))
+	textf("")
+}
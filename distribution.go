@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// distributionPattern matches a scenario setting drawn from a distribution,
+// like normal(70,10) or uniform(2G,6G)
+var distributionPattern = regexp.MustCompile(`^\s*(normal|uniform)\(\s*([^,()]+?)\s*,\s*([^,()]+?)\s*\)\s*$`)
+
+// drawCeilings are the upper bounds of the settings that have one, draws
+// above are clamped to them like negative draws are to 0
+var drawCeilings = map[string]float64{"cpu": 100, "max-cpu": 100}
+
+// newSampler returns the random source drawing scenario settings and its
+// seed, a random one when seed is 0 so it can be recorded and replayed
+func newSampler(seed int64) (*rand.Rand, int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed)), seed
+}
+
+// settingField returns the Config field behind a setting
+func settingField(name string) (reflect.StructField, bool) {
+	var find func(t reflect.Type) (reflect.StructField, bool)
+	find = func(t reflect.Type) (reflect.StructField, bool) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				if f, ok := find(field.Type); ok {
+					return f, true
+				}
+			} else if field.Tag.Get("flag") == name {
+				return field, true
+			}
+		}
+		return reflect.StructField{}, false
+	}
+	return find(reflect.TypeOf(Config{}))
+}
+
+// sampleSettings replaces the settings given as distributions with a value
+// drawn from rng. Settings are drawn in name order so a seed reproduces the
+// values; they are returned as "value from distribution" by setting name.
+func sampleSettings(values map[string]string, rng *rand.Rand) (map[string]string, error) {
+	var names []string
+	for name, value := range values {
+		if distributionPattern.MatchString(value) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	samples := make(map[string]string)
+	for _, name := range names {
+		value, err := sampleSetting(name, values[name], rng)
+		if err != nil {
+			return nil, fmt.Errorf("setting %s: %w", name, err)
+		}
+		samples[name] = value + " from " + strings.TrimSpace(values[name])
+		values[name] = value
+	}
+	return samples, nil
+}
+
+// sampleSetting draws one value of setting name from spec. normal takes the
+// mean and standard deviation, uniform the bounds, both in the unit of the
+// setting; settings counted in MB also take sizes like 2G. Draws are clamped
+// to the range of the setting, so every draw of a valid distribution is a
+// valid value.
+func sampleSetting(name, spec string, rng *rand.Rand) (string, error) {
+	field, ok := settingField(name)
+	if !ok {
+		return "", fmt.Errorf("unknown setting")
+	}
+	match := distributionPattern.FindStringSubmatch(spec)
+	var params [2]float64
+	for i, s := range match[2:] {
+		var err error
+		switch field.Type {
+		case reflect.TypeOf(time.Duration(0)):
+			var d time.Duration
+			d, err = time.ParseDuration(s)
+			params[i] = float64(d)
+		case reflect.TypeOf(float64(0)), reflect.TypeOf(int(0)), reflect.TypeOf(int64(0)):
+			params[i], err = strconv.ParseFloat(s, 64)
+			if err != nil && strings.HasSuffix(field.Name, "MB") {
+				var mb int64
+				mb, err = parseFileSize(s)
+				params[i] = float64(mb)
+			}
+		default:
+			return "", fmt.Errorf("%s can't be drawn from a distribution, only numbers, sizes and durations", spec)
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid parameter %q of %s: %v", s, spec, err)
+		}
+	}
+
+	var x float64
+	switch match[1] {
+	case "normal":
+		if params[1] < 0 {
+			return "", fmt.Errorf("invalid %s: the standard deviation must be non-negative", spec)
+		}
+		x = params[0] + params[1]*rng.NormFloat64()
+	case "uniform":
+		if params[1] < params[0] {
+			return "", fmt.Errorf("invalid %s: the upper bound is below the lower one", spec)
+		}
+		x = params[0] + (params[1]-params[0])*rng.Float64()
+	}
+	x = math.Max(x, 0)
+	if ceiling, ok := drawCeilings[name]; ok {
+		x = math.Min(x, ceiling)
+	}
+
+	switch field.Type {
+	case reflect.TypeOf(time.Duration(0)):
+		return time.Duration(x).Round(time.Millisecond).String(), nil
+	case reflect.TypeOf(float64(0)):
+		return strconv.FormatFloat(math.Round(x*10)/10, 'f', -1, 64), nil
+	}
+	if field.Tag.Get("unit") == "size" {
+		return fmt.Sprintf("%.0fM", x), nil
+	}
+	return fmt.Sprintf("%.0f", x), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// dmInterval is how often the -dm-device mapping follows the intensity and error windows
+const dmInterval = 250 * time.Millisecond
+
+// dmSteps is how finely the delay of the mapping follows the intensity; every
+// change reloads its table, which suspends IO for a moment
+const dmSteps = 20
+
+// dmName returns the name of the -dm-device mapping under /dev/mapper.
+// Scenario lanes get a mapping each.
+func (c Config) dmName() string {
+	if c.lane != "" {
+		return c.DMName + "-" + c.lane
+	}
+	return c.DMName
+}
+
+// dmsetup runs a dmsetup command, failing with its output
+func dmsetup(args ...string) error {
+	out, err := exec.Command("dmsetup", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dmsetup %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// dmMapping is the device mapper mapping of -dm-device with the fault its table injects now
+type dmMapping struct {
+	name    string
+	device  string
+	sectors int64 // Size of the device in 512 byte sectors
+	table   string
+}
+
+// tableFor returns the table of the mapping: dm-flakey failing all IO in an
+// error window, dm-delay with a delay, and a plain linear mapping otherwise
+func (m *dmMapping) tableFor(down bool, delay time.Duration) string {
+	switch {
+	case down:
+		// No up interval leaves the device down for good, the windows are ours
+		return fmt.Sprintf("0 %d flakey %s 0 0 1", m.sectors, m.device)
+	case delay > 0:
+		return fmt.Sprintf("0 %d delay %s 0 %d", m.sectors, m.device, delay.Milliseconds())
+	default:
+		return fmt.Sprintf("0 %d linear %s 0", m.sectors, m.device)
+	}
+}
+
+// load replaces the table of the mapping. Resuming swaps in the new table,
+// waiting for IO in flight through the old one.
+func (m *dmMapping) load(table string) error {
+	if table == m.table {
+		return nil
+	}
+	if err := dmsetup("load", m.name, "--table", table); err != nil {
+		return err
+	}
+	if err := dmsetup("resume", m.name); err != nil {
+		return err
+	}
+	m.table = table
+	return nil
+}
+
+// startDeviceMapper maps -dm-device at /dev/mapper/-dm-name, passing IO
+// through unchanged until injectDiskFaults starts the faults with the rampup
+func (rm *ResourceMock) startDeviceMapper() error {
+	f, err := os.Open(rm.config.DMDevice)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil && (info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0) {
+		err = fmt.Errorf("%s is not a block device", rm.config.DMDevice)
+	}
+	var size int64
+	if err == nil {
+		size, err = f.Seek(0, io.SeekEnd)
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	m := &dmMapping{name: rm.config.dmName(), device: rm.config.DMDevice, sectors: size / 512}
+	m.table = m.tableFor(false, 0)
+	if err := dmsetup("create", m.name, "--table", m.table); err != nil {
+		return err
+	}
+	log.Printf("Mapped %s (%d MB) at /dev/mapper/%s with injected latency and errors", m.device, size>>20, m.name)
+
+	rm.wg.Add(1)
+	go rm.injectDiskFaults(m)
+	return nil
+}
+
+// injectDiskFaults moves the mapping between its tables as the run goes on.
+// The delay grows with the rampup and shrinks with host protection and
+// rampdown; error windows recur on the clock of the run like -fuse-hang.
+// The mapping is removed when the run ends, unless something still holds it
+// open, e.g. a mounted filesystem.
+func (rm *ResourceMock) injectDiskFaults(m *dmMapping) {
+	defer rm.wg.Done()
+	defer func() {
+		if err := m.load(m.tableFor(false, 0)); err != nil {
+			log.Printf("Failed to restore the linear table of %s: %v", m.name, err)
+		}
+		if err := dmsetup("remove", "--retry", m.name); err != nil {
+			log.Printf("Left /dev/mapper/%s passing IO through, remove it with \"dmsetup remove %s\" once it is closed: %v", m.name, m.name, err)
+			return
+		}
+		log.Printf("Removed /dev/mapper/%s", m.name)
+	}()
+
+	cycle := rm.config.dmErrors
+	step := rm.config.DMDelay / dmSteps
+	failing := false
+	ticker := time.NewTicker(dmInterval)
+	defer ticker.Stop()
+	for {
+		intensity := rm.rampIntensity("dm")
+		down := false
+		if cycle.On > 0 && intensity > 0 {
+			// Every cycle runs normally for Off and then fails for On
+			down = rm.clock.Since(rm.rampupStart)%(cycle.On+cycle.Off) >= cycle.Off
+		}
+		var delay time.Duration
+		if step > 0 {
+			delay = step * time.Duration(math.Round(intensity*dmSteps))
+		}
+
+		fault := "none"
+		switch {
+		case down:
+			fault = "errors"
+		case delay > 0:
+			fault = fmt.Sprintf("delay %dms", delay.Milliseconds())
+		}
+		if err := m.load(m.tableFor(down, delay)); err != nil {
+			if !failing {
+				rm.markDegraded("dm", fmt.Errorf("reload %s, keeping its table: %w", m.name, err))
+			}
+			failing = true
+		} else {
+			failing = false
+			rm.statusMu.Lock()
+			rm.resourceStatus.DiskFault = fault
+			rm.statusMu.Unlock()
+		}
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
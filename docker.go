@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containerFaultNames are the faults -container-faults can scope to -target-container
+var containerFaultNames = map[string]bool{
+	"cpu":    true, // The load runs in the container's cgroup and competes under its limits
+	"freeze": true, // The container's cgroup is frozen in -freeze duty cycles
+	"net":    true, // The load runs in the container's network namespace
+}
+
+// parseContainerFaults parses a list like "cpu,net"
+func parseContainerFaults(s string) (map[string]bool, error) {
+	faults := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !containerFaultNames[name] {
+			names := make([]string, 0, len(containerFaultNames))
+			for name := range containerFaultNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown fault %q in -container-faults (supported: %s)", name, strings.Join(names, ", "))
+		}
+		faults[name] = true
+	}
+	if faults["cpu"] && faults["freeze"] {
+		return nil, fmt.Errorf("-container-faults cpu and freeze are mutually exclusive, the load would freeze along with the container")
+	}
+	return faults, nil
+}
+
+// containerInfo is the part of the Docker Engine API's container inspect response used here
+type containerInfo struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+		Pid     int  `json:"Pid"`
+	} `json:"State"`
+}
+
+// inspectContainer looks a container up by name or id through the Docker Engine
+// API on a unix socket, which Podman's compatible socket serves as well
+func inspectContainer(socket, name string) (containerInfo, error) {
+	var info containerInfo
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	// The host is ignored, the transport always dials the socket
+	resp, err := client.Get("http://docker/containers/" + url.PathEscape(name) + "/json")
+	if err != nil {
+		return info, fmt.Errorf("inspect container %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return info, fmt.Errorf("no such container: %s", name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("inspect container %s: %s", name, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("inspect container %s: %w", name, err)
+	}
+	if !info.State.Running || info.State.Pid <= 0 {
+		return info, fmt.Errorf("container %s is not running", name)
+	}
+	return info, nil
+}
+
+// processCgroups returns the cgroup paths of a process by v1 controller, with
+// the v2 hierarchy under ""
+func processCgroups(pid int) (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Lines are "<id>:<controllers>:<path>", the v2 hierarchy has id 0 and no controllers
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, nil
+}
+
+// resolveTargetContainer looks up -target-container and points the faults of
+// -container-faults at it: the container's network namespace becomes -netns,
+// its cgroup -freeze-cgroup or the cgroup the load joins
+func (c *Config) resolveTargetContainer() error {
+	info, err := inspectContainer(c.ContainerSocket, c.TargetContainer)
+	if err != nil {
+		return err
+	}
+	cgroups, err := processCgroups(info.State.Pid)
+	if err != nil {
+		return fmt.Errorf("cgroup of container %s: %w", c.TargetContainer, err)
+	}
+	v2, isV2 := cgroups[""]
+	if _, err := os.Stat(filepath.Join(c.CgroupRoot, "cgroup.controllers")); err != nil {
+		isV2 = false
+	}
+
+	if c.containerFaults["net"] {
+		c.Netns = filepath.Join("/proc", strconv.Itoa(info.State.Pid), "ns", "net")
+	}
+	if c.containerFaults["freeze"] {
+		switch {
+		case isV2:
+			c.FreezeCgroup = filepath.Join(c.CgroupRoot, v2)
+		case cgroups["freezer"] != "":
+			c.FreezeCgroup = filepath.Join(c.CgroupRoot, "freezer", cgroups["freezer"])
+		default:
+			return fmt.Errorf("container %s has no freezer cgroup", c.TargetContainer)
+		}
+		if c.freezeCycle, err = parseFreezeCycle(c.Freeze); err != nil {
+			return err
+		}
+	}
+	if c.containerFaults["cpu"] {
+		if !isV2 {
+			return fmt.Errorf("-container-faults cpu requires cgroup v2 at %s", c.CgroupRoot)
+		}
+		c.containerCgroup = filepath.Join(c.CgroupRoot, v2)
+	}
+	if inNamespaceChild() {
+		return nil
+	}
+	log.Printf("Targeting container %s (%.12s, pid %d)", strings.TrimPrefix(info.Name, "/"), info.ID, info.State.Pid)
+	return nil
+}
+
+// joinContainerCgroup moves this process into the cgroup of -target-container,
+// so CPU, memory and IO load is charged to the container and contends with it
+// under its limits. The -run-cmd child started afterwards joins too.
+func (rm *ResourceMock) joinContainerCgroup() error {
+	cg := &cgroup{path: rm.config.containerCgroup, root: rm.config.CgroupRoot}
+	if err := cg.addSelf(); err != nil {
+		return fmt.Errorf("join cgroup %s: %w", cg.path, err)
+	}
+	rm.containerCgroup = cg
+	// -cpu-of limit now refers to the container's quota
+	rm.cpuPool = newCPUPool(rm.config)
+	log.Printf("Generating load in cgroup %s", cg.path)
+	return nil
+}
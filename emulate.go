@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archetype is the load of a kind of application, built as a timeline over
+// the run from the targets as peaks. Resources it doesn't shape are held at
+// their target.
+type archetype struct {
+	uses  []string // Resources it loads, which get emulateDefaults when unset
+	build func(t *archetypeTimeline, d time.Duration)
+}
+
+// emulateDefaults are the peaks of resources an archetype loads when the run
+// sets no target for them
+var emulateDefaults = TimelinePoint{CPUPercent: 50, MemoryMB: 512, FileSizeMB: 1024}
+
+// archetypes are the values of -emulate
+var archetypes = map[string]archetype{
+	// The heap fills up between collections and drops at each one, which
+	// burns CPU while the application is paused
+	"jvm-gc": {uses: []string{"cpu", "memory"}, build: func(t *archetypeTimeline, d time.Duration) {
+		const cycle, pause = 30 * time.Second, time.Second
+		for start := time.Duration(0); start < d; start += cycle {
+			t.at(start, 0.2, 0.4, 1)
+			t.at(start+cycle-pause, 0.2, 1, 1)
+			t.at(start+cycle-pause, 1, 1, 1)
+			t.at(start+cycle, 1, 0.4, 1)
+		}
+	}},
+	// Every batch transforms in a CPU burst and then writes its output,
+	// growing the file in steps to its target by the end of the run
+	"batch-etl": {uses: []string{"cpu", "file"}, build: func(t *archetypeTimeline, d time.Duration) {
+		const cycle, burst = time.Minute, 20 * time.Second
+		batches := math.Ceil(float64(d) / float64(cycle))
+		for i, start := 0.0, time.Duration(0); start < d; i, start = i+1, start+cycle {
+			t.at(start, 1, 1, i/batches)
+			t.at(start+burst, 1, 1, i/batches)
+			t.at(start+burst, 0.15, 1, i/batches)
+			t.at(start+cycle, 0.15, 1, (i+1)/batches)
+		}
+	}},
+	// The cache fills fast at first and slower as it gets warm, over the
+	// first third of the run, with CPU settling from a miss storm
+	"cache-warmup": {uses: []string{"cpu", "memory"}, build: func(t *archetypeTimeline, d time.Duration) {
+		const steps = 10
+		warm := d / 3
+		for i := 0; i <= steps; i++ {
+			filled := (1 - math.Exp(-3*float64(i)/steps)) / (1 - math.Exp(-3))
+			t.at(warm*time.Duration(i)/steps, 1-0.8*filled, filled, 1)
+		}
+		t.at(d, 0.2, 1, 1)
+	}},
+}
+
+// archetypeTimeline collects the points of an archetype as fractions of the peaks
+type archetypeTimeline struct {
+	peak   TimelinePoint
+	points Timeline
+}
+
+// at adds a point at offset. Offsets not after the last point are moved just
+// after it, so a load can jump between two points at the same offset.
+func (t *archetypeTimeline) at(offset time.Duration, cpu, memory, file float64) {
+	if n := len(t.points); n > 0 && offset <= t.points[n-1].Offset {
+		offset = t.points[n-1].Offset + 10*time.Millisecond
+	}
+	t.points = append(t.points, TimelinePoint{
+		Offset:     offset,
+		CPUPercent: math.Round(cpu*t.peak.CPUPercent*10) / 10,
+		MemoryMB:   int64(memory * float64(t.peak.MemoryMB)),
+		FileSizeMB: int64(file * float64(t.peak.FileSizeMB)),
+	})
+}
+
+// archetypeNames returns the values of -emulate, sorted
+func archetypeNames() []string {
+	var names []string
+	for name := range archetypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyEmulate builds the timeline of -emulate over the run, filling in
+// emulateDefaults for the resources the archetype loads without a target
+func (c *Config) applyEmulate() error {
+	if c.Emulate == "" {
+		c.generated = nil
+		return nil
+	}
+	a, ok := archetypes[c.Emulate]
+	if !ok {
+		return fmt.Errorf("invalid -emulate %q (supported: %s)", c.Emulate, strings.Join(archetypeNames(), ", "))
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("-emulate needs a positive -duration")
+	}
+	for _, resource := range a.uses {
+		switch resource {
+		case "cpu":
+			if c.CPUPercent == 0 {
+				c.CPUPercent = emulateDefaults.CPUPercent
+			}
+		case "memory":
+			if c.MemoryMB == 0 {
+				c.MemoryMB = emulateDefaults.MemoryMB
+			}
+		case "file":
+			if c.FileSizeMB == 0 {
+				c.FileSizeMB = emulateDefaults.FileSizeMB
+			}
+		}
+	}
+	t := &archetypeTimeline{peak: TimelinePoint{CPUPercent: c.CPUPercent, MemoryMB: c.MemoryMB, FileSizeMB: c.FileSizeMB}}
+	a.build(t, c.Duration)
+	c.generated = t.points
+	return nil
+}
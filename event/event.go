@@ -0,0 +1,129 @@
+// Package event reports the progress of outagemock runs, so programs that
+// embed the mock can orchestrate and assert on it without parsing logs.
+// Code linked into outagemock, e.g. with a blank import from a build-tagged
+// file of package main as for package consumer, registers a Watcher from an
+// init function and receives the events of every run:
+//
+//	func init() {
+//		event.Watch(func(events <-chan event.Event) {
+//			for e := range events {
+//				...
+//			}
+//		})
+//	}
+package event
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Buffer is how many events a subscriber may fall behind before events are
+// dropped for it. CleanupDone is never dropped.
+const Buffer = 64
+
+// Type identifies what an Event reports
+type Type int
+
+const (
+	PhaseStarted     Type = iota + 1 // The run entered Phase: rampup, steady or rampdown
+	TargetReached                    // Resource reached its full target after rampup
+	AllocationFailed                 // Resource gave up on its target, Err says why
+	CleanupDone                      // The run is over and cleaned up, the last event before the channel closes
+)
+
+func (t Type) String() string {
+	switch t {
+	case PhaseStarted:
+		return "PhaseStarted"
+	case TargetReached:
+		return "TargetReached"
+	case AllocationFailed:
+		return "AllocationFailed"
+	case CleanupDone:
+		return "CleanupDone"
+	}
+	return "Type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event is delivered to the channels of a Bus
+type Event struct {
+	Type     Type
+	Time     time.Time
+	Phase    string // Set for PhaseStarted
+	Resource string // memory, file, tree or connections, set for TargetReached and AllocationFailed
+	Err      error  // Set for AllocationFailed
+}
+
+// Bus delivers the events of one run to its subscribers. The zero value is
+// ready to use.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers []chan Event
+	closed      bool // Set once CleanupDone was published
+}
+
+// Subscribe returns a new channel that receives all later events and is
+// closed after CleanupDone. A subscriber that falls Buffer events behind
+// misses events rather than stalling the run, but always gets CleanupDone.
+func (b *Bus) Subscribe() <-chan Event {
+	// One slot more than the other events may fill is kept for CleanupDone
+	ch := make(chan Event, Buffer+1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers = append(b.subscribers, ch)
+	return ch
+}
+
+// Publish delivers an event to all subscribers. CleanupDone closes the channels.
+func (b *Bus) Publish(e Event) {
+	e.Time = time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	for _, ch := range b.subscribers {
+		// Only the publisher sends and holds mu, so the reserved slot stays free
+		if e.Type == CleanupDone || len(ch) < Buffer {
+			ch <- e
+		}
+	}
+	// Nothing follows cleanup
+	if e.Type == CleanupDone {
+		for _, ch := range b.subscribers {
+			close(ch)
+		}
+		b.subscribers = nil
+		b.closed = true
+	}
+}
+
+// Watcher receives the events of a run until the channel closes
+type Watcher func(events <-chan Event)
+
+var (
+	mu       sync.Mutex
+	watchers []Watcher
+)
+
+// Watch subscribes watcher to every run that starts from now on, each run
+// calls it on a goroutine of its own. It is meant to be called from init
+// functions.
+func Watch(watcher Watcher) {
+	mu.Lock()
+	defer mu.Unlock()
+	watchers = append(watchers, watcher)
+}
+
+// Watchers returns the watchers registered with Watch
+func Watchers() []Watcher {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Watcher(nil), watchers...)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// eventPrefix starts every machine-readable progress line written with -ci-events
+const eventPrefix = "::outagemock::"
+
+// eventStep is the progress granularity of phase events in percent
+const eventStep = 10
+
+// emitEvent writes a progress line like "::outagemock::phase=rampup pct=50"
+func (rm *ResourceMock) emitEvent(phase string, fields ...string) {
+	if !rm.config.CIEvents {
+		return
+	}
+	textf("%s", strings.Join(append([]string{eventPrefix + "phase=" + phase}, fields...), " "))
+}
+
+// phaseAt returns the phase of the run and its progress in percent, which is
+// -1 in the steady phase when the end of the run is secret
+func (rm *ResourceMock) phaseAt(now time.Time) (string, int) {
+	rm.deadlineMu.Lock()
+	deadline, rampdownStart := rm.deadline, rm.rampdownStart
+	rm.deadlineMu.Unlock()
+
+	progress := func(start, end time.Time) int {
+		if !end.After(start) {
+			return 100
+		}
+		pct := int(100 * now.Sub(start) / end.Sub(start))
+		if pct > 100 {
+			pct = 100
+		}
+		return pct / eventStep * eventStep
+	}
+
+	if !rampdownStart.IsZero() {
+		return "rampdown", progress(rampdownStart, deadline)
+	}
+	if rampupEnd := rm.rampupStart.Add(rm.config.RampupTime); now.Before(rampupEnd) {
+		return "rampup", progress(rm.rampupStart, rampupEnd)
+	}
+	if rm.config.hidesEnd() {
+		return "steady", -1
+	}
+	return "steady", progress(rm.rampupStart.Add(rm.config.RampupTime), deadline)
+}
+
+// phaseCountdown returns the time left in the run and the next phase with the
+// time until it starts: steady after rampup, the next point of a replayed
+// timeline, or the end of the run
+func (rm *ResourceMock) phaseCountdown(now time.Time) (remaining time.Duration, next string, until time.Duration) {
+	rm.deadlineMu.Lock()
+	deadline, rampingDown := rm.deadline, !rm.rampdownStart.IsZero()
+	rm.deadlineMu.Unlock()
+
+	remaining = max(0, deadline.Sub(now))
+	if rampingDown {
+		return remaining, "end", remaining
+	}
+	elapsed := now.Sub(rm.rampupStart)
+	if rm.timeline != nil {
+		for i, p := range rm.timeline {
+			if p.Offset > elapsed && p.Offset-elapsed < remaining {
+				return remaining, fmt.Sprintf("point %d", i+1), p.Offset - elapsed
+			}
+		}
+	} else if elapsed < rm.config.RampupTime {
+		return remaining, "steady", rm.config.RampupTime - elapsed
+	}
+	return remaining, "end", remaining
+}
+
+// shortDuration formats d for the status table, like 45s, 4m12s or 1h02m
+func shortDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// emitPhaseEvents writes a progress line whenever the phase or its progress step changes
+func (rm *ResourceMock) emitPhaseEvents() {
+	defer rm.wg.Done()
+
+	ticker := rm.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastPhase, lastPct := "", -1
+	for {
+		phase, pct := rm.phaseAt(rm.clock.Now())
+		if phase != lastPhase || pct != lastPct {
+			if pct < 0 {
+				rm.emitEvent(phase)
+			} else {
+				rm.emitEvent(phase, fmt.Sprintf("pct=%d", pct))
+			}
+			lastPhase, lastPct = phase, pct
+		}
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// junitSuite is the JUnit XML report written with -junit
+type junitSuite struct {
+	XMLName    xml.Name         `xml:"testsuite"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Time       float64          `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Cases      []junitCase      `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Property []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name    string        `xml:"name,attr"`
+	Time    float64       `xml:"time,attr"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// reportResult emits the end event and writes the JUnit report of the finished run
+func (rm *ResourceMock) reportResult(code int) {
+	status := rm.Status()
+	fields := []string{fmt.Sprintf("exit=%d", code),
+		fmt.Sprintf("memory_mb=%d", status.MemoryActualMB), fmt.Sprintf("file_mb=%d", status.FileActualMB)}
+	var properties *junitProperties
+	addProperty := func(name, value string) {
+		if properties == nil {
+			properties = &junitProperties{}
+		}
+		fields = append(fields, name+"="+value)
+		properties.Property = append(properties.Property, junitProperty{name, value})
+	}
+	if b := status.DiskBench; b != nil {
+		// Disk capacity measured with -bench-disk, to put the file growth into perspective
+		addProperty("disk_seq_write_mbps", fmt.Sprintf("%.1f", b.SeqWriteMBps))
+		addProperty("disk_seq_read_mbps", fmt.Sprintf("%.1f", b.SeqReadMBps))
+		addProperty("disk_rand_write_iops", fmt.Sprintf("%.0f", b.RandWriteIOPS))
+		addProperty("disk_rand_read_iops", fmt.Sprintf("%.0f", b.RandReadIOPS))
+	}
+	if rm.canary != nil {
+		// How much the load delayed the co-located canary task
+		c := rm.canary.stats()
+		addProperty("canary_p50_ms", fmt.Sprintf("%.2f", c.P50Ms))
+		addProperty("canary_p99_ms", fmt.Sprintf("%.2f", c.P99Ms))
+		addProperty("canary_max_ms", fmt.Sprintf("%.2f", c.MaxMs))
+	}
+	if rm.config.memoryHigh > 0 {
+		// How hard memory.high throttled the memory workers
+		rm.statusMu.Lock()
+		pressure := rm.peaks.pressure
+		rm.statusMu.Unlock()
+		addProperty("memory_high_events", fmt.Sprint(status.MemoryHighEvents))
+		if pressure != nil {
+			addProperty("memory_pressure_some_peak", fmt.Sprintf("%.2f", pressure.MemorySome))
+			addProperty("memory_pressure_full_peak", fmt.Sprintf("%.2f", pressure.MemoryFull))
+		}
+	}
+	if len(status.Degraded) > 0 {
+		// Consumers that gave up, the run measured less load than configured
+		addProperty("degraded", strings.Join(status.Degraded, ","))
+	}
+	rm.emitEvent("end", fields...)
+
+	if rm.config.JUnit == "" {
+		return
+	}
+	elapsed := rm.clock.Since(rm.rampupStart).Seconds()
+	suite := junitSuite{Name: "outagemock", Time: elapsed, Properties: properties}
+	addCase := func(name string, failure string) {
+		c := junitCase{Name: name, Time: elapsed}
+		if failure != "" {
+			c.Failure = &junitFailure{Message: failure}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+		suite.Tests++
+	}
+
+	if rm.config.CPUPercent > 0 {
+		addCase("cpu", "")
+	}
+	if rm.config.MemoryMB > 0 {
+		failure := ""
+		if status.MemoryDegraded {
+			failure = fmt.Sprintf("memory reached %d MB of %d MB target", status.MemoryActualMB, status.MemoryTargetMB)
+		}
+		addCase("memory", failure)
+	}
+	if rm.config.FileSizeMB > 0 {
+		failure := ""
+		if status.FileDegraded {
+			failure = fmt.Sprintf("file reached %d MB of %d MB target", status.FileActualMB, status.FileTargetMB)
+		}
+		addCase("file", failure)
+	}
+	for _, name := range status.Degraded {
+		if name != "memory" && name != "file" {
+			addCase(name, name+" gave up before reaching its target")
+		}
+	}
+	runFailure := ""
+	switch code {
+	case 0:
+	case exitDegraded:
+		runFailure = fmt.Sprintf("run degraded, %s gave up", strings.Join(status.Degraded, ", "))
+	default:
+		runFailure = fmt.Sprintf("run aborted with exit code %d", code)
+	}
+	addCase("run", runFailure)
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err == nil {
+		err = os.WriteFile(rm.config.JUnit, append([]byte(xml.Header), append(data, '\n')...), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write JUnit report: %v\n", err)
+	}
+}
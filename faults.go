@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFaultRate bounds -fault-rate; a million faults per second already move 4 GB/s of pages
+const maxFaultRate = 1000000
+
+// parseFaultRate parses a page fault rate like 5000/s
+func parseFaultRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "/s"), 64)
+	if err != nil || rate <= 0 || rate > maxFaultRate {
+		return 0, fmt.Errorf("invalid -fault-rate %q (expected e.g. 5000/s, at most %d/s)", s, maxFaultRate)
+	}
+	return rate, nil
+}
+
+// faultPages emulates demand paging: it maps -fault-region without touching it
+// and writes to one new page at a time at the configured rate, each write a
+// page fault. Once every page is resident the region is given back with
+// MADV_DONTNEED and faulted in again. The rate grows linearly during rampup
+// and is scaled by host protection and rampdown.
+func (rm *ResourceMock) faultPages() {
+	defer rm.wg.Done()
+	defer nameThread("om-fault")()
+
+	// The whole region ends up resident, so it counts against -max-memory
+	regionMB := rm.config.capMemory(rm.config.FaultRegionMB)
+	size, page := int(regionMB)*BlockBytes, os.Getpagesize()
+	region, err := mmap(nil, 0, size, mapNoReserve)
+	if err != nil {
+		rm.markDegraded("faults", fmt.Errorf("map fault region: %w", err))
+		return
+	}
+	defer munmap(region)
+	log.Printf("Faulting in pages of a %d MB region at up to %.0f/s", regionMB, rm.config.faultRate)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	due, next := 0.0, 0
+	last, lastFaults := time.Now(), minorFaults()
+	sampled := last
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			due += rm.config.faultRate * rm.rampIntensity("faults") * now.Sub(last).Seconds()
+			last = now
+
+			faulted := int64(0)
+			for ; due >= 1; due-- {
+				region[next*page] = 1
+				faulted++
+				if next++; next*page == size {
+					dontNeed(region)
+					next = 0
+				}
+			}
+
+			// The measured rate includes faults of the rest of the process
+			rm.statusMu.Lock()
+			rm.resourceStatus.PagesFaulted += faulted
+			if now.Sub(sampled) >= time.Second {
+				faults := minorFaults()
+				rm.resourceStatus.MinorFaultRate = float64(faults-lastFaults) / now.Sub(sampled).Seconds()
+				lastFaults, sampled = faults, now
+			}
+			rm.statusMu.Unlock()
+		}
+	}
+}
@@ -1,55 +1,158 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
-// getCurrentFileSizeUsage calculates current file size usage based on rampup progress
+const (
+	fileChunkBytes    = 4 << 20   // Bytes per write call
+	fileWriters       = 4         // Parallel writers for large gaps
+	fileParallelBytes = 64 << 20  // Gaps from this size are split across the writers
+	fileTickBytes     = 256 << 20 // Most bytes written per tick, so the status keeps updating
+)
+
+// FileRate is the token bucket pacing file writes, parsed from -file-rate
+type FileRate struct {
+	Rate   float64 // Bytes per second
+	Burst  float64 // Bytes that may be written at once after a pause
+	Rampup bool    // The file follows -rampup and the bucket only caps its growth
+}
+
+// parseFileRate parses a rate like "50MB/s burst=200MB"; items may also be
+// separated by commas. The burst defaults to one second of the rate, and
+// rampup keeps the file on the -rampup schedule with the rate as a cap.
+func parseFileRate(s string) (FileRate, error) {
+	var rate FileRate
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			key, value = "rate", item
+		}
+		switch {
+		case item == "rampup":
+			rate.Rampup = true
+		case key == "rate":
+			perSecond := value
+			if !strings.Contains(value, "/") {
+				perSecond += "/s"
+			}
+			bytes, err := parseByteRate(perSecond)
+			if err != nil {
+				return rate, fmt.Errorf("invalid -file-rate rate %q (expected e.g. 50MB/s)", value)
+			}
+			rate.Rate = bytes
+		case key == "burst":
+			bytes, err := parseByteSize(value)
+			if err != nil || bytes <= 0 {
+				return rate, fmt.Errorf("invalid -file-rate burst %q (expected e.g. 200MB)", value)
+			}
+			rate.Burst = float64(bytes)
+		default:
+			return rate, fmt.Errorf("invalid -file-rate setting %q (supported: rate, burst, rampup)", item)
+		}
+	}
+	if rate.Rate == 0 {
+		return rate, fmt.Errorf("-file-rate requires a rate, e.g. 50MB/s")
+	}
+	if rate.Burst == 0 {
+		rate.Burst = rate.Rate
+	}
+	return rate, nil
+}
+
+// fileBucket returns the token bucket pacing file writes: -file-rate, or
+// -write-mbps capping the rampup and saving up at most a second
+func (c Config) fileBucket() FileRate {
+	if c.WriteMBps > 0 {
+		return FileRate{Rate: c.WriteMBps * 1024 * 1024, Burst: c.WriteMBps * 1024 * 1024, Rampup: true}
+	}
+	return c.fileRate
+}
+
+// getCurrentFileSizeUsage calculates current file size usage based on rampup progress,
+// limited by the quota and scaled by host protection and rampdown
 func (rm *ResourceMock) getCurrentFileSizeUsage() int64 {
-	elapsed := time.Since(rm.rampupStart)
+	return int64(float64(rm.config.capFile(rm.fileTargetAt(rm.clock.Since(rm.rampupStart)))) * rm.resourceScale("file"))
+}
 
-	// If rampup time is 0 or elapsed time exceeds rampup time, use target values
-	if rm.config.RampupTime <= 0 || elapsed >= rm.config.RampupTime {
-		return rm.config.FileSizeMB
+// fileTargetAt calculates the file size target after elapsed time of the run
+func (rm *ResourceMock) fileTargetAt(elapsed time.Duration) int64 {
+	if target, ok := rm.correlatedTarget("file", elapsed); ok {
+		return int64(target)
+	}
+	// Replayed timelines replace the linear rampup
+	if rm.timeline != nil {
+		return rm.timeline.At(elapsed).FileSizeMB
 	}
 
-	// Calculate rampup progress (0.0 to 1.0)
-	progress := float64(elapsed) / float64(rm.config.RampupTime)
+	// Linear interpolation from 0 to target during the rampup. With -file-rate
+	// the token bucket alone paces the growth toward the target, unless it only
+	// caps the rampup.
+	target := rm.fileTarget()
+	bucket := rm.config.fileBucket()
+	if rm.config.RampupTime > 0 && elapsed < rm.config.RampupTime && (bucket.Rate == 0 || bucket.Rampup) {
+		progress := float64(elapsed) / float64(rm.config.RampupTime)
+		target = int64(progress * float64(target))
+	}
 
-	// Linear interpolation from 0 to target
-	return int64(progress * float64(rm.config.FileSizeMB))
+	// The file can't grow faster than the bucket lets it, which paces in wall time
+	if bucket.Rate > 0 {
+		target = min(target, int64(bucket.Rate*rm.clock.Wall(elapsed).Seconds())/(1024*1024))
+	}
+	return target
 }
 
 // consumeFile creates and grows a file to specified size during rampup
 func (rm *ResourceMock) consumeFile() {
 	defer rm.wg.Done()
+	defer nameThread("om-file")()
 
 	if rm.config.FileSizeMB <= 0 {
 		return
 	}
 
-	// Create file
-	file, err := os.Create(rm.filePath)
+	// Create file, or adopt the one of a resumed run
+	var file *os.File
+	writtenBytes := int64(0) // Track total bytes written
+	err := retryWithBackoff(rm.ctx, "create file", func() error {
+		var err error
+		if rm.resumed == nil {
+			file, err = os.Create(rm.filePath)
+			return err
+		}
+		if file, err = os.OpenFile(rm.filePath, os.O_RDWR|os.O_CREATE, 0666); err != nil {
+			return err
+		}
+		writtenBytes, err = file.Seek(0, io.SeekEnd)
+		return err
+	})
 	if err != nil {
-		log.Printf("Failed to create file: %v", err)
+		if rm.ctx.Err() == nil {
+			rm.markDegraded("file", err)
+		}
 		return
 	}
 	rm.file = file
 
 	//fmt.Printf("Created file: %s (rampup to %.1f MB)\n", rm.filePath, float64(rm.config.FileSizeMB))
 
-	buffer := make([]byte, 1024*1024) // 1MB buffer
-	for i := range buffer {
-		buffer[i] = byte(i % 256)
-	}
+	content := rm.config.fileContent
 
 	// Use ticker to control growth rate during rampup
 	ticker := time.NewTicker(50 * time.Millisecond) // Faster ticker
 	defer ticker.Stop()
 
-	writtenBytes := int64(0) // Track total bytes written
+	// Pace writes with the token bucket of -file-rate or -write-mbps
+	degraded := false
+	bucket := rm.config.fileBucket()
+	allowance, lastTick := 0.0, time.Now() // Bytes the bucket still allows
 
 	for {
 		select {
@@ -62,42 +165,117 @@ func (rm *ResourceMock) consumeFile() {
 			// Calculate how much more to write
 			currentFileSize := currentFileSizeMB * 1024 * 1024
 
-			// Write more data if needed - write multiple MB per tick for faster growth
-			if writtenBytes < currentFileSize {
-				bytesToWrite := currentFileSize - writtenBytes
-				// Write up to 10MB per tick for faster growth
-				maxWritePerTick := int64(10 * 1024 * 1024) // 10MB
-				if bytesToWrite > maxWritePerTick {
-					bytesToWrite = maxWritePerTick
+			// Give back space above the target, e.g. while the host is protected
+			if writtenBytes > currentFileSize {
+				rm.fileSums.truncate(currentFileSize)
+				if err := file.Truncate(currentFileSize); err != nil {
+					log.Printf("Failed to truncate file: %v", err)
+				} else {
+					writtenBytes = currentFileSize
 				}
+			}
 
-				// Write data in chunks
-				for bytesToWrite > 0 {
-					chunkSize := bytesToWrite
-					if chunkSize > int64(len(buffer)) {
-						chunkSize = int64(len(buffer))
-					}
+			// Write whatever the schedule is ahead of the file, up to the rate cap
+			bytesToWrite := currentFileSize - writtenBytes
+			if bytesToWrite > fileTickBytes {
+				bytesToWrite = fileTickBytes
+			}
+			if bucket.Rate > 0 {
+				now := time.Now()
+				allowance = math.Min(allowance+now.Sub(lastTick).Seconds()*bucket.Rate, bucket.Burst)
+				lastTick = now
+				if bytesToWrite > int64(allowance) {
+					bytesToWrite = int64(allowance)
+				}
+			}
 
-					n, err := file.Write(buffer[:chunkSize])
-					if err != nil {
-						log.Fatalf("Failed to write to file: %v", err)
+			if bytesToWrite > 0 && !degraded {
+				n, err := rm.growFile(file, &content, writtenBytes, bytesToWrite)
+				writtenBytes += n
+				allowance -= float64(n)
+				if err != nil {
+					if rm.ctx.Err() != nil {
 						return
 					}
-
-					// Update written bytes counter
-					writtenBytes += int64(n)
-					bytesToWrite -= int64(n)
+					// Keep the written part in place, cut off anything beyond a failed range
+					rm.fileSums.truncate(writtenBytes)
+					file.Truncate(writtenBytes)
+					degraded = true
+					rm.markDegraded("file", err)
 				}
 
 				// Sync to ensure data is written to disk
-				err = file.Sync()
-				if err != nil {
-					log.Fatalf("Failed to sync file: %v", err)
+				if err := file.Sync(); err != nil {
+					log.Printf("Failed to sync file: %v", err)
 				}
 			}
 
 			// Update actual file size in resource status
+			rm.statusMu.Lock()
 			rm.resourceStatus.FileActualMB = writtenBytes / (1024 * 1024)
+			rm.statusMu.Unlock()
+		}
+	}
+}
+
+// growFile writes length bytes at offset, splitting large gaps across parallel
+// writers to reach rates a single sequential writer can't. It returns how many
+// bytes were written contiguously from offset, which is short after an error.
+func (rm *ResourceMock) growFile(file *os.File, content *fileContent, offset, length int64) (int64, error) {
+	if length < fileParallelBytes {
+		return rm.writeFileRange(file, content, offset, length)
+	}
+
+	share := (length/fileWriters + fileChunkBytes - 1) / fileChunkBytes * fileChunkBytes
+	type result struct {
+		size, written int64
+		err           error
+	}
+	results := make([]result, 0, fileWriters)
+	for start := int64(0); start < length; start += share {
+		results = append(results, result{size: min(share, length-start)})
+	}
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int, content fileContent) {
+			defer wg.Done()
+			defer nameThread("om-file-%d", i)()
+			r := &results[i]
+			r.written, r.err = rm.writeFileRange(file, &content, offset+int64(i)*share, r.size)
+		}(i, content.forWriter(i))
+	}
+	wg.Wait()
+
+	total := int64(0)
+	for _, r := range results {
+		total += r.written
+		if r.written < r.size {
+			return total, r.err
+		}
+	}
+	return total, nil
+}
+
+// writeFileRange writes length bytes at offset, retrying failed writes (e.g.
+// ENOSPC) in case space is freed up, and returns how many were written
+func (rm *ResourceMock) writeFileRange(file *os.File, content *fileContent, offset, length int64) (int64, error) {
+	buffer := make([]byte, min(length, fileChunkBytes))
+	written := int64(0)
+	for written < length {
+		content.fill(buffer)
+		start := written
+		chunk := buffer[:min(length-written, int64(len(buffer)))]
+		err := retryWithBackoff(rm.ctx, "write file", func() error {
+			// Continue a short write where it stopped
+			n, err := file.WriteAt(chunk[written-start:], offset+written)
+			written += int64(n)
+			return err
+		})
+		if err != nil {
+			return written, err
 		}
+		rm.fileSums.record(offset+start, chunk)
 	}
+	return written, nil
 }
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	registerInterval = 30 * time.Second     // How often agents register with the coordinator
+	agentExpiry      = 3 * registerInterval // Agents not seen for this long are no longer selected
+
+	defaultMaxClockOffset = 50 * time.Millisecond // Agents further off are not started in a synchronized run
+)
+
+// AgentInfo is an agent registered with the coordinator
+type AgentInfo struct {
+	Name     string            `json:"name"`
+	URL      string            `json:"url"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	LastSeen time.Time         `json:"last_seen"`
+}
+
+// FleetRequest asks the coordinator to start an experiment on a fraction of its agents
+type FleetRequest struct {
+	Fraction float64           `json:"fraction"`           // Share of the matching agents to activate (0-1)
+	Selector map[string]string `json:"selector,omitempty"` // Labels an agent must have to be considered
+	Seed     int64             `json:"seed,omitempty"`     // Seed of the random selection (0 = random)
+	Settings map[string]string `json:"settings"`           // Experiment flag values sent to every selected agent
+
+	// Agents refuse experiments loading a resource class a running one loads,
+	// or queue them with OnConflict queue; Owner names who asked in conflicts
+	Owner      string `json:"owner,omitempty"`
+	OnConflict string `json:"on_conflict,omitempty"` // reject or queue, default the agent's -on-conflict
+
+	// Synchronized start: agents start at now+StartLead by their own clock after
+	// the coordinator checked that their clocks are within MaxClockOffset of its own
+	StartLead      string `json:"start_lead,omitempty"`       // e.g. 5s, empty or 0 to start at once
+	MaxClockOffset string `json:"max_clock_offset,omitempty"` // e.g. 50ms, default defaultMaxClockOffset
+}
+
+// FleetReport records which agents a fleet experiment was started on
+type FleetReport struct {
+	Seed     int64              `json:"seed"`
+	Fraction float64            `json:"fraction"`
+	Selector map[string]string  `json:"selector,omitempty"`
+	Matched  int                `json:"matched"`
+	StartAt  *time.Time         `json:"start_at,omitempty"`
+	Selected []FleetAgentResult `json:"selected"`
+
+	Conflicts int `json:"conflicts,omitempty"` // Selected agents busy with a conflicting experiment
+}
+
+// FleetAgentResult is the outcome of starting the experiment on one agent
+type FleetAgentResult struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Error string `json:"error,omitempty"`
+
+	ClockOffset string `json:"clock_offset,omitempty"` // Agent clock minus coordinator clock
+
+	Conflict string `json:"conflict,omitempty"` // Why the agent didn't start the experiment at once
+	Queued   bool   `json:"queued,omitempty"`   // The agent starts it when the conflicting experiment ends
+}
+
+// Coordinator keeps track of registered agents and starts experiments on a subset of them
+type Coordinator struct {
+	mu     sync.Mutex
+	agents map[string]*AgentInfo
+	client *http.Client
+}
+
+// NewCoordinator creates a coordinator without agents
+func NewCoordinator() *Coordinator {
+	return &Coordinator{
+		agents: make(map[string]*AgentInfo),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// coordinatorCommand serves the coordinator API until interrupted
+func coordinatorCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	listen := fs.String("listen", ":7080", "Address to serve the coordinator API on")
+	sec := bindAPIFlags(fs, true, true)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if err := sec.validate(); err != nil {
+		return exitCodeFor(err)
+	}
+	client, err := sec.client(10 * time.Second)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+
+	coordinator := NewCoordinator()
+	coordinator.client = client
+	server := &http.Server{Addr: *listen, Handler: coordinator.Handler()}
+	go func() {
+		sig := <-notifySignals()
+		fmt.Printf("Received signal %v, stopping coordinator...\n", sig)
+		server.Close()
+	}()
+
+	fmt.Printf("Coordinator listening on %s\n", *listen)
+	if err := sec.serve(server, nil, "coordinator"); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Coordinator failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Handler returns the coordinator's HTTP handler
+//
+//	POST /register  register or refresh an agent; the body is an AgentInfo
+//	GET  /agents    list the live agents
+//	POST /run       start an experiment on a fraction of the agents; the body is a FleetRequest
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", c.handleRegister)
+	mux.HandleFunc("/agents", c.handleAgents)
+	mux.HandleFunc("/run", c.handleRun)
+	return mux
+}
+
+func (c *Coordinator) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var info AgentInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if info.Name == "" || info.URL == "" {
+		http.Error(w, "name and url are required", http.StatusBadRequest)
+		return
+	}
+	info.LastSeen = time.Now()
+
+	c.mu.Lock()
+	if _, known := c.agents[info.Name]; !known {
+		log.Printf("Agent %s registered at %s", info.Name, info.URL)
+	}
+	c.agents[info.Name] = &info
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleAgents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.liveAgents(nil))
+}
+
+func (c *Coordinator) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req FleetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Fraction <= 0 || req.Fraction > 1 {
+		http.Error(w, "fraction must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	if err := checkExperimentSettings(req.Settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := configFromSettings(req.Settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.OnConflict != "" && !slices.Contains(conflictPolicies, req.OnConflict) {
+		http.Error(w, fmt.Sprintf("invalid on_conflict %q (expected reject or queue)", req.OnConflict), http.StatusBadRequest)
+		return
+	}
+	lead, maxOffset, err := parseSyncStart(req.StartLead, req.MaxClockOffset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := c.startFleet(r.Context(), req, lead, maxOffset)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// liveAgents returns the agents seen recently that have all labels of selector, sorted by name
+func (c *Coordinator) liveAgents(selector map[string]string) []AgentInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var agents []AgentInfo
+	for _, info := range c.agents {
+		if time.Since(info.LastSeen) > agentExpiry || !matchLabels(info.Labels, selector) {
+			continue
+		}
+		agents = append(agents, *info)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return agents
+}
+
+// matchLabels reports whether labels contain every key and value of selector
+func matchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// selectAgents picks a seeded random fraction of agents, rounding up so that
+// any positive fraction of a non-empty fleet selects at least one agent
+func selectAgents(agents []AgentInfo, fraction float64, seed int64) []AgentInfo {
+	count := int(math.Ceil(fraction * float64(len(agents))))
+	if count > len(agents) {
+		count = len(agents)
+	}
+	shuffled := append([]AgentInfo(nil), agents...)
+	rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	selected := shuffled[:count]
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	return selected
+}
+
+// startFleet starts the experiment on the selected agents and reports the selection.
+// With a lead the agents are told to start together at now+lead, and agents whose
+// clock is more than maxOffset away from the coordinator's are left out.
+func (c *Coordinator) startFleet(ctx context.Context, req FleetRequest, lead, maxOffset time.Duration) FleetReport {
+	if req.Seed == 0 {
+		req.Seed = time.Now().UnixNano()
+	}
+	agents := c.liveAgents(req.Selector)
+	report := FleetReport{Seed: req.Seed, Fraction: req.Fraction, Selector: req.Selector, Matched: len(agents)}
+
+	settings := req.Settings
+	if lead > 0 {
+		startAt := time.Now().Add(lead).UTC()
+		report.StartAt = &startAt
+		settings = make(map[string]string, len(req.Settings)+1)
+		for name, value := range req.Settings {
+			settings[name] = value
+		}
+		settings["start-at"] = startAt.Format(time.RFC3339Nano)
+	}
+	body, _ := json.Marshal(settings)
+	query := url.Values{}
+	if req.Owner != "" {
+		query.Set("owner", req.Owner)
+	}
+	if req.OnConflict != "" {
+		query.Set("on_conflict", req.OnConflict)
+	}
+
+	selected := selectAgents(agents, req.Fraction, req.Seed)
+	report.Selected = make([]FleetAgentResult, len(selected))
+	var wg sync.WaitGroup
+	for i, agent := range selected {
+		report.Selected[i] = FleetAgentResult{Name: agent.Name, URL: agent.URL}
+		wg.Add(1)
+		go func(result *FleetAgentResult) {
+			defer wg.Done()
+			if lead > 0 {
+				offset, err := c.clockOffset(ctx, result.URL)
+				if err != nil {
+					result.Error = fmt.Sprintf("check clock: %v", err)
+					return
+				}
+				result.ClockOffset = offset.String()
+				if offset > maxOffset || offset < -maxOffset {
+					result.Error = fmt.Sprintf("clock offset %v exceeds %v, check NTP", offset, maxOffset)
+					return
+				}
+			}
+			reply, err := c.post(ctx, result.URL+"/run?"+query.Encode(), body)
+			var conflict *conflictError
+			switch {
+			case errors.As(err, &conflict):
+				result.Conflict = conflict.reason
+			case err != nil:
+				result.Error = err.Error()
+			default:
+				var started struct {
+					Queued   bool   `json:"queued"`
+					Conflict string `json:"conflict"`
+				}
+				json.Unmarshal(reply, &started)
+				result.Queued, result.Conflict = started.Queued, started.Conflict
+			}
+		}(&report.Selected[i])
+	}
+	wg.Wait()
+	for _, result := range report.Selected {
+		if result.Conflict != "" {
+			report.Conflicts++
+			log.Printf("Agent %s: experiment %s", result.Name, result.Conflict)
+		}
+	}
+	log.Printf("Fleet experiment started on %d of %d agents (seed %d)", len(selected), len(agents), req.Seed)
+	return report
+}
+
+// clockOffset estimates how far the agent's clock is ahead of ours, assuming
+// the agent read its clock halfway through the round trip
+func (c *Coordinator) clockOffset(ctx context.Context, agentURL string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agentURL+"/time", nil)
+	if err != nil {
+		return 0, err
+	}
+	sent := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	received := time.Now()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s", resp.Status)
+	}
+	var reply struct {
+		Time time.Time `json:"time"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return 0, err
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	return reply.Time.Sub(midpoint), nil
+}
+
+// parseSyncStart parses the start lead and clock offset limit of a fleet request
+func parseSyncStart(lead, maxOffset string) (time.Duration, time.Duration, error) {
+	var leadDur time.Duration
+	offsetDur := defaultMaxClockOffset
+	var err error
+	if lead != "" {
+		if leadDur, err = time.ParseDuration(lead); err != nil || leadDur < 0 {
+			return 0, 0, fmt.Errorf("invalid start lead %q", lead)
+		}
+	}
+	if maxOffset != "" {
+		if offsetDur, err = time.ParseDuration(maxOffset); err != nil || offsetDur <= 0 {
+			return 0, 0, fmt.Errorf("invalid max clock offset %q", maxOffset)
+		}
+	}
+	return leadDur, offsetDur, nil
+}
+
+// conflictError is an agent's refusal of an experiment conflicting with a running one
+type conflictError struct {
+	reason string
+}
+
+func (e *conflictError) Error() string {
+	return e.reason
+}
+
+// post sends a JSON body and returns the reply, failing unless the agent
+// accepted it; refusals for conflicts are conflictErrors
+func (c *Coordinator) post(ctx context.Context, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return nil, &conflictError{reason: strings.TrimSpace(string(msg))}
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return msg, nil
+}
+
+// registerWithCoordinator registers the agent until ctx is done
+func registerWithCoordinator(ctx context.Context, client *http.Client, coordinatorURL string, info AgentInfo) {
+	body, _ := json.Marshal(info)
+	for {
+		resp, err := client.Post(strings.TrimSuffix(coordinatorURL, "/")+"/register", "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to register with coordinator: %v", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Printf("Failed to register with coordinator: %s", resp.Status)
+			}
+		}
+		if !sleepCtx(ctx, registerInterval) {
+			return
+		}
+	}
+}
+
+// parseLabels parses "zone=a,rack=3" into a map
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q (expected key=value)", item)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// fleetCommand asks a coordinator to start an experiment on a fraction of its agents.
+// Flags after -- are the experiment's run flags.
+func fleetCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	coordinatorURL := fs.String("coordinator", "http://localhost:7080", "Base URL of the coordinator")
+	fraction := fs.String("fleet-fraction", "100%", "Percentage of the matching agents to activate, e.g. 30%")
+	selector := fs.String("selector", "", "Labels agents must have, e.g. zone=a")
+	seed := fs.Int64("seed", 0, "Seed of the random agent selection (0 = random, recorded in the report)")
+	reportPath := fs.String("report", "", "Write the JSON selection report to this file")
+	startLead := fs.Duration("start-lead", 5*time.Second, "Start all selected agents together this long after the request (0 = start each at once)")
+	maxOffset := fs.Duration("max-clock-offset", defaultMaxClockOffset, "Leave out agents whose clock is further off than this in a synchronized start")
+	owner := fs.String("owner", os.Getenv("USER"), "Who runs the experiment, named to others whose experiments conflict with it")
+	onConflict := fs.String("on-conflict", "", "Whether agents busy with an experiment loading the same resource class reject this one or queue it (default the agent's -on-conflict)")
+	sec := bindAPIFlags(fs, false, true)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	client, err := sec.client(0)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+
+	req := FleetRequest{Seed: *seed, StartLead: startLead.String(), MaxClockOffset: maxOffset.String(), Owner: *owner, OnConflict: *onConflict}
+	if req.Fraction, err = parsePercent(*fraction); err != nil {
+		return exitCodeFor(err)
+	}
+	if req.Selector, err = parseLabels(*selector); err != nil {
+		return exitCodeFor(err)
+	}
+	if req.Settings, err = settingsFromArgs(fs.Args()); err != nil {
+		return exitCodeFor(err)
+	}
+
+	body, _ := json.Marshal(req)
+	resp, err := client.Post(strings.TrimSuffix(*coordinatorURL, "/")+"/run", "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fleet failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Fleet failed: %s: %s", resp.Status, data)
+		return 1
+	}
+	var report FleetReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		fmt.Fprintf(os.Stderr, "Fleet failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Selected %d of %d agents (seed %d):\n", len(report.Selected), report.Matched, report.Seed)
+	failed := false
+	for _, agent := range report.Selected {
+		offset := ""
+		if agent.ClockOffset != "" {
+			offset = "  clock offset " + agent.ClockOffset
+		}
+		switch {
+		case agent.Error != "":
+			fmt.Printf("  %-24s %s  FAILED: %s\n", agent.Name, agent.URL, agent.Error)
+			failed = true
+		case agent.Queued:
+			fmt.Printf("  %-24s %s  QUEUED: %s\n", agent.Name, agent.URL, agent.Conflict)
+		case agent.Conflict != "":
+			fmt.Printf("  %-24s %s  CONFLICT: %s\n", agent.Name, agent.URL, agent.Conflict)
+			failed = true
+		default:
+			fmt.Printf("  %-24s %s%s\n", agent.Name, agent.URL, offset)
+		}
+	}
+	if report.StartAt != nil {
+		fmt.Printf("Starting at %s\n", report.StartAt.Format(time.RFC3339Nano))
+	}
+	if *reportPath != "" {
+		if err := os.WriteFile(*reportPath, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			return 1
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// settingsFromArgs validates run flags and returns the flags that were set as settings
+func settingsFromArgs(args []string) (map[string]string, error) {
+	var config Config
+	fs := flag.NewFlagSet("experiment", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bindFlags(fs, &config)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	settings := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		settings[f.Name] = f.Value.String()
+	})
+	if _, err := configFromSettings(settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// agentInfo builds the registration of this agent, defaulting the name to the
+// hostname and the URL to the hostname with the listen port
+func agentInfo(name, advertise, scheme, listen, labels string) (AgentInfo, error) {
+	hostname, err := os.Hostname()
+	if err != nil && (name == "" || advertise == "") {
+		return AgentInfo{}, fmt.Errorf("determine hostname: %w", err)
+	}
+	if name == "" {
+		name = hostname
+	}
+	if advertise == "" {
+		_, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return AgentInfo{}, fmt.Errorf("invalid listen address %q: %w", listen, err)
+		}
+		advertise = scheme + "://" + net.JoinHostPort(hostname, port)
+	}
+	parsed, err := parseLabels(labels)
+	if err != nil {
+		return AgentInfo{}, err
+	}
+	return AgentInfo{Name: name, URL: strings.TrimSuffix(advertise, "/"), Labels: parsed}, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// followTimeout bounds each -follow-query request, a slow Prometheus keeps the last target
+const followTimeout = 10 * time.Second
+
+// parseFollowScale parses -follow-scale, the CPU percent per unit of the
+// query result, e.g. 0.1%
+func parseFollowScale(s string) (float64, error) {
+	scale, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || scale <= 0 {
+		return 0, fmt.Errorf("invalid -follow-scale %q (expected CPU percent per unit of the query result, e.g. 0.1%%)", s)
+	}
+	return scale, nil
+}
+
+// queryPrometheus runs an instant query and returns its value. The query has
+// to return a scalar or a single series, aggregate others with sum().
+func queryPrometheus(ctx context.Context, server, query string) (float64, error) {
+	target := strings.TrimSuffix(server, "/") + "/api/v1/query?query=" + url.QueryEscape(query)
+	ctx, cancel := context.WithTimeout(ctx, followTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("%s: %s", server, resp.Status)
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("%s: %s", resp.Status, body.Error)
+	}
+
+	// Samples are [time, "value"]
+	var sample []interface{}
+	switch body.Data.ResultType {
+	case "scalar":
+		err = json.Unmarshal(body.Data.Result, &sample)
+	case "vector":
+		var series []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err = json.Unmarshal(body.Data.Result, &series); err == nil {
+			if len(series) != 1 {
+				return 0, fmt.Errorf("query returned %d series, aggregate them into one, e.g. with sum()", len(series))
+			}
+			sample = series[0].Value
+		}
+	default:
+		return 0, fmt.Errorf("query returned a %s, expected a scalar or a single series", body.Data.ResultType)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("unexpected sample %v", sample)
+	}
+	text, _ := sample[1].(string)
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, fmt.Errorf("query returned %q, not a number", text)
+	}
+	return value, nil
+}
+
+// followQuery moves the CPU target along the result of -follow-query, scaled
+// with -follow-scale up to -cpu. Failed queries keep the last target.
+func (rm *ResourceMock) followQuery() {
+	defer rm.wg.Done()
+
+	// No load until the metric is known
+	rm.targets.mu.Lock()
+	rm.targets.cpu = 0
+	rm.targets.mu.Unlock()
+
+	ticker := time.NewTicker(rm.config.FollowInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		value, err := queryPrometheus(rm.ctx, rm.config.FollowURL, rm.config.FollowQuery)
+		switch {
+		case rm.ctx.Err() != nil:
+			return
+		case err != nil:
+			if !failing {
+				log.Printf("Failed to query %s, keeping the CPU target: %v", rm.config.FollowURL, err)
+			}
+			failing = true
+		default:
+			if failing {
+				log.Printf("Following %s again", rm.config.FollowURL)
+			}
+			failing = false
+			target := math.Min(math.Max(value*rm.config.followScale, 0), rm.config.CPUPercent)
+			rm.targets.mu.Lock()
+			rm.targets.cpu = math.Round(target*10) / 10
+			rm.targets.mu.Unlock()
+			rm.statusMu.Lock()
+			rm.resourceStatus.FollowValue = value
+			rm.statusMu.Unlock()
+		}
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FreezeCycle is the duty cycle of -freeze: the target cgroup is frozen for On, then thawed for Off
+type FreezeCycle struct {
+	On  time.Duration
+	Off time.Duration
+}
+
+// parseFreezeCycle parses a duty cycle like "on=2s,off=8s"
+func parseFreezeCycle(s string) (FreezeCycle, error) {
+	var cycle FreezeCycle
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return cycle, fmt.Errorf("invalid freeze cycle %q (expected on=<duration>,off=<duration>)", s)
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return cycle, fmt.Errorf("invalid freeze cycle %q: %s must be a positive duration", s, key)
+		}
+		switch key {
+		case "on":
+			cycle.On = duration
+		case "off":
+			cycle.Off = duration
+		default:
+			return cycle, fmt.Errorf("unknown freeze cycle setting %q (supported: on, off)", key)
+		}
+	}
+	if cycle.On == 0 || cycle.Off == 0 {
+		return cycle, fmt.Errorf("invalid freeze cycle %q: both on and off are required", s)
+	}
+	return cycle, nil
+}
+
+// setCgroupFrozen freezes or thaws a cgroup through cgroup.freeze (v2) or freezer.state (v1)
+func setCgroupFrozen(path string, frozen bool) error {
+	if _, err := os.Stat(filepath.Join(path, "cgroup.freeze")); err == nil {
+		value := "0"
+		if frozen {
+			value = "1"
+		}
+		return os.WriteFile(filepath.Join(path, "cgroup.freeze"), []byte(value), 0644)
+	}
+	state := "THAWED"
+	if frozen {
+		state = "FROZEN"
+	}
+	return os.WriteFile(filepath.Join(path, "freezer.state"), []byte(state), 0644)
+}
+
+// freezeCgroup freezes and thaws -freeze-cgroup following the duty cycle until the
+// run ends. The cgroup is always left thawed.
+func (rm *ResourceMock) freezeCgroup() {
+	defer rm.wg.Done()
+
+	path, cycle := rm.config.FreezeCgroup, rm.config.freezeCycle
+	defer func() {
+		if err := setCgroupFrozen(path, false); err != nil {
+			log.Printf("Failed to thaw cgroup %s: %v", path, err)
+		}
+	}()
+
+	frozen := false
+	for {
+		// Stop freezing while host protection is throttling or the run ramps down
+		want := !frozen && rm.targetScale() > 0
+		if err := setCgroupFrozen(path, want); err != nil {
+			log.Printf("Failed to freeze cgroup %s: %v", path, err)
+			rm.markDegraded("freeze", err)
+			return
+		}
+		frozen = want
+
+		wait := cycle.Off
+		if frozen {
+			wait = cycle.On
+		}
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
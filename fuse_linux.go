@@ -0,0 +1,798 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The FUSE kernel protocol, version 7.31, as far as the passthrough filesystem of -fuse-mount needs it
+
+const (
+	fuseKernelMajor = 7
+	fuseKernelMinor = 31
+	fuseRootID      = 1
+	fuseMaxWrite    = 128 * 1024
+	fuseInHeader    = 40
+	fuseOutHeader   = 16
+)
+
+// Opcodes of FUSE requests
+const (
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseSetattr     = 4
+	fuseReadlink    = 5
+	fuseSymlink     = 6
+	fuseMknod       = 8
+	fuseMkdir       = 9
+	fuseUnlink      = 10
+	fuseRmdir       = 11
+	fuseRename      = 12
+	fuseLink        = 13
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseWrite       = 16
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseFsync       = 20
+	fuseFlush       = 25
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseFsyncdir    = 30
+	fuseAccess      = 34
+	fuseCreate      = 35
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fuseBatchForget = 42
+	fuseRename2     = 45
+)
+
+// Flags of the FUSE protocol
+const (
+	fuseAsyncRead   = 1 << 0 // INIT: reads may be sent in parallel
+	fuseBigWrites   = 1 << 5 // INIT: writes larger than a page
+	fuseDirectIO    = 1 << 0 // OPEN: bypass the page cache so every read and write reaches us
+	fuseGetattrFH   = 1 << 0 // GETATTR: fh is valid
+	fattrMode       = 1 << 0 // SETATTR: change mode
+	fattrUID        = 1 << 1 // SETATTR: change owner
+	fattrGID        = 1 << 2 // SETATTR: change group
+	fattrSize       = 1 << 3 // SETATTR: truncate
+	fattrAtime      = 1 << 4 // SETATTR: set access time
+	fattrMtime      = 1 << 5 // SETATTR: set modification time
+	fattrFH         = 1 << 6 // SETATTR: fh is valid
+	fattrAtimeNow   = 1 << 7 // SETATTR: set access time to now
+	fattrMtimeNow   = 1 << 8 // SETATTR: set modification time to now
+	fuseAttrSize    = 88     // struct fuse_attr
+	fuseEntryOut    = 40 + fuseAttrSize
+	fuseAttrOut     = 16 + fuseAttrSize
+	fuseOpenOutSize = 16
+)
+
+// fuseFaultOps are the operations that get latency, hangs and errors; the
+// protocol's lifecycle operations are left alone so the mount stays consistent
+var fuseFaultOps = map[uint32]bool{
+	fuseLookup: true, fuseGetattr: true, fuseSetattr: true, fuseReadlink: true,
+	fuseSymlink: true, fuseMknod: true, fuseMkdir: true, fuseUnlink: true,
+	fuseRmdir: true, fuseRename: true, fuseLink: true, fuseOpen: true,
+	fuseRead: true, fuseWrite: true, fuseStatfs: true, fuseFsync: true,
+	fuseFlush: true, fuseOpendir: true, fuseReaddir: true, fuseCreate: true,
+	fuseRename2: true,
+}
+
+// fuseNode is a path of the backing directory the kernel holds a reference to
+type fuseNode struct {
+	path    string // Relative to the backing directory, "" for the root
+	lookups uint64
+}
+
+// fuseFS serves the backing directory through a FUSE mount and injects the
+// faults configured with -fuse-latency, -fuse-error-rate and -fuse-hang
+type fuseFS struct {
+	rm      *ResourceMock
+	backing string
+	mount   string
+	dev     *os.File
+
+	mu         sync.Mutex
+	nodes      map[uint64]*fuseNode
+	byPath     map[string]uint64
+	nextNode   uint64
+	files      map[uint64]*os.File
+	dirs       map[uint64][]fuseDirent
+	nextHandle uint64
+	errorUntil time.Time
+	rng        *rand.Rand
+}
+
+// fuseDirent is a directory entry returned by READDIR
+type fuseDirent struct {
+	ino  uint64
+	typ  uint32
+	name string
+}
+
+// startFuse mounts the backing directory at -fuse-mount and serves it until the run ends
+func (rm *ResourceMock) startFuse() error {
+	backing, err := filepath.Abs(rm.config.FuseBacking)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(backing); err != nil || !info.IsDir() {
+		return fmt.Errorf("-fuse-backing %s is not a directory", backing)
+	}
+	mount, err := filepath.Abs(rm.config.FuseMount)
+	if err != nil {
+		return err
+	}
+
+	fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open /dev/fuse: %w", err)
+	}
+	options := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,allow_other,default_permissions",
+		fd, os.Getuid(), os.Getgid())
+	if err := syscall.Mount("outagemock", mount, "fuse.outagemock", syscall.MS_NOSUID|syscall.MS_NODEV, options); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("mount %s: %w", mount, err)
+	}
+	// A non-blocking device goes through the poller, so closing it ends a pending read
+	syscall.SetNonblock(fd, true)
+
+	fs := &fuseFS{
+		rm:       rm,
+		backing:  backing,
+		mount:    mount,
+		dev:      os.NewFile(uintptr(fd), "/dev/fuse"),
+		nodes:    map[uint64]*fuseNode{fuseRootID: {path: "", lookups: 1}},
+		byPath:   map[string]uint64{"": fuseRootID},
+		nextNode: fuseRootID + 1,
+		files:    make(map[uint64]*os.File),
+		dirs:     make(map[uint64][]fuseDirent),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	log.Printf("Serving %s at %s with injected faults", backing, mount)
+
+	rm.wg.Add(2)
+	go fs.serve()
+	go func() {
+		defer rm.wg.Done()
+		<-rm.ctx.Done()
+		if err := syscall.Unmount(mount, syscall.MNT_DETACH); err != nil {
+			log.Printf("Failed to unmount %s: %v", mount, err)
+		}
+		// Closing the device aborts the connection, failing requests of files still open
+		fs.dev.Close()
+	}()
+	return nil
+}
+
+// serve reads requests from the device and handles each in its own goroutine
+func (fs *fuseFS) serve() {
+	defer fs.rm.wg.Done()
+	buf := make([]byte, fuseMaxWrite+64*1024)
+	for {
+		n, err := fs.dev.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+				continue
+			}
+			// ENODEV after unmount or a closed device at the end of the run
+			if fs.rm.ctx.Err() == nil && !errors.Is(err, syscall.ENODEV) {
+				fs.rm.markDegraded("fuse", fmt.Errorf("read the FUSE device: %w", err))
+			}
+			return
+		}
+		if n < fuseInHeader {
+			continue
+		}
+		req := make([]byte, n)
+		copy(req, buf[:n])
+		go fs.handle(req)
+	}
+}
+
+// fuseRequest is a decoded request header and its body
+type fuseRequest struct {
+	opcode uint32
+	unique uint64
+	nodeID uint64
+	uid    uint32
+	gid    uint32
+	body   []byte
+}
+
+// handle answers one request
+func (fs *fuseFS) handle(data []byte) {
+	le := binary.LittleEndian
+	req := fuseRequest{
+		opcode: le.Uint32(data[4:]),
+		unique: le.Uint64(data[8:]),
+		nodeID: le.Uint64(data[16:]),
+		uid:    le.Uint32(data[24:]),
+		gid:    le.Uint32(data[28:]),
+		body:   data[fuseInHeader:],
+	}
+
+	switch req.opcode {
+	case fuseForget:
+		fs.forget(req.nodeID, le.Uint64(req.body))
+		return
+	case fuseBatchForget:
+		count := le.Uint32(req.body)
+		for i := uint32(0); i < count; i++ {
+			entry := req.body[8+16*i:]
+			fs.forget(le.Uint64(entry), le.Uint64(entry[8:]))
+		}
+		return
+	case fuseInterrupt:
+		return
+	}
+
+	if fuseFaultOps[req.opcode] {
+		if errno := fs.fault(); errno != 0 {
+			fs.reply(req.unique, errno, nil)
+			return
+		}
+	}
+	out, err := fs.dispatch(&req)
+	fs.reply(req.unique, toErrno(err), out)
+}
+
+// reply writes the answer to a request
+func (fs *fuseFS) reply(unique uint64, errno syscall.Errno, out []byte) {
+	if errno != 0 {
+		out = nil
+	}
+	msg := make([]byte, fuseOutHeader+len(out))
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	binary.LittleEndian.PutUint32(msg[4:], uint32(-int32(errno)))
+	binary.LittleEndian.PutUint64(msg[8:], unique)
+	copy(msg[fuseOutHeader:], out)
+	// ENOENT means the request was interrupted and is gone
+	if _, err := fs.dev.Write(msg); err != nil && fs.rm.ctx.Err() == nil && !errors.Is(err, syscall.ENOENT) {
+		log.Printf("FUSE reply failed: %v", err)
+	}
+}
+
+// toErrno converts an error of the backing filesystem into the errno returned to the kernel
+func toErrno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return syscall.EIO
+}
+
+// fault delays a request as configured and returns EIO when it should fail.
+// Faults follow the rampup and are scaled down by host protection and rampdown.
+func (fs *fuseFS) fault() syscall.Errno {
+	rm := fs.rm
+	if cycle := rm.config.fuseHang; cycle.On > 0 {
+		// Every cycle runs normally for Off and then hangs for On
+		period := cycle.On + cycle.Off
+		if pos := rm.clock.Since(rm.rampupStart) % period; pos >= cycle.Off {
+			if !sleepCtx(rm.ctx, period-pos) {
+				return syscall.EIO
+			}
+		}
+	}
+
+	intensity := rm.rampIntensity("fuse")
+	if latency := time.Duration(float64(rm.config.FuseLatency) * intensity); latency > 0 {
+		if !sleepCtx(rm.ctx, latency) {
+			return syscall.EIO
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	now := time.Now()
+	if now.Before(fs.errorUntil) {
+		return syscall.EIO
+	}
+	if fs.rng.Float64() < rm.config.fuseErrorRate*intensity {
+		fs.errorUntil = now.Add(rm.config.FuseErrorBurst)
+		return syscall.EIO
+	}
+	return 0
+}
+
+// dispatch performs a request on the backing directory and returns the reply body
+func (fs *fuseFS) dispatch(req *fuseRequest) ([]byte, error) {
+	le := binary.LittleEndian
+	body := req.body
+	switch req.opcode {
+	case fuseInit:
+		return fs.init(body), nil
+	case fuseDestroy:
+		return nil, nil
+	case fuseLookup:
+		return fs.lookup(req.nodeID, cString(body))
+	case fuseGetattr:
+		if le.Uint32(body)&fuseGetattrFH != 0 {
+			if f := fs.file(le.Uint64(body[8:])); f != nil {
+				return fs.attrOutFile(f)
+			}
+		}
+		return fs.attrOut(req.nodeID)
+	case fuseSetattr:
+		return fs.setattr(req.nodeID, body)
+	case fuseReadlink:
+		target, err := os.Readlink(fs.path(req.nodeID))
+		return []byte(target), err
+	case fuseSymlink:
+		name, rest := splitCString(body)
+		target, _ := splitCString(rest)
+		path := fs.child(req.nodeID, name)
+		if err := os.Symlink(target, path); err != nil {
+			return nil, err
+		}
+		return fs.created(req, name, path)
+	case fuseMknod:
+		name := cString(body[16:])
+		path := fs.child(req.nodeID, name)
+		if err := syscall.Mknod(path, le.Uint32(body), int(le.Uint32(body[4:]))); err != nil {
+			return nil, err
+		}
+		return fs.created(req, name, path)
+	case fuseMkdir:
+		name := cString(body[8:])
+		path := fs.child(req.nodeID, name)
+		if err := syscall.Mkdir(path, le.Uint32(body)); err != nil {
+			return nil, err
+		}
+		return fs.created(req, name, path)
+	case fuseUnlink:
+		return nil, syscall.Unlink(fs.child(req.nodeID, cString(body)))
+	case fuseRmdir:
+		return nil, syscall.Rmdir(fs.child(req.nodeID, cString(body)))
+	case fuseRename:
+		return nil, fs.rename(req.nodeID, le.Uint64(body), body[8:])
+	case fuseRename2:
+		if le.Uint32(body[8:]) != 0 {
+			return nil, syscall.EINVAL
+		}
+		return nil, fs.rename(req.nodeID, le.Uint64(body), body[16:])
+	case fuseLink:
+		name := cString(body[8:])
+		path := fs.child(req.nodeID, name)
+		if err := os.Link(fs.path(le.Uint64(body)), path); err != nil {
+			return nil, err
+		}
+		return fs.entry(req.nodeID, name)
+	case fuseOpen:
+		flags := int(le.Uint32(body)) &^ (syscall.O_CREAT | syscall.O_EXCL | syscall.O_NOCTTY)
+		f, err := os.OpenFile(fs.path(req.nodeID), flags, 0)
+		if err != nil {
+			return nil, err
+		}
+		return openOut(fs.addFile(f), fuseDirectIO), nil
+	case fuseCreate:
+		return fs.create(req)
+	case fuseRead:
+		f := fs.file(le.Uint64(body))
+		if f == nil {
+			return nil, syscall.EBADF
+		}
+		buf := make([]byte, le.Uint32(body[16:]))
+		n, err := syscall.Pread(int(f.Fd()), buf, int64(le.Uint64(body[8:])))
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case fuseWrite:
+		f := fs.file(le.Uint64(body))
+		if f == nil {
+			return nil, syscall.EBADF
+		}
+		size := le.Uint32(body[16:])
+		n, err := syscall.Pwrite(int(f.Fd()), body[40:40+size], int64(le.Uint64(body[8:])))
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 8)
+		le.PutUint32(out, uint32(n))
+		return out, nil
+	case fuseStatfs:
+		return fs.statfs()
+	case fuseRelease:
+		if f := fs.removeFile(le.Uint64(body)); f != nil {
+			f.Close()
+		}
+		return nil, nil
+	case fuseFsync:
+		if f := fs.file(le.Uint64(body)); f != nil {
+			return nil, f.Sync()
+		}
+		return nil, syscall.EBADF
+	case fuseFlush, fuseFsyncdir:
+		return nil, nil
+	case fuseOpendir:
+		entries, err := fs.readDir(fs.path(req.nodeID))
+		if err != nil {
+			return nil, err
+		}
+		fs.mu.Lock()
+		fs.nextHandle++
+		fh := fs.nextHandle
+		fs.dirs[fh] = entries
+		fs.mu.Unlock()
+		return openOut(fh, 0), nil
+	case fuseReaddir:
+		return fs.readdir(le.Uint64(body), le.Uint64(body[8:]), le.Uint32(body[16:])), nil
+	case fuseReleasedir:
+		fs.mu.Lock()
+		delete(fs.dirs, le.Uint64(body))
+		fs.mu.Unlock()
+		return nil, nil
+	case fuseAccess:
+		return nil, syscall.Access(fs.path(req.nodeID), le.Uint32(body))
+	}
+	return nil, syscall.ENOSYS
+}
+
+// init answers the INIT handshake
+func (fs *fuseFS) init(body []byte) []byte {
+	le := binary.LittleEndian
+	out := make([]byte, 64)
+	le.PutUint32(out, fuseKernelMajor)
+	le.PutUint32(out[4:], fuseKernelMinor)
+	le.PutUint32(out[8:], le.Uint32(body[8:])) // max_readahead as offered
+	le.PutUint32(out[12:], (fuseAsyncRead|fuseBigWrites)&le.Uint32(body[12:]))
+	le.PutUint16(out[16:], 16)                // max_background
+	le.PutUint16(out[18:], 12)                // congestion_threshold
+	le.PutUint32(out[20:], fuseMaxWrite)      // max_write
+	le.PutUint32(out[24:], 1)                 // time_gran in nanoseconds
+	le.PutUint16(out[28:], fuseMaxWrite/4096) // max_pages
+	return out
+}
+
+// path returns the backing path of a node
+func (fs *fuseFS) path(nodeID uint64) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if node := fs.nodes[nodeID]; node != nil {
+		return filepath.Join(fs.backing, node.path)
+	}
+	return filepath.Join(fs.backing, fmt.Sprintf(".outagemock-stale-node-%d", nodeID))
+}
+
+// child returns the backing path of name in the directory node parent
+func (fs *fuseFS) child(parent uint64, name string) string {
+	return filepath.Join(fs.path(parent), name)
+}
+
+// lookup resolves name in parent and takes a reference on its node
+func (fs *fuseFS) lookup(parent uint64, name string) ([]byte, error) {
+	return fs.entry(parent, name)
+}
+
+// entry stats name in parent and returns its fuse_entry_out, taking a node reference
+func (fs *fuseFS) entry(parent uint64, name string) ([]byte, error) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(fs.child(parent, name), &st); err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	rel := filepath.Join(fs.nodes[parent].path, name)
+	nodeID, ok := fs.byPath[rel]
+	if !ok {
+		nodeID = fs.nextNode
+		fs.nextNode++
+		fs.nodes[nodeID] = &fuseNode{path: rel}
+		fs.byPath[rel] = nodeID
+	}
+	fs.nodes[nodeID].lookups++
+	fs.mu.Unlock()
+
+	// Zero timeouts send every access to us, where the faults are injected
+	out := make([]byte, fuseEntryOut)
+	binary.LittleEndian.PutUint64(out, nodeID)
+	putAttr(out[40:], &st)
+	return out, nil
+}
+
+// created hands a new entry to the caller's uid and gid and returns its fuse_entry_out
+func (fs *fuseFS) created(req *fuseRequest, name, path string) ([]byte, error) {
+	if os.Getuid() == 0 {
+		os.Lchown(path, int(req.uid), int(req.gid))
+	}
+	return fs.entry(req.nodeID, name)
+}
+
+// create creates and opens a file
+func (fs *fuseFS) create(req *fuseRequest) ([]byte, error) {
+	le := binary.LittleEndian
+	flags := int(le.Uint32(req.body)) | syscall.O_CREAT
+	mode := le.Uint32(req.body[4:])
+	name := cString(req.body[16:])
+	path := fs.child(req.nodeID, name)
+	f, err := os.OpenFile(path, flags&^syscall.O_NOCTTY, os.FileMode(mode&0777))
+	if err != nil {
+		return nil, err
+	}
+	entry, err := fs.created(req, name, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return append(entry, openOut(fs.addFile(f), fuseDirectIO)...), nil
+}
+
+// forget drops references the kernel no longer holds
+func (fs *fuseFS) forget(nodeID, count uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node := fs.nodes[nodeID]
+	if node == nil || nodeID == fuseRootID {
+		return
+	}
+	if node.lookups <= count {
+		delete(fs.nodes, nodeID)
+		if fs.byPath[node.path] == nodeID {
+			delete(fs.byPath, node.path)
+		}
+		return
+	}
+	node.lookups -= count
+}
+
+// rename moves an entry and the paths of the nodes below it
+func (fs *fuseFS) rename(oldParent, newParent uint64, names []byte) error {
+	oldName, rest := splitCString(names)
+	newName, _ := splitCString(rest)
+	if err := os.Rename(fs.child(oldParent, oldName), fs.child(newParent, newName)); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldRel := filepath.Join(fs.nodes[oldParent].path, oldName)
+	newRel := filepath.Join(fs.nodes[newParent].path, newName)
+	delete(fs.byPath, newRel)
+	for nodeID, node := range fs.nodes {
+		if node.path != oldRel && !strings.HasPrefix(node.path, oldRel+"/") {
+			continue
+		}
+		if fs.byPath[node.path] == nodeID {
+			delete(fs.byPath, node.path)
+		}
+		node.path = newRel + strings.TrimPrefix(node.path, oldRel)
+		fs.byPath[node.path] = nodeID
+	}
+	return nil
+}
+
+// attrOut returns the fuse_attr_out of a node
+func (fs *fuseFS) attrOut(nodeID uint64) ([]byte, error) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(fs.path(nodeID), &st); err != nil {
+		return nil, err
+	}
+	out := make([]byte, fuseAttrOut)
+	putAttr(out[16:], &st)
+	return out, nil
+}
+
+// attrOutFile returns the fuse_attr_out of an open file
+func (fs *fuseFS) attrOutFile(f *os.File) ([]byte, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return nil, err
+	}
+	out := make([]byte, fuseAttrOut)
+	putAttr(out[16:], &st)
+	return out, nil
+}
+
+// setattr changes mode, owner, size and times of a node
+func (fs *fuseFS) setattr(nodeID uint64, body []byte) ([]byte, error) {
+	le := binary.LittleEndian
+	valid := le.Uint32(body)
+	path := fs.path(nodeID)
+	var f *os.File
+	if valid&fattrFH != 0 {
+		f = fs.file(le.Uint64(body[8:]))
+	}
+
+	if valid&fattrMode != 0 {
+		if err := syscall.Chmod(path, le.Uint32(body[60:])&07777); err != nil {
+			return nil, err
+		}
+	}
+	if valid&(fattrUID|fattrGID) != 0 {
+		uid, gid := -1, -1
+		if valid&fattrUID != 0 {
+			uid = int(le.Uint32(body[68:]))
+		}
+		if valid&fattrGID != 0 {
+			gid = int(le.Uint32(body[72:]))
+		}
+		if err := syscall.Lchown(path, uid, gid); err != nil {
+			return nil, err
+		}
+	}
+	if valid&fattrSize != 0 {
+		size := int64(le.Uint64(body[16:]))
+		var err error
+		if f != nil {
+			err = f.Truncate(size)
+		} else {
+			err = syscall.Truncate(path, size)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if valid&(fattrAtime|fattrMtime|fattrAtimeNow|fattrMtimeNow) != 0 {
+		// UTIME_OMIT leaves a time alone, UTIME_NOW sets it to the current time
+		const utimeNow, utimeOmit = (1 << 30) - 1, (1 << 30) - 2
+		times := []syscall.Timespec{{Nsec: utimeOmit}, {Nsec: utimeOmit}}
+		for i, bits := range [][2]uint32{{fattrAtime, fattrAtimeNow}, {fattrMtime, fattrMtimeNow}} {
+			switch {
+			case valid&bits[1] != 0:
+				times[i] = syscall.Timespec{Nsec: utimeNow}
+			case valid&bits[0] != 0:
+				times[i] = syscall.NsecToTimespec(int64(le.Uint64(body[32+8*i:]))*1e9 + int64(le.Uint32(body[56+4*i:])))
+			}
+		}
+		if err := syscall.UtimesNano(path, times); err != nil {
+			return nil, err
+		}
+	}
+	return fs.attrOut(nodeID)
+}
+
+// statfs reports the backing filesystem
+func (fs *fuseFS) statfs() ([]byte, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(fs.backing, &st); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	out := make([]byte, 80)
+	le.PutUint64(out, st.Blocks)
+	le.PutUint64(out[8:], st.Bfree)
+	le.PutUint64(out[16:], st.Bavail)
+	le.PutUint64(out[24:], st.Files)
+	le.PutUint64(out[32:], st.Ffree)
+	le.PutUint32(out[40:], uint32(st.Bsize))
+	le.PutUint32(out[44:], uint32(st.Namelen))
+	le.PutUint32(out[48:], uint32(st.Frsize))
+	return out, nil
+}
+
+// readDir lists a backing directory including . and ..
+func (fs *fuseFS) readDir(path string) ([]fuseDirent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	sort.Strings(names)
+	entries := []fuseDirent{{ino: 1, typ: syscall.DT_DIR, name: "."}, {ino: 1, typ: syscall.DT_DIR, name: ".."}}
+	for _, name := range names {
+		var st syscall.Stat_t
+		if syscall.Lstat(filepath.Join(path, name), &st) != nil {
+			continue
+		}
+		entries = append(entries, fuseDirent{ino: st.Ino, typ: st.Mode & syscall.S_IFMT >> 12, name: name})
+	}
+	return entries, nil
+}
+
+// readdir returns the fuse_dirent records of an open directory from offset that fit into size
+func (fs *fuseFS) readdir(fh, offset uint64, size uint32) []byte {
+	fs.mu.Lock()
+	entries := fs.dirs[fh]
+	fs.mu.Unlock()
+
+	le := binary.LittleEndian
+	var out []byte
+	for i := offset; i < uint64(len(entries)); i++ {
+		entry := entries[i]
+		length := (24 + len(entry.name) + 7) &^ 7
+		if len(out)+length > int(size) {
+			break
+		}
+		record := make([]byte, length)
+		le.PutUint64(record, entry.ino)
+		le.PutUint64(record[8:], i+1)
+		le.PutUint32(record[16:], uint32(len(entry.name)))
+		le.PutUint32(record[20:], entry.typ)
+		copy(record[24:], entry.name)
+		out = append(out, record...)
+	}
+	return out
+}
+
+// addFile registers an open backing file and returns its handle
+func (fs *fuseFS) addFile(f *os.File) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.nextHandle++
+	fs.files[fs.nextHandle] = f
+	return fs.nextHandle
+}
+
+// file returns the backing file of a handle
+func (fs *fuseFS) file(fh uint64) *os.File {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.files[fh]
+}
+
+// removeFile unregisters a handle and returns its file
+func (fs *fuseFS) removeFile(fh uint64) *os.File {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f := fs.files[fh]
+	delete(fs.files, fh)
+	return f
+}
+
+// openOut returns a fuse_open_out
+func openOut(fh uint64, flags uint32) []byte {
+	out := make([]byte, fuseOpenOutSize)
+	binary.LittleEndian.PutUint64(out, fh)
+	binary.LittleEndian.PutUint32(out[8:], flags)
+	return out
+}
+
+// putAttr encodes a stat result as struct fuse_attr
+func putAttr(b []byte, st *syscall.Stat_t) {
+	le := binary.LittleEndian
+	le.PutUint64(b, st.Ino)
+	le.PutUint64(b[8:], uint64(st.Size))
+	le.PutUint64(b[16:], uint64(st.Blocks))
+	le.PutUint64(b[24:], uint64(st.Atim.Sec))
+	le.PutUint64(b[32:], uint64(st.Mtim.Sec))
+	le.PutUint64(b[40:], uint64(st.Ctim.Sec))
+	le.PutUint32(b[48:], uint32(st.Atim.Nsec))
+	le.PutUint32(b[52:], uint32(st.Mtim.Nsec))
+	le.PutUint32(b[56:], uint32(st.Ctim.Nsec))
+	le.PutUint32(b[60:], st.Mode)
+	le.PutUint32(b[64:], uint32(st.Nlink))
+	le.PutUint32(b[68:], st.Uid)
+	le.PutUint32(b[72:], st.Gid)
+	le.PutUint32(b[76:], uint32(st.Rdev))
+	le.PutUint32(b[80:], uint32(st.Blksize))
+}
+
+// cString returns the NUL-terminated string at the start of b
+func cString(b []byte) string {
+	s, _ := splitCString(b)
+	return s
+}
+
+// splitCString splits b after its first NUL-terminated string
+func splitCString(b []byte) (string, []byte) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:]
+		}
+	}
+	return string(b), nil
+}
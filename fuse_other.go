@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// startFuse fails, the filesystem speaks the FUSE protocol of Linux
+func (rm *ResourceMock) startFuse() error {
+	return fmt.Errorf("-fuse-mount is %w", errNotLinux)
+}
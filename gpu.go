@@ -0,0 +1,308 @@
+//go:build gpu
+
+package main
+
+/*
+#cgo LDFLAGS: -lcuda -lnvidia-ml
+#include <stdlib.h>
+#include <cuda.h>
+#include <nvml.h>
+
+// launchSpin runs the spin kernel with one thread per block, keeping every SM busy for cycles
+static CUresult launchSpin(CUfunction fn, unsigned int blocks, long long cycles) {
+	void *args[] = { &cycles };
+	return cuLaunchKernel(fn, blocks, 1, 1, 1, 1, 1, 0, NULL, args, NULL);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"outagemock/consumer"
+)
+
+// Built with -tags gpu, the gpu consumer keeps the GPU busy for a percentage of
+// the time and gpumem holds a number of MB of device memory. The device is the
+// first one CUDA sees, pick another with CUDA_VISIBLE_DEVICES.
+func init() {
+	consumer.Register("gpu", func() (consumer.Consumer, error) { return &gpuLoad{}, nil })
+	consumer.Register("gpumem", func() (consumer.Consumer, error) { return &gpuMemory{}, nil })
+}
+
+const (
+	gpuPeriod  = 100 * time.Millisecond // Duty cycle period of the gpu consumer
+	gpuChunkMB = 16                     // Device memory is allocated in chunks of this size
+)
+
+// gpuSpinPTX spins every launched block until the given number of clock cycles has passed
+const gpuSpinPTX = `
+.version 6.0
+.target sm_50
+.address_size 64
+
+.visible .entry spin(.param .u64 cycles)
+{
+	.reg .pred %p;
+	.reg .b64 %rd<5>;
+	ld.param.u64 %rd1, [cycles];
+	mov.u64 %rd2, %clock64;
+LOOP:
+	mov.u64 %rd3, %clock64;
+	sub.s64 %rd4, %rd3, %rd2;
+	setp.lt.s64 %p, %rd4, %rd1;
+	@%p bra LOOP;
+	ret;
+}
+`
+
+// gpuDevice is the CUDA device shared by the gpu consumers, opened once
+type gpuDevice struct {
+	ctx      C.CUcontext // Primary context, made current on each consumer's thread
+	spin     C.CUfunction
+	sms      int // Streaming multiprocessors, one spinning block each
+	clockKHz int
+	nvml     C.nvmlDevice_t // Reports utilization, nil without NVML
+}
+
+var (
+	gpuOnce sync.Once
+	gpu     *gpuDevice
+	gpuErr  error
+)
+
+// cudaError turns a CUDA result into an error
+func cudaError(res C.CUresult, what string) error {
+	if res == C.CUDA_SUCCESS {
+		return nil
+	}
+	var msg *C.char
+	C.cuGetErrorString(res, &msg)
+	if msg == nil {
+		return fmt.Errorf("%s: CUDA error %d", what, int(res))
+	}
+	return fmt.Errorf("%s: %s", what, C.GoString(msg))
+}
+
+// openGPU initializes CUDA and NVML on first use. The caller's thread is left
+// with the device's context current.
+func openGPU() (*gpuDevice, error) {
+	gpuOnce.Do(func() {
+		gpu, gpuErr = initGPU()
+	})
+	if gpuErr != nil {
+		return nil, gpuErr
+	}
+	return gpu, cudaError(C.cuCtxSetCurrent(gpu.ctx), "make context current")
+}
+
+func initGPU() (*gpuDevice, error) {
+	if err := cudaError(C.cuInit(0), "init CUDA"); err != nil {
+		return nil, err
+	}
+	var dev C.CUdevice
+	if err := cudaError(C.cuDeviceGet(&dev, 0), "get device"); err != nil {
+		return nil, err
+	}
+	d := &gpuDevice{}
+	if err := cudaError(C.cuDevicePrimaryCtxRetain(&d.ctx, dev), "retain context"); err != nil {
+		return nil, err
+	}
+	if err := cudaError(C.cuCtxSetCurrent(d.ctx), "make context current"); err != nil {
+		return nil, err
+	}
+
+	var sms, clock C.int
+	if err := cudaError(C.cuDeviceGetAttribute(&sms, C.CU_DEVICE_ATTRIBUTE_MULTIPROCESSOR_COUNT, dev), "get SM count"); err != nil {
+		return nil, err
+	}
+	if err := cudaError(C.cuDeviceGetAttribute(&clock, C.CU_DEVICE_ATTRIBUTE_CLOCK_RATE, dev), "get clock rate"); err != nil {
+		return nil, err
+	}
+	d.sms, d.clockKHz = int(sms), int(clock)
+
+	ptx := C.CString(gpuSpinPTX)
+	defer C.free(unsafe.Pointer(ptx))
+	var module C.CUmodule
+	if err := cudaError(C.cuModuleLoadData(&module, unsafe.Pointer(ptx)), "load spin kernel"); err != nil {
+		return nil, err
+	}
+	name := C.CString("spin")
+	defer C.free(unsafe.Pointer(name))
+	if err := cudaError(C.cuModuleGetFunction(&d.spin, module, name), "get spin kernel"); err != nil {
+		return nil, err
+	}
+
+	// NVML numbers devices differently from CUDA, match them by PCI bus id
+	busID := make([]C.char, 32)
+	C.cuDeviceGetPCIBusId(&busID[0], C.int(len(busID)), dev)
+	if C.nvmlInit_v2() != C.NVML_SUCCESS || C.nvmlDeviceGetHandleByPciBusId_v2(&busID[0], &d.nvml) != C.NVML_SUCCESS {
+		log.Printf("NVML is unavailable, reporting the GPU duty cycle instead of measured utilization")
+		d.nvml = nil
+	}
+	log.Printf("Using GPU %s with %d SMs at %d MHz", strings.TrimRight(C.GoString(&busID[0]), "\x00"), d.sms, d.clockKHz/1000)
+	return d, nil
+}
+
+// utilization returns the GPU utilization measured by NVML in percent, or -1
+func (d *gpuDevice) utilization() float64 {
+	if d.nvml == nil {
+		return -1
+	}
+	var rates C.nvmlUtilization_t
+	if C.nvmlDeviceGetUtilizationRates(d.nvml, &rates) != C.NVML_SUCCESS {
+		return -1
+	}
+	return float64(rates.gpu)
+}
+
+// gpuWorker runs a consumer's loop on a locked thread with the device's context current
+type gpuWorker struct {
+	target   atomicFloat
+	degraded atomic.Bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func (w *gpuWorker) start(ctx context.Context, name string, loop func(ctx context.Context, d *gpuDevice) error) error {
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	opened := make(chan error, 1)
+	go func() {
+		defer close(w.done)
+		defer nameThread("om-%s", name)()
+		d, err := openGPU()
+		opened <- err
+		if err != nil {
+			return
+		}
+		if err := loop(ctx, d); err != nil {
+			log.Printf("GPU consumer %s failed: %v", name, err)
+			w.degraded.Store(true)
+		}
+	}()
+	return <-opened
+}
+
+func (w *gpuWorker) SetTarget(target float64) {
+	w.target.Store(target)
+}
+
+func (w *gpuWorker) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// gpuLoad keeps all SMs busy for the target percentage of every gpuPeriod,
+// which is what NVML reports as GPU utilization
+type gpuLoad struct {
+	gpuWorker
+	dev  atomic.Pointer[gpuDevice]
+	duty atomicFloat // Share of the last period the kernel ran, in percent
+}
+
+func (l *gpuLoad) Start(ctx context.Context) error {
+	return l.start(ctx, "gpu", func(ctx context.Context, d *gpuDevice) error {
+		l.dev.Store(d)
+		for {
+			start := time.Now()
+			busy := time.Duration(float64(gpuPeriod) * l.target.Load() / 100)
+			if busy > gpuPeriod {
+				busy = gpuPeriod
+			}
+			if busy > 0 {
+				cycles := busy.Nanoseconds() * int64(d.clockKHz) / 1e6
+				if err := cudaError(C.launchSpin(d.spin, C.uint(d.sms), C.longlong(cycles)), "launch kernel"); err != nil {
+					return err
+				}
+				if err := cudaError(C.cuCtxSynchronize(), "wait for kernel"); err != nil {
+					return err
+				}
+			}
+			l.duty.Store(100 * float64(time.Since(start)) / float64(gpuPeriod))
+			if !sleepCtx(ctx, gpuPeriod-time.Since(start)) {
+				return nil
+			}
+		}
+	})
+}
+
+func (l *gpuLoad) Status() consumer.Status {
+	actual := l.duty.Load()
+	if d := l.dev.Load(); d != nil {
+		if measured := d.utilization(); measured >= 0 {
+			actual = measured
+		}
+	}
+	return consumer.Status{Unit: "%", Target: l.target.Load(), Actual: actual, Degraded: l.degraded.Load()}
+}
+
+// gpuMemory holds the target MB of device memory, written once so it is backed
+type gpuMemory struct {
+	gpuWorker
+	allocatedMB atomic.Int64
+}
+
+func (m *gpuMemory) Start(ctx context.Context) error {
+	return m.start(ctx, "gpumem", func(ctx context.Context, d *gpuDevice) error {
+		var chunks []C.CUdeviceptr
+		defer func() {
+			for _, ptr := range chunks {
+				C.cuMemFree(ptr)
+			}
+			m.allocatedMB.Store(0)
+		}()
+
+		full := false // Allocation failed, don't retry until the target drops
+		ticker := time.NewTicker(gpuPeriod)
+		defer ticker.Stop()
+		for {
+			want := int(m.target.Load()) / gpuChunkMB
+			if want < len(chunks) {
+				full = false
+			}
+			for len(chunks) > want {
+				C.cuMemFree(chunks[len(chunks)-1])
+				chunks = chunks[:len(chunks)-1]
+			}
+			for len(chunks) < want && !full {
+				var ptr C.CUdeviceptr
+				size := C.size_t(gpuChunkMB << 20)
+				err := cudaError(C.cuMemAlloc(&ptr, size), "allocate device memory")
+				if err == nil {
+					err = cudaError(C.cuMemsetD8(ptr, 0xa5, size), "write device memory")
+					if err != nil {
+						C.cuMemFree(ptr)
+					}
+				}
+				if err != nil {
+					log.Printf("GPU memory stays at %d MB: %v", len(chunks)*gpuChunkMB, err)
+					m.degraded.Store(true)
+					full = true
+					break
+				}
+				chunks = append(chunks, ptr)
+			}
+			m.allocatedMB.Store(int64(len(chunks) * gpuChunkMB))
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (m *gpuMemory) Status() consumer.Status {
+	return consumer.Status{Unit: "MB", Target: m.target.Load(), Actual: float64(m.allocatedMB.Load()), Degraded: m.degraded.Load()}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// testSampleInterval is how often the test command samples the lanes for its checks
+const testSampleInterval = time.Second
+
+// ScenarioCheck compares a status metric of lanes with a value.
+//
+//	{"name": "memory held", "lane": "db", "metric": "memory_actual_mb", "op": ">=", "value": 900, "when": "end"}
+//
+// Metrics are the JSON fields of the status, nested ones joined with dots
+// like canary.p99_ms; booleans count as 0 and 1. Without a lane the check
+// applies to every lane.
+type ScenarioCheck struct {
+	Name   string  `json:"name"`
+	Lane   string  `json:"lane"`
+	Metric string  `json:"metric"`
+	Op     string  `json:"op"`
+	Value  float64 `json:"value"`
+	When   string  `json:"when"` // Assertions only: end (default), peak, or always once rampup is over
+}
+
+// checkOps compare an observed metric with the value of a check
+var checkOps = map[string]func(a, b float64) bool{
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+// validate checks the check against the lanes of its scenario and fills in defaults
+func (c *ScenarioCheck) validate(lanes []lane) error {
+	if c.Name == "" {
+		c.Name = strings.TrimSpace(fmt.Sprintf("%s %s %s %g", c.Lane, c.Metric, c.Op, c.Value))
+	}
+	if c.When == "" {
+		c.When = "end"
+	}
+	if _, ok := checkOps[c.Op]; !ok {
+		return fmt.Errorf("check %q: invalid op %q (supported: <, <=, >, >=, ==, !=)", c.Name, c.Op)
+	}
+	if c.When != "end" && c.When != "peak" && c.When != "always" {
+		return fmt.Errorf("check %q: invalid when %q (supported: end, peak, always)", c.Name, c.When)
+	}
+	top, _, _ := strings.Cut(c.Metric, ".")
+	if !statusFields[top] {
+		return fmt.Errorf("check %q: unknown metric %q", c.Name, c.Metric)
+	}
+	if c.Lane != "" {
+		for _, l := range lanes {
+			if l.name == c.Lane {
+				return nil
+			}
+		}
+		return fmt.Errorf("check %q: unknown lane %q", c.Name, c.Lane)
+	}
+	return nil
+}
+
+// statusFields are the JSON names of the top level status fields checks can refer to
+var statusFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(ResourceStatus{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = true
+	}
+	return fields
+}()
+
+// statusMetrics flattens a status into the metrics checks compare
+func statusMetrics(status ResourceStatus) map[string]float64 {
+	data, _ := json.Marshal(status)
+	var tree map[string]interface{}
+	json.Unmarshal(data, &tree)
+	flat := map[string]string{}
+	flattenJSON("", tree, flat)
+
+	metrics := map[string]float64{}
+	for name, value := range flat {
+		switch value {
+		case "true":
+			metrics[name] = 1
+		case "false":
+			metrics[name] = 0
+		default:
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				metrics[name] = v
+			}
+		}
+	}
+	return metrics
+}
+
+// checkResult tracks one check on one lane during the test
+type checkResult struct {
+	check     ScenarioCheck
+	lane      int
+	guardrail bool
+	observed  float64 // Last, peak or violating value, depending on the check
+	seen      bool    // The metric was reported at least once
+	failure   string  // Set once the check failed
+}
+
+// name identifies the check and lane in the report
+func (r *checkResult) name(lanes []lane) string {
+	kind := "assertion"
+	if r.guardrail {
+		kind = "guardrail"
+	}
+	return fmt.Sprintf("%s: %s [%s]", kind, r.check.Name, lanes[r.lane].name)
+}
+
+// observe updates the result with a sample of the lane's metrics
+func (r *checkResult) observe(metrics map[string]float64, afterRampup bool) {
+	value, ok := metrics[r.check.Metric]
+	if !ok {
+		// Fields left out when zero are reported as 0
+		if !statusFields[r.check.Metric] {
+			return
+		}
+		value = 0
+	}
+	holds := checkOps[r.check.Op](value, r.check.Value)
+	switch {
+	case r.guardrail || r.check.When == "always" && afterRampup:
+		if !holds && r.failure == "" {
+			r.failure = fmt.Sprintf("%s was %g, violating %s %g", r.check.Metric, value, r.check.Op, r.check.Value)
+		}
+		r.observed = value
+	case r.check.When == "peak":
+		if !r.seen || value > r.observed {
+			r.observed = value
+		}
+	case r.check.When == "end":
+		r.observed = value
+	}
+	r.seen = true
+}
+
+// finish evaluates end and peak assertions once the lanes are done
+func (r *checkResult) finish() {
+	if r.failure != "" || r.guardrail || r.check.When == "always" {
+		return
+	}
+	if !r.seen {
+		r.failure = fmt.Sprintf("%s was never reported", r.check.Metric)
+		return
+	}
+	if !checkOps[r.check.Op](r.observed, r.check.Value) {
+		r.failure = fmt.Sprintf("%s %s was %g, expected %s %g", r.check.When, r.check.Metric, r.observed, r.check.Op, r.check.Value)
+	}
+}
+
+// testCommand runs a scenario as an integration test: guardrails stop the
+// run when violated, assertions are evaluated afterwards, and the result is
+// written as JUnit XML and reported in the exit code
+func testCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	junit := fs.String("junit", "outagemock-test.xml", "Write the results as a JUnit XML report to this file, empty to skip")
+	vars := scenarioVars{}
+	fs.Var(vars, "set", "Set the scenario variable ${NAME}, as NAME=value; repeatable, overrides the environment")
+	timeScale := fs.String("time-scale", "", "Run every lane this many times faster than real time, e.g. 10x to rehearse the timelines; overrides -time-scale of the lanes")
+	seed := fs.Int64("seed", 0, "Seed of the settings drawn from distributions (0 = random, recorded in the report to repeat the run)")
+	var quota Quota
+	bindFlags(fs, &quota)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: outagemock test [flags] SCENARIO\n\n%s\n\nSCENARIO is a JSON file, convert YAML with e.g. yq -o=json.\n\n", cmd.summary)
+		printFlagGroups(out, fs, cmd.helpGroup)
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	rng, usedSeed := newSampler(*seed)
+	lanes, scenario, err := loadScenario(fs.Arg(0), vars, rng)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	if err := openOutput(lanes[0].rm.config); err != nil {
+		return exitCodeFor(err)
+	}
+	printSamples(lanes, usedSeed)
+	if len(scenario.Assertions)+len(scenario.Guardrails) == 0 {
+		return exitCodeFor(fmt.Errorf("scenario %s has no assertions or guardrails to test", fs.Arg(0)))
+	}
+	for _, l := range lanes {
+		l.rm.config.Quota = l.rm.config.Quota.tighten(quota)
+	}
+	if err := scaleLanes(lanes, *timeScale); err != nil {
+		return exitCodeFor(err)
+	}
+
+	var results []*checkResult
+	for guardrail, checks := range [][]ScenarioCheck{scenario.Assertions, scenario.Guardrails} {
+		for _, check := range checks {
+			for i, l := range lanes {
+				if check.Lane == "" || check.Lane == l.name {
+					results = append(results, &checkResult{check: check, lane: i, guardrail: guardrail == 1})
+				}
+			}
+		}
+	}
+
+	// Signals and violated guardrails both stop every lane
+	stop := make(chan os.Signal, 1)
+	signals := notifyRunSignals()
+	codes := make(chan int, 1)
+	start := time.Now()
+	laneStarts := make([]time.Time, len(lanes))
+	for i, l := range lanes {
+		laneStarts[i] = l.rm.clock.Now()
+	}
+	go func() { codes <- runLanes(lanes, stop) }()
+
+	ticker := time.NewTicker(testSampleInterval)
+	defer ticker.Stop()
+	var code int
+	for running, stopped := true, false; running; {
+		select {
+		case code = <-codes:
+			running = false
+		case sig := <-signals:
+			select {
+			case stop <- sig:
+			default:
+			}
+		case <-ticker.C:
+			for _, r := range results {
+				l := lanes[r.lane]
+				if l.rm.ctx.Err() != nil {
+					continue
+				}
+				r.observe(statusMetrics(l.rm.Status()), l.rm.clock.Since(laneStarts[r.lane]) >= l.rm.config.RampupTime)
+				if r.guardrail && r.failure != "" && !stopped {
+					messagef("Guardrail %s violated: %s, stopping", r.name(lanes), r.failure)
+					stopped = true
+					select {
+					case stop <- syscall.SIGTERM:
+					default:
+					}
+				}
+			}
+		}
+	}
+
+	elapsed := time.Since(start).Seconds()
+	suite := junitSuite{Name: "outagemock test " + fs.Arg(0), Time: elapsed}
+	// The drawn settings and their seed, to reproduce the run
+	for _, l := range lanes {
+		for _, name := range l.sampleNames() {
+			if suite.Properties == nil {
+				suite.Properties = &junitProperties{Property: []junitProperty{{"seed", strconv.FormatInt(usedSeed, 10)}}}
+			}
+			suite.Properties.Property = append(suite.Properties.Property, junitProperty{l.name + "." + name, l.samples[name]})
+		}
+	}
+	addCase := func(name, failure string) {
+		c := junitCase{Name: name, Time: elapsed}
+		if failure != "" {
+			c.Failure = &junitFailure{Message: failure}
+			suite.Failures++
+			textf("FAIL %s: %s", name, failure)
+		} else {
+			textf("PASS %s", name)
+		}
+		suite.Cases = append(suite.Cases, c)
+		suite.Tests++
+	}
+	runFailure := ""
+	if code != 0 {
+		runFailure = fmt.Sprintf("run ended with exit code %d", code)
+	}
+	addCase("run", runFailure)
+	for _, r := range results {
+		r.finish()
+		addCase(r.name(lanes), r.failure)
+	}
+
+	if *junit != "" {
+		data, err := xml.MarshalIndent(suite, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*junit, append([]byte(xml.Header), append(data, '\n')...), 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write JUnit report: %v\n", err)
+		}
+	}
+	if suite.Failures > 0 {
+		textf("%d of %d checks failed", suite.Failures, suite.Tests)
+		return exitTestFailed
+	}
+	textf("All %d checks passed", suite.Tests)
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// heartbeatRecord is the JSON written with -heartbeat-file and -heartbeat-url.
+// A record whose time stops advancing while its phase isn't "end" means the
+// process died, and WorkPaths lists what it leaves behind.
+type heartbeatRecord struct {
+	PID       int            `json:"pid"`
+	Host      string         `json:"host"`
+	Time      time.Time      `json:"time"`
+	Phase     string         `json:"phase"`                  // rampup, steady, rampdown or end
+	Progress  *int           `json:"progress_pct,omitempty"` // Unset in the steady phase before a secret end
+	Deadline  *time.Time     `json:"deadline,omitempty"`     // Unset while the end is secret
+	ExitCode  *int           `json:"exit_code,omitempty"`    // Set in the final record
+	WorkPaths []string       `json:"work_paths,omitempty"`
+	Status    ResourceStatus `json:"status"`
+}
+
+// heartbeatRecord builds the current record
+func (rm *ResourceMock) heartbeatRecord() heartbeatRecord {
+	host, _ := os.Hostname()
+	now := time.Now()
+	phase, pct := rm.phaseAt(rm.clock.Now())
+	record := heartbeatRecord{
+		PID:    os.Getpid(),
+		Host:   host,
+		Time:   now.UTC(),
+		Phase:  phase,
+		Status: rm.Status(),
+	}
+	if pct >= 0 {
+		record.Progress = &pct
+	}
+	if deadline := rm.publicDeadline(); deadline != nil {
+		utc := deadline.UTC()
+		record.Deadline = &utc
+	}
+	if rm.config.FileSizeMB > 0 && rm.filePath != "" {
+		record.WorkPaths = append(record.WorkPaths, rm.filePath)
+	}
+	if rm.config.Tree != "" && rm.filePath != "" {
+		record.WorkPaths = append(record.WorkPaths, treeRoot(rm.filePath))
+	}
+	if rm.config.FuseMount != "" {
+		record.WorkPaths = append(record.WorkPaths, rm.config.FuseMount)
+	}
+	if rm.config.DMDevice != "" {
+		record.WorkPaths = append(record.WorkPaths, "/dev/mapper/"+rm.config.dmName())
+	}
+	if rm.cgroup != nil {
+		record.WorkPaths = append(record.WorkPaths, rm.cgroup.path)
+	}
+	return record
+}
+
+// sendHeartbeats publishes the status every -heartbeat-interval until the run ends
+func (rm *ResourceMock) sendHeartbeats() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(rm.config.HeartbeatInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		err := rm.publishHeartbeat(rm.heartbeatRecord())
+		// Log when publishing starts and stops failing instead of every beat
+		if err != nil && !failing {
+			log.Printf("Failed to publish heartbeat: %v", err)
+		} else if err == nil && failing {
+			log.Printf("Heartbeat published again")
+		}
+		failing = err != nil
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// finalHeartbeat publishes the end of the run with its exit code
+func (rm *ResourceMock) finalHeartbeat(code int) {
+	if rm.config.HeartbeatFile == "" && rm.config.HeartbeatURL == "" {
+		return
+	}
+	record := rm.heartbeatRecord()
+	record.Phase = "end"
+	done := 100
+	record.Progress = &done
+	record.ExitCode = &code
+	record.WorkPaths = nil
+	if err := rm.publishHeartbeat(record); err != nil {
+		log.Printf("Failed to publish final heartbeat: %v", err)
+	}
+}
+
+// publishHeartbeat writes the record to the heartbeat file and posts it to the heartbeat URL
+func (rm *ResourceMock) publishHeartbeat(record heartbeatRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if path := rm.config.HeartbeatFile; path != "" {
+		// Rename over the old file so watchers never read a partial record
+		if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	if rm.config.HeartbeatURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), rm.config.HeartbeatInterval)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, rm.config.HeartbeatURL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s: %s", rm.config.HeartbeatURL, resp.Status)
+		}
+	}
+	return nil
+}
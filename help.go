@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+// flagGroup is a section of the help of the commands taking Config flags,
+// holding the flags whose group tag is name
+type flagGroup struct {
+	name     string
+	title    string
+	examples []string
+}
+
+// flagGroups lists the sections of the help in the order they are shown
+var flagGroups = []flagGroup{
+	{"cpu", "CPU", []string{
+		"outagemock -cpu 80 -rampup 2m -duration 10m",
+		"outagemock -cpu-cores-used 2.5 -cpu-of limit -cpu-workload avx",
+	}},
+	{"memory", "Memory", []string{
+		"outagemock -memory 4096 -mlock -duration 15m",
+		"outagemock -memory 2048 -mem-variance 20% -mem-variance-period 1m",
+	}},
+	{"file", "Files and disks", []string{
+		"outagemock -fsize 10G -fpath /data/fill -file-rate 100MB/s,rampup",
+		"outagemock -fill-all-mounts pattern=/data*,fill=90% -duration 5m",
+	}},
+	{"kernel", "Kernel and scheduler", []string{
+		"outagemock -loadavg 16 -loadavg-mix 50%",
+		"outagemock -softirq net=200000,timers=20000 -kmem dentries=2000000",
+	}},
+	{"network", "Network and object storage", []string{
+		"outagemock -conn-target redis://cache:6379 -conn-count 5000 -conn-payload redis-ping",
+		"outagemock -s3-endpoint http://minio:9000 -s3-bucket load -s3-upload-rate 50M",
+	}},
+	{"shape", "Load shapes", []string{
+		"outagemock -emulate jvm-gc -cpu 60 -memory 4096 -duration 1h",
+		"outagemock -cpu 50 -memory 1024 -correlate memory=cpu@30s*40+512",
+	}},
+	{"timing", "Timing", []string{
+		"outagemock -cpu 70 -duration 20m±10m -jitter 10% -seed 42",
+		"outagemock -cpu 90 -start-at 2030-01-01T03:00:00Z -rampdown 1m",
+	}},
+	{"faults", "Fault injection", []string{
+		"outagemock -fuse-mount /mnt/slow -fuse-backing /data -fuse-latency 200ms",
+		"outagemock -freeze-cgroup /sys/fs/cgroup/app.slice -freeze on=5s,off=30s",
+	}},
+	{"isolation", "Targeting and isolation", []string{
+		"outagemock -target-container payments -memory 1024",
+		"outagemock -systemd-run -systemd-properties CPUQuota=200%,MemoryMax=2G -memory 4096",
+	}},
+	{"safety", "Safety", []string{
+		"outagemock -cpu 95 -max-cpu 80 -protect-host cpu=95,load=2x",
+		"outagemock -fsize 5G -strict -on-consumer-error abort",
+	}},
+	{"reporting", "Output and reporting", []string{
+		"outagemock -cpu 50 -output console,json:/var/log/outagemock.json",
+		"outagemock -cpu 50 -junit result.xml -ci-events -status-file /run/outagemock.json",
+	}},
+}
+
+// lookupFlagGroup returns the group with the given name, or nil
+func lookupFlagGroup(name string) *flagGroup {
+	for i := range flagGroups {
+		if flagGroups[i].name == name {
+			return &flagGroups[i]
+		}
+	}
+	return nil
+}
+
+// flagGroupOf returns the group tag of a Config flag, empty for the flags of
+// a command itself, even those named like a Config flag
+func flagGroupOf(f *flag.Flag) string {
+	field, ok := settingField(f.Name)
+	if !ok || field.Tag.Get("usage") != f.Usage {
+		return ""
+	}
+	return field.Tag.Get("group")
+}
+
+// printFlagGroups writes the flags of fs by group, only those of the group
+// named only when it is set. Flags outside Config come first; the commands
+// taking all Config flags get examples.
+func printFlagGroups(w io.Writer, fs *flag.FlagSet, only string) {
+	sections := map[string]*flag.FlagSet{}
+	fs.VisitAll(func(f *flag.Flag) {
+		group := flagGroupOf(f)
+		if sections[group] == nil {
+			sections[group] = flag.NewFlagSet(group, flag.ContinueOnError)
+			sections[group].SetOutput(w)
+		}
+		sections[group].Var(f.Value, f.Name, f.Usage)
+		sections[group].Lookup(f.Name).DefValue = f.DefValue
+	})
+
+	separate := false
+	if section := sections[""]; section != nil && only == "" {
+		fmt.Fprintln(w, "Flags:")
+		section.PrintDefaults()
+		separate = true
+	}
+	examples := fs.Lookup("cpu") != nil
+	for _, group := range flagGroups {
+		section := sections[group.name]
+		if section == nil || only != "" && only != group.name {
+			continue
+		}
+		if separate {
+			fmt.Fprintln(w)
+		}
+		separate = true
+		fmt.Fprintf(w, "%s flags (outagemock help %s %s):\n", group.title, fs.Name(), group.name)
+		section.PrintDefaults()
+		if examples {
+			fmt.Fprintln(w, "  Examples:")
+			for _, example := range group.examples {
+				fmt.Fprintf(w, "    %s\n", example)
+			}
+		}
+	}
+}
+
+// flagGroupNames returns the names of the groups
+func flagGroupNames() []string {
+	names := make([]string, len(flagGroups))
+	for i, group := range flagGroups {
+		names[i] = group.name
+	}
+	return names
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyIDFormat names the report of each run in the -history directory,
+// followed by the pid so concurrent runs get their own
+const historyIDFormat = "20060102T150405Z"
+
+// runID returns the id of a run ending at t in this process
+func runID(t time.Time) string {
+	return t.UTC().Format(historyIDFormat) + "-" + strconv.Itoa(os.Getpid())
+}
+
+// createUnique calls create with name and then name-2, name-3 and so on while
+// the name already exists, e.g. of an earlier run of the agent in the same
+// second, and returns the name it created
+func createUnique(name string, create func(name string) error) (string, error) {
+	for n := 1; ; n++ {
+		unique := name
+		if n > 1 {
+			unique += "-" + strconv.Itoa(n)
+		}
+		if err := create(unique); !errors.Is(err, fs.ErrExist) {
+			return unique, err
+		}
+	}
+}
+
+// historyEnv describes the host a run happened on
+type historyEnv struct {
+	Host          string `json:"host"`
+	Kernel        string `json:"kernel"`
+	Arch          string `json:"arch"`
+	CPUs          int    `json:"cpus"`
+	MemoryTotalMB int64  `json:"memory_total_mb"`
+	Version       string `json:"go_version"`
+}
+
+// historyRecord is the report of one run stored with -history
+type historyRecord struct {
+	ID          string         `json:"id"`
+	Start       time.Time      `json:"start"`
+	End         time.Time      `json:"end"`
+	ExitCode    int            `json:"exit_code"`
+	Environment historyEnv     `json:"environment"`
+	Config      Config         `json:"config"`
+	Peaks       historyPeaks   `json:"peaks"`
+	Status      ResourceStatus `json:"status"`
+}
+
+// historyPeaks are the highest values of a run, see runPeaks
+type historyPeaks struct {
+	CPUPercent  float64   `json:"cpu_percent"`
+	MeasuredCPU float64   `json:"measured_cpu_percent,omitempty"`
+	MemoryMB    int64     `json:"memory_mb"`
+	FileMB      int64     `json:"file_mb"`
+	Pressure    *Pressure `json:"pressure,omitempty"` // Highest PSI avg10 values
+}
+
+// hostEnvironment describes this host for the history
+func hostEnvironment() historyEnv {
+	env := historyEnv{Arch: runtime.GOARCH, CPUs: runtime.NumCPU(), Version: runtime.Version()}
+	env.Host, _ = os.Hostname()
+	env.Kernel = kernelRelease()
+	if info, err := readMemInfo(); err == nil {
+		env.MemoryTotalMB = info["MemTotal"] / 1024
+	}
+	return env
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// runRecord returns the report of the finished run
+func (rm *ResourceMock) runRecord(code int) historyRecord {
+	rm.statusMu.Lock()
+	peaks := rm.peaks
+	rm.statusMu.Unlock()
+	now := time.Now().UTC()
+	return historyRecord{
+		ID:          runID(now),
+		Start:       rm.rampupStart.UTC(),
+		End:         now,
+		ExitCode:    code,
+		Environment: hostEnvironment(),
+		Config:      rm.config,
+		Peaks:       historyPeaks{peaks.cpuPercent, peaks.measuredCPU, peaks.memoryMB, peaks.fileMB, peaks.pressure},
+		Status:      rm.Status(),
+	}
+}
+
+// saveHistory stores the report of the finished run in the -history directory
+func (rm *ResourceMock) saveHistory(code int) {
+	if rm.config.History == "" {
+		return
+	}
+	record := rm.runRecord(code)
+
+	dir := expandHome(rm.config.History)
+	var file *os.File
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		record.ID, err = createUnique(record.ID, func(id string) (err error) {
+			file, err = os.OpenFile(filepath.Join(dir, id+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			return err
+		})
+	}
+	if err == nil {
+		data, _ := json.MarshalIndent(record, "", "  ")
+		_, err = file.Write(append(data, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save run history: %v\n", err)
+		return
+	}
+	messagef("Run saved to history as %s\n", record.ID)
+}
+
+// loadHistory reads a run by id, or by path to its report
+func loadHistory(dir, run string) (map[string]interface{}, error) {
+	path := run
+	if !strings.ContainsRune(run, os.PathSeparator) && !strings.HasSuffix(run, ".json") {
+		path = filepath.Join(expandHome(dir), run+".json")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return record, nil
+}
+
+// flattenJSON turns nested JSON objects into dotted keys like status.memory_actual_mb
+func flattenJSON(prefix string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flattenJSON(name, item, out)
+		}
+	case nil:
+	case string:
+		out[prefix] = v
+	default:
+		data, _ := json.Marshal(v)
+		out[prefix] = string(data)
+	}
+}
+
+// historyCommand lists stored runs and compares two of them
+func historyCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	dir := fs.String("history", "~/.outagemock/history", "Directory the runs were saved to with -history")
+	all := fs.Bool("all", false, "With diff, also show the values both runs share")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: outagemock history [flags] [list | diff RUN1 RUN2]\n\n%s\n\nFlags:\n", cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+
+	switch rest := fs.Args(); {
+	case len(rest) == 0 || rest[0] == "list":
+		return exitCodeFor(listHistory(os.Stdout, expandHome(*dir)))
+	case rest[0] == "diff" && len(rest) == 3:
+		a, err := loadHistory(*dir, rest[1])
+		if err != nil {
+			return exitCodeFor(err)
+		}
+		b, err := loadHistory(*dir, rest[2])
+		if err != nil {
+			return exitCodeFor(err)
+		}
+		diffHistory(os.Stdout, a, b, *all)
+		return 0
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+}
+
+// listHistory prints the stored runs, oldest first
+func listHistory(w io.Writer, dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	fmt.Fprintf(w, "%-24s %-20s %-5s %-8s %-12s %-12s\n", "Run", "Host", "Exit", "CPU %", "Memory (MB)", "File (MB)")
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var record historyRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(w, "%-24s %-20s %-5d %-8.1f %-12d %-12d\n", record.ID, truncateString(record.Environment.Host, 20),
+			record.ExitCode, record.Peaks.CPUPercent, record.Peaks.MemoryMB, record.Peaks.FileMB)
+	}
+	return nil
+}
+
+// diffHistory prints the values that differ between two runs, or all of them
+func diffHistory(w io.Writer, a, b map[string]interface{}, all bool) {
+	fa, fb := map[string]string{}, map[string]string{}
+	flattenJSON("", a, fa)
+	flattenJSON("", b, fb)
+	keys := map[string]bool{}
+	for key := range fa {
+		keys[key] = true
+	}
+	for key := range fb {
+		keys[key] = true
+	}
+	var names []string
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%-36s %-24s %-24s\n", "Field", a["id"], b["id"])
+	for _, name := range names {
+		va, vb := fa[name], fb[name]
+		if va == vb && !all {
+			continue
+		}
+		mark := ""
+		if va != vb {
+			mark = " *"
+		}
+		fmt.Fprintf(w, "%-36s %-24s %-24s%s\n", name, truncateString(va, 24), truncateString(vb, 24), mark)
+	}
+}
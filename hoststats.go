@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cpuTimes holds aggregated jiffies from the first line of /proc/stat
+type cpuTimes struct {
+	idle    uint64
+	softirq uint64
+	total   uint64
+}
+
+// readCPUTimes reads the aggregated CPU times of the host
+func readCPUTimes() (cpuTimes, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return cpuTimes{}, fmt.Errorf("empty /proc/stat")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("unexpected /proc/stat line: %q", scanner.Text())
+	}
+
+	var times cpuTimes
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return cpuTimes{}, err
+		}
+		times.total += value
+		// idle and iowait
+		if i == 3 || i == 4 {
+			times.idle += value
+		}
+		if i == 6 {
+			times.softirq = value
+		}
+	}
+	return times, nil
+}
+
+// busyPercent returns the host CPU usage between two samples
+func busyPercent(prev, cur cpuTimes) float64 {
+	total := cur.total - prev.total
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(total-(cur.idle-prev.idle)) / float64(total)
+}
+
+// softirqPercent returns the share of host CPU time spent in softirqs between two samples
+func softirqPercent(prev, cur cpuTimes) float64 {
+	total := cur.total - prev.total
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(cur.softirq-prev.softirq) / float64(total)
+}
+
+// readProcsRunning returns the number of runnable tasks on the host, including the caller
+func readProcsRunning() (int, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "procs_running "); ok {
+			return strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	return 0, fmt.Errorf("no procs_running in /proc/stat")
+}
+
+// readMemInfo returns /proc/meminfo values in kB keyed by name
+func readMemInfo() (map[string]int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		info[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	return info, scanner.Err()
+}
+
+// usedMemoryMB returns the host memory in use, excluding reclaimable caches
+func usedMemoryMB() (int64, error) {
+	info, err := readMemInfo()
+	if err != nil {
+		return 0, err
+	}
+	return (info["MemTotal"] - info["MemAvailable"]) / 1024, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPProxyConfig holds the fault settings of the HTTP reverse proxy.
+// Fault rates ramp up linearly from zero over Rampup like resource targets do.
+type HTTPProxyConfig struct {
+	Listen     string
+	Upstream   *url.URL
+	Paths      []string      // Path prefixes faults apply to (empty = all paths)
+	ErrorRate  float64       // Fraction of matched requests answered with ErrorCode (0-1)
+	ErrorCode  int           // Status code returned for injected errors
+	Latency    time.Duration // Delay added before matched requests are forwarded
+	Jitter     time.Duration // Random +/- variation of the delay
+	Truncate   float64       // Fraction of matched responses cut off mid-body (0-1)
+	Reset      float64       // Fraction of matched requests answered with a connection reset (0-1)
+	Rampup     time.Duration
+	Duration   time.Duration // Time to serve before exiting (0 = until interrupted)
+	Net        NetOptions    // Stack of the listener and the upstream connections
+	rampupFrom time.Time
+}
+
+// httpTruncateUnknown is how much of a response without Content-Length is sent before truncation
+const httpTruncateUnknown = 512
+
+// httpProxyCommand runs an HTTP reverse proxy injecting errors, delays, truncated bodies and resets
+func httpProxyCommand(cmd *command, args []string) int {
+	var config HTTPProxyConfig
+	var upstream, paths, errorRate, truncate, reset string
+	fs := cmd.flagSet()
+	fs.StringVar(&config.Listen, "listen", "", "Address to accept requests on, e.g. :8081 (required)")
+	fs.StringVar(&upstream, "upstream", "", "Base URL to forward requests to, e.g. http://api:8080 (required)")
+	fs.StringVar(&paths, "paths", "", "Comma separated path prefixes faults apply to (default all paths)")
+	fs.StringVar(&errorRate, "error-rate", "0", "Percentage of matched requests answered with -error-code, e.g. 20%")
+	fs.IntVar(&config.ErrorCode, "error-code", http.StatusServiceUnavailable, "Status code of injected errors")
+	fs.DurationVar(&config.Latency, "latency", 0, "Delay added to matched requests")
+	fs.DurationVar(&config.Jitter, "jitter", 0, "Random variation added to or removed from the latency")
+	fs.StringVar(&truncate, "truncate", "0", "Percentage of matched responses whose body is cut off")
+	fs.StringVar(&reset, "reset", "0", "Percentage of matched requests answered with a connection reset")
+	fs.DurationVar(&config.Rampup, "rampup", 0, "Time over which fault rates grow linearly to their targets")
+	fs.DurationVar(&config.Duration, "duration", 0, "Time to serve before exiting (0 = until interrupted)")
+	bindFlags(fs, &config.Net)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if config.Listen == "" || upstream == "" {
+		return exitCodeFor(fmt.Errorf("-listen and -upstream are required"))
+	}
+	var err error
+	if config.Upstream, err = url.Parse(upstream); err != nil || config.Upstream.Host == "" {
+		return exitCodeFor(fmt.Errorf("invalid upstream URL %q", upstream))
+	}
+	if err := config.Net.validate(); err != nil {
+		return exitCodeFor(err)
+	}
+	if err := config.Net.checkHost(config.Upstream.Hostname()); err != nil {
+		return exitCodeFor(fmt.Errorf("invalid -upstream: %v", err))
+	}
+	for _, prefix := range strings.Split(paths, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			config.Paths = append(config.Paths, prefix)
+		}
+	}
+	if config.ErrorRate, err = parsePercent(errorRate); err != nil {
+		return exitCodeFor(err)
+	}
+	if config.Truncate, err = parsePercent(truncate); err != nil {
+		return exitCodeFor(err)
+	}
+	if config.Reset, err = parsePercent(reset); err != nil {
+		return exitCodeFor(err)
+	}
+	if http.StatusText(config.ErrorCode) == "" {
+		return exitCodeFor(fmt.Errorf("invalid -error-code %d", config.ErrorCode))
+	}
+
+	config.rampupFrom = time.Now()
+	server := &http.Server{Addr: config.Listen, Handler: newHTTPFaultProxy(&config)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+	go func() {
+		select {
+		case sig := <-notifySignals():
+			fmt.Printf("Received signal %v, stopping proxy...\n", sig)
+		case <-ctx.Done():
+		}
+		server.Close()
+	}()
+
+	fmt.Printf("Proxying HTTP %s -> %s (errors %.1f%% %d, latency %v, jitter %v, truncate %.1f%%, reset %.1f%%, rampup %v)\n",
+		config.Listen, config.Upstream, config.ErrorRate*100, config.ErrorCode, config.Latency, config.Jitter,
+		config.Truncate*100, config.Reset*100, config.Rampup)
+	listener, err := config.Net.listen(config.Listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Proxy failed: %v\n", err)
+		return 1
+	}
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Proxy failed: %v\n", err)
+		return 1
+	}
+	fmt.Println("Proxy stopped")
+	return 0
+}
+
+// rampFactor returns how far the fault rates have ramped up, from 0 to 1
+func (c *HTTPProxyConfig) rampFactor() float64 {
+	elapsed := time.Since(c.rampupFrom)
+	if c.Rampup <= 0 || elapsed >= c.Rampup {
+		return 1
+	}
+	return float64(elapsed) / float64(c.Rampup)
+}
+
+// matches reports whether faults apply to the request path
+func (c *HTTPProxyConfig) matches(path string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range c.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateKey marks requests whose response body should be cut off
+type truncateKey struct{}
+
+// newHTTPFaultProxy returns a reverse proxy to config.Upstream injecting faults into matched requests
+func newHTTPFaultProxy(config *HTTPProxyConfig) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(config.Upstream)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = config.Net.dial
+	proxy.Transport = transport
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.Request.Context().Value(truncateKey{}) != nil {
+			limit := int64(httpTruncateUnknown)
+			if resp.ContentLength > 0 {
+				limit = resp.ContentLength / 2
+			}
+			resp.Body = &truncatedBody{body: resp.Body, remaining: limit}
+		}
+		return nil
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.matches(r.URL.Path) {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		factor := config.rampFactor()
+		if config.Latency > 0 || config.Jitter > 0 {
+			select {
+			case <-time.After(time.Duration(factor * float64(proxyDelay(config.Latency, config.Jitter)))):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if rand.Float64() < config.Reset*factor {
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					resetConn(conn)
+					return
+				}
+			}
+			// Aborting the handler closes the connection without a response
+			panic(http.ErrAbortHandler)
+		}
+		if rand.Float64() < config.ErrorRate*factor {
+			http.Error(w, fmt.Sprintf("outagemock injected %d", config.ErrorCode), config.ErrorCode)
+			return
+		}
+		if rand.Float64() < config.Truncate*factor {
+			r = r.WithContext(context.WithValue(r.Context(), truncateKey{}, true))
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// truncatedBody returns the first remaining bytes of body and then fails,
+// which makes the reverse proxy abort the response mid-body
+type truncatedBody struct {
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *truncatedBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// applyJitter randomizes the rampup, duration and rampdowns of the run by up
+// to -jitter each, and moves the inner points of its timeline by up to
+// -jitter of half the gap to their nearest neighbor so they keep their
+// order. It runs as the run starts, before anything follows the timing; a
+// resumed run keeps the timing of the crashed one.
+func (rm *ResourceMock) applyJitter() {
+	c := &rm.config
+	if c.jitter == 0 || rm.resumed != nil {
+		return
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	offset := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * c.jitter * (2*rng.Float64() - 1))
+	}
+
+	rampup, duration := c.RampupTime, c.Duration
+	c.RampupTime += offset(c.RampupTime).Round(time.Millisecond)
+	c.Duration += offset(c.Duration).Round(time.Millisecond)
+	c.Rampdown += offset(c.Rampdown).Round(time.Millisecond)
+	c.GracefulRampdown += offset(c.GracefulRampdown).Round(time.Millisecond)
+
+	rm.deadlineMu.Lock()
+	rm.deadline = rm.deadline.Add(c.Duration - duration)
+	rm.deadlineTimer.Reset(rm.clock.Wall(-rm.clock.Since(rm.deadline)))
+	rm.deadlineMu.Unlock()
+
+	if len(rm.timeline) > 2 {
+		// A copy, the timeline may be shared with other runs of the config
+		timeline := append(Timeline(nil), rm.timeline...)
+		for i := 1; i < len(timeline)-1; i++ {
+			room := min(rm.timeline[i].Offset-rm.timeline[i-1].Offset, rm.timeline[i+1].Offset-rm.timeline[i].Offset) / 2
+			timeline[i].Offset += offset(room)
+		}
+		rm.timeline = timeline
+	}
+	log.Printf("Jittered the timing by up to %.0f%% with -seed %d: rampup %v to %v, duration %v to %v",
+		c.jitter*100, seed, rampup, c.RampupTime, duration, c.Duration)
+}
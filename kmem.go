@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KmemSpec is the kernel object count -kmem builds up, parsed from e.g.
+// dentries=2000000,epoll=1000,timers=10000
+type KmemSpec struct {
+	Dentries int64 // Negative dentries created by stat'ing paths that don't exist
+	Epoll    int64 // epoll instances
+	Timers   int64 // Armed timerfds, each watched by one of the epoll instances
+}
+
+// kmemBatch is the most objects created per tick, so the status keeps updating
+const kmemBatch = 100000
+
+// parseCounts parses object counts like dentries=2000000,epoll=1000 given to
+// flag, allowing only the listed keys
+func parseCounts(flag, s string, keys ...string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s setting %q (expected key=value)", flag, item)
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s count %q", flag, item)
+		}
+		if !slices.Contains(keys, key) {
+			return nil, fmt.Errorf("unknown %s setting %q (supported: %s)", flag, key, strings.Join(keys, ", "))
+		}
+		counts[key] = n
+	}
+	return counts, nil
+}
+
+// parseKmemSpec parses -kmem
+func parseKmemSpec(s string) (KmemSpec, error) {
+	counts, err := parseCounts("-kmem", s, "dentries", "epoll", "timers")
+	if err != nil {
+		return KmemSpec{}, err
+	}
+	return KmemSpec{Dentries: counts["dentries"], Epoll: counts["epoll"], Timers: counts["timers"]}, nil
+}
+
+// slabMB returns the kernel slab memory of the host and its unreclaimable part
+func slabMB() (total, unreclaimable int64) {
+	info, err := readMemInfo()
+	if err != nil {
+		return 0, 0
+	}
+	return info["Slab"] / 1024, info["SUnreclaim"] / 1024
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// armTimer creates a timerfd firing every hour, so it stays armed without waking anyone
+func armTimer() (int, error) {
+	fd, err := createTimer()
+	if err != nil {
+		return -1, err
+	}
+	if err := setTimer(fd, time.Hour); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// createTimer creates a disarmed, non-blocking timerfd on the monotonic clock
+func createTimer() (int, error) {
+	const clockMonotonic = 1
+	fd, _, errno := syscall.Syscall(syscall.SYS_TIMERFD_CREATE, clockMonotonic, syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// setTimer makes a timerfd fire every interval, or disarms it with 0
+func setTimer(fd int, interval time.Duration) error {
+	ts := syscall.NsecToTimespec(int64(interval))
+	spec := [2]syscall.Timespec{ts, ts} // Interval and first expiry
+	if _, _, errno := syscall.Syscall6(syscall.SYS_TIMERFD_SETTIME, uintptr(fd), 0, uintptr(unsafe.Pointer(&spec)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pressureKmem grows kernel slab caches toward -kmem during rampup: negative
+// dentries from stat'ing generated names in the directory of -fpath, epoll
+// instances and armed timerfds registered with them. File descriptors are
+// closed when the target shrinks and at the end; negative dentries stay
+// until the kernel reclaims them.
+func (rm *ResourceMock) pressureKmem() {
+	defer rm.wg.Done()
+	defer nameThread("om-kmem")()
+
+	spec := rm.config.kmem
+	dir := filepath.Dir(rm.filePath)
+	prefix := filepath.Base(rm.filePath) + fmt.Sprintf("_dentry_%d_", os.Getpid())
+	if spec.Epoll+spec.Timers > 0 {
+		// Every epoll instance and timer is a file descriptor
+		raiseNofile()
+	}
+
+	var epolls, timers []int
+	defer func() {
+		for _, fd := range append(epolls, timers...) {
+			syscall.Close(fd)
+		}
+	}()
+	dentries := int64(0)
+	failed := false
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+			scale := rm.rampIntensity("kmem")
+			target := func(n int64) int64 { return int64(float64(n) * scale) }
+
+			// Negative dentries can't be dropped, only the shrinker frees them
+			for end := min(target(spec.Dentries), dentries+kmemBatch); dentries < end; dentries++ {
+				var st syscall.Stat_t
+				syscall.Stat(filepath.Join(dir, prefix+strconv.FormatInt(dentries, 10)), &st)
+			}
+
+			for int64(len(epolls)) > target(spec.Epoll) {
+				syscall.Close(epolls[len(epolls)-1])
+				epolls = epolls[:len(epolls)-1]
+			}
+			for int64(len(timers)) > target(spec.Timers) {
+				syscall.Close(timers[len(timers)-1])
+				timers = timers[:len(timers)-1]
+			}
+			var err error
+			for n := 0; int64(len(epolls)) < target(spec.Epoll) && n < kmemBatch && err == nil && !failed; n++ {
+				var fd int
+				if fd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err == nil {
+					epolls = append(epolls, fd)
+				}
+			}
+			for n := 0; int64(len(timers)) < target(spec.Timers) && n < kmemBatch && err == nil && !failed; n++ {
+				var fd int
+				if fd, err = armTimer(); err != nil {
+					break
+				}
+				timers = append(timers, fd)
+				if len(epolls) > 0 {
+					// Each registration is an epitem in its own slab cache
+					event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+					syscall.EpollCtl(epolls[len(timers)%len(epolls)], syscall.EPOLL_CTL_ADD, fd, &event)
+				}
+			}
+			if err != nil && !failed {
+				// Usually EMFILE or ENFILE, keep what was created
+				failed = true
+				rm.markDegraded("kmem", fmt.Errorf("object creation stopped at %d epoll instances and %d timers: %w", len(epolls), len(timers), err))
+			}
+
+			slab, unreclaimable := slabMB()
+			rm.statusMu.Lock()
+			rm.resourceStatus.KmemDentries = dentries
+			rm.resourceStatus.KmemEpoll = int64(len(epolls))
+			rm.resourceStatus.KmemTimers = int64(len(timers))
+			rm.resourceStatus.SlabMB = slab
+			rm.resourceStatus.SUnreclaimMB = unreclaimable
+			rm.statusMu.Unlock()
+		}
+	}
+}
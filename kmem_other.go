@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// pressureKmem degrades, epoll instances and timerfds are Linux objects
+func (rm *ResourceMock) pressureKmem() {
+	defer rm.wg.Done()
+	rm.markDegraded("kmem", fmt.Errorf("-kmem is %w", errNotLinux))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	llcPeriod = 100 * time.Millisecond // Duty cycle period of the chasing threads
+	llcLine   = 64                     // Bytes per cache line, one pointer each
+)
+
+// LLCThrash is the cache pollution of -llc-thrash, parsed from e.g. size=32MB,threads=2,duty=50%
+type LLCThrash struct {
+	Size    int64   // Working set in bytes, beyond the last-level cache
+	Threads int     // Threads chasing pointers through the working set
+	Duty    float64 // Fraction of each period the threads chase, sleeping the rest
+}
+
+// parseLLCThrash parses -llc-thrash. The working set defaults to twice the
+// last-level cache of the host.
+func parseLLCThrash(s string) (LLCThrash, error) {
+	spec := LLCThrash{Size: 2 * lastLevelCacheBytes(), Threads: 1, Duty: 1}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return LLCThrash{}, fmt.Errorf("invalid -llc-thrash setting %q (expected key=value)", item)
+		}
+		var err error
+		switch key {
+		case "size":
+			spec.Size, err = parseByteSize(value)
+		case "threads":
+			spec.Threads, err = strconv.Atoi(value)
+		case "duty":
+			spec.Duty, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			spec.Duty /= 100
+		default:
+			return LLCThrash{}, fmt.Errorf("unknown -llc-thrash setting %q (supported: size, threads, duty)", key)
+		}
+		if err != nil {
+			return LLCThrash{}, fmt.Errorf("invalid -llc-thrash setting %q: %v", item, err)
+		}
+	}
+	if spec.Size < llcLine*2 || spec.Threads < 1 || spec.Duty <= 0 || spec.Duty > 1 {
+		return LLCThrash{}, fmt.Errorf("invalid -llc-thrash %q: size must be at least 128 bytes, threads positive and duty between 0 and 100%%", s)
+	}
+	return spec, nil
+}
+
+// lastLevelCacheBytes returns the size of the highest level cache of CPU 0,
+// or 32 MB when sysfs doesn't tell
+func lastLevelCacheBytes() int64 {
+	size, level := int64(32<<20), 0
+	dirs, _ := filepath.Glob("/sys/devices/system/cpu/cpu0/cache/index*")
+	for _, dir := range dirs {
+		l, err := os.ReadFile(filepath.Join(dir, "level"))
+		if err != nil {
+			continue
+		}
+		s, err := os.ReadFile(filepath.Join(dir, "size"))
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(string(l)))
+		bytes, err := parseByteSize(strings.TrimSpace(string(s)))
+		if err == nil && n > level {
+			size, level = bytes, n
+		}
+	}
+	return size
+}
+
+// chaseRing links the cache lines of buf into one cycle in random order, so
+// every load depends on the previous one and the prefetchers can't guess the next
+func chaseRing(buf []uint64) {
+	const stride = llcLine / 8
+	lines := len(buf) / stride
+	order := rand.Perm(lines)
+	for i, line := range order {
+		buf[line*stride] = uint64(order[(i+1)%lines] * stride)
+	}
+}
+
+// thrashLLC chases pointers through a working set larger than the last-level
+// cache, evicting the lines of co-located workloads and lowering their IPC.
+// Every load waits for memory, so the threads spend little CPU time on
+// instructions. The duty cycle grows linearly during rampup and is scaled by
+// host protection and rampdown.
+func (rm *ResourceMock) thrashLLC() {
+	defer rm.wg.Done()
+
+	spec := rm.config.llcThrash
+	buf, err := mmap(nil, 0, int(spec.Size), 0)
+	if err != nil {
+		rm.markDegraded("llc", fmt.Errorf("map working set: %w", err))
+		return
+	}
+	defer munmap(buf)
+	ring := unsafe.Slice((*uint64)(unsafe.Pointer(&buf[0])), len(buf)/8)
+	chaseRing(ring)
+	log.Printf("Thrashing the last-level cache with a %d MB working set, %d threads at %.0f%% duty", spec.Size>>20, spec.Threads, spec.Duty*100)
+
+	var mu sync.Mutex
+	var loads int64
+	var busy time.Duration
+	var threads sync.WaitGroup
+	for i := 0; i < spec.Threads; i++ {
+		threads.Add(1)
+		go func(i int) {
+			defer threads.Done()
+			defer nameThread("om-llc-%d", i)()
+			// Threads start at different lines of the ring
+			p := ring[(len(ring)/spec.Threads*i)/(llcLine/8)*(llcLine/8)]
+			for rm.ctx.Err() == nil {
+				chase := time.Duration(float64(llcPeriod) * spec.Duty * rm.rampIntensity("llc"))
+				start, n := time.Now(), int64(0)
+				for time.Since(start) < chase {
+					for j := 0; j < 1024; j++ {
+						p = ring[p]
+					}
+					n += 1024
+				}
+				took := time.Since(start)
+				mu.Lock()
+				loads += n
+				busy += took
+				mu.Unlock()
+				if !sleepCtx(rm.ctx, llcPeriod-took) {
+					return
+				}
+			}
+		}(i)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			threads.Wait()
+			return
+		case now := <-ticker.C:
+			mu.Lock()
+			n, d := loads, busy
+			loads, busy = 0, 0
+			mu.Unlock()
+			rm.statusMu.Lock()
+			rm.resourceStatus.LLCWorkingSetMB = spec.Size >> 20
+			rm.resourceStatus.LLCLoadsPerSec = float64(n) / now.Sub(last).Seconds()
+			if n > 0 {
+				rm.resourceStatus.LLCLoadNs = float64(d.Nanoseconds()) / float64(n)
+			}
+			rm.statusMu.Unlock()
+			last = now
+		}
+	}
+}
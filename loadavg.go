@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadInterval is how often the -loadavg driver resizes its workers; the
+// 1-minute average moves by about 8% of the gap in that time
+const loadInterval = 5 * time.Second
+
+// loadIOBytes is the size of every synchronous write of a blocked worker
+const loadIOBytes = 4096
+
+// loadPath returns the file blocked -loadavg workers write to, next to the work file
+func loadPath(filePath string) string {
+	return strings.TrimSuffix(filePath, fileSuffix) + "_load" + fileSuffix
+}
+
+// loadWorkers is one kind of -loadavg worker, each stopped by closing its channel
+type loadWorkers struct {
+	stops []chan struct{}
+	wg    sync.WaitGroup
+}
+
+// resize starts or stops workers until n run
+func (w *loadWorkers) resize(n int, run func(id int, stop <-chan struct{})) {
+	for len(w.stops) > n {
+		close(w.stops[len(w.stops)-1])
+		w.stops = w.stops[:len(w.stops)-1]
+	}
+	for len(w.stops) < n {
+		stop := make(chan struct{})
+		w.stops = append(w.stops, stop)
+		w.wg.Add(1)
+		go func(id int) {
+			defer w.wg.Done()
+			run(id, stop)
+		}(len(w.stops) - 1)
+	}
+}
+
+// spinner returns a worker spinning on its own thread named like format, one
+// runnable task in the load average and the run queue
+func spinner(format string) func(id int, stop <-chan struct{}) {
+	return func(id int, stop <-chan struct{}) {
+		defer nameThread(format, id)()
+		spin := cpuKernels[cpuWorkloadInt]()
+		for count := 0; ; count = spin(count, 1<<20) {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// blockedLoad keeps its thread in uninterruptible sleep (D state) waiting for
+// synchronous direct writes, one blocked task in the load average. A worker
+// that can't write counts itself in failed until it is stopped.
+func blockedLoad(path string, failed *atomic.Int64) func(id int, stop <-chan struct{}) {
+	return func(id int, stop <-chan struct{}) {
+		defer nameThread("om-load-d%d", id)()
+		fail := func(err error) {
+			log.Printf("Blocked load worker %d: %v", id, err)
+			failed.Add(1)
+			<-stop
+			failed.Add(-1)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|oDirect|oDsync, 0600)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer file.Close()
+
+		// Direct IO needs page aligned buffers, which mmap provides
+		buf, err := mmap(nil, 0, loadIOBytes, 0)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer munmap(buf)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := file.WriteAt(buf, int64(id)*loadIOBytes); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}
+}
+
+// directIO reports whether the file system of path takes direct writes;
+// tmpfs and overlayfs don't
+func directIO(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|oDirect|oDsync, 0600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// driveLoadAvg holds the 1-minute load average of the host at -loadavg by
+// running spinning workers and workers blocked in disk IO, split by
+// -loadavg-mix. Load from the rest of the host counts toward the target, so
+// it is estimated from how far the average exceeds the workers held. Under
+// -max-cpu fewer workers spin than the mix asks for.
+func (rm *ResourceMock) driveLoadAvg() {
+	defer rm.wg.Done()
+
+	// Without direct IO the writes never block, so all the load spins
+	path := loadPath(rm.filePath)
+	mix := rm.config.loadMix
+	if mix < 1 {
+		if err := directIO(path); err != nil {
+			log.Printf("Blocked -loadavg workers need direct IO next to the work file (%v), spinning instead", err)
+			mix = 1
+		}
+	}
+
+	// Spinning workers must not take the Ps the rest of the process runs on
+	cores := runtime.NumCPU()
+	procs := rm.config.capThreads(int(math.Ceil(rm.config.LoadAvg*mix)), cores)
+	adjustProcs(procs, "-loadavg")
+	defer adjustProcs(-procs, "-loadavg")
+
+	var runnable, blocked loadWorkers
+	var failed atomic.Int64 // Blocked workers that couldn't write and hold no load
+	degraded := false
+	defer func() {
+		runnable.resize(0, nil)
+		blocked.resize(0, nil)
+		runnable.wg.Wait()
+		blocked.wg.Wait()
+		os.Remove(path)
+	}()
+
+	ticker := time.NewTicker(loadInterval)
+	defer ticker.Stop()
+	for {
+		load, err := readLoadAverage()
+		if err != nil {
+			rm.markDegraded("loadavg", err)
+			return
+		}
+		stuck := int(failed.Load())
+		if stuck > 0 && !degraded {
+			rm.markDegraded("loadavg", fmt.Errorf("%d blocked workers failed to write %s", stuck, path))
+			degraded = true
+		}
+		held := float64(len(runnable.stops) + len(blocked.stops) - stuck)
+		background := max(0, load-held)
+		want := max(0, rm.config.LoadAvg*rm.rampIntensity("loadavg")-background)
+		spinning := int(math.Round(want * mix))
+		runnable.resize(rm.config.capThreads(spinning, cores), spinner("om-load-r%d"))
+		blocked.resize(int(math.Round(want))-spinning, blockedLoad(path, &failed))
+
+		rm.statusMu.Lock()
+		rm.resourceStatus.LoadAvg = load
+		rm.resourceStatus.LoadRunnable = len(runnable.stops)
+		rm.resourceStatus.LoadBlocked = max(0, len(blocked.stops)-int(failed.Load()))
+		rm.statusMu.Unlock()
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LogFlood is parsed from -log-flood, e.g. path=/var/log/app.log,rate=10MB/min,format=json
+type LogFlood struct {
+	Path   string
+	Rate   float64 // Bytes per second
+	Format string  // json, text or access
+}
+
+// logFloodFormats are the line formats -log-flood writes
+var logFloodFormats = []string{"json", "text", "access"}
+
+// parseLogFlood parses -log-flood
+func parseLogFlood(s string) (LogFlood, error) {
+	flood := LogFlood{Format: "json"}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return flood, fmt.Errorf("invalid -log-flood setting %q (expected key=value)", item)
+		}
+		switch key {
+		case "path":
+			flood.Path = value
+		case "rate":
+			rate, err := parseByteRate(value)
+			if err != nil {
+				return flood, fmt.Errorf("invalid -log-flood rate: %v", err)
+			}
+			flood.Rate = rate
+		case "format":
+			if !slices.Contains(logFloodFormats, value) {
+				return flood, fmt.Errorf("invalid -log-flood format %q (supported: %s)", value, strings.Join(logFloodFormats, ", "))
+			}
+			flood.Format = value
+		default:
+			return flood, fmt.Errorf("unknown -log-flood setting %q (supported: path, rate, format)", key)
+		}
+	}
+	if flood.Path == "" || flood.Rate == 0 {
+		return flood, fmt.Errorf("-log-flood requires path and rate, e.g. path=/var/log/app.log,rate=10MB/min")
+	}
+	return flood, nil
+}
+
+// logMessages are what the flooded application pretends to log, by level
+var logMessages = map[string][]string{
+	"INFO": {
+		"request completed", "cache hit", "user session refreshed", "job scheduled",
+		"connection returned to pool", "health check passed", "config reloaded",
+	},
+	"WARN": {
+		"slow query detected", "retrying upstream request", "connection pool nearly exhausted",
+		"request timeout approaching deadline", "cache miss storm",
+	},
+	"ERROR": {
+		"upstream returned 503", "database connection refused", "failed to write to queue",
+		"context deadline exceeded", "unexpected EOF reading response body",
+	},
+}
+
+// logLevels are drawn with these weights, like a service having a bad day
+var logLevels = []struct {
+	level  string
+	weight int
+}{{"INFO", 85}, {"WARN", 10}, {"ERROR", 5}}
+
+// logComponents name the parts of the application the lines come from
+var logComponents = []string{"api", "db", "cache", "worker", "auth", "gateway"}
+
+// logPaths are the request paths of access log lines
+var logPaths = []string{"/api/v1/orders", "/api/v1/users", "/api/v1/search?q=item", "/healthz", "/static/app.js", "/login"}
+
+// logLine renders one realistic log line in format, ending with a newline
+func logLine(format string, rng *rand.Rand, now time.Time) []byte {
+	pick := rng.Intn(100)
+	level := logLevels[0].level
+	for _, l := range logLevels {
+		if pick < l.weight {
+			level = l.level
+			break
+		}
+		pick -= l.weight
+	}
+	messages := logMessages[level]
+	msg := messages[rng.Intn(len(messages))]
+	component := logComponents[rng.Intn(len(logComponents))]
+	requestID := fmt.Sprintf("%016x", rng.Uint64())
+	latency := rng.ExpFloat64() * 40
+
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		line, _ := json.Marshal(map[string]interface{}{
+			"ts": now.UTC().Format(time.RFC3339Nano), "level": strings.ToLower(level), "logger": component,
+			"msg": msg, "request_id": requestID, "latency_ms": float64(int(latency*100)) / 100, "user_id": rng.Intn(100000),
+		})
+		buf.Write(line)
+	case "text":
+		fmt.Fprintf(&buf, "%s %-5s [%s] %s request_id=%s latency_ms=%.2f", now.Format("2006-01-02T15:04:05.000Z07:00"), level, component, msg, requestID, latency)
+	case "access":
+		status := 200
+		if level == "WARN" {
+			status = 429
+		} else if level == "ERROR" {
+			status = 503
+		}
+		fmt.Fprintf(&buf, `10.%d.%d.%d - - [%s] "GET %s HTTP/1.1" %d %d "-" "Mozilla/5.0 (X11; Linux x86_64)" %.3f`,
+			rng.Intn(256), rng.Intn(256), rng.Intn(256), now.Format("02/Jan/2006:15:04:05 -0700"),
+			logPaths[rng.Intn(len(logPaths))], status, 200+rng.Intn(20000), latency/1000)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// openLogFlood opens the flooded log for appending and returns its identity
+func openLogFlood(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// floodLog appends log lines to -log-flood at its rate, growing linearly
+// during rampup and scaled by host protection and rampdown. The file is
+// reopened when a log rotation moves it away, like applications do on
+// SIGHUP. What was written is left in place for the log shipper.
+func (rm *ResourceMock) floodLog() {
+	defer rm.wg.Done()
+	defer nameThread("om-logflood")()
+
+	flood := rm.config.logFlood
+	file, opened, err := openLogFlood(flood.Path)
+	if err != nil {
+		rm.markDegraded("logs", err)
+		return
+	}
+	defer func() { file.Close() }()
+	log.Printf("Flooding %s with %s lines at up to %.1f KB/s", flood.Path, flood.Format, flood.Rate/1024)
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	due, last, checked := 0.0, time.Now(), time.Now()
+	failed := false
+	var buf bytes.Buffer
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			due += flood.Rate * rm.rampIntensity("logs") * now.Sub(last).Seconds()
+			last = now
+
+			// Follow rotations, which rename or remove the file
+			if now.Sub(checked) >= time.Second {
+				checked = now
+				if info, err := os.Stat(flood.Path); err != nil || !os.SameFile(info, opened) {
+					file.Close()
+					if file, opened, err = openLogFlood(flood.Path); err != nil {
+						rm.markDegraded("logs", err)
+						return
+					}
+				}
+			}
+
+			buf.Reset()
+			for float64(buf.Len()) < due {
+				buf.Write(logLine(flood.Format, rng, now))
+			}
+			if buf.Len() == 0 {
+				continue
+			}
+			due -= float64(buf.Len())
+			if _, err := file.Write(buf.Bytes()); err != nil {
+				if !failed {
+					// A full disk is an expected outcome of a flood, keep trying quietly
+					log.Printf("Log flood write failed: %v", err)
+					failed = true
+				}
+				continue
+			}
+			if failed {
+				log.Printf("Log flood writes to %s succeed again", flood.Path)
+				failed = false
+			}
+
+			rm.statusMu.Lock()
+			rm.resourceStatus.LogFloodBytes += int64(buf.Len())
+			rm.statusMu.Unlock()
+		}
+	}
+}
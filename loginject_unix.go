@@ -0,0 +1,197 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalSocket is where journald accepts entries in its native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// maxInjectRate caps -inject-log so entries can't turn into a busy loop
+const maxInjectRate = 10000
+
+// syslogPriorities are the priority names of -inject-log
+var syslogPriorities = map[string]syslog.Priority{
+	"emerg": syslog.LOG_EMERG, "alert": syslog.LOG_ALERT, "crit": syslog.LOG_CRIT, "err": syslog.LOG_ERR,
+	"warning": syslog.LOG_WARNING, "notice": syslog.LOG_NOTICE, "info": syslog.LOG_INFO, "debug": syslog.LOG_DEBUG,
+}
+
+// LogInject is parsed from -inject-log, e.g. rate=5/s,priority=err,tag=payments,target=journald
+type LogInject struct {
+	Rate     float64 // Entries per second
+	Priority syslog.Priority
+	Tag      string
+	Target   string // syslog for /dev/log, journald, or udp://host:port and tcp://host:port for a remote syslog
+}
+
+// parseLogInject parses -inject-log
+func parseLogInject(s string) (LogInject, error) {
+	inject := LogInject{Priority: syslog.LOG_ERR, Tag: "outagemock", Target: "syslog"}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return inject, fmt.Errorf("invalid -inject-log setting %q (expected key=value)", item)
+		}
+		switch key {
+		case "rate":
+			rate, err := parseEventRate(value)
+			if err != nil || rate > maxInjectRate {
+				return inject, fmt.Errorf("invalid -inject-log rate %q (expected e.g. 5/s or 100/min, at most %d/s)", value, maxInjectRate)
+			}
+			inject.Rate = rate
+		case "priority":
+			priority, ok := syslogPriorities[value]
+			if !ok {
+				return inject, fmt.Errorf("invalid -inject-log priority %q (supported: emerg, alert, crit, err, warning, notice, info, debug)", value)
+			}
+			inject.Priority = priority
+		case "tag":
+			inject.Tag = value
+		case "target":
+			if value != "syslog" && value != "journald" && !strings.HasPrefix(value, "udp://") && !strings.HasPrefix(value, "tcp://") {
+				return inject, fmt.Errorf("invalid -inject-log target %q (supported: syslog, journald, udp://host:port, tcp://host:port)", value)
+			}
+			inject.Target = value
+		default:
+			return inject, fmt.Errorf("unknown -inject-log setting %q (supported: rate, priority, tag, target)", key)
+		}
+	}
+	if inject.Rate == 0 {
+		return inject, fmt.Errorf("-inject-log requires a rate, e.g. rate=5/s")
+	}
+	return inject, nil
+}
+
+// renderLogMessage fills the placeholders of -inject-log-message
+func renderLogMessage(template string, seq int64, host string, rng *rand.Rand, now time.Time) string {
+	return strings.NewReplacer(
+		"{seq}", strconv.FormatInt(seq, 10),
+		"{host}", host,
+		"{time}", now.Format(time.RFC3339),
+		"{rand}", strconv.Itoa(rng.Intn(10000)),
+	).Replace(template)
+}
+
+// logSink sends injected entries to syslog or journald
+type logSink interface {
+	send(message string) error
+	Close() error
+}
+
+// syslogSink writes to the local or a remote syslog daemon
+type syslogSink struct {
+	*syslog.Writer
+}
+
+func (s syslogSink) send(message string) error {
+	_, err := s.Write([]byte(message))
+	return err
+}
+
+// journalSink writes entries in journald's native protocol, so they carry
+// the priority and identifier as structured fields
+type journalSink struct {
+	conn     net.Conn
+	priority syslog.Priority
+	tag      string
+}
+
+func (s journalSink) send(message string) error {
+	// Multi-line values would need the binary length-prefixed form
+	message = strings.ReplaceAll(message, "\n", " ")
+	entry := fmt.Sprintf("PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\nMESSAGE=%s\nOUTAGEMOCK=1\n", s.priority, s.tag, message)
+	_, err := s.conn.Write([]byte(entry))
+	return err
+}
+
+func (s journalSink) Close() error {
+	return s.conn.Close()
+}
+
+// openLogSink connects to the target of -inject-log
+func openLogSink(inject LogInject) (logSink, error) {
+	switch {
+	case inject.Target == "journald":
+		conn, err := net.Dial("unixgram", journalSocket)
+		if err != nil {
+			return nil, err
+		}
+		return journalSink{conn, inject.Priority, inject.Tag}, nil
+	case inject.Target == "syslog":
+		w, err := syslog.New(inject.Priority|syslog.LOG_USER, inject.Tag)
+		if err != nil {
+			return nil, err
+		}
+		return syslogSink{w}, nil
+	default:
+		network, addr, _ := strings.Cut(inject.Target, "://")
+		w, err := syslog.Dial(network, addr, inject.Priority|syslog.LOG_USER, inject.Tag)
+		if err != nil {
+			return nil, err
+		}
+		return syslogSink{w}, nil
+	}
+}
+
+// injectLogs emits -inject-log entries with the -inject-log-message template
+// at the configured rate, growing linearly during rampup and scaled by host
+// protection and rampdown, so alert rules on log patterns fire alongside the
+// resource pressure
+func (rm *ResourceMock) injectLogs() {
+	defer rm.wg.Done()
+	defer nameThread("om-loginject")()
+
+	inject := rm.config.logInject
+	sink, err := openLogSink(inject)
+	if err != nil {
+		rm.markDegraded("logs", fmt.Errorf("connect to %s: %w", inject.Target, err))
+		return
+	}
+	defer sink.Close()
+	log.Printf("Injecting log entries into %s at up to %.1f/s", inject.Target, inject.Rate)
+
+	host, _ := os.Hostname()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	due, last, seq := 0.0, time.Now(), int64(0)
+	failed := false
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			due += inject.Rate * rm.rampIntensity("logs") * now.Sub(last).Seconds()
+			last = now
+
+			sent := int64(0)
+			for ; due >= 1; due-- {
+				seq++
+				if err := sink.send(renderLogMessage(rm.config.InjectLogMessage, seq, host, rng, now)); err != nil {
+					if !failed {
+						// The daemon may be restarting, keep trying quietly
+						log.Printf("Log injection failed: %v", err)
+						failed = true
+					}
+					continue
+				}
+				failed = false
+				sent++
+			}
+
+			rm.statusMu.Lock()
+			rm.resourceStatus.LogsInjected += sent
+			rm.statusMu.Unlock()
+		}
+	}
+}
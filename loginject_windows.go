@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+// LogInject is parsed from -inject-log, which needs syslog or journald
+type LogInject struct{}
+
+// parseLogInject rejects -inject-log, Windows has neither syslog nor journald
+func parseLogInject(s string) (LogInject, error) {
+	return LogInject{}, fmt.Errorf("-inject-log is not supported on Windows, it writes to syslog or journald")
+}
+
+// injectLogs is never started, parseLogInject rejects -inject-log
+func (rm *ResourceMock) injectLogs() {
+	defer rm.wg.Done()
+}
@@ -2,106 +2,111 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"regexp"
-	"runtime"
-	"strconv"
+	"os/exec"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-)
-
-// Config holds the configuration for the resource mock
-type Config struct {
-	CPUPercent float64       // CPU usage percentage (0-100)
-	MemoryMB   int64         // Memory size in MB
-	FileSizeMB int64         // File size in MB
-	FilePath   string        // File path
-	Duration   time.Duration // Running duration
-	RampupTime time.Duration // Time to ramp up CPU and memory linearly
-}
-
-// ResourceMock manages the resource consumption
-type ResourceMock struct {
-	config         Config
-	memory         []byte
-	file           *os.File
-	filePath       string
-	ctx            context.Context
-	cancel         context.CancelFunc
-	wg             sync.WaitGroup
-	cleanup        sync.Once
-	rampupStart    time.Time
-	displayMgr     *DisplayManager
-	resourceStatus ResourceStatus
-}
 
-// parseFileSize parses a file size string with units (B, K, M, G, T)
-// Examples: "100M", "1.5G", "500K", "2T"
-func parseFileSize(sizeStr string) (int64, error) {
-	if sizeStr == "" {
-		return 0, nil
-	}
-
-	// Regular expression to match number and unit
-	re := regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([BKMGTP]?)$`)
-	matches := re.FindStringSubmatch(strings.ToUpper(sizeStr))
-
-	if len(matches) != 3 {
-		return 0, fmt.Errorf("invalid file size format: %s (expected format: number + unit, e.g., 100M, 1.5G)", sizeStr)
-	}
-
-	// Parse the numeric part
-	value, err := strconv.ParseFloat(matches[1], 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid number in file size: %s", matches[1])
-	}
+	"outagemock/event"
+)
 
-	// Get the unit (default to B if not specified)
-	unit := matches[2]
-	if unit == "" {
-		unit = "B"
-	}
+// Exit codes reported by the process
+const (
+	exitUnhealthy  = 2  // Host scheduler is unhealthy
+	exitStrictMiss = 3  // A resource missed its target in strict mode
+	exitChild      = 4  // The -run-cmd child could not be started
+	exitCgroup     = 5  // The cgroup for -io-throttle or -target-container could not be set up
+	exitFuse       = 6  // The -fuse-mount filesystem could not be mounted
+	exitPrivileges = 7  // Privileges could not be dropped to -user
+	exitTestFailed = 8  // An assertion or guardrail of the test command failed
+	exitConsumer   = 9  // A consumer gave up with -on-consumer-error abort
+	exitDegraded   = 10 // A consumer gave up with -on-consumer-error degrade
+	exitDevice     = 11 // The -dm-device mapping could not be set up
+	exitUsage      = 64 // Invalid command line or configuration
+)
 
-	// Convert to bytes based on unit
-	var multiplier float64
-	switch unit {
-	case "B":
-		multiplier = 1
-	case "K":
-		multiplier = 1024
-	case "M":
-		multiplier = 1024 * 1024
-	case "G":
-		multiplier = 1024 * 1024 * 1024
-	case "T":
-		multiplier = 1024 * 1024 * 1024 * 1024
-	default:
-		return 0, fmt.Errorf("unsupported unit: %s (supported: B, K, M, G, T)", unit)
-	}
+// What the run does when a consumer gives up, set with -on-consumer-error
+const (
+	consumerErrorContinue = "continue" // The other consumers keep their targets
+	consumerErrorAbort    = "abort"    // The run stops at once
+	consumerErrorDegrade  = "degrade"  // The run finishes and exits with exitDegraded
+)
 
-	// Calculate total bytes
-	totalBytes := int64(value * multiplier)
+const (
+	strictThreshold = 0.95             // Fraction of the target a resource must reach in strict mode
+	strictGrace     = 10 * time.Second // Time allowed after rampup before targets are checked
+)
 
-	// Convert to MB for internal use
-	return totalBytes / (1024 * 1024), nil
+// ResourceMock manages the resource consumption
+type ResourceMock struct {
+	config          Config
+	file            *os.File
+	filePath        string
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
+	cleanup         sync.Once
+	rampupStart     time.Time
+	clock           Clock // Time of the run, faster than real time with -time-scale
+	timeline        Timeline
+	displayMgr      *DisplayManager
+	resourceStatus  ResourceStatus
+	statusMu        sync.Mutex
+	exitCode        int
+	throttle        atomicFloat // Factor applied to all targets by host protection
+	child           *exec.Cmd   // Started from -run-cmd
+	childDone       chan struct{}
+	cgroup          *cgroup   // Created for -io-throttle
+	containerCgroup *cgroup   // Cgroup of -target-container this process joined
+	privilegesKept  bool      // Root is kept in the saved set-user-ID for cleanup after -user
+	statePath       string    // State file of this run, see saveState
+	resumed         *runState // State of the crashed run continued with -resume
+	deadlineMu      sync.Mutex
+	deadline        time.Time     // Current end of the run, moved by Extend and EndGraceful
+	deadlineTimer   *time.Timer   // Cancels the run at the deadline
+	rampdownStart   time.Time     // Set once EndGraceful starts ramping targets down
+	overhead        overheadStats // Collected with -self-overhead, guarded by statusMu
+	burn            burnState     // Duty cycle of workers above cpuBurnThreshold
+	burnSupervised  atomic.Bool   // Set while the burn supervisor runs
+	burnStart       sync.Once     // Starts the burn supervisor once the CPU target reaches cpuBurnThreshold
+	memoryFileMB    atomic.Int64  // Resident -mem-filebacked memory of the memory workers
+	workerStats     *workerStats  // CPU time achieved by each CPU worker
+	cpuPool         cpuPool       // Sizes the CPU workers from the usable cores
+	fileSums        *fileSums     // Checksums of the written file with -verify-file or -corrupt-file
+	canary          *canary       // Latency probe run with -canary
+	peaks           runPeaks      // Highest status values, guarded by statusMu
+	trend           trend         // Achieved load for sparklines, guarded by statusMu
+	toggleMu        sync.Mutex
+	disabled        map[string]bool   // Consumers turned off with SetEnabled
+	governors       map[string]string // Previous cpufreq governors replaced with -governor, by sysfs file
+	paused          bool              // Set by SetPaused, guarded by toggleMu
+	targets         liveTargets       // CPU and memory targets, changed while running by AdjustTarget
+	events          event.Bus         // Progress of the run for watchers registered with event.Watch
 }
 
 // monitorSchedulerHealth continuously monitors that the process can be scheduled smoothly
-// by performing sleep loops and checking if the actual sleep time is within expected range
-func (rm *ResourceMock) monitorSchedulerHealth() {
+// by performing sleep loops and checking if the actual sleep time is within expected range,
+// until ctx is done
+func (rm *ResourceMock) monitorSchedulerHealth(ctx context.Context) {
 	const expectedSleepMs = 100
 	const minAcceptableMs = 95
 	const maxAcceptableMs = 130
 
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
 	count := 0
 	for {
-		time.Sleep(2 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 
 		// Perform a single sleep test
 		start := time.Now()
@@ -116,7 +121,8 @@ func (rm *ResourceMock) monitorSchedulerHealth() {
 				break
 			}
 			os.Stderr.WriteString("resource busy, abort the cpu/memory/disk consuming test\n")
-			os.Exit(2)
+			rm.abort(exitUnhealthy)
+			return
 		} else {
 			count = 0
 		}
@@ -124,113 +130,412 @@ func (rm *ResourceMock) monitorSchedulerHealth() {
 }
 
 func main() {
-	var config Config
-	var fileSizeStr string
-
-	flag.Float64Var(&config.CPUPercent, "cpu", 0, "CPU usage percentage (0-100)")
-	flag.Int64Var(&config.MemoryMB, "memory", 0, "Memory size in MB")
-	flag.StringVar(&fileSizeStr, "fsize", "0", "File size with unit (e.g., 100M, 1.5G, 500K, 2T)")
-	flag.StringVar(&config.FilePath, "fpath", "outagemock_temp_file", "File path")
-	flag.DurationVar(&config.Duration, "duration", 30*time.Second, "Running duration")
-	flag.DurationVar(&config.RampupTime, "rampup", 10*time.Second, "Rampup time to reach target CPU and memory")
-
-	// Parse flags
-	flag.Parse()
-
-	// Parse file size with units
-	var err error
-	config.FileSizeMB, err = parseFileSize(fileSizeStr)
-	if err != nil {
-		log.Fatalf("Error parsing file size: %v", err)
-	}
-
-	// Validate configuration
-	if config.CPUPercent < 0 || config.CPUPercent > 100 {
-		log.Fatal("CPU percentage must be between 0 and 100")
-	}
-	if config.MemoryMB < 0 {
-		log.Fatal("Memory size must be non-negative")
-	}
-	if config.FileSizeMB < 0 {
-		log.Fatal("File size must be non-negative")
-	}
-	if config.Duration <= 0 {
-		log.Fatal("Duration must be positive")
-	}
-
-	// Ensure file path has the safety suffix
-	if config.FilePath != "" {
-		config.FilePath = config.FilePath + "_outagemock_test.data"
-	}
-
-	fmt.Printf("Starting resource mock with:\n")
-	fmt.Printf("  CPU: %.1f%% (rampup: %v)\n", config.CPUPercent, config.RampupTime)
-	fmt.Printf("  Memory: %d MB (rampup: %v)\n", config.MemoryMB, config.RampupTime)
-	fmt.Printf("  File: %d MB at %s (rampup: %v)\n", config.FileSizeMB, config.FilePath, config.RampupTime)
-	fmt.Printf("  Duration: %v\n", config.Duration)
+	code := dispatch(os.Args[1:])
+	closeArtifacts()
+	os.Exit(code)
+}
 
-	// Create resource mock
-	ctx, cancel := context.WithTimeout(context.Background(), config.Duration)
+// NewResourceMock creates a resource mock whose run ends after config.Duration
+// unless the deadline is moved with Extend or EndGraceful
+func NewResourceMock(config Config) *ResourceMock {
+	ctx, cancel := context.WithCancel(context.Background())
 	rm := &ResourceMock{
 		config:   config,
 		ctx:      ctx,
 		cancel:   cancel,
 		filePath: config.FilePath,
+		clock:    newClock(config.timeScale),
+		timeline: config.generated,
 	}
+	rm.throttle.Store(1)
+	rm.targets.cpu, rm.targets.memoryMB, rm.targets.fileMB = config.CPUPercent, config.MemoryMB, config.FileSizeMB
+	rm.resourceStatus.Throttle = 1
+	rm.cpuPool = newCPUPool(config)
+	if config.VerifyFile > 0 || config.CorruptFile != "" {
+		rm.fileSums = newFileSums()
+	}
+	rm.startDeadline()
+	return rm
+}
 
-	// Setup signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
+// Run starts resource consumption and blocks until the duration completes,
+// the run is aborted or a signal is received on stop. It returns the exit code.
+func (rm *ResourceMock) Run(stop <-chan os.Signal) int {
 	// Start continuous scheduler health monitoring
-	go rm.monitorSchedulerHealth()
+	go rm.monitorSchedulerHealth(rm.ctx)
 
-	// Start resource consumption
+	// Let embedding programs follow the run from its first phase
+	rm.watchRun()
+
+	// Clean up after a crashed run with the same work file, or resume it
+	if err := rm.recoverState(); err != nil {
+		// Leave the work file alone, it belongs to the other run
+		messagef("Error: %v\n", err)
+		rm.cancel()
+		rm.deadlineTimer.Stop()
+		rm.events.Publish(event.Event{Type: event.CleanupDone})
+		return exitUsage
+	}
+
+	// Fail or clamp targets the host can't hold before consuming anything
+	if err := rm.checkCapacity(); err != nil {
+		messagef("Error: %v\n", err)
+		rm.cancel()
+		rm.deadlineTimer.Stop()
+		if rm.statePath != "" {
+			releaseState(rm.statePath)
+		}
+		rm.events.Publish(event.Event{Type: event.CleanupDone})
+		return exitUsage
+	}
+
+	// Measure the disk before anything else writes to it, a resumed run already did
+	if rm.config.BenchDisk && rm.resumed == nil && !rm.runDiskBench(stop) {
+		rm.Cleanup()
+		return 0
+	}
+
+	// Start resource consumption, at -start-at when given
+	if !rm.waitForStart(stop) {
+		rm.Cleanup()
+		return 0
+	}
 	rm.Start()
 
 	// Wait for completion or signal
-	select {
-	case <-ctx.Done():
-		fmt.Println("Duration completed, shutting down...")
-	case sig := <-sigChan:
-		fmt.Printf("Received signal %v, shutting down...\n", sig)
-		rm.Stop()
+wait:
+	for {
+		select {
+		case <-rm.ctx.Done():
+			messagef("Duration completed, shutting down...\n")
+			if rm.config.hidesEnd() {
+				messagef("The duration drawn from %s was %v (seed: %d)\n", rm.config.durationRange, rm.config.Duration, rm.config.Seed)
+			}
+			break wait
+		case sig := <-stop:
+			switch {
+			case sig == sigExtend:
+				rm.Extend(rm.config.ExtendStep)
+			case sig == sigEnd:
+				rm.EndGraceful()
+			case sig == syscall.SIGTERM && rm.gracefulRampdown() > 0 && !rm.rampingDown():
+				// Let monitoring see a recovery curve, a second signal or SIGQUIT stops at once
+				messagef("Received signal %v, ramping down over %v...\n", sig, rm.gracefulRampdown())
+				rm.rampDown(rm.gracefulRampdown())
+			default:
+				messagef("Received signal %v, shutting down...\n", sig)
+				rm.Stop()
+				break wait
+			}
+		}
 	}
 
 	// Cleanup and exit
 	rm.Cleanup()
-	fmt.Println("Resource mock completed")
+	degraded := rm.Status().Degraded
+	if rm.exitCode == 0 && len(degraded) > 0 && rm.config.OnConsumerError == consumerErrorDegrade {
+		rm.exitCode = exitDegraded
+	}
+	rm.printSelfOverhead()
+	rm.printWorkerReport()
+	rm.printCanaryReport()
+	rm.reportResult(rm.exitCode)
+	rm.finalHeartbeat(rm.exitCode)
+	rm.observeFinal()
+	rm.pushSummary(rm.exitCode)
+	rm.saveHistory(rm.exitCode)
+	rm.saveArtifacts(rm.exitCode)
+	if rm.exitCode == exitDegraded {
+		messagef("Resource mock completed degraded, %s gave up\n", strings.Join(degraded, ", "))
+		return rm.exitCode
+	}
+	if rm.exitCode != 0 {
+		messagef("Resource mock aborted\n")
+		return rm.exitCode
+	}
+	messagef("Resource mock completed\n")
+	return 0
+}
+
+// Status returns a snapshot of the current resource status
+func (rm *ResourceMock) Status() ResourceStatus {
+	rm.statusMu.Lock()
+	defer rm.statusMu.Unlock()
+	return rm.resourceStatus
 }
 
 // Start begins resource consumption
 func (rm *ResourceMock) Start() {
-	rm.rampupStart = time.Now()
+	rm.rampupStart = rm.clock.Now()
+	if rm.resumed != nil {
+		// Continue on the timeline of the crashed run
+		rm.rampupStart = rm.resumed.RampupStart
+	} else if rm.config.startAt.After(rm.rampupStart.Add(-startLateTolerance)) {
+		// Measure the rampup from the agreed start so late starters catch up with the other hosts
+		rm.rampupStart = rm.config.startAt
+	}
+	rm.applyJitter()
+	rm.config.warnCapped()
+
+	// Initialize display manager, scenario lanes share the scenario's table instead
+	rm.displayMgr = NewDisplayManager(&rm.config, rm.clock, rm.rampupStart)
+	if rm.config.lane == "" {
+		rm.displayMgr.Start()
+	}
+
+	// Throttle IO before anything is written
+	if len(rm.config.ioThrottle) > 0 {
+		if err := rm.setupIOThrottle(); err != nil {
+			log.Printf("Failed to throttle IO: %v", err)
+			rm.abort(exitCgroup)
+			return
+		}
+	}
+
+	// Throttle memory before anything is allocated
+	if rm.config.memoryHigh > 0 {
+		if err := rm.setupMemoryHigh(); err != nil {
+			log.Printf("Failed to set memory.high: %v", err)
+			rm.abort(exitCgroup)
+			return
+		}
+	}
+
+	// Charge the load to the target container before anything is allocated
+	if rm.config.containerCgroup != "" {
+		if err := rm.joinContainerCgroup(); err != nil {
+			log.Printf("Failed to target container: %v", err)
+			rm.abort(exitCgroup)
+			return
+		}
+	}
+
+	// Serve the backing directory with injected faults before the workload touches it
+	if rm.config.FuseMount != "" {
+		if err := rm.startFuse(); err != nil {
+			log.Printf("Failed to mount FUSE filesystem: %v", err)
+			rm.abort(exitFuse)
+			return
+		}
+	}
+
+	// Map the test device before the workload opens it
+	if rm.config.DMDevice != "" {
+		if err := rm.startDeviceMapper(); err != nil {
+			log.Printf("Failed to map %s: %v", rm.config.DMDevice, err)
+			rm.abort(exitDevice)
+			return
+		}
+	}
+
+	// Pin the CPU frequency policy before any load runs
+	if rm.config.Governor != "" {
+		if err := rm.setGovernor(); err != nil {
+			rm.markDegraded("governor", fmt.Errorf("set cpufreq governor: %w", err))
+		}
+	}
 
-	// Initialize display manager
-	rm.displayMgr = NewDisplayManager(&rm.config, rm.rampupStart)
-	rm.displayMgr.Start()
+	// Record the run so a crash can be cleaned up or resumed
+	rm.saveState()
 
-	// Allocate memory if requested
-	if rm.config.MemoryMB > 0 {
+	// Launch the workload under test, with a skewed clock if requested
+	if rm.config.RunCmd != "" {
+		if err := rm.startChild(); err != nil {
+			log.Printf("Failed to run child: %v", err)
+			rm.abort(exitChild)
+			return
+		}
+	}
+
+	// Give up root once the privileged setup is done
+	if rm.config.User != "" {
+		if err := rm.dropPrivileges(); err != nil {
+			log.Printf("Failed to drop privileges: %v", err)
+			rm.abort(exitPrivileges)
+			return
+		}
+	}
+
+	// Calibrate the canary before any load competes with it
+	if rm.config.Canary {
+		rm.canary = newCanary()
+		rm.wg.Add(1)
+		go rm.runCanary()
+	}
+
+	// Fault pages in at a steady rate if requested
+	if rm.config.faultRate > 0 {
 		rm.wg.Add(1)
-		go rm.consumeMemory()
+		go rm.faultPages()
 	}
 
-	// Create and grow file if requested
-	if rm.config.FileSizeMB > 0 {
+	// Drive the load average if requested
+	if rm.config.LoadAvg > 0 {
 		rm.wg.Add(1)
-		go rm.consumeFile()
+		go rm.driveLoadAvg()
 	}
 
-	// Consume CPU if requested
-	if rm.config.CPUPercent > 0 {
+	// Hold pressure stalls if requested
+	if len(rm.config.psiTargets) > 0 {
 		rm.wg.Add(1)
-		go rm.consumeCPU()
+		go rm.holdPressure()
+	}
+
+	// Evict the last-level cache if requested
+	if rm.config.LLCThrash != "" {
+		rm.wg.Add(1)
+		go rm.thrashLLC()
+	}
+
+	// Miss the TLB and grow the page tables if requested
+	if rm.config.TLBPressure != "" {
+		rm.wg.Add(1)
+		go rm.pressureTLB()
+	}
+
+	// Duplicate shared pages in forked children if requested
+	if rm.config.CoWFork != "" {
+		rm.wg.Add(1)
+		go rm.forkCoW()
+	}
+
+	// Grow kernel slab caches if requested
+	if rm.config.Kmem != "" {
+		rm.wg.Add(1)
+		go rm.pressureKmem()
+	}
+
+	// Queue runnable threads behind the cores if requested
+	if rm.config.Runqueue != "" {
+		rm.wg.Add(1)
+		go rm.holdRunqueue()
+	}
+
+	// Drive softirq CPU if requested
+	if rm.config.Softirq != "" {
+		rm.wg.Add(1)
+		go rm.pressureSoftirq()
+	}
+
+	// Hold unix IPC objects open if requested
+	if rm.config.UnixObjects != "" {
+		rm.wg.Add(1)
+		go rm.holdUnixObjects()
+	}
+
+	// Flood the log file if requested
+	if rm.config.LogFlood != "" {
+		rm.wg.Add(1)
+		go rm.floodLog()
+	}
+
+	// Inject syslog or journald entries if requested
+	if rm.config.InjectLog != "" {
+		rm.wg.Add(1)
+		go rm.injectLogs()
+	}
+
+	// Read back and corrupt the file if requested
+	if rm.config.VerifyFile > 0 {
+		rm.wg.Add(1)
+		go rm.verifyFile()
+	}
+	if rm.config.CorruptFile != "" {
+		rm.wg.Add(1)
+		go rm.corruptFile()
+	}
+
+	// Create a directory tree of small files if requested
+	if rm.config.Tree != "" {
+		rm.wg.Add(1)
+		go rm.consumeTree()
+	}
+
+	// Move data to and from object storage if requested
+	if rm.config.S3Endpoint != "" {
+		rm.wg.Add(1)
+		go rm.consumeS3()
+	}
+
+	// Consume CPU, memory and file if requested
+	for _, c := range rm.builtinConsumers() {
+		c.Start(rm.ctx)
+	}
+
+	// Drive consumers plugged in with consumer.Register
+	if len(rm.config.consumerTargets) > 0 {
+		rm.wg.Add(1)
+		go rm.runConsumers()
+	}
+
+	// Watch how frequency scaling changes what the CPU load means
+	if rm.config.CPUPercent > 0 || rm.config.Governor != "" {
+		rm.wg.Add(1)
+		go rm.monitorCPUFreq()
+	}
+
+	// Watch the temperature the thermal load causes
+	if rm.config.Thermal {
+		rm.wg.Add(1)
+		go rm.monitorThermal()
+	}
+
+	// Stall the target cgroup in duty cycles
+	if rm.config.FreezeCgroup != "" {
+		rm.wg.Add(1)
+		go rm.freezeCgroup()
+	}
+
+	// Occupy the target service's connection slots
+	if rm.config.ConnCount > 0 {
+		rm.wg.Add(1)
+		go rm.consumeConnections()
+	}
+
+	// Flood the target process with signals
+	if rm.config.SignalStorm != "" {
+		rm.wg.Add(1)
+		go rm.signalStorm()
+	}
+
+	// Track the live metric with the CPU target
+	if rm.config.FollowQuery != "" {
+		rm.wg.Add(1)
+		go rm.followQuery()
+	}
+
+	// Back off when the host becomes unhealthy
+	if rm.config.hostLimits != (HostLimits{}) {
+		rm.wg.Add(1)
+		go rm.protectHost()
 	}
 
 	// Start display update goroutine
 	rm.wg.Add(1)
 	go rm.updateDisplay()
+
+	// Compare this process's own CPU usage with the target
+	if rm.config.SelfOverhead {
+		rm.wg.Add(1)
+		go rm.trackSelfOverhead()
+	}
+
+	// Publish a heartbeat for external watchers
+	if rm.config.HeartbeatFile != "" || rm.config.HeartbeatURL != "" {
+		rm.wg.Add(1)
+		go rm.sendHeartbeats()
+	}
+
+	// Report progress to the watchers of the run
+	rm.wg.Add(1)
+	go rm.publishProgress()
+
+	// Report progress milestones for CI
+	if rm.config.CIEvents {
+		rm.wg.Add(1)
+		go rm.emitPhaseEvents()
+	}
+
 }
 
 // Stop stops all resource consumption
@@ -242,31 +547,142 @@ func (rm *ResourceMock) Stop() {
 func (rm *ResourceMock) updateDisplay() {
 	defer rm.wg.Done()
 
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := rm.clock.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
+	statusFileFailing := false
 	for {
 		select {
 		case <-rm.ctx.Done():
 			return
-		case <-ticker.C:
+		case now := <-ticker.C:
 			// Update resource status
+			rm.statusMu.Lock()
 			rm.resourceStatus.CPUPercent = rm.getCurrentCPUUsage()
 			rm.resourceStatus.MemoryTargetMB = rm.getCurrentMemoryUsage()
 			rm.resourceStatus.FileTargetMB = rm.getCurrentFileSizeUsage()
+			rm.resourceStatus.Throttle = rm.throttle.Load()
+			rm.resourceStatus.Phase, _ = rm.phaseAt(rm.clock.Now())
+			remaining, next, until := rm.phaseCountdown(rm.clock.Now())
+			if rm.config.hidesEnd() {
+				// Don't count down to a secret end
+				remaining = 0
+				if next == "end" {
+					next, until = "", 0
+				}
+			}
+			rm.resourceStatus.RemainingSec = remaining.Seconds()
+			rm.resourceStatus.NextPhase = next
+			rm.resourceStatus.NextPhaseSec = until.Seconds()
+			rm.resourceStatus.MarkovState = rm.config.markovStateAt(rm.clock.Since(rm.rampupStart))
+			rm.resourceStatus.Pressure, _ = readPressure()
+			if rm.config.memoryHigh > 0 && rm.cgroup != nil {
+				rm.resourceStatus.MemoryHighEvents = rm.cgroup.memoryHighEvents()
+			}
+			cpuTarget, memoryTargetMB := rm.currentTargets()
+			rm.trend.observe(now, rm.resourceStatus, cpuTarget, memoryTargetMB, rm.config.FileSizeMB)
+			rm.resourceStatus.Sparklines = rm.trend.sparklines(&rm.config)
+			status := rm.resourceStatus
+			rm.peaks.observe(status)
+			rm.statusMu.Unlock()
 
 			// Update display
-			rm.displayMgr.UpdateStatus(rm.resourceStatus)
+			if rm.config.lane == "" {
+				rm.displayMgr.UpdateStatus(status)
+			}
+
+			// Log when writing starts and stops failing instead of every update
+			if rm.config.StatusFile != "" {
+				err := rm.writeStatusFile(status)
+				if err != nil && !statusFileFailing {
+					log.Printf("Failed to write status file: %v", err)
+				} else if err == nil && statusFileFailing {
+					log.Printf("Status file written again")
+				}
+				statusFileFailing = err != nil
+			}
+
+			if rm.config.Strict {
+				rm.checkStrictTargets(status)
+			}
 		}
 	}
 }
 
+// markDegraded records that a resource gave up trying to reach its target.
+// In strict mode and with -on-consumer-error abort the whole run is aborted.
+func (rm *ResourceMock) markDegraded(resource string, err error) {
+	log.Printf("%s consumption degraded: %v", resource, err)
+
+	rm.statusMu.Lock()
+	if !slices.Contains(rm.resourceStatus.Degraded, resource) {
+		rm.resourceStatus.Degraded = append(rm.resourceStatus.Degraded, resource)
+	}
+	switch resource {
+	case "memory":
+		rm.resourceStatus.MemoryDegraded = true
+	case "file":
+		rm.resourceStatus.FileDegraded = true
+	case "tree":
+		rm.resourceStatus.TreeDegraded = true
+	}
+	rm.statusMu.Unlock()
+	rm.events.Publish(event.Event{Type: event.AllocationFailed, Resource: resource, Err: err})
+
+	switch {
+	case rm.config.Strict:
+		rm.abort(exitStrictMiss)
+	case rm.config.OnConsumerError == consumerErrorAbort:
+		log.Printf("Aborting the run, -on-consumer-error is abort")
+		rm.abort(exitConsumer)
+	}
+}
+
+// checkStrictTargets aborts the run once rampup is over if memory or file
+// usage stays below strictThreshold of its current target. CPU usage is not
+// measured and therefore not checked.
+func (rm *ResourceMock) checkStrictTargets(status ResourceStatus) {
+	if rm.clock.Since(rm.rampupStart) < rm.config.RampupTime+strictGrace {
+		return
+	}
+	// With -mem-variance memory legitimately dips below its target by the variance
+	if float64(status.MemoryActualMB) < float64(status.MemoryTargetMB)*(1-rm.config.memVariance)*strictThreshold {
+		log.Printf("Memory reached %d MB of %d MB target", status.MemoryActualMB, status.MemoryTargetMB)
+		rm.abort(exitStrictMiss)
+	}
+	if float64(status.FileActualMB) < float64(status.FileTargetMB)*strictThreshold {
+		log.Printf("File reached %d MB of %d MB target", status.FileActualMB, status.FileTargetMB)
+		rm.abort(exitStrictMiss)
+	}
+}
+
+// abort stops the run and makes the process exit with the given code
+func (rm *ResourceMock) abort(code int) {
+	rm.statusMu.Lock()
+	if rm.exitCode == 0 {
+		rm.exitCode = code
+	}
+	rm.statusMu.Unlock()
+	rm.cancel()
+}
+
 // Cleanup performs cleanup operations
 func (rm *ResourceMock) Cleanup() {
 	rm.cleanup.Do(func() {
 		rm.cancel()
+		rm.deadlineTimer.Stop()
 		rm.wg.Wait()
 
+		rm.stopChild()
+		rm.regainPrivileges()
+		restoreGovernors(rm.governors)
+		if rm.cgroup != nil {
+			rm.cgroup.remove()
+		}
+		if rm.containerCgroup != nil {
+			rm.containerCgroup.leave()
+		}
+
 		// Stop display manager
 		if rm.displayMgr != nil {
 			rm.displayMgr.Stop()
@@ -276,12 +692,17 @@ func (rm *ResourceMock) Cleanup() {
 		if rm.file != nil {
 			rm.file.Close()
 		}
-		if rm.filePath != "" {
+		// Only a run with -fsize owns the file, another experiment may hold it
+		if rm.config.FileSizeMB > 0 && rm.filePath != "" {
 			os.Remove(rm.filePath)
 		}
-
-		// Clear memory
-		rm.memory = nil
-		runtime.GC()
+		if rm.config.Tree != "" && rm.filePath != "" {
+			os.RemoveAll(treeRoot(rm.filePath))
+		}
+		if rm.statePath != "" {
+			os.Remove(rm.statePath)
+			releaseState(rm.statePath)
+		}
+		rm.events.Publish(event.Event{Type: event.CleanupDone})
 	})
 }
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"hash/crc32"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"outagemock/consumer"
+	"outagemock/event"
 )
 
 // 基准测试：模拟消耗 10MB 内存、10MB 文件、50% CPU，持续 2 秒
@@ -20,3 +32,537 @@ func BenchmarkResourceMock(b *testing.B) {
 	}
 	fmt.Println(count)
 }
+
+// BenchmarkBurnAccuracy measures the CPU usage reached by the burn loop at a 95% target
+func BenchmarkBurnAccuracy(b *testing.B) {
+	const target = 95.0
+	for i := 0; i < b.N; i++ {
+		rm := NewResourceMock(Config{CPUPercent: target, Duration: time.Minute})
+		rm.rampupStart = time.Now()
+		rm.wg.Add(1)
+		go rm.consumeCPU()
+
+		// Let the supervisor settle before measuring
+		time.Sleep(time.Second)
+		start, cpu := time.Now(), processCPUTime()
+		time.Sleep(3 * time.Second)
+		measured := 100 * float64(processCPUTime()-cpu) / float64(time.Since(start)) / float64(runtime.NumCPU())
+
+		rm.cancel()
+		rm.wg.Wait()
+		b.ReportMetric(measured-target, "cpu-error-%")
+	}
+}
+
+// TestEvents checks that a subscriber sees the run from its first phase to cleanup
+func TestEvents(t *testing.T) {
+	config, err := configFromSettings(map[string]string{
+		"fsize": "1M", "duration": "1h", "rampup": "10s", "rampdown": "0s", "fpath": t.TempDir() + "/events",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.lane = "test"
+	rm := NewResourceMock(config)
+	clock := &manualClock{now: time.Now()}
+	rm.useClock(clock)
+	events := rm.events.Subscribe()
+	go rm.Run(nil)
+
+	var got []string
+	record := func(e event.Event) {
+		got = append(got, e.Type.String()+" "+e.Phase+e.Resource)
+	}
+	// The first phase is published once the run's ticker exists
+	record(<-events)
+	// Move the clock a second at a time until the file reaches its target after rampup
+	for i := 0; i < 600 && len(got) < 3; i++ {
+		clock.Advance(time.Second)
+		select {
+		case e := <-events:
+			record(e)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	rm.Stop()
+	for e := range events {
+		record(e)
+	}
+	want := []string{"PhaseStarted rampup", "PhaseStarted steady", "TargetReached file", "CleanupDone "}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+	if _, ok := <-rm.events.Subscribe(); ok {
+		t.Error("Subscribe after cleanup returned an open channel")
+	}
+}
+
+// TestEventsOverflow checks that a subscriber that fell behind still gets CleanupDone
+func TestEventsOverflow(t *testing.T) {
+	var bus event.Bus
+	events := bus.Subscribe()
+	for i := 0; i < 2*event.Buffer; i++ {
+		bus.Publish(event.Event{Type: event.PhaseStarted, Phase: "steady"})
+	}
+	bus.Publish(event.Event{Type: event.CleanupDone})
+
+	var got []event.Event
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != event.Buffer+1 || got[len(got)-1].Type != event.CleanupDone {
+		t.Errorf("got %d events ending in %v, want %d ending in CleanupDone", len(got), got[len(got)-1].Type, event.Buffer+1)
+	}
+}
+
+// fakeConsumer records what the run asks of it
+type fakeConsumer struct {
+	mu      sync.Mutex
+	target  float64
+	stopped bool
+}
+
+func (c *fakeConsumer) Start(ctx context.Context) error { return nil }
+func (c *fakeConsumer) SetTarget(target float64)        { c.mu.Lock(); c.target = target; c.mu.Unlock() }
+func (c *fakeConsumer) Status() consumer.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return consumer.Status{Unit: "%", Target: c.target, Actual: c.target}
+}
+func (c *fakeConsumer) Stop() { c.mu.Lock(); c.stopped = true; c.mu.Unlock() }
+
+// TestRegisteredConsumer checks that a plugged-in consumer gets its target and is stopped
+func TestRegisteredConsumer(t *testing.T) {
+	fake := &fakeConsumer{}
+	consumer.Register("fake", func() (consumer.Consumer, error) { return fake, nil })
+
+	config, err := configFromSettings(map[string]string{
+		"consumer": "fake=40", "duration": "300ms", "rampup": "0s", "fpath": t.TempDir() + "/consumer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.lane = "test"
+	NewResourceMock(config).Run(nil)
+
+	if fake.target != 40 || !fake.stopped {
+		t.Errorf("consumer got target %g, stopped %v; want 40, true", fake.target, fake.stopped)
+	}
+	if _, err := configFromSettings(map[string]string{"consumer": "gpu=80"}); err == nil {
+		t.Error("an unregistered consumer was accepted")
+	}
+}
+
+// TestOnConsumerError checks the exit code and status of a run whose consumer fails under each policy
+func TestOnConsumerError(t *testing.T) {
+	consumer.Register("failing", func() (consumer.Consumer, error) { return nil, fmt.Errorf("permission denied") })
+
+	for policy, want := range map[string]int{"continue": 0, "degrade": exitDegraded, "abort": exitConsumer} {
+		config, err := configFromSettings(map[string]string{
+			"consumer": "failing=1", "duration": "300ms", "rampup": "0s", "on-consumer-error": policy, "fpath": t.TempDir() + "/consumer",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.lane = "test"
+		rm := NewResourceMock(config)
+		if code := rm.Run(nil); code != want {
+			t.Errorf("%s: exit code %d, want %d", policy, code, want)
+		}
+		if degraded := rm.Status().Degraded; len(degraded) != 1 || degraded[0] != "failing" {
+			t.Errorf("%s: degraded %v, want [failing]", policy, degraded)
+		}
+	}
+}
+
+// TestFileSums checks that only fully written blocks get checksums and truncation forgets them
+func TestFileSums(t *testing.T) {
+	fs := newFileSums()
+	fs.record(0, make([]byte, 2*fileBlockBytes+100))
+	fs.record(4*fileBlockBytes+1, make([]byte, fileBlockBytes))
+	if got := fs.knownBlocks(); len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Errorf("known blocks %v, want [0 1]", got)
+	}
+	// A block written in pieces, like with -file-rate, is known once complete
+	data := make([]byte, fileBlockBytes)
+	data[7] = 1
+	fs.record(2*fileBlockBytes, data[:100])
+	fs.record(2*fileBlockBytes+100, data[100:])
+	if sum, ok := fs.sum(2); !ok || sum != crc32.Checksum(data, castagnoli) {
+		t.Errorf("block written in pieces: sum %x known %v, want %x", sum, ok, crc32.Checksum(data, castagnoli))
+	}
+	fs.record(2*fileBlockBytes+10, data[:10])
+	if _, ok := fs.sum(2); ok {
+		t.Error("partly overwritten block is still known")
+	}
+	fs.truncate(fileBlockBytes + 1)
+	if _, ok := fs.sum(1); ok {
+		t.Error("block cut off by truncation is still known")
+	}
+	if _, ok := fs.sum(0); !ok {
+		t.Error("block before the truncation was forgotten")
+	}
+}
+
+// TestCanaryStats checks that quantiles of the canary histogram stay within a bucket of the latencies
+func TestCanaryStats(t *testing.T) {
+	c := &canary{}
+	for i := 1; i <= 100; i++ {
+		c.record(time.Duration(i) * time.Millisecond)
+	}
+	stats := c.stats()
+	for _, q := range []struct {
+		name      string
+		got, want float64
+	}{{"p50", stats.P50Ms, 50}, {"p99", stats.P99Ms, 99}, {"max", stats.MaxMs, 100}} {
+		if q.got < q.want || q.got > q.want*canaryGrowth {
+			t.Errorf("%s = %.2f ms, want %.0f ms within a bucket", q.name, q.got, q.want)
+		}
+	}
+	if stats.Samples != 100 {
+		t.Errorf("samples = %d, want 100", stats.Samples)
+	}
+}
+
+// TestConsoleCommand checks that console commands adjust the targets and reject what the run can't do
+func TestConsoleCommand(t *testing.T) {
+	rm := NewResourceMock(Config{CPUPercent: 50, MemoryMB: 1000, Duration: time.Minute})
+	defer rm.Cleanup()
+	for _, line := range []string{"cpu +20", "mem -300", "pause"} {
+		if _, err := rm.consoleCommand(line, nil); err != nil {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+	if cpu, memoryMB := rm.currentTargets(); cpu != 70 || memoryMB != 700 {
+		t.Errorf("targets %g%% and %d MB, want 70%% and 700 MB", cpu, memoryMB)
+	}
+	if rm.resourceScale("cpu") != 0 {
+		t.Error("paused run still applies load")
+	}
+	for _, line := range []string{"cpu 120", "mem", "disable gpu", "warp 9"} {
+		if _, err := rm.consoleCommand(line, nil); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+// TestWorkerShareCores checks that -cpu-cores-used fills whole cores before the fractional one
+func TestWorkerShareCores(t *testing.T) {
+	pool := cpuPool{basis: 4, workers: 3, cores: true}
+	// 2.5 of 4 cores
+	shares := pool.workerShares(62.5)
+	for i, want := range []float64{100, 100, 50} {
+		if shares[i] != want {
+			t.Errorf("worker %d runs %.1f%%, want %.1f%%", i, shares[i], want)
+		}
+	}
+	if even := (cpuPool{basis: 4, workers: 4}).workerShare(3, 62.5); even != 62.5 {
+		t.Errorf("evenly shared worker runs %.1f%%, want 62.5%%", even)
+	}
+}
+
+// manualClock is a Clock that only moves when a test advances it
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// manualTicker ticks when the clock is advanced past its next tick
+type manualTicker struct {
+	c       chan time.Time
+	every   time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Since(t time.Time) time.Duration    { return c.Now().Sub(t) }
+func (c *manualClock) Wall(d time.Duration) time.Duration { return d }
+
+func (c *manualClock) NewTicker(d time.Duration) *Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{c: make(chan time.Time, 1), every: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return &Ticker{C: t.c, stop: func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		t.stopped = true
+	}}
+}
+
+// Advance moves the clock forward by d and fires the tickers due, dropping
+// ticks a slow receiver missed like time.Ticker
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped || c.now.Before(t.next) {
+			continue
+		}
+		for !c.now.Before(t.next) {
+			t.next = t.next.Add(t.every)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
+
+// TestScaledDeadline checks that the deadline of a faster clock is reported in wall time
+func TestScaledDeadline(t *testing.T) {
+	rm := NewResourceMock(Config{CPUPercent: 1, Duration: 30 * time.Minute})
+	defer rm.Cleanup()
+	rm.useClock(newClock(10))
+
+	if left := time.Until(rm.Deadline()); left < 179*time.Second || left > 180*time.Second {
+		t.Errorf("a 30m run at 10x ends in %v, want 3m", left)
+	}
+	if left := time.Until(rm.Extend(10 * time.Minute)); left < 239*time.Second || left > 240*time.Second {
+		t.Errorf("extended by 10m at 10x it ends in %v, want 4m", left)
+	}
+}
+
+// TestCleanupKeepsForeignFile checks that a run without -fsize leaves the
+// file at its -fpath in place, another experiment may be growing it
+func TestCleanupKeepsForeignFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared"+fileSuffix)
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rm := NewResourceMock(Config{CPUPercent: 1, FilePath: path})
+	rm.Cleanup()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("cleanup of a run without -fsize removed %s: %v", path, err)
+	}
+}
+
+// TestFileRateTarget checks that the file target follows the rate of
+// -file-rate and -write-mbps, so -strict and plan don't expect the whole size
+// before it could have been written
+func TestFileRateTarget(t *testing.T) {
+	for _, tc := range []struct {
+		settings map[string]string
+		at       time.Duration
+		want     int64
+	}{
+		{map[string]string{"file-rate": "10MB/s"}, 0, 0},
+		{map[string]string{"file-rate": "10MB/s"}, 30 * time.Second, 300},
+		{map[string]string{"file-rate": "10MB/s"}, 5 * time.Minute, 1000},
+		{map[string]string{"file-rate": "100MB/s,rampup", "rampup": "100s"}, 50 * time.Second, 500},
+		{map[string]string{"write-mbps": "2", "rampup": "100s"}, 50 * time.Second, 100},
+		{map[string]string{"write-mbps": "20", "rampup": "100s"}, 50 * time.Second, 500},
+	} {
+		tc.settings["fsize"] = "1000M"
+		tc.settings["fpath"] = filepath.Join(t.TempDir(), "rate")
+		config, err := configFromSettings(tc.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rm := NewResourceMock(config)
+		if got := rm.fileTargetAt(tc.at); got != tc.want {
+			t.Errorf("%v: file target %d MB after %v, want %d MB", tc.settings, got, tc.at, tc.want)
+		}
+		rm.Cleanup()
+	}
+	if _, err := configFromSettings(map[string]string{"file-rate": "10MB/s", "write-mbps": "5"}); err == nil {
+		t.Error("-file-rate and -write-mbps were accepted together")
+	}
+}
+
+// TestClockRampup checks the rampup and phases on a clock moved forward by hand
+func TestClockRampup(t *testing.T) {
+	rm := NewResourceMock(Config{CPUPercent: 80, RampupTime: 10 * time.Second, Duration: time.Minute})
+	defer rm.Cleanup()
+	clock := &manualClock{now: time.Now()}
+	rm.useClock(clock)
+	rm.rampupStart = clock.Now()
+
+	clock.Advance(5 * time.Second)
+	if cpu := rm.getCurrentCPUUsage(); cpu != 40 {
+		t.Errorf("CPU target %g%% halfway through the rampup, want 40%%", cpu)
+	}
+	if phase, pct := rm.phaseAt(clock.Now()); phase != "rampup" || pct != 50 {
+		t.Errorf("phase %s at %d%%, want rampup at 50%%", phase, pct)
+	}
+	clock.Advance(10 * time.Second)
+	if cpu := rm.getCurrentCPUUsage(); cpu != 80 {
+		t.Errorf("CPU target %g%% after the rampup, want 80%%", cpu)
+	}
+	if remaining, _, _ := rm.phaseCountdown(clock.Now()); remaining != 45*time.Second {
+		t.Errorf("%v left of the run, want 45s", remaining)
+	}
+
+	ticker := clock.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	clock.Advance(time.Second)
+	select {
+	case <-ticker.C:
+		t.Error("ticker fired before its interval passed on the clock")
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-ticker.C:
+	default:
+		t.Error("ticker didn't fire after its interval passed on the clock")
+	}
+}
+
+// TestCreateUnique checks that runs ending in the same second keep their own reports
+func TestCreateUnique(t *testing.T) {
+	dir := t.TempDir()
+	id := runID(time.Now())
+	var names []string
+	for i := 0; i < 3; i++ {
+		name, err := createUnique(filepath.Join(dir, id), func(name string) error {
+			return os.Mkdir(name, 0755)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Base(name))
+	}
+	if want := []string{id, id + "-2", id + "-3"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("created %v, want %v", names, want)
+	}
+}
+
+// TestDMTable checks the tables of the -dm-device mapping and its names per lane
+func TestDMTable(t *testing.T) {
+	m := &dmMapping{device: "/dev/sdb", sectors: 2048}
+	for _, tc := range []struct {
+		down  bool
+		delay time.Duration
+		want  string
+	}{
+		{false, 0, "0 2048 linear /dev/sdb 0"},
+		{false, 150 * time.Millisecond, "0 2048 delay /dev/sdb 0 150"},
+		{true, 150 * time.Millisecond, "0 2048 flakey /dev/sdb 0 0 1"},
+	} {
+		if got := m.tableFor(tc.down, tc.delay); got != tc.want {
+			t.Errorf("tableFor(%v, %v) = %q, want %q", tc.down, tc.delay, got, tc.want)
+		}
+	}
+	if name := (Config{DMName: "outagemock"}).dmName(); name != "outagemock" {
+		t.Errorf("dmName of a run = %q", name)
+	}
+	if name := (Config{DMName: "outagemock", lane: "db"}).dmName(); name != "outagemock-db" {
+		t.Errorf("dmName of lane db = %q, want outagemock-db", name)
+	}
+}
+
+// TestResourceClasses checks which experiments the agent considers overlapping
+func TestResourceClasses(t *testing.T) {
+	for _, tc := range []struct {
+		config Config
+		want   []string
+	}{
+		{Config{}, nil},
+		{Config{CPUPercent: 50, FileSizeMB: 100}, []string{"cpu", "disk"}},
+		{Config{LLCThrash: "size=1M"}, []string{"cpu"}},
+		{Config{psiTargets: map[string]float64{"cpu": 30, "io": 20}}, []string{"cpu", "disk"}},
+		{Config{CoWFork: "children=2"}, []string{"memory"}},
+		{Config{MemoryHigh: "20%"}, []string{"memory", "cgroup"}},
+		{Config{IOThrottle: "/dev/sda=10M"}, []string{"disk", "cgroup"}},
+		{Config{ConnTarget: "tcp://db:5432", S3Config: S3Config{S3Endpoint: "http://minio:9000"}}, []string{"network"}},
+		{Config{Kmem: "dentries=1000", InjectLog: "rate=5/s", SignalStorm: "pid=2", FreezeCgroup: "/x"}, []string{"kernel", "logs", "signals", "freeze"}},
+		{Config{consumerTargets: map[string]float64{"gpu": 80}}, []string{"gpu"}},
+	} {
+		if got := resourceClasses(tc.config); !slices.Equal(got, tc.want) {
+			t.Errorf("resourceClasses(%+v) = %v, want %v", tc.config, got, tc.want)
+		}
+	}
+	a, b := &agentRun{classes: []string{"cpu", "disk"}}, &agentRun{classes: []string{"disk", "network"}}
+	if shared := a.overlap(b); !slices.Equal(shared, []string{"disk"}) {
+		t.Errorf("overlap = %v, want [disk]", shared)
+	}
+	if a.conflict([]*agentRun{{classes: []string{"memory"}}, b}) != b {
+		t.Error("conflict didn't find the run sharing disk")
+	}
+}
+
+// TestHiddenEnd checks that nothing reports the end of a run whose duration
+// was drawn from a range
+func TestHiddenEnd(t *testing.T) {
+	config, err := configFromSettings(map[string]string{"cpu": "1", "duration": "20m±10m", "seed": "7", "rampup": "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := NewResourceMock(config)
+	defer rm.Cleanup()
+	rm.rampupStart = rm.clock.Now().Add(-time.Minute)
+	rm.startDeadline()
+	defer rm.deadlineTimer.Stop()
+	secret := func(what, s string) {
+		t.Helper()
+		for _, leak := range []string{config.Duration.String(), "ends at", "ending at", `"deadline"`, "pct="} {
+			if strings.Contains(s, leak) {
+				t.Errorf("%s reveals the end with %q: %s", what, leak, s)
+			}
+		}
+	}
+
+	if phase, pct := rm.phaseAt(rm.clock.Now()); phase != "steady" || pct != -1 {
+		t.Errorf("phase %s at %d%%, want steady without progress", phase, pct)
+	}
+	if record := rm.heartbeatRecord(); record.Deadline != nil || record.Progress != nil {
+		t.Errorf("heartbeat reports deadline %v and progress %v", record.Deadline, record.Progress)
+	}
+	secret("console status", rm.consoleStatus())
+	reply, _ := rm.consoleCommand("extend 5m", nil)
+	secret("console extend", reply)
+
+	a := &Agent{running: []*agentRun{{id: 1, rm: rm}}}
+	status := a.status()
+	if status.Deadline != nil || status.Config.Duration != 0 || status.Config.Seed != 0 || status.Experiments[0].Deadline != nil {
+		t.Errorf("agent status reports deadline %v, duration %v and seed %d", status.Deadline, status.Config.Duration, status.Config.Seed)
+	}
+	for _, path := range []string{"/extend?by=5m", "/end"} {
+		w := httptest.NewRecorder()
+		handler := a.handleExtend
+		if path == "/end" {
+			handler = a.handleEnd
+		}
+		handler(w, httptest.NewRequest("POST", path, nil))
+		secret("agent "+path, w.Body.String())
+	}
+	reply, _ = rm.consoleCommand("end", nil)
+	secret("console end", reply)
+}
+
+// TestAgentRunCmdNeedsToken checks that an unguarded agent doesn't run commands for anyone
+func TestAgentRunCmdNeedsToken(t *testing.T) {
+	a := &Agent{onConflict: "reject"}
+	w := httptest.NewRecorder()
+	a.handleRun(w, httptest.NewRequest("POST", "/run", strings.NewReader(`{"run-cmd": "id", "duration": "1s"}`)))
+	if w.Code != 403 || len(a.running) != 0 {
+		t.Errorf("unguarded agent answered %d with %d experiments running, want 403", w.Code, len(a.running))
+	}
+}
+
+// TestSchedulerHealthStops checks that the scheduler monitor ends with its run
+func TestSchedulerHealthStops(t *testing.T) {
+	rm := NewResourceMock(Config{CPUPercent: 1, Duration: time.Minute})
+	defer rm.Cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rm.monitorSchedulerHealth(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("scheduler monitor still running after its context was canceled")
+	}
+}
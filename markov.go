@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MarkovChain is a -markov file: load states the run moves between at random.
+// It is JSON, which YAML parsers read as well; YAML files are not read.
+//
+//	{
+//	  "initial": "quiet",
+//	  "transition": "30s",
+//	  "states": {
+//	    "quiet": {"cpu": 10, "memory_mb": 512, "dwell": "20m-2h", "next": {"busy": 0.8, "spike": 0.2}},
+//	    "busy":  {"cpu": 45, "memory_mb": 2048, "dwell": "30m-1h", "next": {"quiet": 1}},
+//	    "spike": {"cpu": 90, "memory_mb": 3072, "file_mb": 1024, "dwell": "2m-5m", "next": {"quiet": 1}}
+//	  }
+//	}
+//
+// Each visit to a state lasts a dwell time drawn uniformly from its range, then
+// the next state is drawn with the weights of next. The targets move to the
+// next state's over transition, by default at once.
+type MarkovChain struct {
+	Initial    string                 `json:"initial"`
+	Transition string                 `json:"transition"`
+	States     map[string]MarkovState `json:"states"`
+}
+
+// MarkovState is one load state of a MarkovChain
+type MarkovState struct {
+	CPUPercent float64            `json:"cpu"`
+	MemoryMB   int64              `json:"memory_mb"`
+	FileSizeMB int64              `json:"file_mb"`
+	Dwell      string             `json:"dwell"` // A duration or a range like 10m-30m
+	Next       map[string]float64 `json:"next"`  // Weights of the states following this one
+
+	minDwell, maxDwell time.Duration
+	next               []string // Names of Next, sorted so a seed draws the same states
+}
+
+// markovVisit is a stay in a state at an offset from the start of the run
+type markovVisit struct {
+	Offset time.Duration
+	State  string
+}
+
+// loadMarkovChain reads and checks a -markov file
+func loadMarkovChain(path string) (MarkovChain, error) {
+	var chain MarkovChain
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return chain, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		return chain, fmt.Errorf("parse %s: not a JSON object, -markov reads JSON only (convert YAML with e.g. yq -o=json)", path)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&chain); err != nil {
+		return chain, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if _, ok := chain.States[chain.Initial]; !ok {
+		return chain, fmt.Errorf("%s: initial state %q is not among the states", path, chain.Initial)
+	}
+	if _, err := time.ParseDuration(chain.Transition); chain.Transition != "" && err != nil {
+		return chain, fmt.Errorf("%s: invalid transition %q: %v", path, chain.Transition, err)
+	}
+	for name, state := range chain.States {
+		if state.CPUPercent < 0 || state.CPUPercent > 100 || state.MemoryMB < 0 || state.FileSizeMB < 0 {
+			return chain, fmt.Errorf("%s: state %s: cpu must be between 0 and 100, memory_mb and file_mb non-negative", path, name)
+		}
+		low, high, _ := strings.Cut(state.Dwell, "-")
+		if high == "" {
+			high = low
+		}
+		state.minDwell, err = time.ParseDuration(low)
+		if err == nil {
+			state.maxDwell, err = time.ParseDuration(high)
+		}
+		if err != nil || state.minDwell <= 0 || state.maxDwell < state.minDwell {
+			return chain, fmt.Errorf("%s: state %s: invalid dwell %q (expected a positive duration or a range like 10m-30m)", path, name, state.Dwell)
+		}
+		total := 0.0
+		for next, weight := range state.Next {
+			if _, ok := chain.States[next]; !ok {
+				return chain, fmt.Errorf("%s: state %s: unknown next state %q", path, name, next)
+			}
+			if weight < 0 {
+				return chain, fmt.Errorf("%s: state %s: the weight of %s is negative", path, name, next)
+			}
+			total += weight
+			state.next = append(state.next, next)
+		}
+		if total == 0 {
+			return chain, fmt.Errorf("%s: state %s: next needs a state with a positive weight", path, name)
+		}
+		sort.Strings(state.next)
+		chain.States[name] = state
+	}
+	return chain, nil
+}
+
+// walk draws the states visited over d and returns them with the timeline of
+// their targets
+func (chain MarkovChain) walk(d time.Duration, rng *rand.Rand) ([]markovVisit, Timeline) {
+	transition, _ := time.ParseDuration(chain.Transition)
+	var visits []markovVisit
+	t := &archetypeTimeline{peak: TimelinePoint{CPUPercent: 1, MemoryMB: 1, FileSizeMB: 1}}
+	name := chain.Initial
+	for offset := time.Duration(0); offset < d; {
+		state := chain.States[name]
+		visits = append(visits, markovVisit{offset, name})
+		dwell := state.minDwell + time.Duration(rng.Int63n(int64(state.maxDwell-state.minDwell)+1))
+		t.at(offset+min(transition, dwell), state.CPUPercent, float64(state.MemoryMB), float64(state.FileSizeMB))
+		t.at(offset+dwell, state.CPUPercent, float64(state.MemoryMB), float64(state.FileSizeMB))
+		offset += dwell
+
+		total := 0.0
+		for _, next := range state.next {
+			total += state.Next[next]
+		}
+		pick := rng.Float64() * total
+		for _, next := range state.next {
+			if name = next; pick < state.Next[next] {
+				break
+			}
+			pick -= state.Next[next]
+		}
+	}
+	return visits, t.points
+}
+
+// applyMarkov walks the chain of -markov over the run, seeded with -seed, and
+// sets the targets to the peaks of its states
+func (c *Config) applyMarkov() error {
+	if c.Markov == "" {
+		c.markovVisits = nil
+		return nil
+	}
+	if c.Emulate != "" {
+		return fmt.Errorf("-emulate and -markov are mutually exclusive")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("-markov needs a positive -duration")
+	}
+	chain, err := loadMarkovChain(c.Markov)
+	if err != nil {
+		return fmt.Errorf("invalid -markov: %w", err)
+	}
+	if c.Seed == 0 {
+		// Fixed here so the jitter of the run shares the seed that is logged
+		c.Seed = time.Now().UnixNano()
+	}
+	c.markovVisits, c.generated = chain.walk(c.Duration, rand.New(rand.NewSource(c.Seed)))
+	peak := c.generated.Peak()
+	c.CPUPercent, c.MemoryMB, c.FileSizeMB = peak.CPUPercent, peak.MemoryMB, peak.FileSizeMB
+	return nil
+}
+
+// markovStateAt returns the state of -markov the run is in after elapsed
+func (c *Config) markovStateAt(elapsed time.Duration) string {
+	state := ""
+	for _, visit := range c.markovVisits {
+		if visit.Offset > elapsed {
+			break
+		}
+		state = visit.State
+	}
+	return state
+}
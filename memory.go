@@ -1,70 +1,274 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
 	"runtime"
 	"time"
 )
 
 const BlockBytes = 1024 * 1024
 
-// Page represents a 4KB memory page
-type Page struct {
-	data [4096]byte
+// PageBytes is the size of a memory page
+const PageBytes = 4096
+
+// Block is a 1MB anonymous memory mapping, or a shared mapping of a file with
+// -mem-filebacked. Blocks live outside the Go heap, so the garbage collector
+// neither scans nor accounts for them.
+type Block struct {
+	data    []byte
+	evicted bool // Pages returned to the kernel while the block stays mapped
+	file    bool // Mapped from the file of the area, the pages are page cache
 }
 
-// Get returns the byte at the specified position
-func (p *Page) Get(pos int) byte {
-	return p.data[pos]
+// Prefault modes of -mem-prefault
+const (
+	prefaultWrite    = "write"        // Write one byte per page, guaranteeing residency
+	prefaultRead     = "read"         // Read one byte per page, only reserving address space backed by the zero page
+	prefaultPopulate = "MAP_POPULATE" // Let the kernel populate the mapping in one call
+)
+
+// validPrefault reports whether mode is a supported -mem-prefault mode
+func validPrefault(mode string) bool {
+	return mode == prefaultWrite || mode == prefaultRead || mode == prefaultPopulate
 }
 
-// Set sets the byte at the specified position
-func (p *Page) Set(pos int, value byte) {
-	p.data[pos] = value
+// NewBlock maps a new block and prefaults its pages as selected by mode
+func NewBlock(mode string) (*Block, error) {
+	return mapBlock(mode, nil, 0)
 }
 
-// Block represents a 1MB memory block containing 256 pages
-type Block struct {
-	pages [256]*Page
+// NewFileBlock maps the block at offset of f, growing the file, and
+// prefaults its pages as selected by mode
+func NewFileBlock(mode string, f *os.File, offset int64) (*Block, error) {
+	if err := f.Truncate(offset + BlockBytes); err != nil {
+		return nil, fmt.Errorf("grow memory file: %w", err)
+	}
+	return mapBlock(mode, f, offset)
 }
 
-// NewBlock creates a new block with allocated pages
-func NewBlock() *Block {
-	block := &Block{}
-	for i := 0; i < 256; i++ {
-		block.pages[i] = &Page{}
-		// Fill page with pattern to ensure physical allocation
-		for j := 0; j < 4096; j += 1023 {
-			block.pages[i].Set(j, byte(j))
-		}
+func mapBlock(mode string, f *os.File, offset int64) (*Block, error) {
+	flags := 0
+	if mode == prefaultPopulate {
+		flags = mapPopulate
 	}
-	return block
+	data, err := mmap(f, offset, BlockBytes, flags)
+	if err != nil {
+		return nil, fmt.Errorf("mmap block: %w", err)
+	}
+	block := &Block{data: data, file: f != nil}
+	switch mode {
+	case prefaultWrite:
+		block.touch(true)
+	case prefaultRead:
+		block.touch(false)
+	}
+	return block, nil
 }
 
-func (b *Block) Iter() {
-	for i := 0; i < 256; i++ {
-		page := b.pages[i]
-		for j := 0; j < 4096; j += 1023 {
-			page.Set(j, page.Get(j+1))
+// touch reads or writes one byte per page, the cheapest access that faults a page in
+func (b *Block) touch(write bool) byte {
+	var sum byte
+	for page := 0; page < BlockBytes; page += PageBytes {
+		if write {
+			b.data[page]++
+		} else {
+			sum += b.data[page]
 		}
 	}
+	return sum
+}
+
+// Iter touches every page of the block to keep it resident, evicted blocks are left alone
+func (b *Block) Iter(write bool) {
+	if !b.evicted {
+		b.touch(write)
+	}
+}
+
+// Evict returns the block's pages to the kernel but keeps it mapped
+func (b *Block) Evict() {
+	b.drop()
+	b.evicted = true
+}
+
+// drop frees the pages of the block. Unmapping file pages leaves them in the
+// page cache, so they are removed from the file instead.
+func (b *Block) drop() {
+	if b.file && removePages(b.data) == nil {
+		return
+	}
+	dontNeed(b.data)
+}
+
+// Restore faults the pages of an evicted block back in
+func (b *Block) Restore(write bool) {
+	b.touch(write)
+	b.evicted = false
+}
+
+// Release returns the block's pages to the kernel and unmaps it
+func (b *Block) Release() {
+	if b.data == nil {
+		return
+	}
+	b.drop()
+	munmap(b.data)
+	b.data = nil
 }
 
 // Area represents a memory area containing multiple blocks
 type Area struct {
-	blocks []*Block
-	curPos int
+	blocks   []*Block
+	curPos   int
+	prefault string
+	resident int  // Blocks that are not evicted
+	window   int  // First block of the resident window, the blocks after it are evicted
+	moved    bool // The window moved since the blocks were last evicted and restored
+	locked   bool // Blocks are locked into memory with mlock
+
+	file         *os.File // Unlinked temporary file backing the file blocks, nil for anonymous memory only
+	fileShare    float64  // Share of the blocks mapped from file, -mem-filebacked
+	fileBlocks   int
+	fileResident int // File blocks that are not evicted
 }
 
-// NewArea creates a new area with the specified capacity
-func NewArea(capacity int) *Area {
+// NewArea creates a new area with the specified capacity and prefault mode
+func NewArea(capacity int, prefault string) *Area {
 	return &Area{
-		blocks: make([]*Block, 0, capacity),
+		blocks:   make([]*Block, 0, capacity),
+		prefault: prefault,
+	}
+}
+
+// BackWithFile maps share of the blocks added from now on from an unlinked
+// temporary file in dir, so they are page cache instead of anonymous memory
+func (a *Area) BackWithFile(dir string, share float64) error {
+	f, err := os.CreateTemp(dir, ".outagemock-mem-*")
+	if err != nil {
+		return fmt.Errorf("create memory file: %w", err)
+	}
+	os.Remove(f.Name())
+	a.file, a.fileShare = f, share
+	return nil
+}
+
+// TryIncrease adds a new block to the area, from the file while the file
+// blocks are below their share
+func (a *Area) TryIncrease() error {
+	var block *Block
+	var err error
+	if a.file != nil && a.fileBlocks < int(math.Round(a.fileShare*float64(len(a.blocks)+1))) {
+		block, err = NewFileBlock(a.prefault, a.file, int64(len(a.blocks))*BlockBytes)
+	} else {
+		block, err = NewBlock(a.prefault)
+	}
+	if err != nil {
+		return err
+	}
+	if a.locked {
+		// Locked pages are unevictable, the kernel can neither swap nor reclaim them
+		if err := mlock(block.data); err != nil {
+			block.Release()
+			return fmt.Errorf("mlock block: %w", err)
+		}
+	}
+	a.blocks = append(a.blocks, block)
+	a.resident++
+	if block.file {
+		a.fileBlocks++
+		a.fileResident++
+	}
+	return nil
+}
+
+// Shrink releases blocks beyond the first n
+func (a *Area) Shrink(n int) {
+	if n >= len(a.blocks) {
+		return
+	}
+	for i := n; i < len(a.blocks); i++ {
+		block := a.blocks[i]
+		if !block.evicted {
+			a.resident--
+		}
+		if block.file {
+			a.fileBlocks--
+			if !block.evicted {
+				a.fileResident--
+			}
+		}
+		block.Release()
+		a.blocks[i] = nil
+	}
+	a.blocks = a.blocks[:n]
+	if a.curPos >= n {
+		a.curPos = 0
+	}
+	if a.window >= n {
+		a.window = 0
+	}
+}
+
+// SetResident keeps n blocks from the resident window on resident and evicts the
+// others, so RSS can drop below the mapped size without unmapping anything
+func (a *Area) SetResident(n int) {
+	count := len(a.blocks)
+	if n > count {
+		n = count
+	}
+	if n == a.resident && !a.moved {
+		return
+	}
+	for k := 0; k < count; k++ {
+		block := a.blocks[(a.window+k)%count]
+		switch {
+		case k < n && block.evicted:
+			block.Restore(a.prefault != prefaultRead)
+			a.resident++
+			if block.file {
+				a.fileResident++
+			}
+		case k >= n && !block.evicted:
+			block.Evict()
+			a.resident--
+			if block.file {
+				a.fileResident--
+			}
+		}
+	}
+	a.moved = false
+}
+
+// Rotate moves the resident window by a tenth of the blocks, so the next
+// SetResident evicts a different subset and re-touches some evicted blocks
+func (a *Area) Rotate() {
+	if count := len(a.blocks); count > 0 && a.resident < count {
+		a.window = (a.window + count/10 + 1) % count
+		a.moved = true
 	}
 }
 
-// Increase adds a new block to the area
-func (a *Area) Increase() {
-	a.blocks = append(a.blocks, NewBlock())
+// GetResidentMB returns the resident size in MB
+func (a *Area) GetResidentMB() int64 {
+	return int64(a.resident)
+}
+
+// GetFileResidentMB returns the resident size of the file blocks in MB
+func (a *Area) GetFileResidentMB() int64 {
+	return int64(a.fileResident)
+}
+
+// Release unmaps all blocks of the area and closes its file
+func (a *Area) Release() {
+	a.Shrink(0)
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
 }
 
 // GetBlockCount returns the number of blocks in the area
@@ -92,24 +296,37 @@ func (a *Area) Access() {
 			a.curPos = 0
 		}
 		block := a.blocks[a.curPos]
-		block.Iter()
+		block.Iter(a.prefault != prefaultRead)
 	}
 }
 
-// getCurrentMemoryUsage calculates current memory usage based on rampup progress
+// getCurrentMemoryUsage calculates current memory usage based on rampup progress,
+// limited by the quota and scaled by host protection and rampdown
 func (rm *ResourceMock) getCurrentMemoryUsage() int64 {
-	elapsed := time.Since(rm.rampupStart)
+	return int64(float64(rm.config.capMemory(rm.memoryTargetAt(rm.clock.Since(rm.rampupStart)))) * rm.resourceScale("memory"))
+}
+
+// memoryTargetAt calculates the memory target after elapsed time of the run
+func (rm *ResourceMock) memoryTargetAt(elapsed time.Duration) int64 {
+	if target, ok := rm.correlatedTarget("memory", elapsed); ok {
+		return int64(target)
+	}
+	// Replayed timelines replace the linear rampup
+	if rm.timeline != nil {
+		return rm.timeline.At(elapsed).MemoryMB
+	}
 
 	// If rampup time is 0 or elapsed time exceeds rampup time, use target values
+	_, target := rm.currentTargets()
 	if rm.config.RampupTime <= 0 || elapsed >= rm.config.RampupTime {
-		return rm.config.MemoryMB
+		return target
 	}
 
 	// Calculate rampup progress (0.0 to 1.0)
 	progress := float64(elapsed) / float64(rm.config.RampupTime)
 
 	// Linear interpolation from 0 to target
-	return int64(progress * float64(rm.config.MemoryMB))
+	return int64(progress * float64(target))
 }
 
 // consumeMemory allocates and randomly accesses memory using multiple goroutines
@@ -119,13 +336,32 @@ func (rm *ResourceMock) consumeMemory() {
 	// Use CPU count goroutines for better distribution
 	numGoroutines := runtime.NumCPU()
 
+	// Lift the locked memory limit, which only privileged processes can raise above the hard limit
+	if rm.config.Mlock {
+		if err := setMemlockLimit(rlimInfinity, rlimInfinity); err != nil {
+			// Unprivileged processes can still raise the soft limit up to the hard limit
+			if _, max, err := memlockLimit(); err == nil {
+				setMemlockLimit(max, max)
+			}
+			limit, _, _ := memlockLimit()
+			log.Printf("Locked memory is limited to %d MB: %v", limit>>20, err)
+		}
+	}
+
+	// Page cache only behaves like page cache on a disk filesystem
+	if rm.config.memFileBacked > 0 {
+		if onTmpfs(memoryFileDir(rm.config.FilePath)) {
+			log.Printf("The -mem-filebacked file is on tmpfs next to -fpath, its pages are shared memory that can't be written back")
+		}
+	}
+
 	// Channel to send target memory to each worker
-	targetChans := make([]chan int64, numGoroutines)
+	targetChans := make([]chan memoryTarget, numGoroutines)
 	for i := 0; i < numGoroutines; i++ {
-		targetChans[i] = make(chan int64, 1)
+		targetChans[i] = make(chan memoryTarget, 1)
 	}
 
-	// Channel to collect 1MB increments from workers
+	// Channel to collect 1MB increments (and releases) from workers
 	incrementChan := make(chan int, numGoroutines*100) // Buffer for increments
 
 	// Start memory allocation goroutines
@@ -135,7 +371,7 @@ func (rm *ResourceMock) consumeMemory() {
 	}
 
 	// Update memory allocation every 2 seconds
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := rm.clock.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
 	// Track actual allocated memory
@@ -148,21 +384,20 @@ func (rm *ResourceMock) consumeMemory() {
 			for i := 0; i < numGoroutines; i++ {
 				close(targetChans[i])
 			}
-			close(incrementChan)
+			// Leave the memory reached since the last tick in the final status
+			rm.statusMu.Lock()
+			rm.resourceStatus.MemoryActualMB = totalActualMB
+			rm.statusMu.Unlock()
 			return
 		case <-ticker.C:
 			// Get current target memory usage based on rampup progress
-			currentMemoryMB := rm.getCurrentMemoryUsage()
-
-			// Calculate memory per goroutine
-			memoryPerGoroutine := currentMemoryMB / int64(numGoroutines)
-			remainingMemory := currentMemoryMB % int64(numGoroutines)
+			mapped, resident := rm.memoryTargets(rm.getCurrentMemoryUsage(), rm.clock.Since(rm.rampupStart))
 
-			// Send target memory to each goroutine
+			// Send target memory to each goroutine, distributing the remainder to the first few
 			for i := 0; i < numGoroutines; i++ {
-				target := memoryPerGoroutine
-				if i < int(remainingMemory) {
-					target++ // Distribute remaining memory to first few goroutines
+				target := memoryTarget{
+					mappedMB:   splitShare(mapped, numGoroutines, i),
+					residentMB: splitShare(resident, numGoroutines, i),
 				}
 				select {
 				case targetChans[i] <- target:
@@ -174,21 +409,78 @@ func (rm *ResourceMock) consumeMemory() {
 			}
 
 			// Update actual memory size in resource status
+			rm.statusMu.Lock()
 			rm.resourceStatus.MemoryActualMB = totalActualMB
-		case <-incrementChan:
-			// Worker allocated 1MB, increment counter
-			totalActualMB++
+			rm.resourceStatus.MemoryFileMB = rm.memoryFileMB.Load()
+			rm.statusMu.Unlock()
+		case delta := <-incrementChan:
+			// Worker allocated or released memory, update counter
+			totalActualMB += int64(delta)
 		}
 	}
 }
 
+// memoryFileDir is where -mem-filebacked creates the files of the workers, next to -fpath
+func memoryFileDir(filePath string) string {
+	return filepath.Dir(filePath)
+}
+
+// memoryTarget is the memory a worker keeps mapped and the part of it kept resident
+type memoryTarget struct {
+	mappedMB   int64
+	residentMB int64
+}
+
+// splitShare returns worker i's share of total split across n workers
+func splitShare(total int64, n, i int) int64 {
+	share := total / int64(n)
+	if int64(i) < total%int64(n) {
+		share++
+	}
+	return share
+}
+
+// memoryTargets returns how much memory to keep mapped and resident for target.
+// With -mem-variance the mapping is the hard ceiling target*(1+variance), limited
+// by -max-memory, and the resident part oscillates around target as a sine over
+// -mem-variance-period, evicting a rotating subset of blocks.
+func (rm *ResourceMock) memoryTargets(target int64, elapsed time.Duration) (mapped, resident int64) {
+	variance := rm.config.memVariance
+	if variance == 0 || target == 0 {
+		return target, target
+	}
+	mapped = int64(math.Ceil(float64(target) * (1 + variance)))
+	if max := rm.config.MaxMemoryMB; max > 0 && mapped > max {
+		mapped = max
+	}
+	phase := 2 * math.Pi * float64(elapsed) / float64(rm.config.MemVariancePeriod)
+	resident = int64(math.Round(float64(target) * (1 + variance*math.Sin(phase))))
+	if resident > mapped {
+		resident = mapped
+	}
+	return mapped, resident
+}
+
 // memoryWorker allocates memory blocks and maintains them using Area structure
-func (rm *ResourceMock) memoryWorker(workerID int, targetChan <-chan int64, incrementChan chan<- int) {
+func (rm *ResourceMock) memoryWorker(workerID int, targetChan <-chan memoryTarget, incrementChan chan<- int) {
 	defer rm.wg.Done()
+	defer nameThread("om-memw-%d", workerID)()
 
 	// Create memory area with initial capacity
-	area := NewArea(4096) // Pre-allocate capacity for 4096 blocks (4GB)
-	var currentTargetMB int64
+	area := NewArea(4096, rm.config.MemPrefault) // Pre-allocate capacity for 4096 blocks (4GB)
+	area.locked = rm.config.Mlock
+	defer area.Release()
+	if rm.config.memFileBacked > 0 {
+		if err := area.BackWithFile(memoryFileDir(rm.config.FilePath), rm.config.memFileBacked); err != nil {
+			rm.markDegraded("memory", err)
+			return
+		}
+	}
+	defer func() { rm.memoryFileMB.Add(-area.GetFileResidentMB()) }()
+	var target memoryTarget
+	var reportedMB int64 // Resident memory reported to the controller
+	var reportedFileMB int64
+	degraded := false
 
 	// Ticker for allocation and access
 	allocTicker := time.NewTicker(10 * time.Millisecond)
@@ -198,32 +490,55 @@ func (rm *ResourceMock) memoryWorker(workerID int, targetChan <-chan int64, incr
 		select {
 		case <-rm.ctx.Done():
 			return
-		case targetMB, ok := <-targetChan:
+		case t, ok := <-targetChan:
 			if !ok {
 				return // Channel closed
 			}
-			currentTargetMB = targetMB
+			target = t
+			area.Rotate()
 		case <-allocTicker.C:
 			// Access memory to keep it active
 			area.Access()
 
-			// Allocate 1MB if we haven't reached target yet
-			if currentTargetMB > 0 {
-				currentMB := area.GetTotalSizeMB()
-				if currentMB < currentTargetMB {
-					// Add one 1MB block
-					area.Increase()
-
-					// Send 1MB increment to controller
-					select {
-					case incrementChan <- 1:
-					case <-rm.ctx.Done():
-						return
-					default:
-						// Channel might be full, continue
+			currentMB := area.GetTotalSizeMB()
+
+			// Release memory above the target, e.g. while the host is protected
+			if currentMB > target.mappedMB {
+				area.Shrink(int(target.mappedMB))
+			} else if target.mappedMB > 0 && !degraded {
+				// Allocate 1MB if we haven't reached target yet
+				if currentMB < target.mappedMB {
+					// Add one 1MB block, backing off while allocation fails
+					err := retryWithBackoff(rm.ctx, fmt.Sprintf("memory worker %d", workerID), area.TryIncrease)
+					if err != nil {
+						if rm.ctx.Err() != nil {
+							return
+						}
+						// Keep holding what was allocated so far
+						degraded = true
+						rm.markDegraded("memory", err)
 					}
 				}
 			}
+			area.SetResident(int(target.residentMB))
+
+			// The file part is only reported for the status
+			if delta := area.GetFileResidentMB() - reportedFileMB; delta != 0 {
+				rm.memoryFileMB.Add(delta)
+				reportedFileMB += delta
+			}
+
+			// Send the change of resident memory to the controller
+			if delta := area.GetResidentMB() - reportedMB; delta != 0 {
+				select {
+				case incrementChan <- int(delta):
+					reportedMB += delta
+				case <-rm.ctx.Done():
+					return
+				default:
+					// Channel might be full, report with the next tick
+				}
+			}
 		}
 	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fillLaneSettings are the settings of the run command a -fill-all-mounts lane inherits
+var fillLaneSettings = []string{
+	"duration", "rampup", "rampdown", "graceful-rampdown", "extend-step", "start-at",
+	"file-rate", "file-content", "verify-file", "corrupt-file", "max-fsize", "strict",
+}
+
+// fillFileName is the work file created in the root of every filled mount
+const fillFileName = "outagemock_fill"
+
+// MountFill is parsed from -fill-all-mounts
+type MountFill struct {
+	Pattern   string             // Glob matched against mount points
+	Fill      float64            // Usage each filesystem is filled to, as a fraction of its size
+	Overrides map[string]float64 // Fill of individual mount points
+}
+
+// parseMountFill parses a spec like pattern=/data*,fill=90%,/data3=70%
+func parseMountFill(s string) (MountFill, error) {
+	fill := MountFill{Fill: 0.9, Overrides: map[string]float64{}}
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fill, fmt.Errorf("invalid -fill-all-mounts %q: expected key=value", part)
+		}
+		switch {
+		case key == "pattern":
+			if _, err := filepath.Match(value, "/"); err != nil {
+				return fill, fmt.Errorf("invalid -fill-all-mounts pattern %q: %v", value, err)
+			}
+			fill.Pattern = value
+		case key == "fill" || strings.HasPrefix(key, "/"):
+			pct, err := parsePercent(value)
+			if err != nil {
+				return fill, fmt.Errorf("invalid -fill-all-mounts %s: %v", key, err)
+			}
+			if key == "fill" {
+				fill.Fill = pct
+			} else {
+				fill.Overrides[filepath.Clean(key)] = pct
+			}
+		default:
+			return fill, fmt.Errorf("invalid -fill-all-mounts key %q (supported: pattern, fill, /mount/point)", key)
+		}
+	}
+	if fill.Pattern == "" {
+		return fill, fmt.Errorf("-fill-all-mounts requires pattern, e.g. pattern=/data*")
+	}
+	return fill, nil
+}
+
+// matchingMounts returns the mount points of this namespace matching the pattern, sorted
+func matchingMounts(pattern string) ([]string, error) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seen := map[string]bool{}
+	var mounts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// Spaces and other special characters are octal escaped
+		point := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(fields[1])
+		if ok, _ := filepath.Match(pattern, point); ok && !seen[point] {
+			seen[point] = true
+			mounts = append(mounts, point)
+		}
+	}
+	sort.Strings(mounts)
+	return mounts, scanner.Err()
+}
+
+// mountFillMB returns how many MB must be written to bring the filesystem at
+// path to fill of its size, limited to the space available to this user
+func mountFillMB(path string, fill float64) (int64, error) {
+	size, free, avail, err := diskSpace(path)
+	if err != nil {
+		return 0, err
+	}
+	need := int64(fill*float64(size)) - (size - free)
+	need = min(need, avail)
+	if need < 0 {
+		need = 0
+	}
+	return need >> 20, nil
+}
+
+// fillMountLanes builds a lane filling every mount matched by -fill-all-mounts,
+// plus a main lane running the rest of the configuration
+func fillMountLanes(c *configCommand) ([]lane, error) {
+	spec := c.config.mountFill
+	mounts, err := matchingMounts(spec.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no mount point matches -fill-all-mounts pattern %s", spec.Pattern)
+	}
+
+	main := c.config
+	main.lane = "main"
+	lanes := []lane{{name: main.lane, rm: NewResourceMock(main)}}
+	for _, mount := range mounts {
+		fill, ok := spec.Overrides[mount]
+		if !ok {
+			fill = spec.Fill
+		}
+		mb, err := mountFillMB(mount, fill)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", mount, err)
+		}
+		if mb == 0 {
+			messagef("Skipping %s, already %.0f%% full or more\n", mount, 100*fill)
+			continue
+		}
+
+		values := map[string]string{
+			"fsize": fmt.Sprintf("%dM", mb),
+			"fpath": filepath.Join(mount, fillFileName),
+		}
+		for _, name := range fillLaneSettings {
+			values[name] = c.fs.Lookup(name).Value.String()
+		}
+		config, err := configFromSettings(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", mount, err)
+		}
+		config.lane = mount
+		lanes = append(lanes, lane{name: mount, rm: NewResourceMock(config)})
+	}
+	return lanes, nil
+}
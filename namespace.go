@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// namespaceChildEnv marks the copy of this program running inside the namespaces of -netns and -unshare
+const namespaceChildEnv = "OUTAGEMOCK_NAMESPACE_CHILD"
+
+// parseUnshare parses a list like "mount,net" into clone flags
+func parseUnshare(s string) (uintptr, error) {
+	var flags uintptr
+	if s == "" {
+		return 0, nil
+	}
+	if len(unshareNames) == 0 {
+		return 0, fmt.Errorf("-unshare is only supported on Linux")
+	}
+	for _, name := range strings.Split(s, ",") {
+		flag, ok := unshareNames[strings.TrimSpace(name)]
+		if !ok {
+			names := make([]string, 0, len(unshareNames))
+			for name := range unshareNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return 0, fmt.Errorf("unknown namespace %q in -unshare (supported: %s)", name, strings.Join(names, ", "))
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// netnsPath resolves -netns: a name created with "ip netns add", pid:<pid> for
+// the network namespace of a process such as a container, or a path
+func netnsPath(s string) string {
+	if pid, ok := strings.CutPrefix(s, "pid:"); ok {
+		return filepath.Join("/proc", pid, "ns", "net")
+	}
+	if strings.Contains(s, "/") {
+		return s
+	}
+	return filepath.Join("/var/run/netns", s)
+}
+
+// inNamespaceChild reports whether this process is the copy started by runInNamespaces
+func inNamespaceChild() bool {
+	return os.Getenv(namespaceChildEnv) != ""
+}
+
+// hostPID returns this process's pid as seen from the host, which differs
+// inside a PID namespace as long as /proc is the host's
+func hostPID() int {
+	if link, err := os.Readlink("/proc/self"); err == nil {
+		if pid, err := strconv.Atoi(link); err == nil {
+			return pid
+		}
+	}
+	return os.Getpid()
+}
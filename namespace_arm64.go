@@ -0,0 +1,7 @@
+package main
+
+// sysSetns is the setns(2) syscall number, which package syscall lacks
+const sysSetns = 268
+
+// sysFadvise64 is the fadvise64(2) syscall number, which package syscall only has on Linux
+const sysFadvise64 = 223
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// unshareNames are the namespaces -unshare can create
+var unshareNames = map[string]uintptr{
+	"mount": syscall.CLONE_NEWNS,
+	"net":   syscall.CLONE_NEWNET,
+	"pid":   syscall.CLONE_NEWPID,
+	"ipc":   syscall.CLONE_NEWIPC,
+	"uts":   syscall.CLONE_NEWUTS,
+}
+
+// runInNamespaces runs this command again as a child inside the namespaces of
+// -netns and -unshare, so network and file load stay inside them while this
+// process, the controller, stays in the host namespaces. Signals are forwarded
+// and the child is killed if the controller dies.
+func runInNamespaces(config Config) int {
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), namespaceChildEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// The child becomes init of a new PID namespace, everything it starts dies with it
+		Cloneflags:   config.unshare & syscall.CLONE_NEWPID,
+		Unshareflags: config.unshare &^ syscall.CLONE_NEWPID,
+		Pdeathsig:    syscall.SIGKILL,
+	}
+
+	signals := notifyRunSignals()
+
+	// Go can't move a whole process into a namespace, but a child forked by a
+	// thread inherits that thread's namespaces. The thread is never unlocked so
+	// it exits with the goroutine, after the child, which Pdeathsig is tied to.
+	started := make(chan error, 1)
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if config.Netns != "" {
+			if err := setns(netnsPath(config.Netns), syscall.CLONE_NEWNET); err != nil {
+				started <- fmt.Errorf("enter network namespace %s: %w", config.Netns, err)
+				return
+			}
+		}
+		if err := cmd.Start(); err != nil {
+			started <- err
+			return
+		}
+		started <- nil
+		done <- cmd.Wait()
+	}()
+
+	if err := <-started; err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start in namespaces: %v\n", err)
+		return 1
+	}
+	for {
+		select {
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+		case err := <-done:
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				if code := exitErr.ExitCode(); code > 0 {
+					return code
+				}
+				return 1
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Run in namespaces failed: %v\n", err)
+				return 1
+			}
+			return 0
+		}
+	}
+}
+
+// setns moves the calling thread into the namespace at path
+func setns(path string, nstype uintptr) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), nstype, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeMountsPrivate stops mounts of a new mount namespace, e.g. the FUSE mount,
+// from propagating back to the host through shared mounts
+func makeMountsPrivate() error {
+	return syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+}
+
+// bringUpLoopback sets lo up, which starts out down in a new network namespace
+func bringUpLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|sockCloexec, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq: the interface name followed by the flags
+	var req [40]byte
+	copy(req[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req[0]))); errno != 0 {
+		return errno
+	}
+	flags := *(*uint16)(unsafe.Pointer(&req[syscall.IFNAMSIZ]))
+	*(*uint16)(unsafe.Pointer(&req[syscall.IFNAMSIZ])) = flags | syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !amd64 && !arm64
+
+package main
+
+// sysSetns is unknown on this architecture, the invalid number fails with ENOSYS
+const sysSetns = ^uintptr(0)
+
+// sysFadvise64 is unknown too, 32-bit architectures split the offsets; the
+// page cache is left alone
+const sysFadvise64 = ^uintptr(0)
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// unshareNames is empty, namespaces are Linux only
+var unshareNames = map[string]uintptr{}
+
+// runInNamespaces fails, -netns and -unshare need Linux
+func runInNamespaces(config Config) int {
+	fmt.Fprintf(os.Stderr, "Failed to start in namespaces: %v\n", errNotLinux)
+	return 1
+}
+
+// makeMountsPrivate is never needed without mount namespaces
+func makeMountsPrivate() error {
+	return errNotLinux
+}
+
+// bringUpLoopback is never needed without network namespaces
+func bringUpLoopback() error {
+	return errNotLinux
+}
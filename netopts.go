@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// NetOptions select the IP stack and the exit path of the traffic of the
+// network consumers and proxies
+type NetOptions struct {
+	NetFamily    string `flag:"net-family" group:"network" values:"4,6" usage:"Force network traffic to one stack: 4 for IPv4 or 6 for IPv6 (default both, whichever the target resolves to)"`
+	NetBindIface string `flag:"net-bind-iface" group:"network" usage:"Send outgoing network traffic through this interface only (SO_BINDTODEVICE), e.g. eth1, keeping management interfaces unaffected"`
+	NetSourceIP  string `flag:"net-source-ip" group:"network" usage:"Source address of outgoing network traffic; it must be assigned to this host, and to -net-bind-iface if given"`
+}
+
+// validate checks the network options
+func (o NetOptions) validate() error {
+	if o.NetFamily != "" && o.NetFamily != "4" && o.NetFamily != "6" {
+		return fmt.Errorf("invalid -net-family %q (supported: 4, 6)", o.NetFamily)
+	}
+	if o.NetBindIface != "" {
+		if _, err := net.InterfaceByName(o.NetBindIface); err != nil {
+			return fmt.Errorf("invalid -net-bind-iface: %v", err)
+		}
+	}
+	if o.NetSourceIP != "" {
+		ip := net.ParseIP(o.NetSourceIP)
+		if ip == nil {
+			return fmt.Errorf("invalid -net-source-ip %q (expected an IPv4 or IPv6 address)", o.NetSourceIP)
+		}
+		if err := o.checkHost(o.NetSourceIP); err != nil {
+			return fmt.Errorf("invalid -net-source-ip: %v", err)
+		}
+		if !o.assigned(ip) {
+			where := "any interface of this host"
+			if o.NetBindIface != "" {
+				where = o.NetBindIface
+			}
+			return fmt.Errorf("-net-source-ip %s is not assigned to %s", ip, where)
+		}
+	}
+	return nil
+}
+
+// assigned reports whether ip is an address of -net-bind-iface, or of any
+// interface without it
+func (o NetOptions) assigned(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if o.NetBindIface != "" {
+		var iface *net.Interface
+		if iface, err = net.InterfaceByName(o.NetBindIface); err == nil {
+			addrs, err = iface.Addrs()
+		}
+	}
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// network restricts network, "tcp" or "udp", to -net-family
+func (o NetOptions) network(network string) string {
+	return network + o.NetFamily
+}
+
+// checkHost rejects an IP literal of the other stack, which could never be reached
+func (o NetOptions) checkHost(host string) error {
+	ip := net.ParseIP(host)
+	if ip == nil || o.NetFamily == "" {
+		return nil
+	}
+	if v4 := ip.To4() != nil; v4 != (o.NetFamily == "4") {
+		return fmt.Errorf("%s is not an IPv%s address as -net-family requires", host, o.NetFamily)
+	}
+	return nil
+}
+
+// checkAddr is checkHost for a host:port address
+func (o NetOptions) checkAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	return o.checkHost(host)
+}
+
+// dial connects to addr over network, "tcp" or "udp", on the selected stack,
+// interface and source address. It matches the DialContext of http.Transport.
+func (o NetOptions) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Control: o.bindDevice}
+	if ip := net.ParseIP(o.NetSourceIP); ip != nil {
+		// Targets only resolving to the other stack fail to dial
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	return dialer.DialContext(ctx, o.network(network), addr)
+}
+
+// bindDevice binds a socket to -net-bind-iface before it connects, which
+// needs CAP_NET_RAW on kernels before 5.7
+func (o NetOptions) bindDevice(network, address string, c syscall.RawConn) error {
+	if o.NetBindIface == "" {
+		return nil
+	}
+	var err error
+	if ctrlErr := c.Control(func(fd uintptr) {
+		err = bindToDevice(int(fd), o.NetBindIface)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return fmt.Errorf("bind to %s: %w", o.NetBindIface, err)
+	}
+	return nil
+}
+
+// listen accepts TCP connections on addr on the selected stack
+func (o NetOptions) listen(addr string) (net.Listener, error) {
+	return net.Listen(o.network("tcp"), addr)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of output records
+const (
+	outputText    = "text"    // Lines for people: the startup summary, status table and reports
+	outputMessage = "message" // Progress messages of the run
+	outputLog     = "log"     // Lines written with the log package
+	outputData    = "data"    // Structured records for machines: the config and status samples
+)
+
+// logTimeFormat is the prefix text sinks put in front of log lines, like the log package
+const logTimeFormat = "2006/01/02 15:04:05 "
+
+// OutputRecord is one piece of output of a run
+type OutputRecord struct {
+	Time   time.Time
+	Kind   string
+	Level  string                 // info or error
+	Msg    string                 // One line, or the summary of a data record
+	Fields map[string]interface{} // Data of a data record
+}
+
+// OutputSink is where the output of a run goes. Each sink picks the kinds of
+// records it is meant for.
+type OutputSink interface {
+	Emit(r OutputRecord)
+	Close() error
+}
+
+var (
+	outputMu  sync.Mutex
+	output    OutputSink      = consoleOutput{} // Set with -output by openOutput
+	artifacts *artifactBundle                   // Bundle of the run with -artifacts
+)
+
+// emit sends a record to the output sinks
+func emit(r OutputRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if r.Level == "" {
+		r.Level = "info"
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	output.Emit(r)
+	if artifacts != nil {
+		artifacts.Emit(r)
+	}
+}
+
+// textf writes lines for people, like the status table
+func textf(format string, args ...interface{}) {
+	for _, line := range strings.Split(strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"), "\n") {
+		emit(OutputRecord{Kind: outputText, Msg: line})
+	}
+}
+
+// messagef writes a progress message of the run
+func messagef(format string, args ...interface{}) {
+	emit(OutputRecord{Kind: outputMessage, Msg: strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")})
+}
+
+// emitData writes a structured record
+func emitData(msg string, fields map[string]interface{}) {
+	emit(OutputRecord{Kind: outputData, Msg: msg, Fields: fields})
+}
+
+// outputWriter emits every line written to it as a record of kind
+type outputWriter struct {
+	kind string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		emit(OutputRecord{Kind: w.kind, Msg: line})
+	}
+	return len(p), nil
+}
+
+// textOutput is an io.Writer for reports shared with commands that print to stdout
+var textOutput io.Writer = outputWriter{kind: outputText}
+
+// parseOutput checks -output, a comma separated list of sinks
+func parseOutput(s string) ([]string, error) {
+	var sinks []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		name, arg, _ := strings.Cut(item, ":")
+		switch {
+		case name == "file" && arg == "":
+			return nil, fmt.Errorf("invalid -output %q: file needs a path, e.g. file:/var/log/outagemock.log", item)
+		case name == "syslog" && !syslogSupported:
+			return nil, fmt.Errorf("invalid -output %q: there is no syslog on this platform", item)
+		case name == "console" || name == "null" || name == "file" || name == "json" || name == "syslog":
+			sinks = append(sinks, item)
+		default:
+			return nil, fmt.Errorf("invalid -output %q (supported: console, file:PATH, json[:PATH], syslog[:TAG], null)", item)
+		}
+	}
+	return sinks, nil
+}
+
+// openOutput sends the output of the process to the sinks of -output, and to
+// the bundle of -artifacts, the log package included
+func openOutput(config Config) error {
+	var sinks multiOutput
+	for _, spec := range config.outputs {
+		name, arg, _ := strings.Cut(spec, ":")
+		var sink OutputSink
+		switch name {
+		case "console":
+			sink = consoleOutput{}
+		case "null":
+			sink = nullOutput{}
+		case "file", "json":
+			w := os.Stderr
+			if arg != "" {
+				f, err := os.OpenFile(arg, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+				if err != nil {
+					sinks.Close()
+					return fmt.Errorf("open -output %s: %w", spec, err)
+				}
+				w = f
+			}
+			if name == "file" {
+				sink = fileOutput{w}
+			} else {
+				sink = jsonOutput{w}
+			}
+		case "syslog":
+			if arg == "" {
+				arg = "outagemock"
+			}
+			var err error
+			if sink, err = openSyslogOutput(arg); err != nil {
+				sinks.Close()
+				return fmt.Errorf("open -output %s: %w", spec, err)
+			}
+		}
+		sinks = append(sinks, sink)
+	}
+	var bundle *artifactBundle
+	if config.Artifacts != "" {
+		var err error
+		if bundle, err = openArtifacts(config); err != nil {
+			sinks.Close()
+			return err
+		}
+	}
+
+	outputMu.Lock()
+	output.Close()
+	output = sinks
+	if artifacts != nil {
+		artifacts.Close()
+	}
+	artifacts = bundle
+	outputMu.Unlock()
+	// Sinks add their own timestamps
+	log.SetFlags(0)
+	log.SetOutput(outputWriter{kind: outputLog})
+	return nil
+}
+
+// consoleOutput prints text and messages to stdout and log lines to stderr
+type consoleOutput struct{}
+
+func (consoleOutput) Emit(r OutputRecord) {
+	switch r.Kind {
+	case outputText, outputMessage:
+		fmt.Fprintln(os.Stdout, r.Msg)
+	case outputLog:
+		fmt.Fprintln(os.Stderr, r.Time.Format(logTimeFormat)+r.Msg)
+	}
+}
+
+func (consoleOutput) Close() error { return nil }
+
+// fileOutput writes text, messages and log lines to a file, without terminal
+// control sequences
+type fileOutput struct {
+	f *os.File
+}
+
+func (s fileOutput) Emit(r OutputRecord) {
+	switch r.Kind {
+	case outputText, outputMessage:
+		fmt.Fprintln(s.f, strings.ReplaceAll(r.Msg, "\033[2J\033[H", ""))
+	case outputLog:
+		fmt.Fprintln(s.f, r.Time.Format(logTimeFormat)+r.Msg)
+	}
+}
+
+func (s fileOutput) Close() error {
+	if s.f == os.Stderr {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// jsonOutput writes messages, log lines and data as one JSON object per line,
+// the log format of container platforms
+type jsonOutput struct {
+	f *os.File
+}
+
+func (s jsonOutput) Emit(r OutputRecord) {
+	if r.Kind == outputText {
+		return
+	}
+	entry := map[string]interface{}{
+		"time":  r.Time.UTC().Format(time.RFC3339Nano),
+		"level": r.Level,
+		"msg":   r.Msg,
+	}
+	for k, v := range r.Fields {
+		entry[k] = v
+	}
+	data, _ := json.Marshal(entry)
+	s.f.Write(append(data, '\n'))
+}
+
+func (s jsonOutput) Close() error {
+	if s.f == os.Stderr {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// nullOutput discards everything
+type nullOutput struct{}
+
+func (nullOutput) Emit(OutputRecord) {}
+func (nullOutput) Close() error      { return nil }
+
+// multiOutput fans records out to several sinks
+type multiOutput []OutputSink
+
+func (m multiOutput) Emit(r OutputRecord) {
+	for _, sink := range m {
+		sink.Emit(r)
+	}
+}
+
+func (m multiOutput) Close() error {
+	var first error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
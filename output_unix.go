@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import "log/syslog"
+
+// syslogSupported tells parseOutput that the syslog sink of -output is available
+const syslogSupported = true
+
+// openSyslogOutput connects to the local syslog daemon, tagging entries with tag
+func openSyslogOutput(tag string) (OutputSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return syslogOutput{w}, nil
+}
+
+// syslogOutput sends messages and log lines to the local syslog daemon
+type syslogOutput struct {
+	w *syslog.Writer
+}
+
+func (s syslogOutput) Emit(r OutputRecord) {
+	if r.Kind != outputMessage && r.Kind != outputLog {
+		return
+	}
+	if r.Level == "error" {
+		s.w.Err(r.Msg)
+	} else {
+		s.w.Info(r.Msg)
+	}
+}
+
+func (s syslogOutput) Close() error { return s.w.Close() }
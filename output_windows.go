@@ -0,0 +1,11 @@
+package main
+
+import "errors"
+
+// syslogSupported is false, Windows has no syslog for the syslog sink of -output
+const syslogSupported = false
+
+// openSyslogOutput fails, parseOutput already rejects the syslog sink
+func openSyslogOutput(tag string) (OutputSink, error) {
+	return nil, errors.New("there is no syslog on Windows")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pressure is the pressure stall information (PSI) of the host: the share of
+// the last 10 seconds in percent in which some or all non-idle tasks stalled
+// waiting for a resource
+type Pressure struct {
+	CPUSome    float64 `json:"cpu_some_avg10"`
+	MemorySome float64 `json:"memory_some_avg10"`
+	MemoryFull float64 `json:"memory_full_avg10"`
+	IOSome     float64 `json:"io_some_avg10"`
+	IOFull     float64 `json:"io_full_avg10"`
+}
+
+// pressureLine is the some or full line of a PSI file
+type pressureLine struct {
+	avg10 float64       // Percentage of the last 10 seconds tasks stalled
+	total time.Duration // Time tasks stalled since boot
+}
+
+// readPressureFile returns the some and full lines of a PSI file, like
+// /proc/pressure/memory or memory.pressure of a cgroup
+func readPressureFile(path string) (some, full pressureLine, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return some, full, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		var parsed pressureLine
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				parsed.avg10, err = strconv.ParseFloat(value, 64)
+			case "total":
+				var us int64
+				us, err = strconv.ParseInt(value, 10, 64)
+				parsed.total = time.Duration(us) * time.Microsecond
+			}
+			if err != nil {
+				return some, full, fmt.Errorf("parse %s: %w", path, err)
+			}
+		}
+		switch fields[0] {
+		case "some":
+			some = parsed
+		case "full":
+			full = parsed
+		}
+	}
+	return some, full, nil
+}
+
+// readPressure samples /proc/pressure, which kernels without PSI lack
+func readPressure() (*Pressure, error) {
+	cpu, _, err := readPressureFile("/proc/pressure/cpu")
+	if err != nil {
+		return nil, err
+	}
+	memorySome, memoryFull, err := readPressureFile("/proc/pressure/memory")
+	if err != nil {
+		return nil, err
+	}
+	ioSome, ioFull, err := readPressureFile("/proc/pressure/io")
+	if err != nil {
+		return nil, err
+	}
+	return &Pressure{
+		CPUSome:    cpu.avg10,
+		MemorySome: memorySome.avg10,
+		MemoryFull: memoryFull.avg10,
+		IOSome:     ioSome.avg10,
+		IOFull:     ioFull.avg10,
+	}, nil
+}
+
+// setupMemoryHigh moves this process into a cgroup whose memory.high is the
+// memory it holds now plus -memory-high of the memory target. Beyond it the
+// kernel throttles the allocating memory workers and reclaims their pages,
+// so the run stalls on memory instead of reaching the target.
+func (rm *ResourceMock) setupMemoryHigh() error {
+	cg, err := createCgroup(rm.config.CgroupRoot, fmt.Sprintf("outagemock-%d", os.Getpid()), "memory")
+	if err != nil {
+		return err
+	}
+	baseMB := int64(selfRSSMB())
+	highMB := baseMB + int64(float64(rm.config.MemoryMB)*rm.config.memoryHigh)
+	if err := cg.set("memory.high", strconv.FormatInt(highMB<<20, 10)); err != nil {
+		cg.remove()
+		return err
+	}
+	if err := cg.addSelf(); err != nil {
+		cg.remove()
+		return err
+	}
+	log.Printf("Holding memory in cgroup %s with memory.high %d MB (%d MB of this process and %.0f%% of the target)", cg.path, highMB, baseMB, rm.config.memoryHigh*100)
+	rm.cgroup = cg
+	rm.statusMu.Lock()
+	rm.resourceStatus.MemoryHighMB = highMB
+	rm.statusMu.Unlock()
+	return nil
+}
+
+// memoryHighEvents returns how often the memory of the cgroup went over memory.high
+func (cg *cgroup) memoryHighEvents() int64 {
+	data, err := os.ReadFile(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "high "); ok {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
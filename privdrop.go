@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// runAs is the user and group the load generation runs as with -user
+type runAs struct {
+	uid int
+	gid int
+}
+
+// parseRunAs parses -user: a user name or uid, optionally followed by :group.
+// The group defaults to the user's primary group.
+func parseRunAs(s string) (runAs, error) {
+	name, group, hasGroup := strings.Cut(s, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return runAs{}, fmt.Errorf("invalid -user %q: %v", s, err)
+		}
+	}
+	as := runAs{}
+	as.uid, _ = strconv.Atoi(u.Uid)
+	as.gid, _ = strconv.Atoi(u.Gid)
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return runAs{}, fmt.Errorf("invalid -user %q: %v", s, err)
+			}
+		}
+		as.gid, _ = strconv.Atoi(g.Gid)
+	}
+	if as.uid == 0 {
+		return runAs{}, fmt.Errorf("invalid -user %q: it must not be root", s)
+	}
+	return as, nil
+}
+
+// checkRunAs rejects -user where it can't work
+func (c *Config) checkRunAs() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("-user requires starting as root")
+	}
+	// Both act as root for the whole run, not just during setup
+	if c.FreezeCgroup != "" || c.FuseMount != "" || c.TargetContainer != "" || c.DMDevice != "" {
+		return fmt.Errorf("-user can't be combined with -freeze-cgroup, -fuse-mount, -target-container or -dm-device, which need root until the end")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+)
+
+// dropPrivileges switches the whole process to -user once the privileged setup
+// is done. The drop is permanent unless teardown needs root, which is the case
+// for the cgroup of the run and the cpufreq governors replaced by -governor:
+// then root stays in the saved set-user-ID and is taken back by
+// regainPrivileges for cleanup. Children started afterwards,
+// such as -run-cmd, run as -user too.
+func (rm *ResourceMock) dropPrivileges() error {
+	as := rm.config.runAs
+	saved := as.uid
+	var teardown []string
+	if rm.cgroup != nil {
+		teardown = append(teardown, "remove cgroup "+rm.cgroup.path)
+	}
+	if rm.governors != nil {
+		teardown = append(teardown, "restore the cpufreq governors")
+	}
+	if len(teardown) > 0 {
+		saved = 0
+	}
+	// Groups go first, changing them needs root
+	if err := syscall.Setgroups([]int{as.gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setresgid(as.gid, as.gid, as.gid); err != nil {
+		return fmt.Errorf("setresgid: %w", err)
+	}
+	if err := syscall.Setresuid(as.uid, as.uid, saved); err != nil {
+		return fmt.Errorf("setresuid: %w", err)
+	}
+	rm.privilegesKept = saved == 0
+	if rm.privilegesKept {
+		log.Printf("Running as uid %d gid %d, keeping root only to %s at the end", as.uid, as.gid, strings.Join(teardown, " and "))
+	} else {
+		log.Printf("Dropped privileges to uid %d gid %d", as.uid, as.gid)
+	}
+	return nil
+}
+
+// regainPrivileges takes back root kept by dropPrivileges for cleanup
+func (rm *ResourceMock) regainPrivileges() {
+	if !rm.privilegesKept {
+		return
+	}
+	if err := syscall.Setresuid(0, 0, 0); err != nil {
+		log.Printf("Failed to regain root for cleanup: %v", err)
+		return
+	}
+	if err := syscall.Setresgid(0, 0, 0); err != nil {
+		log.Printf("Failed to regain the root group for cleanup: %v", err)
+	}
+	rm.privilegesKept = false
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// dropPrivileges fails, switching the whole process to -user needs Linux
+func (rm *ResourceMock) dropPrivileges() error {
+	return fmt.Errorf("-user is %w", errNotLinux)
+}
+
+// regainPrivileges has nothing to take back
+func (rm *ResourceMock) regainPrivileges() {}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HostLimits are the host health thresholds given with -protect-host
+type HostLimits struct {
+	CPUPercent float64 // Host CPU usage above which consumption backs off (0 disables)
+	MemFreeMB  int64   // Available memory below which consumption backs off (0 disables)
+	LoadFactor float64 // 1-minute load average per core above which consumption backs off (0 disables)
+}
+
+const (
+	protectInterval = 2 * time.Second
+	protectRecovery = 0.1 // Throttle factor regained per healthy sample
+)
+
+// parseHostLimits parses a list like "cpu=95,mem-free=1GB,load=2x"
+func parseHostLimits(s string) (HostLimits, error) {
+	var limits HostLimits
+	if s == "" {
+		return limits, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return limits, fmt.Errorf("invalid host limit %q (expected key=value)", item)
+		}
+		var err error
+		switch key {
+		case "cpu":
+			limits.CPUPercent, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		case "mem-free":
+			limits.MemFreeMB, err = parseFileSize(value)
+		case "load":
+			limits.LoadFactor, err = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		default:
+			return limits, fmt.Errorf("unknown host limit %q (supported: cpu, mem-free, load)", key)
+		}
+		if err != nil {
+			return limits, fmt.Errorf("invalid host limit %q: %v", item, err)
+		}
+	}
+	return limits, nil
+}
+
+// atomicFloat is a float64 that can be read and written concurrently
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func (f *atomicFloat) Store(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+// readLoadAverage returns the 1-minute load average of the host
+func readLoadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// hostViolation samples the host and describes the first exceeded limit, or returns ""
+func hostViolation(limits HostLimits, prevCPU *cpuTimes) string {
+	if limits.CPUPercent > 0 {
+		if cur, err := readCPUTimes(); err == nil {
+			busy := busyPercent(*prevCPU, cur)
+			*prevCPU = cur
+			if busy > limits.CPUPercent {
+				return fmt.Sprintf("host CPU %.1f%% > %.1f%%", busy, limits.CPUPercent)
+			}
+		}
+	}
+	if limits.MemFreeMB > 0 {
+		if info, err := readMemInfo(); err == nil {
+			if free := info["MemAvailable"] / 1024; free < limits.MemFreeMB {
+				return fmt.Sprintf("available memory %d MB < %d MB", free, limits.MemFreeMB)
+			}
+		}
+	}
+	if limits.LoadFactor > 0 {
+		if load, err := readLoadAverage(); err == nil {
+			if max := limits.LoadFactor * float64(runtime.NumCPU()); load > max {
+				return fmt.Sprintf("load average %.2f > %.2f", load, max)
+			}
+		}
+	}
+	return ""
+}
+
+// protectHost samples host health and scales all targets down while the host
+// crosses a limit, halving the throttle factor on every unhealthy sample and
+// recovering gradually once the host is healthy again
+func (rm *ResourceMock) protectHost() {
+	defer rm.wg.Done()
+
+	limits := rm.config.hostLimits
+	prevCPU, _ := readCPUTimes()
+	ticker := time.NewTicker(protectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+			factor := rm.throttle.Load()
+			if violation := hostViolation(limits, &prevCPU); violation != "" {
+				factor /= 2
+				if factor < 0.05 {
+					factor = 0
+				}
+				log.Printf("Host unhealthy (%s), throttling consumption to %.0f%%", violation, factor*100)
+			} else if factor < 1 {
+				factor = math.Min(1, factor+protectRecovery)
+				if factor == 1 {
+					log.Printf("Host healthy, consumption resumed")
+				}
+			}
+			rm.throttle.Store(factor)
+		}
+	}
+}
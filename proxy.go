@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyConfig holds the fault settings of the TCP proxy
+type ProxyConfig struct {
+	Listen    string
+	Upstream  string
+	Latency   time.Duration // Delay added to every forwarded chunk
+	Jitter    time.Duration // Random +/- variation of the delay
+	Drop      float64       // Fraction of new connections reset immediately (0-1)
+	Bandwidth int64         // Bytes per second per direction and connection (0 = unlimited)
+	Duration  time.Duration // Time to serve before exiting (0 = until interrupted)
+	Net       NetOptions    // Stack of the listener and the upstream connections
+}
+
+// proxyChunkSize is the largest chunk read from a connection before it is delayed and forwarded
+const proxyChunkSize = 32 * 1024
+
+// delayedChunk is data read from one side waiting to be written to the other
+type delayedChunk struct {
+	data []byte
+	due  time.Time
+}
+
+// parsePercent parses "1%", "1" or "0.5%" into a fraction between 0 and 1
+func parsePercent(s string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	if value < 0 || value > 100 {
+		return 0, fmt.Errorf("percentage %q must be between 0 and 100", s)
+	}
+	return value / 100, nil
+}
+
+// proxyCommand runs a TCP proxy adding latency, jitter, bandwidth caps and resets
+func proxyCommand(cmd *command, args []string) int {
+	var config ProxyConfig
+	var drop, bandwidth string
+	fs := cmd.flagSet()
+	fs.StringVar(&config.Listen, "listen", "", "Address to accept connections on, e.g. :5433 (required)")
+	fs.StringVar(&config.Upstream, "upstream", "", "Address to forward connections to, e.g. db:5432 (required)")
+	fs.DurationVar(&config.Latency, "latency", 0, "Delay added to data in each direction")
+	fs.DurationVar(&config.Jitter, "jitter", 0, "Random variation added to or removed from the latency")
+	fs.StringVar(&drop, "drop", "0", "Percentage of connections reset right after accept, e.g. 1%")
+	fs.StringVar(&bandwidth, "bandwidth", "0", "Bandwidth cap per direction and connection in bytes/s with unit, e.g. 512K")
+	fs.DurationVar(&config.Duration, "duration", 0, "Time to serve before exiting (0 = until interrupted)")
+	bindFlags(fs, &config.Net)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if config.Listen == "" || config.Upstream == "" {
+		return exitCodeFor(fmt.Errorf("-listen and -upstream are required"))
+	}
+	if err := config.Net.validate(); err != nil {
+		return exitCodeFor(err)
+	}
+	if err := config.Net.checkAddr(config.Upstream); err != nil {
+		return exitCodeFor(fmt.Errorf("invalid -upstream: %v", err))
+	}
+	var err error
+	if config.Drop, err = parsePercent(drop); err != nil {
+		return exitCodeFor(err)
+	}
+	if config.Bandwidth, err = parseByteSize(bandwidth); err != nil {
+		return exitCodeFor(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+	go func() {
+		select {
+		case sig := <-notifySignals():
+			fmt.Printf("Received signal %v, stopping proxy...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := runProxy(ctx, config); err != nil {
+		fmt.Fprintf(os.Stderr, "Proxy failed: %v\n", err)
+		return 1
+	}
+	fmt.Println("Proxy stopped")
+	return 0
+}
+
+// runProxy accepts connections until ctx is done
+func runProxy(ctx context.Context, config ProxyConfig) error {
+	listener, err := config.Net.listen(config.Listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Proxying %s -> %s (latency %v, jitter %v, drop %.1f%%, bandwidth %d B/s)\n",
+		listener.Addr(), config.Upstream, config.Latency, config.Jitter, config.Drop*100, config.Bandwidth)
+
+	var wg sync.WaitGroup
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleProxyConn(ctx, conn, config)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// handleProxyConn forwards one client connection to the upstream
+func handleProxyConn(ctx context.Context, client net.Conn, config ProxyConfig) {
+	if rand.Float64() < config.Drop {
+		resetConn(client)
+		return
+	}
+
+	upstream, err := config.Net.dial(ctx, "tcp", config.Upstream)
+	if err != nil {
+		log.Printf("Proxy failed to dial upstream %s: %v", config.Upstream, err)
+		resetConn(client)
+		return
+	}
+
+	// Closing both sides unblocks the pipes when the run ends
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		client.Close()
+		upstream.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		proxyPipe(upstream, client, config)
+	}()
+	go func() {
+		defer wg.Done()
+		proxyPipe(client, upstream, config)
+	}()
+	wg.Wait()
+	close(done)
+}
+
+// resetConn closes a connection with an RST instead of a FIN
+func resetConn(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// proxyPipe copies src to dst, delaying every chunk and limiting bandwidth.
+// Chunks keep their order because each due time is at least the previous one.
+func proxyPipe(dst, src net.Conn, config ProxyConfig) {
+	chunks := make(chan delayedChunk, 64)
+
+	go func() {
+		defer close(chunks)
+		var lastDue time.Time
+		for {
+			buf := make([]byte, proxyChunkSize)
+			n, err := src.Read(buf)
+			if n > 0 {
+				due := time.Now().Add(proxyDelay(config.Latency, config.Jitter))
+				if due.Before(lastDue) {
+					due = lastDue
+				}
+				lastDue = due
+				chunks <- delayedChunk{data: buf[:n], due: due}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for chunk := range chunks {
+		time.Sleep(time.Until(chunk.due))
+		if config.Bandwidth > 0 {
+			time.Sleep(time.Duration(float64(len(chunk.data)) / float64(config.Bandwidth) * float64(time.Second)))
+		}
+		if _, err := dst.Write(chunk.data); err != nil {
+			// Unblock the reader and the opposite direction
+			src.Close()
+			dst.Close()
+			for range chunks {
+			}
+			return
+		}
+	}
+
+	// Propagate the half-close so request/response protocols finish cleanly
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+}
+
+// proxyDelay returns the latency with random jitter applied, never negative
+func proxyDelay(latency, jitter time.Duration) time.Duration {
+	delay := latency
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	psiPeriod   = 100 * time.Millisecond // Duty cycle of the -psi stall workers, shorter than the PSI averaging
+	psiInterval = time.Second            // How often the duty is corrected to the measured stall time
+	psiGain     = 0.5                    // Share of the gap between target and measured stall time corrected per interval
+)
+
+// psiResources are the resources -psi can hold the pressure of
+var psiResources = []string{"cpu", "io"}
+
+// PSIStatus is the progress of one -psi target
+type PSIStatus struct {
+	Target   float64 `json:"target"`   // Percentage of time some tasks should stall now, during rampup a share of the target
+	Measured float64 `json:"measured"` // Percentage of the last second some tasks stalled
+	Duty     float64 `json:"duty"`     // Share of each period the stall workers run
+}
+
+// parsePSITargets parses -psi, a list like cpu=30,io=20 of some percentages.
+// Repeated resources take the last value, so config, environment and flags
+// can add to each other.
+func parsePSITargets(s string) (map[string]float64, error) {
+	targets := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -psi %q (expected e.g. cpu=30)", item)
+		}
+		switch key {
+		case "cpu", "io":
+		default:
+			return nil, fmt.Errorf("unknown -psi resource %q (supported: %s; memory pressure builds up with -memory-high)", key, strings.Join(psiResources, ", "))
+		}
+		target, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || target <= 0 || target > 100 {
+			return nil, fmt.Errorf("invalid -psi %q: the target must be a percentage above 0 and at most 100", item)
+		}
+		targets[key] = target
+	}
+	return targets, nil
+}
+
+// psiDuty is the share of each psiPeriod the stall workers of a resource run
+type psiDuty struct {
+	value atomicFloat
+}
+
+// wait sleeps until the duty window of the current period opens, or the
+// next one when the window is over, and reports false once the worker is
+// stopped. All workers share the windows, so they stall each other at the
+// same time.
+func (d *psiDuty) wait(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	if d.open() {
+		return true
+	}
+	now := time.Now()
+	select {
+	case <-stop:
+		return false
+	case <-time.After(now.Truncate(psiPeriod).Add(psiPeriod).Sub(now)):
+		return true
+	}
+}
+
+// open reports whether the duty window of the current period is still open
+func (d *psiDuty) open() bool {
+	now := time.Now()
+	return now.Sub(now.Truncate(psiPeriod)) < time.Duration(d.value.Load()*float64(psiPeriod))
+}
+
+// cpuStaller spins during the duty windows. More spinning threads than cores
+// leave some of them waiting in the run queues, the CPU stall of PSI.
+func cpuStaller(duty *psiDuty) func(id int, stop <-chan struct{}) {
+	return func(id int, stop <-chan struct{}) {
+		defer nameThread("om-psi-cpu%d", id)()
+		spin := cpuKernels[cpuWorkloadInt]()
+		for count := 0; duty.wait(stop); {
+			for i := 0; i < 64 && duty.open(); i++ {
+				count = spin(count, 1<<14)
+			}
+		}
+	}
+}
+
+// ioStaller writes synchronously past the page cache during the duty
+// windows, waiting for the disk in uninterruptible sleep, the IO stall of PSI.
+// Filesystems without direct IO, like tmpfs, can't stall and degrade -psi.
+func (rm *ResourceMock) ioStaller(path string, duty *psiDuty) func(id int, stop <-chan struct{}) {
+	return func(id int, stop <-chan struct{}) {
+		defer nameThread("om-psi-io%d", id)()
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|oDirect|oDsync, 0600)
+		if err != nil {
+			rm.markDegraded("psi", fmt.Errorf("io stall worker %d: %w (direct IO is needed, tmpfs has none)", id, err))
+			return
+		}
+		defer file.Close()
+		buf, err := mmap(nil, 0, loadIOBytes, 0)
+		if err != nil {
+			rm.markDegraded("psi", fmt.Errorf("io stall worker %d: %w", id, err))
+			return
+		}
+		defer munmap(buf)
+		for duty.wait(stop) {
+			if _, err := file.WriteAt(buf, int64(id)*loadIOBytes); err != nil {
+				rm.markDegraded("psi", fmt.Errorf("io stall worker %d: %w", id, err))
+				return
+			}
+		}
+	}
+}
+
+// psiPath returns the file the -psi io workers write to, next to the work file
+func psiPath(filePath string) string {
+	return strings.TrimSuffix(filePath, fileSuffix) + "_psi" + fileSuffix
+}
+
+// holdPressure holds the pressure stall information of the host at the -psi
+// targets. Stall workers run in a duty cycle whose duty is corrected every
+// second by the gap between the target and the stall time PSI measured,
+// stalls of the rest of the host included. Targets grow during rampup and
+// shrink with host protection and rampdown like other loads.
+func (rm *ResourceMock) holdPressure() {
+	defer rm.wg.Done()
+
+	targets := rm.config.psiTargets
+	resources := make([]string, 0, len(targets))
+	for resource := range targets {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	path := psiPath(rm.filePath)
+	workers := make(map[string]*loadWorkers)
+	duties := make(map[string]*psiDuty)
+	totals := make(map[string]time.Duration)
+	raised := 0
+	defer func() {
+		for _, w := range workers {
+			w.resize(0, nil)
+			w.wg.Wait()
+		}
+		adjustProcs(-raised, "-psi cpu")
+		os.Remove(path)
+	}()
+	for _, resource := range resources {
+		some, _, err := readPressureFile("/proc/pressure/" + resource)
+		if err != nil {
+			rm.markDegraded("psi", fmt.Errorf("read pressure of %s: %w", resource, err))
+			return
+		}
+		totals[resource] = some.total
+		duties[resource] = &psiDuty{}
+		workers[resource] = &loadWorkers{}
+		switch resource {
+		case "cpu":
+			// Twice as many threads as cores leave a waiting task in every run queue during a window
+			n := runtime.NumCPU() * 2
+			adjustProcs(n, "-psi cpu")
+			raised = n
+			workers[resource].resize(n, cpuStaller(duties[resource]))
+		case "io":
+			workers[resource].resize(2, rm.ioStaller(path, duties[resource]))
+		}
+	}
+	log.Printf("Holding pressure stalls at %s", rm.config.PSI)
+
+	ticker := time.NewTicker(psiInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			intensity := rm.rampIntensity("psi")
+			statuses := make(map[string]PSIStatus, len(targets))
+			for _, resource := range resources {
+				some, _, err := readPressureFile("/proc/pressure/" + resource)
+				if err != nil {
+					continue
+				}
+				measured := 100 * float64(some.total-totals[resource]) / float64(now.Sub(last))
+				totals[resource] = some.total
+				target := targets[resource] * intensity
+				duty := duties[resource].value.Load()
+				if target == 0 {
+					duty = 0
+				} else {
+					duty = math.Min(1, math.Max(0, duty+psiGain*(target-measured)/100))
+				}
+				if resource == "cpu" {
+					// The stall workers oversubscribe every core, so the duty is the share of the host they use
+					duty = math.Min(duty, rm.config.capCPU(100)/100)
+				}
+				duties[resource].value.Store(duty)
+				statuses[resource] = PSIStatus{Target: target, Measured: measured, Duty: duty}
+			}
+			last = now
+
+			rm.statusMu.Lock()
+			rm.resourceStatus.PSI = statuses
+			rm.statusMu.Unlock()
+		}
+	}
+}
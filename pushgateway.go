@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// pushTimeout bounds the push of the summary so a dead gateway can't hold up the exit
+const pushTimeout = 10 * time.Second
+
+// runPeaks are the highest values the status reached during the run, guarded by statusMu
+type runPeaks struct {
+	cpuPercent  float64
+	measuredCPU float64
+	memoryMB    int64
+	fileMB      int64
+	pressure    *Pressure // Highest avg10 of each PSI line, nil without PSI
+}
+
+// observe raises the peaks to the values of status
+func (p *runPeaks) observe(status ResourceStatus) {
+	p.cpuPercent = max(p.cpuPercent, status.CPUPercent)
+	p.measuredCPU = max(p.measuredCPU, status.MeasuredCPU)
+	p.memoryMB = max(p.memoryMB, status.MemoryActualMB)
+	p.fileMB = max(p.fileMB, status.FileActualMB)
+	if s := status.Pressure; s != nil {
+		if p.pressure == nil {
+			p.pressure = &Pressure{}
+		}
+		p.pressure.CPUSome = max(p.pressure.CPUSome, s.CPUSome)
+		p.pressure.MemorySome = max(p.pressure.MemorySome, s.MemorySome)
+		p.pressure.MemoryFull = max(p.pressure.MemoryFull, s.MemoryFull)
+		p.pressure.IOSome = max(p.pressure.IOSome, s.IOSome)
+		p.pressure.IOFull = max(p.pressure.IOFull, s.IOFull)
+	}
+}
+
+// observeFinal raises the peaks to the final status. updateDisplay only
+// samples them every 2 seconds, which a short run may never reach.
+func (rm *ResourceMock) observeFinal() {
+	rm.statusMu.Lock()
+	defer rm.statusMu.Unlock()
+	rm.resourceStatus.CPUPercent = rm.getCurrentCPUUsage()
+	rm.peaks.observe(rm.resourceStatus)
+}
+
+// summaryMetrics renders the summary of the finished run in the Prometheus text format
+func (rm *ResourceMock) summaryMetrics(code int) []byte {
+	rm.statusMu.Lock()
+	peaks := rm.peaks
+	rm.statusMu.Unlock()
+	status := rm.Status()
+
+	var buf bytes.Buffer
+	metric := func(name, help string, value float64) {
+		fmt.Fprintf(&buf, "# HELP outagemock_%s %s\n# TYPE outagemock_%s gauge\noutagemock_%s %g\n", name, help, name, name, value)
+	}
+	achieved := func(actual, target int64) float64 {
+		if target == 0 {
+			return 0
+		}
+		return float64(actual) / float64(target)
+	}
+
+	metric("duration_seconds", "Time from the start of the rampup to the end of the run.", rm.clock.Since(rm.rampupStart).Seconds())
+	metric("exit_code", "Exit code of the run, 0 when it completed.", float64(code))
+	metric("last_run_timestamp_seconds", "Unix time the run ended.", float64(time.Now().Unix()))
+	metric("cpu_target_percent", "Configured CPU target.", rm.config.CPUPercent)
+	metric("cpu_peak_percent", "Highest CPU target applied during the run.", peaks.cpuPercent)
+	if rm.config.SelfOverhead {
+		metric("cpu_measured_peak_percent", "Highest CPU usage of the process measured with -self-overhead.", peaks.measuredCPU)
+	}
+	metric("memory_target_mb", "Configured memory target.", float64(rm.config.MemoryMB))
+	metric("memory_peak_mb", "Highest memory allocated during the run.", float64(peaks.memoryMB))
+	metric("memory_achieved_ratio", "Peak memory as a fraction of the target.", achieved(peaks.memoryMB, rm.config.MemoryMB))
+	metric("file_target_mb", "Configured file size target.", float64(rm.config.FileSizeMB))
+	metric("file_peak_mb", "Largest size the file reached during the run.", float64(peaks.fileMB))
+	metric("file_achieved_ratio", "Peak file size as a fraction of the target.", achieved(peaks.fileMB, rm.config.FileSizeMB))
+	if p := peaks.pressure; p != nil {
+		metric("memory_pressure_some_peak", "Highest share of 10 seconds some tasks stalled on memory, in percent.", p.MemorySome)
+		metric("memory_pressure_full_peak", "Highest share of 10 seconds all tasks stalled on memory, in percent.", p.MemoryFull)
+	}
+	if status.Canary != nil {
+		metric("canary_p99_ms", "99th percentile latency of the -canary task.", status.Canary.P99Ms)
+	}
+	return buf.Bytes()
+}
+
+// pushSummary replaces this host's group on the -pushgateway with the summary of the run
+func (rm *ResourceMock) pushSummary(code int) {
+	if rm.config.Pushgateway == "" {
+		return
+	}
+	host, _ := os.Hostname()
+	target := strings.TrimSuffix(rm.config.Pushgateway, "/") + "/metrics/job/outagemock/instance/" + url.PathEscape(host)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(rm.summaryMetrics(code)))
+	if err == nil {
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("%s: %s", target, resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to push summary to Pushgateway: %v", err)
+	}
+}
@@ -0,0 +1,1263 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// A minimal HTTP/3 client over QUIC version 1 (RFC 9000, 9001 and 9114) for
+// the h3:// targets of -conn-target. It does what a client idling after a GET
+// needs on top of the QUIC support of crypto/tls: the handshake with Retry,
+// acknowledgements, retransmission on probe timeouts and flow control credit.
+// It follows key updates of the server but doesn't migrate, send 0-RTT or use
+// the QPACK dynamic table, and only protects packets with AES-GCM.
+
+const (
+	quicVersion1     = 1
+	quicMinDatagram  = 1200                  // Datagrams carrying Initial packets are padded to this size
+	quicMaxDatagram  = 1452                  // The largest datagram accepted, advertised as max_udp_payload_size
+	quicMaxPayload   = 1100                  // Frames packed into one packet, leaving room for headers and an ACK
+	quicMaxCrypto    = 1000                  // Handshake data per CRYPTO frame
+	quicStreamWindow = 1 << 20               // Flow control credit of each stream, renewed as data arrives
+	quicConnWindow   = 4 << 20               // Flow control credit of the connection
+	quicMaxAckDelay  = 25 * time.Millisecond // Assumed delay of the server's acknowledgements
+)
+
+// Packet number spaces, RFC 9000 section 12.3
+const (
+	quicInitial = iota
+	quicHandshake
+	quicApp
+)
+
+var (
+	// quicInitialSalt derives the keys of Initial packets from the client's
+	// first destination connection ID, RFC 9001 section 5.2
+	quicInitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+	// Key and nonce of the integrity tag of Retry packets, RFC 9001 section 5.8
+	quicRetryKey   = []byte{0xbe, 0x0c, 0x69, 0x0b, 0x9f, 0x66, 0x57, 0x5a, 0x1d, 0x76, 0x6b, 0x54, 0xe3, 0x68, 0xc8, 0x4e}
+	quicRetryNonce = []byte{0x46, 0x15, 0x99, 0xd3, 0x5d, 0x63, 0x2b, 0xf2, 0x23, 0x98, 0x25, 0xbb}
+
+	errQUICShort = errors.New("truncated QUIC packet")
+	errQUICIdle  = errors.New("idle timeout")
+)
+
+// appendVarint appends v as a variable-length integer, RFC 9000 section 16
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return binary.BigEndian.AppendUint16(b, uint16(v)|0x4000)
+	case v < 1<<30:
+		return binary.BigEndian.AppendUint32(b, uint32(v)|0x80000000)
+	}
+	return binary.BigEndian.AppendUint64(b, v|0xc000000000000000)
+}
+
+// readVarint reads a variable-length integer from the front of b and returns
+// it with its size, which is 0 when b is too short
+func readVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// quicReader reads the fields of packets and frames, remembering a short read
+type quicReader struct {
+	b   []byte
+	err error
+}
+
+func (r *quicReader) fail() {
+	r.b, r.err = nil, errQUICShort
+}
+
+func (r *quicReader) varint() uint64 {
+	v, n := readVarint(r.b)
+	if n == 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *quicReader) u8() byte {
+	if len(r.b) == 0 {
+		r.fail()
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *quicReader) bytes(n uint64) []byte {
+	if uint64(len(r.b)) < n {
+		r.fail()
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+// hkdfExtract is HKDF-Extract of RFC 5869
+func hkdfExtract(h func() hash.Hash, salt, secret []byte) []byte {
+	mac := hmac.New(h, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpandLabel is HKDF-Expand-Label of TLS 1.3 with an empty context, RFC 8446 section 7.1
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	info = append(info, byte(len("tls13 ")+len(label)))
+	info = append(append(info, "tls13 "...), label...)
+	info = append(info, 0)
+	mac := hmac.New(h, secret)
+	var out, block []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// quicKeys protect the packets of one direction in one packet number space
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block // Header protection
+
+	suite  uint16
+	hash   func() hash.Hash
+	secret []byte // Traffic secret, to derive the keys of the next key phase
+}
+
+// newQUICKeys derives the packet protection keys from a traffic secret of suite
+func newQUICKeys(suite uint16, secret []byte) (*quicKeys, error) {
+	var h func() hash.Hash
+	var size int
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		h, size = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, size = sha512.New384, 32
+	default:
+		return nil, fmt.Errorf("the server chose %s, only AES-GCM is supported", tls.CipherSuiteName(suite))
+	}
+	block, err := aes.NewCipher(hkdfExpandLabel(h, secret, "quic key", size))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(h, secret, "quic hp", size))
+	if err != nil {
+		return nil, err
+	}
+	return &quicKeys{aead: aead, iv: hkdfExpandLabel(h, secret, "quic iv", 12), hp: hp, suite: suite, hash: h, secret: secret}, nil
+}
+
+// next derives the keys of the next key phase, which keep the header
+// protection key, RFC 9001 section 6
+func (k *quicKeys) next() (*quicKeys, error) {
+	keys, err := newQUICKeys(k.suite, hkdfExpandLabel(k.hash, k.secret, "quic ku", k.hash().Size()))
+	if err != nil {
+		return nil, err
+	}
+	keys.hp = k.hp
+	return keys, nil
+}
+
+// quicInitialKeys derives the keys of the Initial packets the client sends and receives
+func quicInitialKeys(dcid []byte) (seal, open *quicKeys) {
+	initial := hkdfExtract(sha256.New, quicInitialSalt, dcid)
+	seal, _ = newQUICKeys(tls.TLS_AES_128_GCM_SHA256, hkdfExpandLabel(sha256.New, initial, "client in", 32))
+	open, _ = newQUICKeys(tls.TLS_AES_128_GCM_SHA256, hkdfExpandLabel(sha256.New, initial, "server in", 32))
+	return seal, open
+}
+
+// nonce returns the AEAD nonce of packet number pn
+func (k *quicKeys) nonce(pn uint64) []byte {
+	nonce := slices.Clone(k.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return nonce
+}
+
+// protect applies or removes the header protection of packet, whose packet
+// number of pnLen bytes starts at pnOffset, with the mask of sample
+func (k *quicKeys) protect(packet []byte, pnOffset, pnLen int, mask []byte) {
+	if packet[0]&0x80 != 0 {
+		packet[0] ^= mask[0] & 0x0f
+	} else {
+		packet[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+}
+
+// mask returns the header protection mask of the sample of a packet's payload
+func (k *quicKeys) mask(sample []byte) []byte {
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, sample)
+	return mask
+}
+
+// seal encrypts payload behind header, which ends with a 4 byte packet number,
+// and protects the header
+func (k *quicKeys) seal(header, payload []byte, pn uint64) []byte {
+	packet := make([]byte, len(header), len(header)+len(payload)+k.aead.Overhead())
+	copy(packet, header)
+	packet = k.aead.Seal(packet, k.nonce(pn), payload, header)
+	pnOffset := len(header) - 4
+	k.protect(packet, pnOffset, 4, k.mask(packet[pnOffset+4:pnOffset+4+aes.BlockSize]))
+	return packet
+}
+
+// unprotect removes the header protection of packet, whose packet number
+// starts at pnOffset, and returns the packet number and the size of the
+// header. The packet number is expanded from its truncated form next to
+// largest, the largest one received in its space.
+func (k *quicKeys) unprotect(packet []byte, pnOffset int, largest int64) (uint64, int, error) {
+	if len(packet) < pnOffset+4+aes.BlockSize {
+		return 0, 0, errQUICShort
+	}
+	mask := k.mask(packet[pnOffset+4 : pnOffset+4+aes.BlockSize])
+	pnLen := int((packet[0]^mask[0])&3) + 1
+	k.protect(packet, pnOffset, pnLen, mask)
+	var truncated uint64
+	for _, b := range packet[pnOffset : pnOffset+pnLen] {
+		truncated = truncated<<8 | uint64(b)
+	}
+	return decodePacketNumber(largest, truncated, pnLen*8), pnOffset + pnLen, nil
+}
+
+// decrypt returns the payload of packet number pn behind a header of headerLen bytes
+func (k *quicKeys) decrypt(packet []byte, headerLen int, pn uint64) ([]byte, error) {
+	return k.aead.Open(nil, k.nonce(pn), packet[headerLen:], packet[:headerLen])
+}
+
+// decodePacketNumber expands a truncated packet number of bits bits to the
+// packet number closest to the one after largest, RFC 9000 appendix A.3
+func decodePacketNumber(largest int64, truncated uint64, bits int) uint64 {
+	expected := uint64(largest + 1)
+	window := uint64(1) << bits
+	candidate := expected&^(window-1) | truncated
+	switch {
+	case candidate+window/2 <= expected && candidate < 1<<62-window:
+		return candidate + window
+	case candidate > expected+window/2 && candidate >= window:
+		return candidate - window
+	}
+	return candidate
+}
+
+// quicRange is a range of packet numbers, both ends included
+type quicRange struct{ lo, hi uint64 }
+
+// quicSent is an ack-eliciting packet waiting for its acknowledgement
+type quicSent struct {
+	at     time.Time
+	frames []byte // Frames sent again if the packet is lost
+}
+
+// quicStream reassembles the data received on a stream, or the handshake data
+// of a packet number space, in order
+type quicStream struct {
+	offset  uint64            // Data before it was delivered
+	pending map[uint64][]byte // Data received ahead of offset
+	highest uint64            // End of the data received
+	limit   uint64            // Flow control limit granted to the server
+	fin     bool              // The end of the stream is known
+	size    uint64            // Final size of the stream with fin
+}
+
+// push adds data received at offset and returns what can be delivered now
+func (s *quicStream) push(offset uint64, data []byte) []byte {
+	s.highest = max(s.highest, offset+uint64(len(data)))
+	if offset > s.offset {
+		if s.pending == nil {
+			s.pending = make(map[uint64][]byte)
+		}
+		if len(data) > len(s.pending[offset]) {
+			s.pending[offset] = slices.Clone(data)
+		}
+		return nil
+	}
+	var out []byte
+	deliver := func(offset uint64, data []byte) {
+		if end := offset + uint64(len(data)); end > s.offset {
+			out = append(out, data[s.offset-offset:]...)
+			s.offset = end
+		}
+	}
+	deliver(offset, data)
+	for progressed := true; progressed; {
+		progressed = false
+		for at, data := range s.pending {
+			if at <= s.offset {
+				delete(s.pending, at)
+				deliver(at, data)
+				progressed = true
+			}
+		}
+	}
+	return out
+}
+
+// quicSpace is the state of a packet number space
+type quicSpace struct {
+	seal, open *quicKeys           // Nil before the keys are known and after they are discarded
+	next       uint64              // Packet number of the next packet sent
+	largest    int64               // Largest packet number received, -1 before any
+	received   []quicRange         // Packet numbers received, descending, to acknowledge
+	ackDue     bool                // An ack-eliciting packet wasn't acknowledged yet
+	inFlight   map[uint64]quicSent // Ack-eliciting packets not acknowledged yet
+	queue      [][]byte            // Frames to send
+
+	cryptoOut    []byte     // Handshake data to send
+	cryptoOffset uint64     // Offset of cryptoOut in the handshake data
+	cryptoIn     quicStream // Handshake data received
+}
+
+// receive records packet number pn and reports whether it is new
+func (s *quicSpace) receive(pn uint64) bool {
+	for _, r := range s.received {
+		if pn >= r.lo && pn <= r.hi {
+			return false
+		}
+	}
+	s.received = append(s.received, quicRange{pn, pn})
+	slices.SortFunc(s.received, func(a, b quicRange) int { return cmp.Compare(b.hi, a.hi) })
+	merged := s.received[:1]
+	for _, r := range s.received[1:] {
+		if last := &merged[len(merged)-1]; r.hi+1 == last.lo {
+			last.lo = r.lo
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	// Acknowledging the recent ranges is enough, the server stops resending the rest
+	s.received = merged[:min(len(merged), 32)]
+	s.largest = max(s.largest, int64(pn))
+	return true
+}
+
+// appendAck appends an ACK frame of the packets received
+func (s *quicSpace) appendAck(b []byte) []byte {
+	r := s.received
+	b = append(b, 0x02)
+	b = appendVarint(b, r[0].hi)
+	b = appendVarint(b, 0) // ACK Delay
+	b = appendVarint(b, uint64(len(r)-1))
+	b = appendVarint(b, r[0].hi-r[0].lo)
+	for i := 1; i < len(r); i++ {
+		b = appendVarint(b, r[i-1].lo-r[i].hi-2)
+		b = appendVarint(b, r[i].hi-r[i].lo)
+	}
+	return b
+}
+
+// discard drops the keys and state of the space once the handshake moved on
+func (s *quicSpace) discard() {
+	*s = quicSpace{largest: s.largest, next: s.next}
+}
+
+// quicParams are the transport parameters of the server, RFC 9000 section 18.2
+type quicParams struct {
+	odcid          []byte        // original_destination_connection_id
+	idleTimeout    time.Duration // max_idle_timeout
+	maxData        uint64        // initial_max_data
+	maxStreamData  uint64        // initial_max_stream_data_bidi_remote, for the request
+	maxUniData     uint64        // initial_max_stream_data_uni, for the control stream
+	maxStreams     uint64        // initial_max_streams_bidi
+	maxUniStreams  uint64        // initial_max_streams_uni
+	retrySCID      []byte        // retry_source_connection_id
+	initialSCID    []byte        // initial_source_connection_id
+	hasInitialSCID bool
+}
+
+// parseQUICParams parses the transport parameters of the server
+func parseQUICParams(b []byte) (quicParams, error) {
+	var p quicParams
+	r := quicReader{b: b}
+	for len(r.b) > 0 && r.err == nil {
+		id := r.varint()
+		value := slices.Clone(r.bytes(r.varint()))
+		v, _ := readVarint(value)
+		switch id {
+		case 0x00:
+			p.odcid = value
+		case 0x01:
+			p.idleTimeout = time.Duration(v) * time.Millisecond
+		case 0x04:
+			p.maxData = v
+		case 0x06:
+			p.maxStreamData = v
+		case 0x07:
+			p.maxUniData = v
+		case 0x08:
+			p.maxStreams = v
+		case 0x09:
+			p.maxUniStreams = v
+		case 0x0f:
+			p.initialSCID, p.hasInitialSCID = value, true
+		case 0x10:
+			p.retrySCID = value
+		}
+	}
+	if r.err != nil {
+		return p, fmt.Errorf("invalid transport parameters: %w", r.err)
+	}
+	return p, nil
+}
+
+// h3Response follows the HTTP/3 frames of the response, RFC 9114 section 7
+type h3Response struct {
+	header  []byte // Start of a frame header not complete yet
+	skip    uint64 // Payload of the current frame still to come
+	headers bool   // A HEADERS frame arrived
+}
+
+// feed consumes the next data of the response
+func (h *h3Response) feed(data []byte) {
+	for len(data) > 0 {
+		if h.skip > 0 {
+			n := min(h.skip, uint64(len(data)))
+			h.skip -= n
+			data = data[n:]
+			continue
+		}
+		h.header = append(h.header, data...)
+		typ, n := readVarint(h.header)
+		length, m := readVarint(h.header[n:])
+		if n == 0 || m == 0 {
+			return
+		}
+		if typ == 0x01 {
+			h.headers = true
+		}
+		data, h.header, h.skip = h.header[n+m:], nil, length
+	}
+}
+
+// h3Request encodes a GET of target as a HEADERS frame. The field lines
+// refer to the QPACK static table, or carry literal values after a name of
+// it, so no dynamic table is needed (RFC 9204).
+func h3Request(target *url.URL) []byte {
+	fields := []byte{0, 0}                    // Required Insert Count and Base
+	fields = append(fields, 0xc0|17, 0xc0|23) // :method GET, :scheme https
+	fields = qpackLiteral(fields, 0, target.Host)
+	if path := target.RequestURI(); path == "/" {
+		fields = append(fields, 0xc0|1)
+	} else {
+		fields = qpackLiteral(fields, 1, path)
+	}
+	fields = qpackLiteral(fields, 95, "outagemock") // user-agent
+	frame := appendVarint([]byte{0x01}, uint64(len(fields)))
+	return append(frame, fields...)
+}
+
+// qpackLiteral appends a field line with the name of static table entry index
+// and a literal value
+func qpackLiteral(b []byte, index uint64, value string) []byte {
+	b = qpackInt(b, 0x50, 4, index)
+	b = qpackInt(b, 0x00, 7, uint64(len(value)))
+	return append(b, value...)
+}
+
+// qpackInt appends v as an integer with an n bit prefix after the bits of
+// first, RFC 7541 section 5.1
+func qpackInt(b []byte, first byte, n uint, v uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if v < limit {
+		return append(b, first|byte(v))
+	}
+	b = append(b, first|byte(limit))
+	for v -= limit; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+// quicConn is an HTTP/3 connection over QUIC that completed one GET and then
+// idles, the h3:// counterpart of the TCP connections of -conn-target. Reads
+// block until the connection ends; a goroutine runs the connection meanwhile.
+type quicConn struct {
+	udp    net.Conn
+	tls    *tls.QUICConn
+	target *url.URL
+
+	dcid, scid []byte // Connection IDs of the server and of the client
+	odcid      []byte // The client's first destination connection ID
+	token      []byte // Token of a Retry, sent in the Initial packets that follow
+	retried    bool
+	heard      bool   // A packet of the server was decrypted
+	hello      []byte // The ClientHello, sent again after a Retry
+	spaces     [3]quicSpace
+	peer       quicParams
+	handshaken bool // The TLS handshake is complete
+	keyPhase   byte // Key phase of the 1-RTT keys, flipped by key updates of the server
+	confirmed  bool // HANDSHAKE_DONE arrived
+
+	streams  map[uint64]*quicStream // Streams of the server, and the request stream
+	response h3Response
+	received uint64 // Stream data received, for the connection's flow control
+	maxData  uint64 // Stream data granted to the server
+
+	srtt, rttvar  time.Duration
+	sampled       bool
+	ptoCount      int
+	lastEliciting time.Time // When the last ack-eliciting packet was sent
+	lastRecv      time.Time // When the last packet was received
+
+	closing  atomic.Bool
+	ready    chan struct{} // Closed when the response is complete
+	complete bool
+	done     chan struct{} // Closed when the connection ended
+	err      error         // Why it ended
+}
+
+// dialH3 opens a QUIC connection to target with dial and completes a GET of
+// its path over HTTP/3, like httpGet does over TCP
+func dialH3(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), target *url.URL) (net.Conn, error) {
+	udp, err := dial(ctx, "udp", target.Host)
+	if err != nil {
+		return nil, err
+	}
+	c := &quicConn{
+		udp:     udp,
+		target:  target,
+		dcid:    make([]byte, 8),
+		scid:    make([]byte, 8),
+		streams: make(map[uint64]*quicStream),
+		maxData: quicConnWindow,
+		srtt:    333 * time.Millisecond, // Until measured, RFC 9002 section 6.2.2
+		rttvar:  333 * time.Millisecond / 2,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	rand.Read(c.dcid)
+	rand.Read(c.scid)
+	c.odcid = c.dcid
+	for i := range c.spaces {
+		c.spaces[i].largest = -1
+		c.spaces[i].inFlight = make(map[uint64]quicSent)
+	}
+	c.spaces[quicInitial].seal, c.spaces[quicInitial].open = quicInitialKeys(c.dcid)
+	c.lastEliciting, c.lastRecv = time.Now(), time.Now()
+
+	c.tls = tls.QUICClient(&tls.QUICConfig{TLSConfig: &tls.Config{
+		ServerName: target.Hostname(),
+		NextProtos: []string{"h3"},
+		MinVersion: tls.VersionTLS13,
+	}})
+	c.tls.SetTransportParameters(c.params())
+	if err := c.tls.Start(ctx); err != nil {
+		udp.Close()
+		return nil, err
+	}
+	if err := c.handleTLS(); err != nil {
+		c.tls.Close()
+		udp.Close()
+		return nil, err
+	}
+	go c.run()
+
+	select {
+	case <-c.ready:
+		return c, nil
+	case <-c.done:
+		return nil, c.err
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// params encodes the transport parameters of the client. The server opens the
+// unidirectional control and QPACK streams, but no bidirectional ones.
+func (c *quicConn) params() []byte {
+	var b []byte
+	add := func(id uint64, value []byte) {
+		b = appendVarint(appendVarint(b, id), uint64(len(value)))
+		b = append(b, value...)
+	}
+	add(0x03, appendVarint(nil, quicMaxDatagram))  // max_udp_payload_size
+	add(0x04, appendVarint(nil, quicConnWindow))   // initial_max_data
+	add(0x05, appendVarint(nil, quicStreamWindow)) // initial_max_stream_data_bidi_local
+	add(0x07, appendVarint(nil, quicStreamWindow)) // initial_max_stream_data_uni
+	add(0x09, appendVarint(nil, 8))                // initial_max_streams_uni
+	add(0x0f, c.scid)                              // initial_source_connection_id
+	return b
+}
+
+// run sends and receives the packets of the connection until it ends
+func (c *quicConn) run() {
+	defer close(c.done)
+	defer c.udp.Close()
+	defer c.tls.Close()
+
+	buf := make([]byte, quicMaxDatagram)
+	for {
+		if err := c.flush(); err != nil {
+			c.err = err
+			return
+		}
+		// Close sets a deadline in the past after the flag, so one of them is seen
+		c.udp.SetReadDeadline(c.deadline())
+		if c.closing.Load() {
+			c.sendClose()
+			c.err = net.ErrClosed
+			return
+		}
+		n, err := c.udp.Read(buf)
+		switch {
+		case c.closing.Load():
+			c.sendClose()
+			c.err = net.ErrClosed
+			return
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			err = c.timeout()
+		case err == nil:
+			err = c.receive(buf[:n])
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+}
+
+// Read blocks until the connection ends, the server closing it or its idle
+// timeout expiring, like reading an idle TCP connection
+func (c *quicConn) Read(b []byte) (int, error) {
+	<-c.done
+	return 0, io.EOF
+}
+
+// Write is not supported, requests are only sent while dialing
+func (c *quicConn) Write(b []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Close closes the connection with H3_NO_ERROR and waits for it to end
+func (c *quicConn) Close() error {
+	if c.closing.CompareAndSwap(false, true) {
+		c.udp.SetReadDeadline(time.Now())
+	}
+	<-c.done
+	return nil
+}
+
+func (c *quicConn) LocalAddr() net.Addr                { return c.udp.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr               { return c.udp.RemoteAddr() }
+func (c *quicConn) SetDeadline(t time.Time) error      { return nil }
+func (c *quicConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *quicConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// handleTLS processes the events of the TLS handshake
+func (c *quicConn) handleTLS() error {
+	for {
+		e := c.tls.NextEvent()
+		space := quicSpaceOf(e.Level)
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+		case tls.QUICSetReadSecret, tls.QUICSetWriteSecret:
+			if space < 0 {
+				continue
+			}
+			keys, err := newQUICKeys(e.Suite, e.Data)
+			if err != nil {
+				return err
+			}
+			if e.Kind == tls.QUICSetReadSecret {
+				c.spaces[space].open = keys
+			} else {
+				c.spaces[space].seal = keys
+			}
+		case tls.QUICWriteData:
+			c.spaces[space].cryptoOut = append(c.spaces[space].cryptoOut, e.Data...)
+			if space == quicInitial {
+				c.hello = append(c.hello, e.Data...)
+			}
+		case tls.QUICTransportParameters:
+			var err error
+			if c.peer, err = parseQUICParams(e.Data); err != nil {
+				return err
+			}
+		case tls.QUICHandshakeDone:
+			if err := c.handshakeDone(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// quicSpaceOf returns the packet number space of a TLS encryption level, or
+// -1 for 0-RTT, which isn't used
+func quicSpaceOf(level tls.QUICEncryptionLevel) int {
+	switch level {
+	case tls.QUICEncryptionLevelInitial:
+		return quicInitial
+	case tls.QUICEncryptionLevelHandshake:
+		return quicHandshake
+	case tls.QUICEncryptionLevelApplication:
+		return quicApp
+	}
+	return -1
+}
+
+// handshakeDone checks the server's transport parameters and sends the
+// request, after opening the control stream HTTP/3 requires
+func (c *quicConn) handshakeDone() error {
+	c.handshaken = true
+	p := c.peer
+	switch {
+	case !bytes.Equal(p.odcid, c.odcid) || !p.hasInitialSCID || !bytes.Equal(p.initialSCID, c.dcid):
+		return fmt.Errorf("the server's transport parameters don't match the connection IDs")
+	case c.retried != (p.retrySCID != nil):
+		return fmt.Errorf("the server's transport parameters don't match its Retry")
+	}
+	control := []byte{0x00, 0x04, 0x00} // Control stream type and an empty SETTINGS frame
+	request := h3Request(c.target)
+	if p.maxStreams == 0 || p.maxUniStreams == 0 || p.maxStreamData < uint64(len(request)) ||
+		p.maxUniData < uint64(len(control)) || p.maxData < uint64(len(control)+len(request)) {
+		return fmt.Errorf("the server's flow control doesn't admit a request")
+	}
+	c.spaces[quicApp].queue = append(c.spaces[quicApp].queue, streamFrame(2, control, false), streamFrame(0, request, true))
+	return nil
+}
+
+// streamFrame encodes a STREAM frame with data at the start of stream id
+func streamFrame(id uint64, data []byte, fin bool) []byte {
+	typ := byte(0x0a) // STREAM with a length
+	if fin {
+		typ |= 0x01
+	}
+	frame := appendVarint(appendVarint([]byte{typ}, id), uint64(len(data)))
+	return append(frame, data...)
+}
+
+// flush sends the queued handshake data, frames and acknowledgements
+func (c *quicConn) flush() error {
+	for space := range c.spaces {
+		s := &c.spaces[space]
+		if s.seal == nil {
+			continue
+		}
+		for len(s.cryptoOut) > 0 {
+			n := min(len(s.cryptoOut), quicMaxCrypto)
+			frame := appendVarint(appendVarint([]byte{0x06}, s.cryptoOffset), uint64(n))
+			s.queue = append(s.queue, append(frame, s.cryptoOut[:n]...))
+			s.cryptoOffset += uint64(n)
+			s.cryptoOut = s.cryptoOut[n:]
+		}
+		for len(s.queue) > 0 {
+			frames := s.queue[0]
+			s.queue = s.queue[1:]
+			for len(s.queue) > 0 && len(frames)+len(s.queue[0]) <= quicMaxPayload {
+				frames = append(slices.Clip(frames), s.queue[0]...)
+				s.queue = s.queue[1:]
+			}
+			if err := c.send(space, frames); err != nil {
+				return err
+			}
+		}
+		if s.ackDue {
+			if err := c.send(space, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// send seals frames, with an acknowledgement if one is due, into a packet of
+// space and sends it in a datagram of its own. Frames are kept until the
+// packet is acknowledged.
+func (c *quicConn) send(space int, frames []byte) error {
+	s := &c.spaces[space]
+	var payload []byte
+	if s.ackDue {
+		payload = s.appendAck(payload)
+		s.ackDue = false
+	}
+	payload = append(payload, frames...)
+
+	pn := s.next
+	s.next++
+	var header []byte
+	if space == quicApp {
+		header = append([]byte{0x43 | c.keyPhase<<2}, c.dcid...) // Short header, 4 byte packet number
+	} else {
+		typ := byte(0x00)
+		if space == quicHandshake {
+			typ = 0x02
+		}
+		header = binary.BigEndian.AppendUint32([]byte{0xc3 | typ<<4}, quicVersion1)
+		header = append(append(header, byte(len(c.dcid))), c.dcid...)
+		header = append(append(header, byte(len(c.scid))), c.scid...)
+		if space == quicInitial {
+			header = append(appendVarint(header, uint64(len(c.token))), c.token...)
+			// Padding to the minimum datagram size proves the path carries it
+			if pad := quicMinDatagram - (len(header) + 2 + 4 + len(payload) + 16); pad > 0 {
+				payload = append(payload, make([]byte, pad)...)
+			}
+		}
+		length := 4 + len(payload) + 16
+		header = append(header, 0x40|byte(length>>8), byte(length)) // Always two bytes
+	}
+	header = binary.BigEndian.AppendUint32(header, uint32(pn))
+
+	if _, err := c.udp.Write(s.seal.seal(header, payload, pn)); err != nil {
+		return err
+	}
+	if len(frames) > 0 {
+		s.inFlight[pn] = quicSent{at: time.Now(), frames: frames}
+		c.lastEliciting = time.Now()
+	}
+	// The server has the client's Handshake packets, Initial packets are over
+	if space == quicHandshake && c.spaces[quicInitial].seal != nil {
+		c.spaces[quicInitial].discard()
+	}
+	return nil
+}
+
+// sendClose tells the server the connection is closed: H3_NO_ERROR once the
+// handshake is complete, NO_ERROR of the transport before
+func (c *quicConn) sendClose() {
+	if c.handshaken && c.spaces[quicApp].seal != nil {
+		c.send(quicApp, append(appendVarint([]byte{0x1d}, 0x100), 0))
+		return
+	}
+	for space := quicHandshake; space >= quicInitial; space-- {
+		if c.spaces[space].seal != nil {
+			c.send(space, []byte{0x1c, 0, 0, 0})
+			return
+		}
+	}
+}
+
+// pto returns the probe timeout, RFC 9002 section 6.2.1
+func (c *quicConn) pto() time.Duration {
+	return (c.srtt + max(4*c.rttvar, time.Millisecond) + quicMaxAckDelay) << c.ptoCount
+}
+
+// deadline returns when the probe timeout or the idle timeout expire. Probes
+// are sent while packets are unacknowledged or the handshake is incomplete.
+func (c *quicConn) deadline() time.Time {
+	var at time.Time
+	inFlight := false
+	for _, s := range c.spaces {
+		inFlight = inFlight || len(s.inFlight) > 0
+	}
+	if inFlight || !c.handshaken {
+		at = c.lastEliciting.Add(c.pto())
+	}
+	if c.peer.idleTimeout > 0 {
+		if idle := c.lastRecv.Add(max(c.peer.idleTimeout, 3*c.pto())); at.IsZero() || idle.Before(at) {
+			at = idle
+		}
+	}
+	return at
+}
+
+// timeout ends an idle connection, or sends unacknowledged frames again
+func (c *quicConn) timeout() error {
+	if c.peer.idleTimeout > 0 && !time.Now().Before(c.lastRecv.Add(max(c.peer.idleTimeout, 3*c.pto()))) {
+		return errQUICIdle
+	}
+	c.ptoCount = min(c.ptoCount+1, 6)
+	probed := false
+	for space := range c.spaces {
+		s := &c.spaces[space]
+		pns := make([]uint64, 0, len(s.inFlight))
+		for pn := range s.inFlight {
+			pns = append(pns, pn)
+		}
+		slices.Sort(pns)
+		for _, pn := range pns {
+			s.queue = append(s.queue, s.inFlight[pn].frames)
+			delete(s.inFlight, pn)
+		}
+		probed = probed || len(pns) > 0
+	}
+	// Without anything to resend a PING asks the server for what it has
+	for space := quicApp; !probed && space >= quicInitial; space-- {
+		if s := &c.spaces[space]; s.seal != nil {
+			s.queue = append(s.queue, []byte{0x01})
+			probed = true
+		}
+	}
+	c.lastEliciting = time.Now()
+	return nil
+}
+
+// receive processes the packets of a datagram
+func (c *quicConn) receive(datagram []byte) error {
+	for len(datagram) > 0 {
+		if datagram[0]&0x80 == 0 {
+			// A short header packet takes the rest of the datagram
+			if len(datagram) < 1+len(c.scid) || !bytes.Equal(datagram[1:1+len(c.scid)], c.scid) {
+				return nil
+			}
+			_, err := c.receivePacket(quicApp, datagram, 1+len(c.scid))
+			return err
+		}
+		r := quicReader{b: datagram[1:]}
+		version := r.bytes(4)
+		dcid := r.bytes(uint64(r.u8()))
+		scid := r.bytes(uint64(r.u8()))
+		if r.err != nil || !bytes.Equal(dcid, c.scid) {
+			return nil
+		}
+		switch binary.BigEndian.Uint32(version) {
+		case 0:
+			if !c.heard && !c.retried {
+				return fmt.Errorf("the server doesn't support QUIC version 1")
+			}
+			return nil
+		case quicVersion1:
+		default:
+			return nil
+		}
+		typ := datagram[0] >> 4 & 0x03
+		if typ == 0x03 {
+			return c.retry(datagram, scid, r.b)
+		}
+		if typ == 0x00 {
+			r.bytes(r.varint()) // Token, always empty from servers
+		}
+		length := r.varint()
+		if r.err != nil || length > uint64(len(r.b)) {
+			return nil
+		}
+		pnOffset := len(datagram) - len(r.b)
+		packet := datagram[:pnOffset+int(length)]
+		datagram = datagram[len(packet):]
+
+		space := quicInitial
+		switch typ {
+		case 0x01:
+			continue // 0-RTT is never sent to clients
+		case 0x02:
+			space = quicHandshake
+		}
+		opened, err := c.receivePacket(space, packet, pnOffset)
+		if err != nil {
+			return err
+		}
+		// The server's first packet picks its connection ID
+		if opened && !c.heard {
+			c.dcid, c.heard = slices.Clone(scid), true
+		}
+	}
+	return nil
+}
+
+// receivePacket decrypts a packet of space and processes its frames. It
+// reports whether the packet could be decrypted; others are dropped.
+func (c *quicConn) receivePacket(space int, packet []byte, pnOffset int) (bool, error) {
+	s := &c.spaces[space]
+	if s.open == nil {
+		return false, nil
+	}
+	pn, headerLen, err := s.open.unprotect(packet, pnOffset, s.largest)
+	if err != nil {
+		return false, nil
+	}
+	// A short header packet of the other key phase starts a key update, which
+	// the client follows for its own packets once it decrypts
+	keys := s.open
+	update := space == quicApp && packet[0]>>2&1 != c.keyPhase
+	if update {
+		if keys, err = keys.next(); err != nil {
+			return false, nil
+		}
+	}
+	payload, err := keys.decrypt(packet, headerLen, pn)
+	if err != nil {
+		return false, nil
+	}
+	if update {
+		next, err := s.seal.next()
+		if err != nil {
+			return false, err
+		}
+		s.open, s.seal = keys, next
+		c.keyPhase ^= 1
+	}
+	if !s.receive(pn) {
+		return true, nil
+	}
+	c.lastRecv = time.Now()
+	eliciting, err := c.frames(space, payload)
+	if eliciting && s.open != nil {
+		s.ackDue = true
+	}
+	return true, err
+}
+
+// retry starts over after a Retry packet of the server, with its connection
+// ID and token, RFC 9000 section 17.2.5
+func (c *quicConn) retry(packet, scid, rest []byte) error {
+	if c.retried || c.heard || len(rest) < 16 {
+		return nil
+	}
+	pseudo := append([]byte{byte(len(c.odcid))}, c.odcid...)
+	pseudo = append(pseudo, packet[:len(packet)-16]...)
+	block, _ := aes.NewCipher(quicRetryKey)
+	aead, _ := cipher.NewGCM(block)
+	if _, err := aead.Open(nil, quicRetryNonce, packet[len(packet)-16:], pseudo); err != nil {
+		return nil
+	}
+	c.retried = true
+	c.token = slices.Clone(rest[:len(rest)-16])
+	c.dcid = slices.Clone(scid)
+	s := &c.spaces[quicInitial]
+	s.seal, s.open = quicInitialKeys(c.dcid)
+	clear(s.inFlight)
+	s.queue = nil
+	s.cryptoOut, s.cryptoOffset = c.hello, 0
+	return nil
+}
+
+// frames processes the frames of a packet of space and reports whether any
+// of them asks for an acknowledgement, RFC 9000 section 19
+func (c *quicConn) frames(space int, payload []byte) (eliciting bool, err error) {
+	r := quicReader{b: payload}
+	for len(r.b) > 0 && r.err == nil {
+		typ := r.varint()
+		switch typ {
+		case 0x00, 0x02, 0x03, 0x1c, 0x1d:
+		default:
+			eliciting = true
+		}
+		switch {
+		case typ <= 0x01: // PADDING, PING
+		case typ == 0x02 || typ == 0x03:
+			c.ack(space, &r, typ == 0x03)
+		case typ == 0x04: // RESET_STREAM
+			id := r.varint()
+			r.varint()
+			r.varint()
+			if id == 0 && !c.complete {
+				return eliciting, fmt.Errorf("the server reset the request")
+			}
+		case typ == 0x05: // STOP_SENDING
+			r.varint()
+			r.varint()
+		case typ == 0x06:
+			offset := r.varint()
+			data := r.bytes(r.varint())
+			if r.err == nil {
+				if err := c.crypto(space, offset, data); err != nil {
+					return eliciting, err
+				}
+			}
+		case typ == 0x07: // NEW_TOKEN
+			r.bytes(r.varint())
+		case typ >= 0x08 && typ <= 0x0f:
+			id := r.varint()
+			var offset uint64
+			if typ&0x04 != 0 {
+				offset = r.varint()
+			}
+			data := r.b
+			if typ&0x02 != 0 {
+				data = r.bytes(r.varint())
+			} else {
+				r.b = nil
+			}
+			if r.err == nil {
+				if err := c.stream(id, offset, data, typ&0x01 != 0); err != nil {
+					return eliciting, err
+				}
+			}
+		case typ == 0x10 || typ >= 0x12 && typ <= 0x14 || typ == 0x16 || typ == 0x17 || typ == 0x19:
+			r.varint() // MAX_DATA, MAX_STREAMS, DATA_BLOCKED, STREAMS_BLOCKED, RETIRE_CONNECTION_ID
+		case typ == 0x11 || typ == 0x15: // MAX_STREAM_DATA, STREAM_DATA_BLOCKED
+			r.varint()
+			r.varint()
+		case typ == 0x18: // NEW_CONNECTION_ID, the first one is kept
+			r.varint()
+			r.varint()
+			r.bytes(uint64(r.u8()))
+			r.bytes(16)
+		case typ == 0x1a:
+			c.spaces[quicApp].queue = append(c.spaces[quicApp].queue, append([]byte{0x1b}, r.bytes(8)...))
+		case typ == 0x1b: // PATH_RESPONSE
+			r.bytes(8)
+		case typ == 0x1c || typ == 0x1d:
+			code := r.varint()
+			if typ == 0x1c {
+				r.varint()
+			}
+			reason := r.bytes(r.varint())
+			return eliciting, fmt.Errorf("the server closed the connection with error %#x %q", code, reason)
+		case typ == 0x1e: // HANDSHAKE_DONE
+			c.confirmed = true
+			c.spaces[quicHandshake].discard()
+		case typ == 0x30: // DATAGRAM
+			r.b = nil
+		case typ == 0x31:
+			r.bytes(r.varint())
+		default:
+			return eliciting, fmt.Errorf("unknown QUIC frame type %#x", typ)
+		}
+	}
+	return eliciting, r.err
+}
+
+// ack processes an ACK frame, dropping the acknowledged packets and sampling
+// the round trip time
+func (c *quicConn) ack(space int, r *quicReader, ecn bool) {
+	s := &c.spaces[space]
+	largest := r.varint()
+	r.varint() // ACK Delay
+	count := r.varint()
+	first := r.varint()
+	if first > largest {
+		r.fail()
+		return
+	}
+	ranges := []quicRange{{largest - first, largest}}
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		gap, length := r.varint(), r.varint()
+		lo := ranges[len(ranges)-1].lo
+		if gap+2+length > lo {
+			r.fail()
+			return
+		}
+		ranges = append(ranges, quicRange{lo - gap - 2 - length, lo - gap - 2})
+	}
+	if ecn {
+		r.varint()
+		r.varint()
+		r.varint()
+	}
+	if r.err != nil {
+		return
+	}
+	if sent, ok := s.inFlight[largest]; ok {
+		c.sample(time.Since(sent.at))
+	}
+	for pn := range s.inFlight {
+		for _, rg := range ranges {
+			if pn >= rg.lo && pn <= rg.hi {
+				delete(s.inFlight, pn)
+				c.ptoCount = 0
+				break
+			}
+		}
+	}
+}
+
+// sample updates the round trip time estimate, RFC 9002 section 5.3
+func (c *quicConn) sample(rtt time.Duration) {
+	if !c.sampled {
+		c.srtt, c.rttvar, c.sampled = rtt, rtt/2, true
+		return
+	}
+	c.rttvar = (3*c.rttvar + (c.srtt - rtt).Abs()) / 4
+	c.srtt = (7*c.srtt + rtt) / 8
+}
+
+// crypto passes the handshake data of space to TLS in order
+func (c *quicConn) crypto(space int, offset uint64, data []byte) error {
+	s := &c.spaces[space]
+	if s.open == nil {
+		return nil
+	}
+	if data = s.cryptoIn.push(offset, data); len(data) == 0 {
+		return nil
+	}
+	level := []tls.QUICEncryptionLevel{tls.QUICEncryptionLevelInitial, tls.QUICEncryptionLevelHandshake, tls.QUICEncryptionLevelApplication}[space]
+	if err := c.tls.HandleData(level, data); err != nil {
+		return err
+	}
+	return c.handleTLS()
+}
+
+// stream processes data of a stream: the response on the request stream, and
+// what the server sends on its control and QPACK streams, which is discarded.
+// The credit of the server is renewed as data arrives.
+func (c *quicConn) stream(id, offset uint64, data []byte, fin bool) error {
+	st := c.streams[id]
+	if st == nil {
+		st = &quicStream{limit: quicStreamWindow}
+		c.streams[id] = st
+	}
+	highest := st.highest
+	delivered := st.push(offset, data)
+	if fin {
+		st.fin, st.size = true, offset+uint64(len(data))
+	}
+
+	queue := &c.spaces[quicApp].queue
+	if st.highest > st.limit-quicStreamWindow/2 && !st.fin {
+		st.limit = st.highest + quicStreamWindow
+		*queue = append(*queue, appendVarint(appendVarint([]byte{0x11}, id), st.limit))
+	}
+	c.received += st.highest - highest
+	if c.received > c.maxData-quicConnWindow/2 {
+		c.maxData = c.received + quicConnWindow
+		*queue = append(*queue, appendVarint([]byte{0x10}, c.maxData))
+	}
+
+	if id != 0 || c.complete {
+		return nil
+	}
+	c.response.feed(delivered)
+	if st.fin && st.offset == st.size {
+		if !c.response.headers {
+			return fmt.Errorf("the server ended the request stream without a response")
+		}
+		c.complete = true
+		close(c.ready)
+	}
+	return nil
+}
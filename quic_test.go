@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"slices"
+	"testing"
+)
+
+// TestQUICInitialKeys checks the client Initial example of RFC 9001 appendix A
+func TestQUICInitialKeys(t *testing.T) {
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	seal, open := quicInitialKeys(unhex("8394c8f03e515708"))
+	if got := seal.nonce(0); !bytes.Equal(got, unhex("fa044b2f42a3fd3b46fb255c")) {
+		t.Errorf("client iv %x", got)
+	}
+	if got := open.nonce(0); !bytes.Equal(got, unhex("0ac1493ca1905853b0bba03e")) {
+		t.Errorf("server iv %x", got)
+	}
+	if got := seal.mask(unhex("d1b1c98dd7689fb8ec11d242b123dc9b"))[:5]; !bytes.Equal(got, unhex("437b9aec36")) {
+		t.Errorf("header protection mask %x, want 437b9aec36", got)
+	}
+
+	// A packet sealed with the client keys opens with them, the header restored
+	header := unhex("c300000001088394c8f03e5157080000449e00000002")
+	packet := seal.seal(header, bytes.Repeat([]byte{0x06}, 1162), 2)
+	pn, headerLen, err := seal.unprotect(packet, len(header)-4, 1)
+	if err != nil || pn != 2 || !bytes.Equal(packet[:headerLen], header) {
+		t.Fatalf("unprotect = %d, header %x, %v", pn, packet[:headerLen], err)
+	}
+	if payload, err := seal.decrypt(packet, headerLen, pn); err != nil || len(payload) != 1162 {
+		t.Errorf("decrypt = %d bytes, %v", len(payload), err)
+	}
+}
+
+// TestQUICRetry checks the Retry example of RFC 9001 appendix A.4
+func TestQUICRetry(t *testing.T) {
+	packet, _ := hex.DecodeString("ff000000010008f067a5502a4262b5746f6b656e04a265ba2eff4d829058fb3f0f2496ba")
+	odcid, _ := hex.DecodeString("8394c8f03e515708")
+	c := &quicConn{odcid: odcid, dcid: odcid}
+	c.spaces[quicInitial].inFlight = make(map[uint64]quicSent)
+	c.receive(slices.Clone(packet))
+	if !c.retried || string(c.token) != "token" || !bytes.Equal(c.dcid, packet[7:15]) {
+		t.Errorf("retry gave token %q and connection ID %x", c.token, c.dcid)
+	}
+
+	// A tag that doesn't match the original connection ID is ignored
+	c = &quicConn{odcid: []byte{1, 2, 3, 4}, dcid: odcid}
+	c.receive(slices.Clone(packet))
+	if c.retried {
+		t.Error("accepted a Retry with an invalid integrity tag")
+	}
+}
+
+func TestQUICVarints(t *testing.T) {
+	// Examples of RFC 9000 appendix A.1
+	for _, tc := range []struct {
+		s string
+		v uint64
+	}{
+		{"c2197c5eff14e88c", 151288809941952652}, {"9d7f3e7d", 494878333}, {"7bbd", 15293}, {"25", 37},
+	} {
+		b, _ := hex.DecodeString(tc.s)
+		if v, n := readVarint(b); v != tc.v || n != len(b) {
+			t.Errorf("readVarint(%s) = %d, %d, want %d", tc.s, v, n, tc.v)
+		}
+		if got := appendVarint(nil, tc.v); !bytes.Equal(got, b) {
+			t.Errorf("appendVarint(%d) = %x, want %s", tc.v, got, tc.s)
+		}
+	}
+	if pn := decodePacketNumber(0xa82f30ea, 0x9b32, 16); pn != 0xa82f9b32 {
+		t.Errorf("decoded packet number %#x, want 0xa82f9b32", pn)
+	}
+	// RFC 7541 appendix C.1.2: 1337 with a 5 bit prefix
+	if got := qpackInt(nil, 0, 5, 1337); !bytes.Equal(got, []byte{31, 154, 10}) {
+		t.Errorf("qpackInt(1337) = %v", got)
+	}
+}
+
+// TestQUICStreams checks that stream data is delivered in order and the
+// response is found in the HTTP/3 frames
+func TestQUICStreams(t *testing.T) {
+	var s quicStream
+	if got := s.push(3, []byte("def")); got != nil {
+		t.Errorf("data ahead of the stream delivered: %q", got)
+	}
+	if got := s.push(0, []byte("abcd")); string(got) != "abcdef" {
+		t.Errorf("delivered %q, want abcdef", got)
+	}
+	if got := s.push(2, []byte("cdefg")); string(got) != "g" {
+		t.Errorf("delivered %q after a retransmission, want g", got)
+	}
+
+	// HEADERS and a DATA frame of 300 bytes, fed a byte at a time
+	response := append([]byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x41, 0x2c}, make([]byte, 300)...)
+	var h h3Response
+	for _, b := range response {
+		h.feed([]byte{b})
+	}
+	if !h.headers || h.skip != 0 || len(h.header) != 0 {
+		t.Errorf("after the response: headers %v, %d bytes to skip, %d header bytes", h.headers, h.skip, len(h.header))
+	}
+}
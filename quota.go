@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"math"
+	"runtime"
+)
+
+// Quota holds absolute safety caps on consumption. Caps are applied to the
+// targets computed by the controller, so no shape, timeline, scenario or API
+// request can exceed them. Zero means no cap.
+type Quota struct {
+	MaxCPUPercent float64 `flag:"max-cpu" default:"0" group:"safety" usage:"Hard cap on the CPU target in percent, regardless of shapes, scenarios or API requests (0 = none)"`
+	MaxMemoryMB   int64   `flag:"max-memory" default:"0" group:"safety" usage:"Hard cap on the memory target in MB (0 = none)"`
+	MaxFileSizeMB int64   `flag:"max-fsize" default:"0" unit:"size" group:"safety" usage:"Hard cap on the file size target with unit, e.g. 10G (0 = none)"`
+}
+
+// capCPU limits a CPU target to the quota
+func (q Quota) capCPU(percent float64) float64 {
+	if q.MaxCPUPercent > 0 {
+		return math.Min(percent, q.MaxCPUPercent)
+	}
+	return percent
+}
+
+// capThreads limits threads that each spin on a core of cores to the CPU cap
+func (q Quota) capThreads(n, cores int) int {
+	if limit := q.capCPU(100); limit < 100 {
+		return min(n, int(limit*float64(cores)/100))
+	}
+	return n
+}
+
+// capMemory limits a memory target to the quota
+func (q Quota) capMemory(mb int64) int64 {
+	return capInt(mb, q.MaxMemoryMB)
+}
+
+// capFile limits a file size target to the quota
+func (q Quota) capFile(mb int64) int64 {
+	return capInt(mb, q.MaxFileSizeMB)
+}
+
+// capCoW limits the pages each child of -cow-fork dirties, so the area and
+// the copies of all children together stay within the memory cap
+func (q Quota) capCoW(spec CoWFork, pages uint64) uint64 {
+	if q.MaxMemoryMB == 0 {
+		return pages
+	}
+	room := q.MaxMemoryMB<<20 - spec.Size
+	if room <= 0 {
+		return 0
+	}
+	return min(pages, uint64(room/int64(spec.Children)/PageBytes))
+}
+
+func capInt(value, limit int64) int64 {
+	if limit > 0 && value > limit {
+		return limit
+	}
+	return value
+}
+
+// tighten returns the quota with every cap lowered to other's where other is stricter
+func (q Quota) tighten(other Quota) Quota {
+	if other.MaxCPUPercent > 0 && (q.MaxCPUPercent == 0 || other.MaxCPUPercent < q.MaxCPUPercent) {
+		q.MaxCPUPercent = other.MaxCPUPercent
+	}
+	if other.MaxMemoryMB > 0 && (q.MaxMemoryMB == 0 || other.MaxMemoryMB < q.MaxMemoryMB) {
+		q.MaxMemoryMB = other.MaxMemoryMB
+	}
+	if other.MaxFileSizeMB > 0 && (q.MaxFileSizeMB == 0 || other.MaxFileSizeMB < q.MaxFileSizeMB) {
+		q.MaxFileSizeMB = other.MaxFileSizeMB
+	}
+	return q
+}
+
+// warnCapped logs the configured targets that the quota will cut down
+func (c *Config) warnCapped() {
+	if c.capCPU(c.CPUPercent) < c.CPUPercent {
+		log.Printf("CPU target %.1f%% capped at %.1f%%", c.CPUPercent, c.MaxCPUPercent)
+	}
+	if threads := int(math.Ceil(c.runqueue * float64(runtime.NumCPU()))); c.capThreads(threads, runtime.NumCPU()) < threads {
+		log.Printf("-runqueue %gx capped at %d spinning threads", c.runqueue, c.capThreads(threads, runtime.NumCPU()))
+	}
+	if threads := int(math.Ceil(c.LoadAvg * c.loadMix)); c.capThreads(threads, runtime.NumCPU()) < threads {
+		log.Printf("-loadavg %g capped at %d spinning workers", c.LoadAvg, c.capThreads(threads, runtime.NumCPU()))
+	}
+	if c.capMemory(c.MemoryMB) < c.MemoryMB {
+		log.Printf("Memory target %d MB capped at %d MB", c.MemoryMB, c.MaxMemoryMB)
+	}
+	if peak := c.cowFork.peakMB(); c.capMemory(peak) < peak {
+		log.Printf("Copy-on-write peak %d MB capped at %d MB", peak, c.MaxMemoryMB)
+	}
+	if c.faultRate > 0 && c.capMemory(c.FaultRegionMB) < c.FaultRegionMB {
+		log.Printf("Fault region %d MB capped at %d MB", c.FaultRegionMB, c.MaxMemoryMB)
+	}
+	if c.capFile(c.FileSizeMB) < c.FileSizeMB {
+		log.Printf("File target %d MB capped at %d MB", c.FileSizeMB, c.MaxFileSizeMB)
+	}
+}
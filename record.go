@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// recordCommand samples host CPU and memory usage into a timeline file.
+// Memory is recorded as growth since the first sample so that a replay on
+// the same host does not double-count the memory already in use.
+func recordCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	out := fs.String("out", "", "Timeline file to write (required)")
+	interval := fs.Duration("interval", time.Second, "Sampling interval")
+	duration := fs.Duration("duration", time.Minute, "Recording duration")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if *out == "" {
+		return exitCodeFor(fmt.Errorf("-out is required"))
+	}
+	if *interval <= 0 || *duration <= 0 {
+		return exitCodeFor(fmt.Errorf("interval and duration must be positive"))
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+
+	prevCPU, err := readCPUTimes()
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	baseMemoryMB, err := usedMemoryMB()
+	if err != nil {
+		return exitCodeFor(err)
+	}
+
+	fmt.Printf("Recording host usage to %s every %v for %v\n", *out, *interval, *duration)
+	sigChan := notifySignals()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	start := time.Now()
+	deadline := time.After(*duration)
+
+	for {
+		select {
+		case sig := <-sigChan:
+			fmt.Printf("Received signal %v, stopping recording\n", sig)
+			return 0
+		case <-deadline:
+			fmt.Println("Recording completed")
+			return 0
+		case <-ticker.C:
+			cpu, err := readCPUTimes()
+			if err != nil {
+				return exitCodeFor(err)
+			}
+			memoryMB, err := usedMemoryMB()
+			if err != nil {
+				return exitCodeFor(err)
+			}
+			point := TimelinePoint{
+				Offset:     time.Since(start).Round(time.Millisecond),
+				CPUPercent: busyPercent(prevCPU, cpu),
+				MemoryMB:   memoryMB - baseMemoryMB,
+			}
+			if point.MemoryMB < 0 {
+				point.MemoryMB = 0
+			}
+			prevCPU = cpu
+			if err := encoder.Encode(point); err != nil {
+				return exitCodeFor(err)
+			}
+		}
+	}
+}
+
+// replayCommand consumes resources following a timeline written by the record command
+func replayCommand(cmd *command, args []string) int {
+	c := newConfigCommand(cmd)
+	in := c.fs.String("in", "", "Timeline file to replay (required)")
+	ok, err := c.parse(args)
+	if !ok || err != nil {
+		return exitCodeFor(err)
+	}
+	if *in == "" {
+		return exitCodeFor(fmt.Errorf("-in is required"))
+	}
+	if err := openOutput(c.config); err != nil {
+		return exitCodeFor(err)
+	}
+	if c.config.Emulate != "" || c.config.Markov != "" || c.config.FollowQuery != "" {
+		return exitCodeFor(fmt.Errorf("-emulate, -markov and -follow-query are mutually exclusive with a replayed timeline"))
+	}
+
+	timeline, err := loadTimeline(*in)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+
+	if err := c.config.applyTimeline(timeline, c.sources["duration"] == sourceDefault); err != nil {
+		return exitCodeFor(err)
+	}
+
+	printStartup(c.config)
+	rm := NewResourceMock(c.config)
+	rm.timeline = timeline
+	return rm.Run(notifyRunSignals())
+}
+
+// applyTimeline sets the targets from the peak of the timeline, which decides
+// which consumers run, and optionally the duration from its length
+func (c *Config) applyTimeline(timeline Timeline, useDuration bool) error {
+	peak := timeline.Peak()
+	c.CPUPercent = peak.CPUPercent
+	c.MemoryMB = peak.MemoryMB
+	c.FileSizeMB = peak.FileSizeMB
+	if useDuration && timeline.Duration() > 0 {
+		c.Duration = timeline.Duration()
+	}
+	return c.validate()
+}
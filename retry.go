@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	retryInitialBackoff = 100 * time.Millisecond
+	retryMaxBackoff     = 5 * time.Second
+	retryMaxAttempts    = 6
+)
+
+// retryWithBackoff calls fn until it succeeds, the context is cancelled or
+// retryMaxAttempts is reached, doubling the wait between attempts
+func retryWithBackoff(ctx context.Context, what string, fn func() error) error {
+	backoff := retryInitialBackoff
+	var err error
+	for attempt := 1; attempt <= retryMaxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == retryMaxAttempts {
+			break
+		}
+		log.Printf("%s failed (attempt %d/%d): %v, retrying in %v", what, attempt, retryMaxAttempts, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
+	return fmt.Errorf("%s failed after %d attempts: %w", what, retryMaxAttempts, err)
+}
+
+// sleepCtx waits for d and reports whether it elapsed before the context was cancelled
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
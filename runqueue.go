@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runqueueInterval is how often -runqueue resizes its threads; procs_running
+// is an instant count, so the rest of the host's share is smoothed over samples
+const runqueueInterval = time.Second
+
+// parseRunqueue parses -runqueue, runnable threads per core like 3x
+func parseRunqueue(s string) (float64, error) {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid -runqueue %q (expected runnable threads per core, e.g. 3x)", s)
+	}
+	return n, nil
+}
+
+// holdRunqueue keeps -runqueue runnable threads per core on the host. Duty
+// cycled CPU load never queues work behind a core, while spinning threads
+// beyond one per core wait in the run queues, so procs_running and the per
+// core run queue depths show the contention. The kernel spreads the threads
+// over the cores; runnable tasks of the rest of the host count toward the
+// target. Under -max-cpu fewer threads spin than the target needs.
+func (rm *ResourceMock) holdRunqueue() {
+	defer rm.wg.Done()
+
+	cores := runtime.NumCPU()
+	target := rm.config.runqueue * float64(cores)
+	// A spinning thread is only runnable in the kernel while it holds a P
+	procs := rm.config.capThreads(int(math.Ceil(target)), cores)
+	adjustProcs(procs, "-runqueue")
+	defer adjustProcs(-procs, "-runqueue")
+
+	var threads loadWorkers
+	defer func() {
+		threads.resize(0, nil)
+		threads.wg.Wait()
+	}()
+
+	ticker := time.NewTicker(runqueueInterval)
+	defer ticker.Stop()
+	background := 0.0
+	for {
+		running, err := readProcsRunning()
+		if err != nil {
+			rm.markDegraded("runqueue", err)
+			return
+		}
+		// Not counting the thread reading /proc/stat
+		others := float64(max(0, running-1-len(threads.stops)))
+		background += (others - background) * 0.3
+		n := rm.config.capThreads(int(math.Round(max(0, target*rm.rampIntensity("runqueue")-background))), cores)
+		threads.resize(n, spinner("om-runq-%d"))
+
+		rm.statusMu.Lock()
+		rm.resourceStatus.ProcsRunning = running
+		rm.resourceStatus.RunqueueThreads = n
+		rm.resourceStatus.RunqueueDepth = float64(running) / float64(cores)
+		rm.statusMu.Unlock()
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
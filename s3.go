@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// S3Config moves data to and from an S3-compatible endpoint at a rate that
+// follows the rampup, like a backup job saturating the network. Credentials
+// are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type S3Config struct {
+	S3Endpoint     string `flag:"s3-endpoint" group:"network" usage:"S3-compatible endpoint to upload to and download from, e.g. http://minio:9000"`
+	S3Bucket       string `flag:"s3-bucket" group:"network" usage:"Existing bucket used with -s3-endpoint"`
+	S3Region       string `flag:"s3-region" default:"us-east-1" group:"network" usage:"Region used to sign S3 requests"`
+	S3Prefix       string `flag:"s3-prefix" default:"outagemock/" group:"network" usage:"Key prefix of the objects created; they are deleted at the end"`
+	S3UploadRate   string `flag:"s3-upload-rate" group:"network" usage:"Upload rate reached after rampup, e.g. 50M per second"`
+	S3DownloadRate string `flag:"s3-download-rate" group:"network" usage:"Download rate reached after rampup, e.g. 50M per second"`
+	S3ObjectSize   string `flag:"s3-object-size" default:"64M" group:"network" usage:"Size of every uploaded object"`
+	S3Concurrency  int    `flag:"s3-concurrency" default:"4" group:"network" usage:"Concurrent requests in each direction"`
+}
+
+// parse validates the S3 settings into the unexported rates of config
+func (s S3Config) parse(config *Config) error {
+	if s.S3Endpoint == "" {
+		return nil
+	}
+	var err error
+	if config.s3UploadBps, err = parseByteSize(s.S3UploadRate); err != nil {
+		return fmt.Errorf("invalid -s3-upload-rate: %v", err)
+	}
+	if config.s3DownloadBps, err = parseByteSize(s.S3DownloadRate); err != nil {
+		return fmt.Errorf("invalid -s3-download-rate: %v", err)
+	}
+	if config.s3ObjectSize, err = parseByteSize(s.S3ObjectSize); err != nil || config.s3ObjectSize <= 0 {
+		return fmt.Errorf("invalid -s3-object-size %q", s.S3ObjectSize)
+	}
+	switch {
+	case s.S3Bucket == "":
+		return fmt.Errorf("-s3-endpoint requires -s3-bucket")
+	case config.s3UploadBps == 0 && config.s3DownloadBps == 0:
+		return fmt.Errorf("-s3-endpoint requires -s3-upload-rate or -s3-download-rate")
+	case s.S3Concurrency < 1:
+		return fmt.Errorf("S3 concurrency must be positive")
+	}
+	return nil
+}
+
+// s3Bucket signs and sends object requests to one bucket with AWS Signature Version 4
+type s3Bucket struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// newS3Bucket creates a client for the configured bucket
+func newS3Bucket(config S3Config, netOptions NetOptions) (*s3Bucket, error) {
+	endpoint, err := url.Parse(config.S3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid -s3-endpoint %q", config.S3Endpoint)
+	}
+	if err := netOptions.checkHost(endpoint.Hostname()); err != nil {
+		return nil, fmt.Errorf("invalid -s3-endpoint: %v", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = netOptions.dial
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for -s3-endpoint")
+	}
+	return &s3Bucket{
+		endpoint:  endpoint,
+		bucket:    config.S3Bucket,
+		region:    config.S3Region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// do sends a signed request for an object in path-style addressing
+func (b *s3Bucket) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	u := *b.endpoint
+	u.Path = "/" + b.bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	signV4(req, b.accessKey, b.secretKey, b.region, "s3", "UNSIGNED-PAYLOAD", time.Now())
+	return b.client.Do(req)
+}
+
+// signV4 adds AWS Signature Version 4 headers to req. The host, range and
+// x-amz-* headers are signed; payloadHash is the hex SHA-256 of the body or
+// UNSIGNED-PAYLOAD.
+func signV4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by name as SigV4 requires
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range values[key] {
+			parts = append(parts, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// byteLimiter paces bytes shared by several workers to a rate that may change over time
+type byteLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes may pass at the current rate in bytes per
+// second, and reports false when ctx is done first
+func (l *byteLimiter) wait(ctx context.Context, n int, rate func() float64) bool {
+	r := rate()
+	for r <= 0 {
+		if !sleepCtx(ctx, 100*time.Millisecond) {
+			return false
+		}
+		r = rate()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		// Unused time is not saved up for bursts
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / r * float64(time.Second)))
+	l.mu.Unlock()
+	return sleepCtx(ctx, time.Until(at))
+}
+
+// s3Chunk is the size in which transfers are paced
+const s3Chunk = 64 * 1024
+
+// pacedReader passes at most remaining bytes through a byteLimiter, generating
+// them with content when src is nil
+type pacedReader struct {
+	ctx       context.Context
+	src       io.Reader
+	content   *fileContent
+	limiter   *byteLimiter
+	rate      func() float64
+	remaining int64
+	counter   *atomic.Int64
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if r.src == nil && r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if len(p) > s3Chunk {
+		p = p[:s3Chunk]
+	}
+	if r.src == nil && int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	if !r.limiter.wait(r.ctx, len(p), r.rate) {
+		return 0, r.ctx.Err()
+	}
+	var n int
+	var err error
+	if r.src != nil {
+		n, err = r.src.Read(p)
+	} else {
+		r.content.fill(p)
+		n = len(p)
+		r.remaining -= int64(n)
+	}
+	r.counter.Add(int64(n))
+	return n, err
+}
+
+// s3Stats counts the traffic of the S3 workers
+type s3Stats struct {
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	throttled  atomic.Int64 // 429 and 503 SlowDown responses
+	failed     atomic.Int64
+}
+
+// s3Rate returns a transfer rate in bytes per second that follows the rampup,
+// scaled by host protection and rampdown
+func (rm *ResourceMock) s3Rate(target int64) float64 {
+	return float64(target) * rm.rampIntensity("s3")
+}
+
+const (
+	s3KeyRing       = 64               // Uploads overwrite the oldest of this many objects
+	s3DeleteTimeout = 10 * time.Second // Timeout of every delete at the end
+)
+
+// consumeS3 uploads and downloads objects at the configured rates until the run
+// ends and then deletes every object it created. Uploads cycle through a ring
+// of s3KeyRing keys, so a long run keeps a bounded number of objects.
+func (rm *ResourceMock) consumeS3() {
+	defer rm.wg.Done()
+
+	bucket, err := newS3Bucket(rm.config.S3Config, rm.config.NetOptions)
+	if err != nil {
+		rm.markDegraded("s3", err)
+		return
+	}
+	runPrefix := fmt.Sprintf("%s%d-%d/", rm.config.S3Prefix, os.Getpid(), time.Now().Unix())
+	stats := &s3Stats{}
+
+	var uploads atomic.Int64
+	source := runPrefix + "source"
+	objectSize := rm.config.s3ObjectSize
+	upload := func(key string, content *fileContent, limiter *byteLimiter, rate func() float64) error {
+		body := &pacedReader{ctx: rm.ctx, content: content, limiter: limiter, rate: rate, remaining: objectSize, counter: &stats.uploaded}
+		return rm.s3Request(bucket, stats, http.MethodPut, key, body, objectSize)
+	}
+
+	var workers sync.WaitGroup
+	uploadLimiter, downloadLimiter := &byteLimiter{}, &byteLimiter{}
+	uploadRate := func() float64 { return rm.s3Rate(rm.config.s3UploadBps) }
+	downloadRate := func() float64 { return rm.s3Rate(rm.config.s3DownloadBps) }
+
+	// Downloads read back an object, uploaded first without pacing when only downloading
+	if rm.config.s3DownloadBps > 0 {
+		content := rm.config.fileContent
+		if err := upload(source, &content, &byteLimiter{}, func() float64 { return 1 << 40 }); err != nil {
+			rm.markDegraded("s3", fmt.Errorf("upload object to download: %w", err))
+		} else {
+			for i := 0; i < rm.config.S3Concurrency; i++ {
+				workers.Add(1)
+				go func() {
+					defer workers.Done()
+					for rm.ctx.Err() == nil {
+						rm.s3Download(bucket, stats, source, downloadLimiter, downloadRate)
+					}
+				}()
+			}
+		}
+	}
+	if rm.config.s3UploadBps > 0 {
+		for i := 0; i < rm.config.S3Concurrency; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				content := rm.config.fileContent
+				for rm.ctx.Err() == nil {
+					key := fmt.Sprintf("%sobject-%03d", runPrefix, (uploads.Add(1)-1)%s3KeyRing)
+					upload(key, &content, uploadLimiter, uploadRate)
+				}
+			}()
+		}
+	}
+
+	// Publish the achieved rates
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		rm.trackS3(stats)
+	}()
+	workers.Wait()
+
+	// Delete the objects with fresh contexts since the run's has ended
+	var keys []string
+	if rm.config.s3DownloadBps > 0 {
+		keys = append(keys, source)
+	}
+	for i := int64(0); i < min(uploads.Load(), s3KeyRing); i++ {
+		keys = append(keys, fmt.Sprintf("%sobject-%03d", runPrefix, i))
+	}
+	deleted := 0
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), s3DeleteTimeout)
+		resp, err := bucket.do(ctx, http.MethodDelete, key, nil, 0)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to delete s3 object %s: %v", key, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotFound {
+			deleted++
+		} else {
+			log.Printf("Failed to delete s3 object %s: %s", key, resp.Status)
+		}
+	}
+	log.Printf("S3: uploaded %d MB, downloaded %d MB, %d throttled and %d failed requests, deleted %d of %d objects",
+		stats.uploaded.Load()>>20, stats.downloaded.Load()>>20, stats.throttled.Load(), stats.failed.Load(), deleted, len(keys))
+}
+
+// s3Download reads one object through the download limiter
+func (rm *ResourceMock) s3Download(bucket *s3Bucket, stats *s3Stats, key string, limiter *byteLimiter, rate func() float64) {
+	resp, err := bucket.do(rm.ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		if rm.ctx.Err() == nil {
+			stats.failed.Add(1)
+			sleepCtx(rm.ctx, time.Second)
+		}
+		return
+	}
+	defer resp.Body.Close()
+	if rm.s3Failed(stats, resp) {
+		return
+	}
+	io.Copy(io.Discard, &pacedReader{ctx: rm.ctx, src: resp.Body, limiter: limiter, rate: rate, counter: &stats.downloaded})
+}
+
+// s3Request sends one request and counts its outcome
+func (rm *ResourceMock) s3Request(bucket *s3Bucket, stats *s3Stats, method, key string, body io.Reader, size int64) error {
+	resp, err := bucket.do(rm.ctx, method, key, body, size)
+	if err != nil {
+		if rm.ctx.Err() == nil {
+			stats.failed.Add(1)
+			sleepCtx(rm.ctx, time.Second)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if rm.s3Failed(stats, resp) {
+		return fmt.Errorf("%s %s: %s", method, key, resp.Status)
+	}
+	return nil
+}
+
+// s3Failed counts an unsuccessful response and backs off, longer when throttled
+func (rm *ResourceMock) s3Failed(stats *s3Stats, resp *http.Response) bool {
+	switch {
+	case resp.StatusCode < 300:
+		return false
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		stats.throttled.Add(1)
+	default:
+		stats.failed.Add(1)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		log.Printf("S3 %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	sleepCtx(rm.ctx, time.Second)
+	return true
+}
+
+// trackS3 publishes the measured transfer rates in the resource status
+func (rm *ResourceMock) trackS3(stats *s3Stats) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	prevUp, prevDown, prevTime := int64(0), int64(0), time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			up, down := stats.uploaded.Load(), stats.downloaded.Load()
+			seconds := now.Sub(prevTime).Seconds()
+			rm.statusMu.Lock()
+			rm.resourceStatus.S3UploadMBps = float64(up-prevUp) / seconds / (1 << 20)
+			rm.resourceStatus.S3DownloadMBps = float64(down-prevDown) / seconds / (1 << 20)
+			rm.resourceStatus.S3Throttled = stats.throttled.Load()
+			rm.statusMu.Unlock()
+			prevUp, prevDown, prevTime = up, down, now
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scenario is a set of named lanes consuming resources concurrently in one process.
+//
+//	{
+//	  "settings": {"duration": "5m"},
+//	  "lanes": [
+//	    {"name": "db-disk", "settings": {"fsize": "2G", "fpath": "/data/db"}, "timeline": "db.jsonl"},
+//	    {"name": "api-cpu", "settings": {"cpu": 60, "rampup": "1m"}}
+//	  ]
+//	}
+//
+// Top level settings apply to every lane and are overridden by the lane's own settings.
+// Numeric settings can be distributions like "normal(70,10)" or "uniform(2G,6G)",
+// drawn for every lane each time the scenario is loaded, seeded with -seed. The
+// targets of every point of a lane's timeline can be distributions too.
+// ${NAME} anywhere in the file is replaced by the value given with -set NAME=value
+// or by the environment variable NAME, ${NAME:-default} falls back to default.
+type Scenario struct {
+	Settings   json.RawMessage `json:"settings"`
+	Lanes      []ScenarioLane  `json:"lanes"`
+	Assertions []ScenarioCheck `json:"assertions"` // Evaluated by the test command
+	Guardrails []ScenarioCheck `json:"guardrails"` // Stop the test command's run when violated
+}
+
+// ScenarioLane is one lane of a scenario with its own resources and optional timeline
+type ScenarioLane struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings"`
+	Timeline string          `json:"timeline"` // Timeline file relative to the scenario file
+}
+
+// lane is a scenario lane ready to run
+type lane struct {
+	name    string
+	rm      *ResourceMock
+	display *DisplayManager   // Formats the lane's row of the scenario table
+	samples map[string]string // Settings drawn from distributions, as "value from distribution"
+}
+
+// scenarioVarPattern matches ${NAME} and ${NAME:-default} in scenario files
+var scenarioVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// scenarioVars is a flag.Value collecting repeated -set NAME=value options
+type scenarioVars map[string]string
+
+func (v scenarioVars) String() string {
+	var pairs []string
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v scenarioVars) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || !scenarioVarPattern.MatchString("${"+name+"}") {
+		return fmt.Errorf("expected NAME=value, got %q", s)
+	}
+	v[name] = value
+	return nil
+}
+
+// expandScenario substitutes the variables of a scenario file, preferring
+// -set values over the environment. Outside of quotes values are inserted as
+// they are, so a variable can stand for a number; inside a JSON string they
+// are escaped, so quotes and backslashes in a value can't break the file.
+func expandScenario(data []byte, vars scenarioVars) ([]byte, error) {
+	var missing []string
+	var expanded []byte
+	last, quoted := 0, false
+	for _, m := range scenarioVarPattern.FindAllSubmatchIndex(data, -1) {
+		quoted = inJSONString(data[last:m[0]], quoted)
+		expanded = append(expanded, data[last:m[0]]...)
+		last = m[1]
+		name := string(data[m[2]:m[3]])
+		value, ok := vars[name]
+		if !ok {
+			value, ok = os.LookupEnv(name)
+		}
+		switch {
+		case ok && quoted:
+			escaped, _ := json.Marshal(value)
+			expanded = append(expanded, escaped[1:len(escaped)-1]...)
+		case ok:
+			expanded = append(expanded, value...)
+		case m[4] >= 0:
+			expanded = append(expanded, data[m[6]:m[7]]...)
+		default:
+			missing = append(missing, name)
+			expanded = append(expanded, data[m[0]:m[1]]...)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined variables %s, set them with -set NAME=value or in the environment", strings.Join(missing, ", "))
+	}
+	return append(expanded, data[last:]...), nil
+}
+
+// inJSONString reports whether the end of data is inside a JSON string,
+// given whether its start is
+func inJSONString(data []byte, quoted bool) bool {
+	for i := 0; i < len(data); i++ {
+		switch {
+		case quoted && data[i] == '\\':
+			i++
+		case data[i] == '"':
+			quoted = !quoted
+		}
+	}
+	return quoted
+}
+
+// loadScenario reads a scenario file, substitutes its variables and builds
+// the validated config of every lane, drawing distributions from rng
+func loadScenario(path string, vars scenarioVars, rng *rand.Rand) ([]lane, Scenario, error) {
+	var scenario Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, scenario, fmt.Errorf("read scenario: %w", err)
+	}
+	if err := jsonOnly(path, data); err != nil {
+		return nil, scenario, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	if data, err = expandScenario(data, vars); err != nil {
+		return nil, scenario, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, scenario, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	lanes, err := scenario.lanes(path, rng)
+	if err != nil {
+		return nil, scenario, err
+	}
+	for _, checks := range [][]ScenarioCheck{scenario.Assertions, scenario.Guardrails} {
+		for i := range checks {
+			if err := checks[i].validate(lanes); err != nil {
+				return nil, scenario, fmt.Errorf("scenario %s: %w", path, err)
+			}
+		}
+	}
+	return lanes, scenario, nil
+}
+
+// lanes builds the validated config of every lane of the scenario read from path
+func (scenario Scenario) lanes(path string, rng *rand.Rand) ([]lane, error) {
+	if len(scenario.Lanes) == 0 {
+		return nil, fmt.Errorf("scenario %s: no lanes", path)
+	}
+
+	shared, err := decodeRawSettings(scenario.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: settings: %w", path, err)
+	}
+
+	var lanes []lane
+	names := make(map[string]bool)
+	for i, spec := range scenario.Lanes {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("scenario %s: lane %d has no name", path, i+1)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("scenario %s: duplicate lane %q", path, spec.Name)
+		}
+		names[spec.Name] = true
+
+		own, err := decodeRawSettings(spec.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: lane %s: %w", path, spec.Name, err)
+		}
+		values := make(map[string]string)
+		for name, value := range shared {
+			values[name] = value
+		}
+		for name, value := range own {
+			values[name] = value
+		}
+		// Keep work files of different lanes apart
+		if _, ok := values["fpath"]; !ok {
+			values["fpath"] = "outagemock_" + spec.Name
+		}
+		samples, err := sampleSettings(values, rng)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: lane %s: %w", path, spec.Name, err)
+		}
+
+		config, err := configFromSettings(values)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: lane %s: %w", path, spec.Name, err)
+		}
+		if config.User != "" {
+			return nil, fmt.Errorf("scenario %s: lane %s: -user would drop root for all lanes, run the scenario as that user instead", path, spec.Name)
+		}
+		if config.Netns != "" || config.Unshare != "" || config.TargetContainer != "" || config.SystemdRun {
+			return nil, fmt.Errorf("scenario %s: lane %s: -netns, -unshare, -target-container and -systemd-run are only supported by the run command", path, spec.Name)
+		}
+		config.lane = spec.Name
+
+		var timeline Timeline
+		if spec.Timeline != "" && (config.Emulate != "" || config.Markov != "") {
+			return nil, fmt.Errorf("scenario %s: lane %s: -emulate and -markov are mutually exclusive with a timeline", path, spec.Name)
+		}
+		if spec.Timeline != "" {
+			timelinePath := spec.Timeline
+			if !filepath.IsAbs(timelinePath) {
+				timelinePath = filepath.Join(filepath.Dir(path), timelinePath)
+			}
+			var drawn map[string]string
+			if timeline, drawn, err = loadSampledTimeline(timelinePath, rng); err != nil {
+				return nil, fmt.Errorf("scenario %s: lane %s: %w", path, spec.Name, err)
+			}
+			for name, value := range drawn {
+				samples[name] = value
+			}
+			_, hasDuration := values["duration"]
+			if err := config.applyTimeline(timeline, !hasDuration); err != nil {
+				return nil, fmt.Errorf("scenario %s: lane %s: %w", path, spec.Name, err)
+			}
+		}
+		lanes = append(lanes, lane{name: spec.Name, rm: newLaneMock(config, timeline), samples: samples})
+	}
+
+	for i := range lanes {
+		for j := i + 1; j < len(lanes); j++ {
+			a, b := lanes[i].rm.config, lanes[j].rm.config
+			if a.FileSizeMB > 0 && b.FileSizeMB > 0 && a.FilePath == b.FilePath {
+				return nil, fmt.Errorf("scenario %s: lanes %s and %s write the same file %s", path, lanes[i].name, lanes[j].name, a.FilePath)
+			}
+		}
+	}
+	return lanes, nil
+}
+
+// newLaneMock creates the resource mock of a lane, following timeline if it has one
+func newLaneMock(config Config, timeline Timeline) *ResourceMock {
+	rm := NewResourceMock(config)
+	if timeline != nil {
+		rm.timeline = timeline
+	}
+	return rm
+}
+
+// decodeRawSettings decodes an optional JSON object of flag values
+func decodeRawSettings(raw json.RawMessage) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return decodeSettings(bytes.NewReader(raw))
+}
+
+// scenarioCommand runs all lanes of a scenario file concurrently
+func scenarioCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	file := fs.String("file", "", "JSON scenario file with the lanes to run (required, convert YAML with e.g. yq -o=json)")
+	vars := scenarioVars{}
+	fs.Var(vars, "set", "Set the scenario variable ${NAME}, as NAME=value; repeatable, overrides the environment")
+	timeScale := fs.String("time-scale", "", "Run every lane this many times faster than real time, e.g. 10x to rehearse the timelines; overrides -time-scale of the lanes")
+	seed := fs.Int64("seed", 0, "Seed of the settings drawn from distributions (0 = random, printed to repeat the run)")
+	var quota Quota
+	bindFlags(fs, &quota)
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if *file == "" {
+		return exitCodeFor(fmt.Errorf("-file is required"))
+	}
+	rng, usedSeed := newSampler(*seed)
+	lanes, _, err := loadScenario(*file, vars, rng)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	// The lanes share the process, the first one decides where its output goes
+	if err := openOutput(lanes[0].rm.config); err != nil {
+		return exitCodeFor(err)
+	}
+	printSamples(lanes, usedSeed)
+	for _, l := range lanes {
+		l.rm.config.Quota = l.rm.config.Quota.tighten(quota)
+	}
+	if err := scaleLanes(lanes, *timeScale); err != nil {
+		return exitCodeFor(err)
+	}
+	return runLanes(lanes, notifyRunSignals())
+}
+
+// sampleNames returns the settings of the lane drawn from distributions, sorted
+func (l lane) sampleNames() []string {
+	var names []string
+	for name := range l.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printSamples lists the settings drawn from distributions with their seed
+func printSamples(lanes []lane, seed int64) {
+	drawn := false
+	for _, l := range lanes {
+		for _, name := range l.sampleNames() {
+			if !drawn {
+				textf("Drawn with seed %d:", seed)
+				drawn = true
+			}
+			textf("  %s: %s=%s", l.name, name, l.samples[name])
+		}
+	}
+}
+
+// runLanes runs every lane until all of them have finished and returns the
+// first non-zero exit code. Signals are forwarded to every lane.
+func runLanes(lanes []lane, signals <-chan os.Signal) int {
+	stops := make([]chan os.Signal, len(lanes))
+	codes := make([]int, len(lanes))
+	var wg sync.WaitGroup
+	for i := range lanes {
+		l := &lanes[i]
+		textf("Lane %s:", l.name)
+		printStartup(l.rm.config)
+		l.display = NewDisplayManager(&l.rm.config, l.rm.clock, l.rm.clock.Now())
+		stops[i] = make(chan os.Signal, 1)
+		wg.Add(1)
+		go func(i int, rm *ResourceMock) {
+			defer wg.Done()
+			codes[i] = rm.Run(stops[i])
+		}(i, l.rm)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	laneTable := newTable(&lanes[0].rm.config, laneColumns)
+	laneTable.printHeader()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case sig := <-signals:
+			for _, stop := range stops {
+				select {
+				case stop <- sig:
+				default:
+				}
+			}
+		case <-ticker.C:
+			for _, l := range lanes {
+				showLaneStatus(laneTable, l)
+			}
+		}
+	}
+
+	for i, code := range codes {
+		if code != 0 {
+			messagef("Lane %s failed with exit code %d", lanes[i].name, code)
+			return code
+		}
+	}
+	return 0
+}
+
+// laneColumns are the columns of the scenario table, the status of each lane
+var laneColumns = append([]column{{"lane", "Lane", "", 12, 8}}, statusColumns...)
+
+// showLaneStatus displays the status row of one lane
+func showLaneStatus(t *table, l lane) {
+	if l.rm.ctx.Err() != nil {
+		return
+	}
+	cells := l.display.statusCells(l.rm.Status())
+	t.printRow(append([]string{l.name}, cells...))
+}
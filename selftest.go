@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Targets of the self-test bursts, small enough for any node
+const (
+	selftestCPU      = 50.0 // Percent of the host
+	selftestMemoryMB = 256
+	selftestFileMB   = 128
+)
+
+// selftestResult is the load one burst of the self-test achieved
+type selftestResult struct {
+	Resource string  `json:"resource"`
+	Target   float64 `json:"target"`
+	Achieved float64 `json:"achieved"`
+	Unit     string  `json:"unit"`
+	OK       bool    `json:"ok"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// capabilityResult tells whether a mechanism some faults rely on works on this host
+type capabilityResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// selftestReport is the outcome of the self-test
+type selftestReport struct {
+	Host         string             `json:"host"`
+	Cores        int                `json:"cores"`
+	Bursts       []selftestResult   `json:"bursts"`
+	Capabilities []capabilityResult `json:"capabilities"`
+}
+
+// runBurst applies the load of one consumer for d, configured by flag values,
+// and returns what done measures at the end while the load is still applied.
+// settled is called once the load had a third of d to settle.
+func runBurst(ctx context.Context, settings map[string]string, d time.Duration, consume func(*ResourceMock), settled func(), done func(*ResourceMock) float64) (float64, error) {
+	settings["rampup"] = "0s"
+	settings["duration"] = "1h" // Ended by Cleanup
+	config, err := configFromSettings(settings)
+	if err != nil {
+		return 0, err
+	}
+	rm := NewResourceMock(config)
+	defer rm.Cleanup()
+	rm.rampupStart = rm.clock.Now()
+	rm.wg.Add(1)
+	go consume(rm)
+
+	if !sleepCtx(ctx, d/3) {
+		return 0, ctx.Err()
+	}
+	settled()
+	if !sleepCtx(ctx, d-d/3) {
+		return 0, ctx.Err()
+	}
+	return done(rm), nil
+}
+
+// selfRSSMB returns the resident memory of this process
+func selfRSSMB() float64 {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, _ := strconv.ParseFloat(fields[1], 64)
+			return kb / 1024
+		}
+	}
+	return 0
+}
+
+// selftestBursts loads CPU, memory and the disk in turn for d each and
+// measures the achieved load independently of the consumers' own accounting
+func selftestBursts(ctx context.Context, filePath string, d time.Duration, tolerance float64) []selftestResult {
+	var results []selftestResult
+	add := func(resource, unit string, target float64, achieved float64, err error) {
+		r := selftestResult{Resource: resource, Target: target, Achieved: achieved, Unit: unit}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.OK = achieved >= target*(1-tolerance) && achieved <= target*(1+tolerance)
+		}
+		results = append(results, r)
+	}
+
+	// CPU time of the process over the settled part of the burst
+	var cpu time.Duration
+	var since time.Time
+	achieved, err := runBurst(ctx, map[string]string{"cpu": fmt.Sprint(selftestCPU)}, d, (*ResourceMock).consumeCPU,
+		func() { cpu, since = processCPUTime(), time.Now() },
+		func(*ResourceMock) float64 {
+			return 100 * float64(processCPUTime()-cpu) / float64(time.Since(since)) / float64(runtime.NumCPU())
+		})
+	add("cpu", "%", selftestCPU, achieved, err)
+
+	// Growth of the resident memory of the process
+	baseline := selfRSSMB()
+	achieved, err = runBurst(ctx, map[string]string{"memory": fmt.Sprint(selftestMemoryMB)}, d, (*ResourceMock).consumeMemory,
+		func() {},
+		func(*ResourceMock) float64 { return selfRSSMB() - baseline })
+	add("memory", "MB", selftestMemoryMB, achieved, err)
+
+	// Blocks allocated to the work file
+	achieved, err = runBurst(ctx, map[string]string{"fsize": fmt.Sprintf("%dM", selftestFileMB), "fpath": filePath}, d, (*ResourceMock).consumeFile,
+		func() {},
+		func(rm *ResourceMock) float64 { return float64(allocatedBytes(rm.filePath)) / (1 << 20) })
+	add("file", "MB", selftestFileMB, achieved, err)
+	return results
+}
+
+// probeCapabilities checks the mechanisms that need privileges or kernel
+// support, so missing ones are known before an experiment relies on them
+func probeCapabilities(cgroupRoot string) []capabilityResult {
+	var results []capabilityResult
+	add := func(name string, err error, detail string) {
+		r := capabilityResult{Name: name, OK: err == nil, Detail: detail}
+		if err != nil {
+			r.Detail = err.Error()
+		}
+		results = append(results, r)
+	}
+
+	// cgroup v2 for -io-throttle and friends
+	cg, err := createCgroup(cgroupRoot, fmt.Sprintf("outagemock-selftest-%d", os.Getpid()))
+	if err == nil {
+		cg.remove()
+	}
+	add("cgroup", err, "cgroup v2 at "+cgroupRoot)
+
+	// mlock for -mlock
+	limit, _, _ := memlockLimit()
+	detail := fmt.Sprintf("RLIMIT_MEMLOCK %d KB, more needs root", limit>>10)
+	if limit == rlimInfinity || os.Geteuid() == 0 {
+		detail = "unlimited"
+	}
+	page, err := mmap(nil, 0, os.Getpagesize(), 0)
+	if err == nil {
+		if err = mlock(page); err == nil {
+			munlock(page)
+		}
+		munmap(page)
+	}
+	add("mlock", err, detail)
+
+	// tc for traffic shaping, which needs CAP_NET_ADMIN
+	tc, err := exec.LookPath("tc")
+	if err == nil && !hasCapability(capNetAdmin) {
+		err = fmt.Errorf("%s found, but CAP_NET_ADMIN is missing", tc)
+	}
+	add("tc", err, tc+" with CAP_NET_ADMIN")
+
+	// FUSE for -fuse-mount
+	fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err == nil {
+		syscall.Close(fd)
+	} else {
+		err = fmt.Errorf("open /dev/fuse: %w", err)
+	}
+	add("fuse", err, "/dev/fuse")
+
+	// cpufreq for -governor
+	governor := "/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor"
+	var f *os.File
+	if f, err = os.OpenFile(governor, os.O_WRONLY, 0); err == nil {
+		f.Close()
+	}
+	add("governor", err, governor)
+
+	// Pressure stall information for the status
+	_, err = os.ReadFile("/proc/pressure/cpu")
+	add("psi", err, "/proc/pressure")
+	return results
+}
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in the capability sets
+const capNetAdmin = 12
+
+// hasCapability reports whether cap is in the effective capabilities of this process
+func hasCapability(cap uint) bool {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if hex, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			caps, _ := strconv.ParseUint(strings.TrimSpace(hex), 16, 64)
+			return caps&(1<<cap) != 0
+		}
+	}
+	return false
+}
+
+// print writes the report as a table
+func (r selftestReport) print() {
+	fmt.Printf("Self-test on %s (%d cores):\n", r.Host, r.Cores)
+	for _, b := range r.Bursts {
+		verdict := "ok"
+		if b.Error != "" {
+			verdict = "FAILED: " + b.Error
+		} else if !b.OK {
+			verdict = "OUT OF TOLERANCE"
+		}
+		fmt.Printf("  %-8s target %6.1f %-2s achieved %6.1f %-2s %s\n", b.Resource, b.Target, b.Unit, b.Achieved, b.Unit, verdict)
+	}
+	printCapabilities(os.Stdout, r.Capabilities)
+}
+
+// printCapabilities writes the results of probeCapabilities as a table
+func printCapabilities(w io.Writer, capabilities []capabilityResult) {
+	fmt.Fprintln(w, "Capabilities:")
+	for _, c := range capabilities {
+		verdict := "no "
+		if c.OK {
+			verdict = "yes"
+		}
+		fmt.Fprintf(w, "  %-8s %s  %s\n", c.Name, verdict, c.Detail)
+	}
+}
+
+// selftestCommand checks that the load reaches its targets on this host and
+// which fault mechanisms are usable, as a validation step for new node images
+func selftestCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	filePath := fs.String("fpath", filepath.Join(os.TempDir(), "outagemock_selftest"), "Work file of the file burst, on the filesystem to check")
+	burst := fs.Duration("time", 5*time.Second, "Length of each burst")
+	tolerance := fs.Float64("tolerance", 10, "Largest deviation of the achieved load from the target in percent")
+	cgroupRoot := fs.String("cgroup-root", cgroupMount, "cgroup v2 directory to check creating cgroups in")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if *burst < time.Second {
+		return exitCodeFor(fmt.Errorf("-time must be at least 1s"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := notifySignals()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	host, _ := os.Hostname()
+	report := selftestReport{
+		Host:         host,
+		Cores:        runtime.NumCPU(),
+		Bursts:       selftestBursts(ctx, *filePath, *burst, *tolerance/100),
+		Capabilities: probeCapabilities(*cgroupRoot),
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		report.print()
+	}
+	for _, b := range report.Bursts {
+		if !b.OK {
+			return 1
+		}
+	}
+	return 0
+}
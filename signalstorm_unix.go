@@ -0,0 +1,169 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxSignalRate caps -signal-storm so a typo can't turn it into a busy loop of kill(2)
+const maxSignalRate = 10000
+
+// signalNames are the signals -signal-storm may send. SIGKILL and SIGSTOP can't
+// be handled, so storming with them tests nothing but kills or stops the target.
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":    syscall.SIGHUP,
+	"SIGINT":    syscall.SIGINT,
+	"SIGQUIT":   syscall.SIGQUIT,
+	"SIGUSR1":   syscall.SIGUSR1,
+	"SIGUSR2":   syscall.SIGUSR2,
+	"SIGPIPE":   syscall.SIGPIPE,
+	"SIGALRM":   syscall.SIGALRM,
+	"SIGTERM":   syscall.SIGTERM,
+	"SIGCHLD":   syscall.SIGCHLD,
+	"SIGCONT":   syscall.SIGCONT,
+	"SIGURG":    syscall.SIGURG,
+	"SIGWINCH":  syscall.SIGWINCH,
+	"SIGIO":     syscall.SIGIO,
+	"SIGPROF":   syscall.SIGPROF,
+	"SIGVTALRM": syscall.SIGVTALRM,
+}
+
+// SignalStorm describes the signals sent with -signal-storm
+type SignalStorm struct {
+	PID    int
+	Signal syscall.Signal
+	Rate   float64 // Signals per second after rampup
+}
+
+// parseSignalStorm parses a list like "pid=1234,signal=SIGUSR1,rate=100/s".
+// signal defaults to SIGUSR1 and rate to 100/s.
+func parseSignalStorm(s string) (SignalStorm, error) {
+	storm := SignalStorm{Signal: syscall.SIGUSR1, Rate: 100}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return SignalStorm{}, fmt.Errorf("invalid signal storm setting %q (expected key=value)", item)
+		}
+		switch key {
+		case "pid":
+			pid, err := strconv.Atoi(value)
+			if err != nil || pid <= 1 {
+				return SignalStorm{}, fmt.Errorf("invalid signal storm pid %q (init and process groups are not allowed)", value)
+			}
+			if ownProcess(pid) {
+				return SignalStorm{}, fmt.Errorf("invalid signal storm pid %d: it is outagemock itself or one of its children", pid)
+			}
+			storm.PID = pid
+		case "signal":
+			name := strings.ToUpper(value)
+			if !strings.HasPrefix(name, "SIG") {
+				name = "SIG" + name
+			}
+			sig, ok := signalNames[name]
+			if !ok {
+				return SignalStorm{}, fmt.Errorf("unsupported signal %q for a signal storm", value)
+			}
+			storm.Signal = sig
+		case "rate":
+			rate, err := strconv.ParseFloat(strings.TrimSuffix(value, "/s"), 64)
+			if err != nil || rate <= 0 || rate > maxSignalRate {
+				return SignalStorm{}, fmt.Errorf("invalid signal storm rate %q (expected e.g. 100/s, at most %d/s)", value, maxSignalRate)
+			}
+			storm.Rate = rate
+		default:
+			return SignalStorm{}, fmt.Errorf("unknown signal storm setting %q (supported: pid, signal, rate)", key)
+		}
+	}
+	if storm.PID == 0 {
+		return SignalStorm{}, fmt.Errorf("invalid signal storm %q: pid is required", s)
+	}
+	return storm, nil
+}
+
+// signalStorm sends signals to the target process at the configured rate until
+// the run ends or the process exits. The rate grows linearly during rampup and
+// is scaled by host protection and rampdown.
+func (rm *ResourceMock) signalStorm() {
+	defer rm.wg.Done()
+
+	storm := rm.config.signalStorm
+	// The pid may have been reused by the -run-cmd child since it was parsed
+	if ownProcess(storm.PID) {
+		rm.markDegraded("signals", fmt.Errorf("process %d is outagemock itself or one of its children", storm.PID))
+		return
+	}
+	log.Printf("Sending %v to process %d at up to %.0f/s", storm.Signal, storm.PID, storm.Rate)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	due := 0.0
+	last := time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			due += storm.Rate * rm.rampIntensity("signals") * now.Sub(last).Seconds()
+			last = now
+
+			sent := int64(0)
+			for ; due >= 1; due-- {
+				if err := syscall.Kill(storm.PID, storm.Signal); err != nil {
+					if errors.Is(err, syscall.ESRCH) {
+						log.Printf("Process %d exited, stopping signal storm", storm.PID)
+					} else {
+						rm.markDegraded("signals", fmt.Errorf("signal process %d: %w", storm.PID, err))
+					}
+					return
+				}
+				sent++
+			}
+			if sent > 0 {
+				rm.statusMu.Lock()
+				rm.resourceStatus.SignalsSent += sent
+				rm.statusMu.Unlock()
+			}
+		}
+	}
+}
+
+// ownProcess reports whether pid is this process or one of its descendants,
+// which a signal storm would take down with it. Without /proc only this
+// process itself is known.
+func ownProcess(pid int) bool {
+	self := os.Getpid()
+	for pid > 1 {
+		if pid == self {
+			return true
+		}
+		ppid, err := parentPID(pid)
+		if err != nil {
+			return false
+		}
+		pid = ppid
+	}
+	return false
+}
+
+// parentPID returns the parent of process pid from /proc
+func parentPID(pid int) (int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces, fields are counted after its closing parenthesis
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("short stat for process %d", pid)
+	}
+	return strconv.Atoi(fields[1])
+}
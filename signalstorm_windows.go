@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+// SignalStorm describes the signals sent with -signal-storm, which needs kill(2)
+type SignalStorm struct{}
+
+// parseSignalStorm rejects -signal-storm, Windows processes have no signal handlers to test
+func parseSignalStorm(s string) (SignalStorm, error) {
+	return SignalStorm{}, fmt.Errorf("-signal-storm is not supported on Windows, which has no signals to send")
+}
+
+// signalStorm is never started, parseSignalStorm rejects -signal-storm
+func (rm *ResourceMock) signalStorm() {
+	defer rm.wg.Done()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SoftirqSpec is the event rate -softirq drives, parsed from e.g. net=200000,timers=20000
+type SoftirqSpec struct {
+	Net    int64 // Loopback UDP packets per second, processed in the NET_RX softirq
+	Timers int64 // timerfd expirations per second
+}
+
+// softirqSenders is the number of goroutines sending -softirq loopback packets
+const softirqSenders = 4
+
+// softirqPacketBytes is the payload of a loopback packet; small packets
+// maximize the per packet softirq work for the bytes copied
+const softirqPacketBytes = 64
+
+// timerHz is the most a single -softirq timerfd fires per second, higher
+// rates are spread over more timers
+const timerHz = 1000
+
+// parseSoftirqSpec parses -softirq
+func parseSoftirqSpec(s string) (SoftirqSpec, error) {
+	counts, err := parseCounts("-softirq", s, "net", "timers")
+	if err != nil {
+		return SoftirqSpec{}, err
+	}
+	return SoftirqSpec{Net: counts["net"], Timers: counts["timers"]}, nil
+}
+
+// softirqRate returns the current share of a -softirq rate, growing linearly
+// during rampup and scaled by host protection and rampdown
+func (rm *ResourceMock) softirqRate(target int64) float64 {
+	return float64(target) * rm.rampIntensity("softirq")
+}
+
+// sendLoopback sends its share of the -softirq packets to addr
+func (rm *ResourceMock) sendLoopback(id int, addr *net.UDPAddr, sent *atomic.Int64) {
+	defer nameThread("om-softirq-n%d", id)()
+	conn, err := net.DialUDP(rm.config.network("udp"), nil, addr)
+	if err != nil {
+		rm.markDegraded("softirq", fmt.Errorf("sender %d: %w", id, err))
+		return
+	}
+	defer conn.Close()
+
+	payload := make([]byte, softirqPacketBytes)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	due, last := 0.0, time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			due += rm.softirqRate(rm.config.softirq.Net) / softirqSenders * now.Sub(last).Seconds()
+			last = now
+			n := int64(0)
+			for ; due >= 1; due-- {
+				// A full receive queue drops packets, which still costs softirq time
+				if _, err := conn.Write(payload); err == nil {
+					n++
+				}
+			}
+			sent.Add(n)
+		}
+	}
+}
+
+// pressureSoftirq drives softirq CPU with -softirq: loopback UDP packets are
+// processed by NET_RX softirqs, often in ksoftirqd, and timerfd storms add
+// timer interrupts. Monitoring shows this as si or softirq time rather than
+// user CPU, which pure CPU load can't reproduce.
+func (rm *ResourceMock) pressureSoftirq() {
+	defer rm.wg.Done()
+
+	spec := rm.config.softirq
+	var sent, fired atomic.Int64
+	var workers sync.WaitGroup
+	var sink *net.UDPConn
+	if spec.Net > 0 {
+		var err error
+		loopback := net.IPv4(127, 0, 0, 1)
+		if rm.config.NetFamily == "6" {
+			loopback = net.IPv6loopback
+		}
+		if sink, err = net.ListenUDP(rm.config.network("udp"), &net.UDPAddr{IP: loopback}); err != nil {
+			rm.markDegraded("softirq", fmt.Errorf("receiver: %w", err))
+			return
+		}
+		sink.SetReadBuffer(4 << 20)
+		addr := sink.LocalAddr().(*net.UDPAddr)
+		for i := 0; i < softirqSenders; i++ {
+			workers.Add(1)
+			go func(id int) {
+				defer workers.Done()
+				rm.sendLoopback(id, addr, &sent)
+			}(i)
+		}
+		// Drained until the socket is closed at the end
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			buf := make([]byte, 2048)
+			for {
+				if _, _, err := sink.ReadFromUDP(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	if spec.Timers > 0 {
+		raiseNofile()
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			rm.fireTimers(&fired)
+		}()
+	}
+	log.Printf("Driving softirqs with up to %d loopback packets/s and %d timer expirations/s", spec.Net, spec.Timers)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	prevTimes, _ := readCPUTimes()
+	prevSent, prevFired, last := int64(0), int64(0), time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			if sink != nil {
+				sink.Close()
+			}
+			workers.Wait()
+			return
+		case now := <-ticker.C:
+			seconds := now.Sub(last).Seconds()
+			packets, expirations := sent.Load(), fired.Load()
+			times, err := readCPUTimes()
+			rm.statusMu.Lock()
+			rm.resourceStatus.SoftirqPPS = float64(packets-prevSent) / seconds
+			rm.resourceStatus.SoftirqTimerHz = float64(expirations-prevFired) / seconds
+			if err == nil {
+				rm.resourceStatus.SoftirqPct = softirqPercent(prevTimes, times)
+				prevTimes = times
+			}
+			rm.statusMu.Unlock()
+			prevSent, prevFired, last = packets, expirations, now
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// fireTimers keeps timerfds firing at the -softirq timer rate and reads
+// their expirations, since an unread timerfd stops firing
+func (rm *ResourceMock) fireTimers(fired *atomic.Int64) {
+	defer nameThread("om-softirq-t")()
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		rm.markDegraded("softirq", fmt.Errorf("timers: %w", err))
+		return
+	}
+	defer syscall.Close(epfd)
+	var timers []int
+	defer func() {
+		for _, fd := range timers {
+			syscall.Close(fd)
+		}
+	}()
+
+	events := make([]syscall.EpollEvent, 128)
+	buf := make([]byte, 8)
+	armed, adjusted := 0.0, time.Time{}
+	short := false // Reported the timers stopping short of the rate
+	for rm.ctx.Err() == nil {
+		if time.Since(adjusted) >= time.Second {
+			adjusted = time.Now()
+			// Re-arming every timer is only worth it when the rate moved noticeably
+			if want := rm.softirqRate(rm.config.softirq.Timers); math.Abs(want-armed) > armed*0.05 {
+				n := int(math.Ceil(want / timerHz))
+				for len(timers) > n {
+					syscall.Close(timers[len(timers)-1])
+					timers = timers[:len(timers)-1]
+				}
+				for len(timers) < n {
+					fd, err := createTimer()
+					if err != nil {
+						if !short {
+							short = true
+							rm.markDegraded("softirq", fmt.Errorf("timers stopped at %d: %w", len(timers), err))
+						}
+						break
+					}
+					event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+					syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event)
+					timers = append(timers, fd)
+				}
+				interval := time.Duration(0)
+				if want > 0 && len(timers) > 0 {
+					interval = time.Duration(float64(len(timers)) / want * float64(time.Second))
+				}
+				for _, fd := range timers {
+					setTimer(fd, interval)
+				}
+				armed = want
+			}
+		}
+
+		n, err := syscall.EpollWait(epfd, events, 100)
+		if err != nil && err != syscall.EINTR {
+			rm.markDegraded("softirq", fmt.Errorf("timers: %w", err))
+			return
+		}
+		for _, event := range events[:max(n, 0)] {
+			if _, err := syscall.Read(int(event.Fd), buf); err == nil {
+				fired.Add(int64(binary.NativeEndian.Uint64(buf)))
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// fireTimers degrades, the timer storm of -softirq is made of timerfds
+func (rm *ResourceMock) fireTimers(fired *atomic.Int64) {
+	rm.markDegraded("softirq", fmt.Errorf("timers: %w", errNotLinux))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// remoteRunScript runs the uploaded binary and guarantees cleanup on the remote host.
+// The local side keeps the session's stdin open for the whole run; when it goes
+// away (interrupt, lost connection) the watchdog stops the run, which removes its
+// work file. The work file is removed again afterwards in case the run was killed,
+// and the binary is always deleted.
+const remoteRunScript = `bin=%[1]s
+exec 3<&0
+"$bin" run %[2]s </dev/null 3<&- &
+pid=$!
+(cat <&3 >/dev/null; kill -TERM $pid) >/dev/null 2>&1 &
+watchdog=$!
+wait $pid
+code=$?
+kill $watchdog 2>/dev/null
+exec 3<&-
+"$bin" cleanup -fpath %[3]s >/dev/null 2>&1
+rm -f "$bin"
+exit $code`
+
+// remoteUploadScript stores the binary read from stdin in a fresh temporary file and prints its path
+const remoteUploadScript = `bin=$(mktemp /tmp/outagemock.XXXXXX) && cat >"$bin" && chmod 700 "$bin" && echo "$bin"`
+
+// sshCommand runs an experiment on every host of a hosts file over SSH, without an agent.
+// Flags after -- are the experiment's run flags.
+func sshCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	hostsPath := fs.String("hosts", "", "File with one SSH destination (host or user@host) per line; # starts a comment")
+	sshPath := fs.String("ssh", "ssh", "SSH client to run")
+	sshOpts := fs.String("ssh-opts", "", "Extra options passed to the SSH client, e.g. \"-i key -p 2222\"")
+	binary := fs.String("binary", "", "outagemock binary copied to the hosts (default this executable; use a cross-compiled one for other architectures)")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if *hostsPath == "" {
+		return exitCodeFor(fmt.Errorf("-hosts is required"))
+	}
+	hosts, err := readHosts(*hostsPath)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	settings, err := settingsFromArgs(fs.Args())
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	config, err := configFromSettings(settings)
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	if *binary == "" {
+		if *binary, err = os.Executable(); err != nil {
+			return exitCodeFor(fmt.Errorf("locate binary: %w", err))
+		}
+	}
+
+	client := sshClient{path: *sshPath, opts: strings.Fields(*sshOpts)}
+	runArgs := runArgsFromSettings(settings)
+	out := &prefixWriter{}
+
+	// Closing stop ends every remote run through its watchdog
+	stop := make(chan struct{})
+	go func() {
+		sig := <-notifySignals()
+		fmt.Printf("Received signal %v, stopping remote runs...\n", sig)
+		close(stop)
+	}()
+
+	codes := make([]int, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			codes[i] = client.run(host, *binary, runArgs, config.FilePath, out, stop)
+		}(i, host)
+	}
+	wg.Wait()
+
+	exitCode := 0
+	for i, host := range hosts {
+		fmt.Printf("%-24s exit %d\n", host, codes[i])
+		if codes[i] != 0 && exitCode == 0 {
+			exitCode = codes[i]
+		}
+	}
+	return exitCode
+}
+
+// readHosts reads the SSH destinations of a hosts file
+func readHosts(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			hosts = append(hosts, line)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", path)
+	}
+	return hosts, nil
+}
+
+// runArgsFromSettings turns settings back into run flags in a stable order
+func runArgsFromSettings(settings map[string]string) []string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, "-"+name+"="+settings[name])
+	}
+	return args
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshClient runs commands on remote hosts with an external SSH client
+type sshClient struct {
+	path string
+	opts []string
+}
+
+// command builds the SSH invocation running script on host
+func (c sshClient) command(host, script string) *exec.Cmd {
+	args := append([]string{"-T", "-o", "BatchMode=yes"}, c.opts...)
+	args = append(args, host, script)
+	return exec.Command(c.path, args...)
+}
+
+// run uploads the binary to host, runs the experiment there streaming its
+// output, and returns the remote exit code
+func (c sshClient) run(host, binary string, runArgs []string, filePath string, out *prefixWriter, stop <-chan struct{}) int {
+	remoteBin, err := c.upload(host, binary)
+	if err != nil {
+		out.printf(host, "upload failed: %v", err)
+		return 1
+	}
+
+	quoted := make([]string, len(runArgs))
+	for i, arg := range runArgs {
+		quoted[i] = shellQuote(arg)
+	}
+	cmd := c.command(host, fmt.Sprintf(remoteRunScript, shellQuote(remoteBin), strings.Join(quoted, " "), shellQuote(filePath)))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		out.printf(host, "%v", err)
+		return 1
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		out.printf(host, "%v", err)
+		return 1
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		out.printf(host, "start ssh: %v", err)
+		return 1
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		}
+		stdin.Close()
+	}()
+	out.copyLines(host, stdout)
+	err = cmd.Wait()
+	close(done)
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		out.printf(host, "%v", err)
+		return 1
+	}
+	return 0
+}
+
+// upload copies the binary to a temporary file on host and returns its path
+func (c sshClient) upload(host, binary string) (string, error) {
+	f, err := os.Open(binary)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cmd := c.command(host, remoteUploadScript)
+	cmd.Stdin = f
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	path := strings.TrimSpace(string(output))
+	if path == "" {
+		return "", fmt.Errorf("no remote path reported")
+	}
+	return path, nil
+}
+
+// prefixWriter prints the output of several hosts line by line, each line prefixed with its host
+type prefixWriter struct {
+	mu sync.Mutex
+}
+
+// printf prints one line for host
+func (w *prefixWriter) printf(host, format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Printf("[%s] %s\n", host, fmt.Sprintf(format, args...))
+}
+
+// copyLines prints every line read from r until it ends
+func (w *prefixWriter) copyLines(host string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		w.printf(host, "%s", scanner.Text())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runState is recorded next to the work file while a run is active, so the
+// next run with the same -fpath can clean up after a crash or resume it
+type runState struct {
+	PID           int               `json:"pid"`
+	Program       string            `json:"program"` // Program name, to tell a live run from a reused pid
+	RampupStart   time.Time         `json:"rampup_start"`
+	Deadline      time.Time         `json:"deadline"`
+	RampdownStart time.Time         `json:"rampdown_start"`
+	FilePath      string            `json:"file_path,omitempty"`
+	TreeRoot      string            `json:"tree_root,omitempty"`
+	Cgroup        string            `json:"cgroup,omitempty"`
+	FuseMount     string            `json:"fuse_mount,omitempty"`
+	DMMapping     string            `json:"dm_mapping,omitempty"` // Name of the -dm-device mapping
+	Governors     map[string]string `json:"governors,omitempty"`  // cpufreq governors to restore, by sysfs file
+}
+
+// heldStates are the state files of the runs of this process. Experiments
+// of the agent share the process, so a state file with this pid is not
+// necessarily left behind by a crash.
+var heldStates = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// holdState claims a state file for a run of this process
+func holdState(path string) bool {
+	heldStates.Lock()
+	defer heldStates.Unlock()
+	if heldStates.paths[path] {
+		return false
+	}
+	heldStates.paths[path] = true
+	return true
+}
+
+// releaseState gives up the claim of holdState
+func releaseState(path string) {
+	heldStates.Lock()
+	delete(heldStates.paths, path)
+	heldStates.Unlock()
+}
+
+// stateFile returns the state file of a work file. It carries the safety
+// suffix so the cleanup command finds it.
+func stateFile(filePath string) string {
+	return strings.TrimSuffix(filePath, fileSuffix) + "_state" + fileSuffix
+}
+
+// saveState records the current run. It is called once Start has set up the
+// run and again whenever the deadline moves.
+func (rm *ResourceMock) saveState() {
+	if rm.statePath == "" {
+		return
+	}
+	rm.deadlineMu.Lock()
+	state := runState{
+		PID:           hostPID(),
+		Program:       processProgram(hostPID()),
+		RampupStart:   rm.rampupStart,
+		Deadline:      rm.deadline,
+		RampdownStart: rm.rampdownStart,
+		FuseMount:     rm.config.FuseMount,
+		Governors:     rm.governors,
+	}
+	rm.deadlineMu.Unlock()
+	if rm.config.FileSizeMB > 0 {
+		state.FilePath = rm.filePath
+	}
+	if rm.config.Tree != "" {
+		state.TreeRoot = treeRoot(rm.filePath)
+	}
+	if rm.cgroup != nil {
+		state.Cgroup = rm.cgroup.path
+	}
+	if rm.config.DMDevice != "" {
+		state.DMMapping = rm.config.dmName()
+	}
+
+	data, _ := json.MarshalIndent(state, "", "  ")
+	tmp := rm.statePath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		log.Printf("Failed to save run state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, rm.statePath); err != nil {
+		os.Remove(tmp)
+		log.Printf("Failed to save run state: %v", err)
+	}
+}
+
+// keepsState reports whether a run leaves something behind when it crashes:
+// work files, mounts, mappings, cgroups or cpufreq governors. Only those runs
+// record their state, -resume runs too so they can be continued. Others,
+// like two CPU loads started in one directory, don't block each other.
+func (c Config) keepsState() bool {
+	return c.FileSizeMB > 0 || c.Tree != "" || c.FuseMount != "" || c.DMDevice != "" ||
+		c.IOThrottle != "" || c.MemoryHigh != "" || c.Governor != "" || c.Resume
+}
+
+// recoverState looks for the state of a previous run with the same work file.
+// Leftovers of a crashed run are cleaned up; with -resume a run that had time
+// left is continued on its recorded timeline instead, adopting its work file
+// and tree. It fails while the previous run is still alive.
+func (rm *ResourceMock) recoverState() error {
+	if rm.filePath == "" || !rm.config.keepsState() {
+		return nil
+	}
+	path := stateFile(rm.filePath)
+	if !holdState(path) {
+		return fmt.Errorf("%s is in use by another experiment of this process", rm.filePath)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		rm.statePath = path
+		return nil
+	}
+	if err != nil {
+		releaseState(path)
+		return fmt.Errorf("reading run state: %w", err)
+	}
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Ignoring unreadable run state %s: %v", path, err)
+		rm.statePath = path
+		return nil
+	}
+	if state.PID != hostPID() && state.Program != "" && processProgram(state.PID) == state.Program {
+		releaseState(path)
+		return fmt.Errorf("%s is in use by a running process (pid %d)", rm.filePath, state.PID)
+	}
+	rm.statePath = path
+
+	log.Printf("Found state of a run (pid %d) that ended without cleaning up", state.PID)
+	if state.FuseMount != "" {
+		if err := unmountDetached(state.FuseMount); err == nil {
+			log.Printf("Unmounted stale FUSE filesystem %s", state.FuseMount)
+		}
+	}
+	if state.DMMapping != "" {
+		if err := dmsetup("remove", state.DMMapping); err == nil {
+			log.Printf("Removed stale device mapping %s", state.DMMapping)
+		}
+	}
+	if state.Cgroup != "" {
+		// Fails while processes of the crashed run, e.g. its child, are still inside
+		if err := os.Remove(state.Cgroup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove stale cgroup %s: %v", state.Cgroup, err)
+		}
+	}
+
+	// A resumed run sets -governor again, remembering the original governors
+	restoreGovernors(state.Governors)
+
+	if rm.config.Resume && time.Now().Before(state.Deadline) {
+		rm.resumed = &state
+		rm.deadlineMu.Lock()
+		rm.deadline = state.Deadline
+		rm.rampdownStart = state.RampdownStart
+		rm.deadlineTimer.Reset(time.Until(state.Deadline))
+		rm.deadlineMu.Unlock()
+		if rm.config.hidesEnd() {
+			log.Printf("Resuming the run started at %s", state.RampupStart.Format(time.RFC3339))
+		} else {
+			log.Printf("Resuming the run started at %s, ending at %s", state.RampupStart.Format(time.RFC3339), state.Deadline.Format(time.RFC3339))
+		}
+		return nil
+	}
+	if rm.config.Resume {
+		log.Printf("The previous run ended at %s, starting a new run", state.Deadline.Format(time.RFC3339))
+	}
+	for _, path := range []string{state.FilePath, state.TreeRoot} {
+		if path == "" {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Failed to remove stale %s: %v", path, err)
+		} else {
+			log.Printf("Removed stale %s", path)
+		}
+	}
+	return nil
+}
+
+// processProgram returns the program name of a live process, or "" when there is none
+func processProgram(pid int) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+	argv0, _, _ := strings.Cut(string(data), "\x00")
+	return filepath.Base(argv0)
+}
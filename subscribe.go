@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"outagemock/event"
+)
+
+// watchRun subscribes the watchers registered with event.Watch to the run
+func (rm *ResourceMock) watchRun() {
+	for _, watch := range event.Watchers() {
+		go watch(rm.events.Subscribe())
+	}
+}
+
+// publishProgress publishes phase changes and resources reaching their targets until the run ends
+func (rm *ResourceMock) publishProgress() {
+	defer rm.wg.Done()
+
+	ticker := rm.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastPhase := ""
+	reached := map[string]bool{}
+	for {
+		phase, _ := rm.phaseAt(rm.clock.Now())
+		if phase != lastPhase {
+			rm.events.Publish(event.Event{Type: event.PhaseStarted, Phase: phase})
+			lastPhase = phase
+		}
+		// Targets are only full once rampup is over
+		if phase == "steady" {
+			status := rm.Status()
+			for resource, done := range map[string]bool{
+				"memory":      rm.config.MemoryMB > 0 && status.MemoryActualMB >= rm.getCurrentMemoryUsage(),
+				"file":        rm.config.FileSizeMB > 0 && status.FileActualMB >= rm.getCurrentFileSizeUsage(),
+				"connections": rm.config.ConnCount > 0 && status.ConnsOpen >= int64(rm.config.ConnCount),
+			} {
+				if done && !reached[resource] {
+					rm.events.Publish(event.Event{Type: event.TargetReached, Resource: resource})
+					reached[resource] = true
+				}
+			}
+		}
+
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
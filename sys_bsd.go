@@ -0,0 +1,138 @@
+//go:build unix && !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Stand-ins for the Linux system interfaces of sys_linux.go on macOS and the
+// BSDs, sys_windows.go has Windows'. The loads that need more than these are
+// Linux only and degrade elsewhere.
+
+const (
+	oDirect     = 0 // Writes go through the page cache
+	mapPopulate = 0 // Mappings fault in when they are touched
+	sockCloexec = 0
+	cloneNewNet = 0 // No network namespaces, -netns and -unshare fail
+	cloneNewNS  = 0
+	oDsync      = syscall.O_SYNC // Write through to the storage
+
+	rlimitMemlock = 6         // RLIMIT_MEMLOCK of the BSDs
+	rlimInfinity  = 1<<63 - 1 // No limit
+)
+
+// rlimit is struct rlimit, whose fields package syscall types differently per BSD
+type rlimit struct {
+	Cur uint64
+	Max uint64
+}
+
+// errNotLinux fails the features that are only supported on Linux
+var errNotLinux = errors.New("only supported on Linux")
+
+// gettid returns the id of the calling thread, unknown outside Linux
+func gettid() int {
+	return 0
+}
+
+// dontNeed returns the pages of a mapping to the kernel; anonymous pages read back as zero
+func dontNeed(b []byte) error {
+	return madvise(b, syscall.MADV_DONTNEED)
+}
+
+// removePages frees the pages of a shared file mapping. Only Linux frees the
+// file blocks too, elsewhere the pages are dropped and the file keeps them.
+func removePages(b []byte) error {
+	return madvise(b, syscall.MADV_DONTNEED)
+}
+
+func madvise(b []byte, advice int) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_MADVISE, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(advice)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// mlock pins the pages of b in memory
+func mlock(b []byte) error {
+	return memorySyscall(syscall.SYS_MLOCK, b)
+}
+
+// munlock unpins the pages of b
+func munlock(b []byte) error {
+	return memorySyscall(syscall.SYS_MUNLOCK, b)
+}
+
+func memorySyscall(trap uintptr, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if _, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// memlockLimit returns the soft and hard limit of locked memory
+func memlockLimit() (cur, max uint64, err error) {
+	var limit rlimit
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_GETRLIMIT, rlimitMemlock, uintptr(unsafe.Pointer(&limit)), 0); errno != 0 {
+		return 0, 0, errno
+	}
+	return limit.Cur, limit.Max, nil
+}
+
+// setMemlockLimit sets the soft and hard limit of locked memory
+func setMemlockLimit(cur, max uint64) error {
+	limit := rlimit{Cur: cur, Max: max}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRLIMIT, rlimitMemlock, uintptr(unsafe.Pointer(&limit)), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// fallocate reserves the blocks of a file, which only Linux supports
+func fallocate(f *os.File, size int64) error {
+	return syscall.EOPNOTSUPP
+}
+
+// dropCache leaves the page cache alone, files are read back from memory
+func dropCache(file *os.File, offset, length int64) {}
+
+// unmountDetached unmounts path
+func unmountDetached(path string) error {
+	return syscall.Unmount(path, 0)
+}
+
+// useCgroupFD does nothing, there are no cgroups outside Linux
+func useCgroupFD(attr *syscall.SysProcAttr, fd int) {}
+
+// bindToDevice fails, sockets are bound to interfaces on Linux only
+func bindToDevice(fd int, iface string) error {
+	return errNotLinux
+}
+
+// kernelRelease returns the release of the running kernel
+func kernelRelease() string {
+	release, _ := syscall.Sysctl("kern.osrelease")
+	return release
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGETA, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// onTmpfs reports false, tmpfs files are only told apart from page cache on Linux
+func onTmpfs(path string) bool {
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux system interfaces that differ on other platforms, see sys_bsd.go and sys_windows.go
+
+const (
+	oDirect     = syscall.O_DIRECT     // Bypass the page cache
+	mapPopulate = syscall.MAP_POPULATE // Fault a mapping in when it is created
+	sockCloexec = syscall.SOCK_CLOEXEC // Create sockets closed on exec
+	cloneNewNet = syscall.CLONE_NEWNET // The network namespace of -netns and -unshare
+	cloneNewNS  = syscall.CLONE_NEWNS  // The mount namespace of -unshare
+	oDsync      = syscall.O_DSYNC      // Write data through to the storage
+
+	rlimitMemlock = 8          // RLIMIT_MEMLOCK, which package syscall doesn't define
+	rlimInfinity  = ^uint64(0) // No limit
+
+	tmpfsMagic = 0x01021994 // The statfs type of tmpfs
+)
+
+// gettid returns the id of the calling thread
+func gettid() int {
+	return syscall.Gettid()
+}
+
+// dontNeed returns the pages of a mapping to the kernel; anonymous pages read back as zero
+func dontNeed(b []byte) error {
+	return syscall.Madvise(b, syscall.MADV_DONTNEED)
+}
+
+// removePages frees the pages of a shared file mapping and the file blocks behind them
+func removePages(b []byte) error {
+	return syscall.Madvise(b, syscall.MADV_REMOVE)
+}
+
+// mlock pins the pages of b in memory
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
+
+// munlock unpins the pages of b
+func munlock(b []byte) error {
+	return syscall.Munlock(b)
+}
+
+// memlockLimit returns the soft and hard limit of locked memory
+func memlockLimit() (cur, max uint64, err error) {
+	var limit syscall.Rlimit
+	err = syscall.Getrlimit(rlimitMemlock, &limit)
+	return limit.Cur, limit.Max, err
+}
+
+// setMemlockLimit sets the soft and hard limit of locked memory
+func setMemlockLimit(cur, max uint64) error {
+	return syscall.Setrlimit(rlimitMemlock, &syscall.Rlimit{Cur: cur, Max: max})
+}
+
+// fallocate reserves the blocks of a file
+func fallocate(f *os.File, size int64) error {
+	return syscall.Fallocate(int(f.Fd()), 0, 0, size)
+}
+
+// dropCache asks the kernel to evict a range of the file from the page cache,
+// so it is read back from the storage rather than from memory
+func dropCache(file *os.File, offset, length int64) {
+	syscall.Syscall6(sysFadvise64, file.Fd(), uintptr(offset), uintptr(length), fadvDontNeed, 0, 0)
+}
+
+// unmountDetached lazily unmounts path, even while files under it are open
+func unmountDetached(path string) error {
+	return syscall.Unmount(path, syscall.MNT_DETACH)
+}
+
+// useCgroupFD starts a process in the cgroup of a directory
+func useCgroupFD(attr *syscall.SysProcAttr, fd int) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = fd
+}
+
+// bindToDevice binds a socket to a network interface
+func bindToDevice(fd int, iface string) error {
+	return syscall.BindToDevice(fd, iface)
+}
+
+// kernelRelease returns the release of the running kernel, e.g. 6.8.0-45-generic
+func kernelRelease() string {
+	var uts syscall.Utsname
+	if syscall.Uname(&uts) != nil {
+		return ""
+	}
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return string(release)
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// onTmpfs reports whether path is on tmpfs, whose files are shared memory rather than page cache
+func onTmpfs(path string) bool {
+	var st syscall.Statfs_t
+	return syscall.Statfs(path, &st) == nil && st.Type == tmpfsMagic
+}
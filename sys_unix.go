@@ -0,0 +1,130 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// System interfaces Linux shares with macOS and the BSDs, see sys_windows.go
+
+// mapNoReserve maps memory without reserving swap for it
+const mapNoReserve = syscall.MAP_NORESERVE
+
+// Signals that extend a run by -extend-step and end it gracefully
+const (
+	sigExtend = syscall.SIGUSR1
+	sigEnd    = syscall.SIGUSR2
+)
+
+// mmap maps size bytes of f at offset shared with the page cache, or private
+// anonymous memory when f is nil. flags adds mapPopulate or mapNoReserve.
+func mmap(f *os.File, offset int64, size int, flags int) ([]byte, error) {
+	fd := -1
+	if f != nil {
+		fd, flags = int(f.Fd()), flags|syscall.MAP_SHARED
+	} else {
+		flags |= syscall.MAP_PRIVATE | syscall.MAP_ANON
+	}
+	return syscall.Mmap(fd, offset, size, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+}
+
+// munmap unmaps a mapping of mmap
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// umask sets the file mode creation mask and returns the previous one
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
+
+// diskSpace returns the size of the filesystem at path, its free bytes and
+// the bytes available to this user, which excludes the blocks reserved for root
+func diskSpace(path string) (size, free, avail int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, 0, err
+	}
+	bsize := int64(st.Bsize)
+	return int64(st.Blocks) * bsize, int64(st.Bfree) * bsize, int64(st.Bavail) * bsize, nil
+}
+
+// terminalColumns returns the width of the terminal f is, or 0
+func terminalColumns(f *os.File) int {
+	var size struct{ rows, cols, x, y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0
+	}
+	return int(size.cols)
+}
+
+// foregroundTerminal reports whether f is a terminal this process is in the
+// foreground of. Background jobs reading their terminal are stopped.
+func foregroundTerminal(f *os.File) bool {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	return errno == 0 && int(pgrp) == syscall.Getpgrp()
+}
+
+// shellCommand runs command through the shell, in a process group of its own
+// so the whole pipeline can be stopped
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// runAsUser starts cmd as uid with gid as its only group
+func runAsUser(cmd *exec.Cmd, uid, gid int) {
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{uint32(gid)}}
+}
+
+// signalProcessGroup asks the process group led by p to exit, or kills it
+func signalProcessGroup(p *os.Process, kill bool) {
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(-p.Pid, sig)
+}
+
+// processCPUTime returns the user and system CPU time used by this process
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// minorFaults returns the minor page faults of this process so far
+func minorFaults() int64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return int64(usage.Minflt)
+}
+
+// raiseNofile lifts the soft limit of open files to the hard limit
+func raiseNofile() {
+	var limit syscall.Rlimit
+	if syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit) == nil && limit.Cur < limit.Max {
+		limit.Cur = limit.Max
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+	}
+}
+
+// allocatedBytes returns the bytes of disk allocated to the file at path
+func allocatedBytes(path string) int64 {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0
+	}
+	return st.Blocks * 512
+}
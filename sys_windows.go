@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Stand-ins for the Linux system interfaces of sys_linux.go on Windows. Where
+// Windows has no equivalent they do nothing or fail with ENOSYS, and the loads
+// that need them degrade.
+
+const (
+	oDirect      = 0 // Writes go through the page cache
+	mapPopulate  = 0 // Mappings fault in when they are touched
+	mapNoReserve = 0
+	sockCloexec  = 0 // Handles are not inherited unless asked for
+	cloneNewNet  = 0 // No network namespaces, -netns and -unshare fail
+	cloneNewNS   = 0
+	oDsync       = syscall.O_SYNC // Write through to the storage
+
+	rlimInfinity = ^uint64(0) // No limit
+)
+
+// sigExtend and sigEnd are never delivered, Windows has no SIGUSR1 and
+// SIGUSR2; the console and the agent API extend and end runs instead
+const (
+	sigExtend = syscall.Signal(-1)
+	sigEnd    = syscall.Signal(-2)
+)
+
+// errNotLinux fails the features that are only supported on Linux
+var errNotLinux = errors.New("only supported on Linux")
+
+// gettid returns the id of the calling thread, which is not needed on Windows
+func gettid() int {
+	return 0
+}
+
+// dontNeed can't return the pages of a mapping, they stay resident
+func dontNeed(b []byte) error {
+	return syscall.ENOSYS
+}
+
+// removePages can't free the pages of a file mapping
+func removePages(b []byte) error {
+	return syscall.ENOSYS
+}
+
+// mlock pins the pages of b in the working set
+func mlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
+
+// munlock unpins the pages of b
+func munlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.VirtualUnlock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
+
+// memlockLimit reports no limit, locked pages are bounded by the working set instead
+func memlockLimit() (cur, max uint64, err error) {
+	return rlimInfinity, rlimInfinity, nil
+}
+
+// setMemlockLimit has no limit to lift
+func setMemlockLimit(cur, max uint64) error {
+	return nil
+}
+
+// fallocate reserves the blocks of a file, which only Linux supports
+func fallocate(f *os.File, size int64) error {
+	return syscall.EOPNOTSUPP
+}
+
+// dropCache leaves the page cache alone, files are read back from memory
+func dropCache(file *os.File, offset, length int64) {}
+
+// unmountDetached fails, there are no mounts to detach
+func unmountDetached(path string) error {
+	return syscall.ENOSYS
+}
+
+// useCgroupFD does nothing, there are no cgroups outside Linux
+func useCgroupFD(attr *syscall.SysProcAttr, fd int) {}
+
+// bindToDevice fails, sockets are bound to interfaces on Linux only
+func bindToDevice(fd int, iface string) error {
+	return errNotLinux
+}
+
+// kernelRelease returns the Windows version, e.g. 10.0.19045
+func kernelRelease() string {
+	version, err := syscall.GetVersion()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d", byte(version), byte(version>>8), uint16(version>>16))
+}
+
+// isTerminal reports whether f is a console
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) == nil
+}
+
+// mmap allocates size bytes of zeroed memory, which Windows commits but only
+// backs with pages once they are touched. Files can't be mapped.
+func mmap(f *os.File, offset int64, size int, flags int) ([]byte, error) {
+	if f != nil {
+		return nil, syscall.ENOSYS
+	}
+	return make([]byte, size), nil
+}
+
+// munmap leaves the memory to the garbage collector once it is dropped
+func munmap(b []byte) error {
+	return nil
+}
+
+// umask does nothing, files and sockets take the ACL of their directory
+func umask(mask int) int {
+	return 0
+}
+
+// Functions of kernel32.dll that package syscall doesn't wrap
+var (
+	kernel32                   = syscall.NewLazyDLL("kernel32.dll")
+	getDiskFreeSpaceEx         = kernel32.NewProc("GetDiskFreeSpaceExW")
+	getConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+// diskSpace returns the size of the volume at path, its free bytes and the
+// bytes available to this user, which excludes quotas
+func diskSpace(path string) (size, free, avail int64, err error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if r, _, errno := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(&avail)), uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&free))); r == 0 {
+		return 0, 0, 0, errno
+	}
+	return size, free, avail, nil
+}
+
+// terminalColumns returns the width of the console window f is, or 0
+func terminalColumns(f *os.File) int {
+	// CONSOLE_SCREEN_BUFFER_INFO: buffer size, cursor position, attributes,
+	// the window's left, top, right and bottom, and the largest window size
+	var info struct {
+		size, cursor             [2]int16
+		attributes               uint16
+		left, top, right, bottom int16
+		maximum                  [2]int16
+	}
+	if r, _, _ := getConsoleScreenBufferInfo.Call(f.Fd(), uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0
+	}
+	return int(info.right-info.left) + 1
+}
+
+// foregroundTerminal reports whether f is a console, Windows has no background jobs
+func foregroundTerminal(f *os.File) bool {
+	return isTerminal(f)
+}
+
+// shellCommand runs command through cmd.exe, in a process group of its own
+func shellCommand(command string) *exec.Cmd {
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	cmd := exec.Command(shell)
+	// cmd.exe parses its command line itself, so it is passed on unquoted
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine:       shell + " /C " + command,
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+	return cmd
+}
+
+// runAsUser does nothing, -user is rejected because Windows has no root to drop
+func runAsUser(cmd *exec.Cmd, uid, gid int) {}
+
+// signalProcessGroup kills p, Windows can't ask a process to exit
+func signalProcessGroup(p *os.Process, kill bool) {
+	p.Kill()
+}
+
+// processCPUTime returns the user and kernel CPU time used by this process
+func processCPUTime() time.Duration {
+	var creation, exit, kernel, user syscall.Filetime
+	process, err := syscall.GetCurrentProcess()
+	if err != nil || syscall.GetProcessTimes(process, &creation, &exit, &kernel, &user) != nil {
+		return 0
+	}
+	// Filetimes count 100ns intervals, Nanoseconds would count them from 1601
+	ticks := func(t syscall.Filetime) int64 { return int64(t.HighDateTime)<<32 | int64(t.LowDateTime) }
+	return time.Duration(ticks(kernel)+ticks(user)) * 100
+}
+
+// minorFaults is unknown, Windows counts soft and hard faults together
+func minorFaults() int64 {
+	return 0
+}
+
+// onTmpfs reports false, Windows has no tmpfs
+func onTmpfs(path string) bool {
+	return false
+}
+
+// raiseNofile does nothing, Windows has no limit of open handles to lift
+func raiseNofile() {}
+
+// allocatedBytes returns the size of the file at path, which Windows allocates
+// in full as it is written
+func allocatedBytes(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// systemdScopeEnv marks the copy of this program running inside the scope of -systemd-run
+const systemdScopeEnv = "OUTAGEMOCK_SYSTEMD_SCOPE"
+
+// parseSystemdProperties parses a list like "CPUQuota=200%,MemoryMax=2G" into
+// systemd-run -p arguments
+func parseSystemdProperties(s string) ([]string, error) {
+	var props []string
+	if s == "" {
+		return nil, nil
+	}
+	for _, prop := range strings.Split(s, ",") {
+		prop = strings.TrimSpace(prop)
+		if key, _, ok := strings.Cut(prop, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid systemd property %q (expected e.g. CPUQuota=200%%)", prop)
+		}
+		props = append(props, prop)
+	}
+	return props, nil
+}
+
+// inSystemdScope reports whether this process was started by runInSystemdScope
+func inSystemdScope() bool {
+	return os.Getenv(systemdScopeEnv) != ""
+}
+
+// runInSystemdScope replaces this process with systemd-run, which starts this
+// command again inside a transient scope unit limited by -systemd-properties.
+// systemd enforces the limits on everything the run starts and stops it all
+// with the scope, even if this program crashes. It only returns on failure.
+func runInSystemdScope(config Config) error {
+	path, err := exec.LookPath("systemd-run")
+	if err != nil {
+		return fmt.Errorf("-systemd-run: %w", err)
+	}
+	args := []string{"systemd-run", "--scope", "--quiet", "--collect",
+		fmt.Sprintf("--unit=outagemock-%d", os.Getpid()),
+		"--description=outagemock " + strings.Join(os.Args[1:], " ")}
+	for _, prop := range config.systemdProperties {
+		args = append(args, "-p", prop)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args = append(append(args, "--", self), os.Args[1:]...)
+	return syscall.Exec(path, args, append(os.Environ(), systemdScopeEnv+"=1"))
+}
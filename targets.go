@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// liveTargets are the CPU, memory and file targets of a run, starting at
+// -cpu, -memory and -fsize and changed by AdjustTarget while the run is going
+type liveTargets struct {
+	mu       sync.Mutex
+	cpu      float64
+	memoryMB int64
+	fileMB   int64 // Set through the file consumer, see builtinConsumer
+}
+
+// currentTargets returns the CPU target in percent and the memory target in MB
+func (rm *ResourceMock) currentTargets() (float64, int64) {
+	rm.targets.mu.Lock()
+	defer rm.targets.mu.Unlock()
+	return rm.targets.cpu, rm.targets.memoryMB
+}
+
+// fileTarget returns the file target in MB
+func (rm *ResourceMock) fileTarget() int64 {
+	rm.targets.mu.Lock()
+	defer rm.targets.mu.Unlock()
+	return rm.targets.fileMB
+}
+
+// AdjustTarget sets the target of cpu (percent) or memory (MB) to change, or
+// moves it by change when it starts with + or -, and returns the new target.
+// Rampup, quotas and host protection still apply. Only consumers the run
+// started with can be adjusted, and only while no shape moves their targets.
+func (rm *ResourceMock) AdjustTarget(resource, change string) (float64, error) {
+	value, err := strconv.ParseFloat(change, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid %s target %q (expected e.g. 90, +10 or -10)", resource, change)
+	}
+	relative := strings.HasPrefix(change, "+") || strings.HasPrefix(change, "-")
+	if resource == "mem" {
+		resource = "memory"
+	}
+	if shape := rm.targetShape(resource); shape != "" {
+		return 0, fmt.Errorf("the %s target follows %s, it can't be adjusted", resource, shape)
+	}
+
+	rm.targets.mu.Lock()
+	defer rm.targets.mu.Unlock()
+	switch resource {
+	case "cpu":
+		if rm.config.CPUPercent <= 0 {
+			return 0, fmt.Errorf("the run has no CPU load, start it with -cpu to adjust it")
+		}
+		if relative {
+			value += rm.targets.cpu
+		}
+		if value < 0 || value > 100 {
+			return 0, fmt.Errorf("CPU target %.1f%% is out of range (0-100)", value)
+		}
+		rm.targets.cpu = value
+		log.Printf("CPU target set to %.1f%%", value)
+		rm.startBurnSupervisor(value)
+	case "memory":
+		if rm.config.MemoryMB <= 0 {
+			return 0, fmt.Errorf("the run holds no memory, start it with -memory to adjust it")
+		}
+		if relative {
+			value += float64(rm.targets.memoryMB)
+		}
+		if value < 0 {
+			return 0, fmt.Errorf("memory target %.0f MB is negative", value)
+		}
+		rm.targets.memoryMB = int64(value)
+		log.Printf("Memory target set to %d MB", rm.targets.memoryMB)
+		value = float64(rm.targets.memoryMB)
+	default:
+		return 0, fmt.Errorf("unknown target %q (supported: cpu, memory)", resource)
+	}
+	return value, nil
+}
+
+// targetShape returns what moves the target of resource instead of
+// AdjustTarget, or "" when nothing does
+func (rm *ResourceMock) targetShape(resource string) string {
+	for _, c := range rm.config.correlations {
+		if c.Target == resource {
+			return "-correlate"
+		}
+	}
+	switch {
+	case rm.config.Emulate != "":
+		return "-emulate"
+	case rm.config.Markov != "":
+		return "-markov"
+	case rm.timeline != nil:
+		return "the replayed timeline"
+	case resource == "cpu" && rm.config.FollowQuery != "":
+		return "-follow-query"
+	}
+	return ""
+}
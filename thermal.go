@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cpuSensors are the hwmon drivers reporting CPU temperatures
+var cpuSensors = map[string]bool{
+	"coretemp":    true, // Intel
+	"k10temp":     true, // AMD
+	"zenpower":    true,
+	"cpu_thermal": true, // Raspberry Pi and other ARM boards
+	"soc_thermal": true,
+}
+
+// scalarKernel does n iterations of independent floating point multiply-adds,
+// the hottest portable work when vector instructions are unavailable
+func scalarKernel(n int) float64 {
+	a, b, c, d := 1.0, 2.0, 3.0, 4.0
+	for i := 0; i < n; i++ {
+		a = a*0.999999 + 0.001
+		b = b*0.999999 + 0.001
+		c = c*0.999999 + 0.001
+		d = d*0.999999 + 0.001
+	}
+	return a + b + c + d
+}
+
+// cpuTemperature returns the hottest CPU temperature reported by hwmon in °C
+func cpuTemperature() (float64, bool) {
+	dirs, _ := filepath.Glob("/sys/class/hwmon/hwmon*")
+	hottest, found := 0.0, false
+	for _, dir := range dirs {
+		name, err := os.ReadFile(filepath.Join(dir, "name"))
+		if err != nil || !cpuSensors[strings.TrimSpace(string(name))] {
+			continue
+		}
+		inputs, _ := filepath.Glob(filepath.Join(dir, "temp*_input"))
+		for _, input := range inputs {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				continue
+			}
+			// hwmon reports millidegrees
+			milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+			if err != nil {
+				continue
+			}
+			if temp := milli / 1000; !found || temp > hottest {
+				hottest, found = temp, true
+			}
+		}
+	}
+	return hottest, found
+}
+
+// thermalThrottleCount returns how often the cores have been throttled for
+// temperature since boot, as counted by the kernel on Intel CPUs
+func thermalThrottleCount() (int64, bool) {
+	files, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count")
+	var total int64
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		total += n
+	}
+	return total, len(files) > 0
+}
+
+// monitorThermal reports the CPU temperature and thermal throttling of the run with -thermal
+func (rm *ResourceMock) monitorThermal() {
+	defer rm.wg.Done()
+
+	log.Printf("Thermal load uses %s on %d workers", thermalKernelName(), rm.cpuPool.workers)
+	startThrottles, canThrottle := thermalThrottleCount()
+	if _, ok := cpuTemperature(); !ok {
+		log.Printf("No CPU temperature sensor found in /sys/class/hwmon")
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	hottest := 0.0
+	for {
+		temp, ok := cpuTemperature()
+		throttles, _ := thermalThrottleCount()
+		rm.statusMu.Lock()
+		if ok {
+			rm.resourceStatus.CPUTempC = temp
+		}
+		if canThrottle {
+			rm.resourceStatus.ThermalThrottles = throttles - startThrottles
+		}
+		rm.statusMu.Unlock()
+		if ok && temp > hottest {
+			hottest = temp
+		}
+
+		select {
+		case <-rm.ctx.Done():
+			if hottest > 0 {
+				log.Printf("Hottest CPU temperature: %.0f°C, thermal throttling events: %d", hottest, throttles-startThrottles)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
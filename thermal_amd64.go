@@ -0,0 +1,38 @@
+package main
+
+// Implemented in thermal_amd64.s
+func fmaLoop(n int)
+func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32)
+func xgetbv() (eax, edx uint32)
+
+// hasFMA reports whether the CPU and the OS support 256-bit FMA instructions
+var hasFMA = func() bool {
+	if maxLeaf, _, _, _ := cpuid(0, 0); maxLeaf < 1 {
+		return false
+	}
+	_, _, ecx, _ := cpuid(1, 0)
+	const fma, osxsave, avx = 1 << 12, 1 << 27, 1 << 28
+	if ecx&fma == 0 || ecx&osxsave == 0 || ecx&avx == 0 {
+		return false
+	}
+	// The OS must save the YMM registers on context switches
+	xcr0, _ := xgetbv()
+	return xcr0&6 == 6
+}()
+
+// thermalKernel does n iterations of the hottest work the CPU supports
+func thermalKernel(n int) float64 {
+	if hasFMA {
+		fmaLoop(n)
+		return 0
+	}
+	return scalarKernel(n)
+}
+
+// thermalKernelName names the work done by thermalKernel
+func thermalKernelName() string {
+	if hasFMA {
+		return "AVX FMA"
+	}
+	return "scalar floating point"
+}
@@ -0,0 +1,16 @@
+//go:build !amd64
+
+package main
+
+// hasFMA is false, 256-bit FMA needs the amd64 assembly of thermal_amd64.s
+const hasFMA = false
+
+// thermalKernel does n iterations of the hottest work available without assembly
+func thermalKernel(n int) float64 {
+	return scalarKernel(n)
+}
+
+// thermalKernelName names the work done by thermalKernel
+func thermalKernelName() string {
+	return "scalar floating point"
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// prctl options for the name of the calling thread
+const (
+	prSetName = 15
+	prGetName = 16
+)
+
+// nameThread locks the calling goroutine to its thread and names the thread
+// like "om-cpu-3", so top -H, htop and profilers attribute the load to the
+// outagemock component producing it. Names are cut to the kernel's 15 bytes.
+// The returned function restores the thread's name and unlocks it.
+func nameThread(format string, args ...interface{}) func() {
+	runtime.LockOSThread()
+	// The main thread's name is the process name shown by top and ps
+	if gettid() == os.Getpid() {
+		return runtime.UnlockOSThread
+	}
+	original := threadName()
+	setThreadName(fmt.Sprintf(format, args...))
+	return func() {
+		setThreadName(original)
+		runtime.UnlockOSThread()
+	}
+}
+
+// setThreadName sets the name of the calling thread, ignoring failures since
+// the name is only cosmetic
+func setThreadName(name string) {
+	buf := make([]byte, 16)
+	copy(buf[:15], name)
+	syscall.RawSyscall(syscall.SYS_PRCTL, prSetName, uintptr(unsafe.Pointer(&buf[0])), 0)
+}
+
+// threadName returns the name of the calling thread, falling back to the program name
+func threadName() string {
+	buf := make([]byte, 16)
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prGetName, uintptr(unsafe.Pointer(&buf[0])), 0); errno != 0 {
+		return filepath.Base(os.Args[0])
+	}
+	return strings.TrimRight(string(buf), "\x00")
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "runtime"
+
+// nameThread locks the calling goroutine to its thread like on Linux, where
+// the thread is named too. The returned function unlocks it.
+func nameThread(format string, args ...interface{}) func() {
+	runtime.LockOSThread()
+	return runtime.UnlockOSThread
+}
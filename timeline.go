@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimelinePoint holds resource targets at an offset from the start of a run
+type TimelinePoint struct {
+	Offset     time.Duration `json:"offset_ns"`
+	CPUPercent float64       `json:"cpu"`
+	MemoryMB   int64         `json:"memory_mb"`
+	FileSizeMB int64         `json:"file_mb"`
+}
+
+// Timeline is a list of points ordered by offset; targets between points are linearly interpolated
+type Timeline []TimelinePoint
+
+// At returns the interpolated targets after elapsed time.
+// Before the first point and after the last point the nearest point is held.
+func (t Timeline) At(elapsed time.Duration) TimelinePoint {
+	if len(t) == 0 {
+		return TimelinePoint{Offset: elapsed}
+	}
+	if elapsed <= t[0].Offset {
+		return t[0]
+	}
+	for i := 1; i < len(t); i++ {
+		if elapsed > t[i].Offset {
+			continue
+		}
+		prev, next := t[i-1], t[i]
+		progress := float64(elapsed-prev.Offset) / float64(next.Offset-prev.Offset)
+		return TimelinePoint{
+			Offset:     elapsed,
+			CPUPercent: prev.CPUPercent + progress*(next.CPUPercent-prev.CPUPercent),
+			MemoryMB:   prev.MemoryMB + int64(progress*float64(next.MemoryMB-prev.MemoryMB)),
+			FileSizeMB: prev.FileSizeMB + int64(progress*float64(next.FileSizeMB-prev.FileSizeMB)),
+		}
+	}
+	return t[len(t)-1]
+}
+
+// Duration returns the offset of the last point
+func (t Timeline) Duration() time.Duration {
+	if len(t) == 0 {
+		return 0
+	}
+	return t[len(t)-1].Offset
+}
+
+// Peak returns the maximum target of each resource over the whole timeline
+func (t Timeline) Peak() TimelinePoint {
+	var peak TimelinePoint
+	for _, p := range t {
+		if p.CPUPercent > peak.CPUPercent {
+			peak.CPUPercent = p.CPUPercent
+		}
+		if p.MemoryMB > peak.MemoryMB {
+			peak.MemoryMB = p.MemoryMB
+		}
+		if p.FileSizeMB > peak.FileSizeMB {
+			peak.FileSizeMB = p.FileSizeMB
+		}
+	}
+	return peak
+}
+
+// timelineSettings are the settings behind the targets of a timeline point
+var timelineSettings = map[string]string{"cpu": "cpu", "memory_mb": "memory", "file_mb": "fsize"}
+
+// loadTimeline reads a timeline written by the record command, one JSON point per line
+func loadTimeline(path string) (Timeline, error) {
+	timeline, _, err := loadSampledTimeline(path, nil)
+	return timeline, err
+}
+
+// loadSampledTimeline reads a timeline of a scenario lane, whose targets may be
+// distributions like "normal(70,10)" drawn from rng for every point. The draws
+// are returned as "value from distribution" by target and offset. Without rng
+// distributions are rejected.
+func loadSampledTimeline(path string, rng *rand.Rand) (Timeline, map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var timeline Timeline
+	samples := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		data, err := samplePoint(scanner.Bytes(), rng, samples)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		var point TimelinePoint
+		if err := json.Unmarshal(data, &point); err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if len(timeline) > 0 && point.Offset <= timeline[len(timeline)-1].Offset {
+			return nil, nil, fmt.Errorf("%s:%d: offsets must be increasing", path, line)
+		}
+		timeline = append(timeline, point)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(timeline) == 0 {
+		return nil, nil, fmt.Errorf("%s: no timeline points", path)
+	}
+	return timeline, samples, nil
+}
+
+// samplePoint replaces the targets of a timeline point given as distributions
+// with a draw from rng, in the unit of the target, and records the draws in
+// samples. Targets are drawn in name order so a seed reproduces them.
+func samplePoint(data []byte, rng *rand.Rand, samples map[string]string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var offset time.Duration
+	json.Unmarshal(fields["offset_ns"], &offset)
+
+	keys := make([]string, 0, len(timelineSettings))
+	for key := range timelineSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	drawn := false
+	for _, key := range keys {
+		var spec string
+		if json.Unmarshal(fields[key], &spec) != nil || !distributionPattern.MatchString(spec) {
+			continue
+		}
+		if rng == nil {
+			return nil, fmt.Errorf("%s: distributions are only drawn in scenario timelines", key)
+		}
+		value, err := sampleSetting(timelineSettings[key], spec, rng)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		// Sizes come back like 2048M, the point holds MB
+		number := strings.TrimSuffix(value, "M")
+		fields[key] = json.RawMessage(number)
+		samples[fmt.Sprintf("timeline.%s@%v", key, offset)] = number + " from " + strings.TrimSpace(spec)
+		drawn = true
+	}
+	if !drawn {
+		return data, nil
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tlbSlot is the address range each touched page of -tlb-pressure has to
+// itself, the range one page table page maps on x86-64 and arm64 with 4K pages
+const tlbSlot = 2 << 20
+
+// TLBPressure is the address space -tlb-pressure spreads pages over, parsed
+// from e.g. span=64G,pages=20000,threads=2,duty=50%
+type TLBPressure struct {
+	Span    int64   // Bytes of sparse address space mapped
+	Pages   int     // Pages touched, each in its own tlbSlot
+	Threads int     // Threads accessing the pages in random order
+	Duty    float64 // Fraction of each period the threads access pages, sleeping the rest
+}
+
+// parseTLBPressure parses -tlb-pressure. Pages default to one per tlbSlot of the span.
+func parseTLBPressure(s string) (TLBPressure, error) {
+	spec := TLBPressure{Span: 64 << 30, Threads: 1, Duty: 1}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return TLBPressure{}, fmt.Errorf("invalid -tlb-pressure setting %q (expected key=value)", item)
+		}
+		var err error
+		switch key {
+		case "span":
+			spec.Span, err = parseByteSize(value)
+		case "pages":
+			spec.Pages, err = strconv.Atoi(value)
+		case "threads":
+			spec.Threads, err = strconv.Atoi(value)
+		case "duty":
+			spec.Duty, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			spec.Duty /= 100
+		default:
+			return TLBPressure{}, fmt.Errorf("unknown -tlb-pressure setting %q (supported: span, pages, threads, duty)", key)
+		}
+		if err != nil {
+			return TLBPressure{}, fmt.Errorf("invalid -tlb-pressure setting %q: %v", item, err)
+		}
+	}
+	slots := int(spec.Span / tlbSlot)
+	if spec.Pages == 0 {
+		spec.Pages = slots
+	}
+	if slots < 1 || spec.Pages < 1 || spec.Pages > slots || spec.Threads < 1 || spec.Duty <= 0 || spec.Duty > 1 {
+		return TLBPressure{}, fmt.Errorf("invalid -tlb-pressure %q: span must be at least 2M with at most one page per 2M, threads positive and duty between 0 and 100%%", s)
+	}
+	return spec, nil
+}
+
+// pageTablesMB returns the memory of the host's page tables
+func pageTablesMB() int64 {
+	info, err := readMemInfo()
+	if err != nil {
+		return 0
+	}
+	return info["PageTables"] / 1024
+}
+
+// pressureTLB maps a large sparse address space and touches one page in each
+// of its 2M slots, so every page needs a page table page of its own, then
+// reads the pages in random order so nearly every access misses the TLB and
+// walks the page tables. The touched pages grow linearly during rampup and
+// are released with host protection and rampdown, like the duty cycle of the
+// accessing threads.
+func (rm *ResourceMock) pressureTLB() {
+	defer rm.wg.Done()
+
+	spec := rm.config.tlbPressure
+	region, err := mmap(nil, 0, int(spec.Span), mapNoReserve)
+	if err != nil {
+		rm.markDegraded("tlb", fmt.Errorf("map %d MB of address space: %w", spec.Span>>20, err))
+		return
+	}
+	defer munmap(region)
+
+	// A random page of randomly chosen slots, touched in this order
+	slots := rand.Perm(int(spec.Span / tlbSlot))[:spec.Pages]
+	offsets := make([]int, spec.Pages)
+	for i, slot := range slots {
+		offsets[i] = slot*tlbSlot + rand.Intn(tlbSlot/PageBytes)*PageBytes
+	}
+	log.Printf("Spreading %d pages over %d MB of address space, %d threads at %.0f%% duty", spec.Pages, spec.Span>>20, spec.Threads, spec.Duty*100)
+
+	var touched atomic.Int64
+	var mu sync.Mutex
+	var accesses int64
+	var busy time.Duration
+	var threads sync.WaitGroup
+	for i := 0; i < spec.Threads; i++ {
+		threads.Add(1)
+		go func(i int) {
+			defer threads.Done()
+			defer nameThread("om-tlb-%d", i)()
+			x := uint64(i)*0x9e3779b97f4a7c15 + 1
+			for rm.ctx.Err() == nil {
+				chase := time.Duration(float64(llcPeriod) * spec.Duty * rm.rampIntensity("tlb"))
+				start, n := time.Now(), int64(0)
+				for pages := uint64(touched.Load()); pages > 0 && time.Since(start) < chase; n += 1024 {
+					for j := 0; j < 1024; j++ {
+						// xorshift, cheap next to the page walk. Mixing in the
+						// byte read makes every access depend on the last one.
+						x ^= x << 13
+						x ^= x >> 7
+						x ^= x << 17
+						x += uint64(region[offsets[x%pages]])
+					}
+				}
+				took := time.Since(start)
+				mu.Lock()
+				accesses += n
+				busy += took
+				mu.Unlock()
+				if !sleepCtx(rm.ctx, llcPeriod-took) {
+					return
+				}
+			}
+		}(i)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			threads.Wait()
+			return
+		case now := <-ticker.C:
+			// Touch or release pages toward the current share of the target
+			have := int(touched.Load())
+			want := int(float64(spec.Pages) * rm.rampIntensity("tlb"))
+			for ; have < want; have++ {
+				region[offsets[have]] = 1
+			}
+			if have > want {
+				// Shrink first so the threads don't read released pages back in
+				touched.Store(int64(want))
+				for ; have > want; have-- {
+					dontNeed(region[offsets[have-1] : offsets[have-1]+PageBytes])
+				}
+			}
+			touched.Store(int64(have))
+
+			if now.Sub(last) < time.Second {
+				continue
+			}
+			mu.Lock()
+			n, d := accesses, busy
+			accesses, busy = 0, 0
+			mu.Unlock()
+			rm.statusMu.Lock()
+			rm.resourceStatus.TLBPages = int64(have)
+			rm.resourceStatus.PageTablesMB = pageTablesMB()
+			if n > 0 {
+				rm.resourceStatus.TLBAccessNs = float64(d.Nanoseconds()) / float64(n)
+			}
+			rm.statusMu.Unlock()
+			last = now
+		}
+	}
+}
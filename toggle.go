@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// toggleResources are the consumers that can be disabled and enabled while a
+// run is going, besides the registered consumers of -consumer
+var toggleResources = []string{"cpu", "memory", "file", "tree", "s3", "conns", "signals", "faults", "fuse", "kmem", "loadavg", "unix", "logs", "softirq", "runqueue", "llc", "tlb", "cow", "psi", "dm"}
+
+// resourceScale is targetScale for one consumer, 0 while it is disabled or
+// the run is paused so it releases what it holds as it does during rampdown
+func (rm *ResourceMock) resourceScale(resource string) float64 {
+	rm.toggleMu.Lock()
+	disabled := rm.disabled[resource] || rm.paused
+	rm.toggleMu.Unlock()
+	if disabled {
+		return 0
+	}
+	return rm.targetScale()
+}
+
+// SetEnabled disables or re-enables one consumer without affecting the others
+func (rm *ResourceMock) SetEnabled(resource string, enabled bool) error {
+	if err := rm.checkToggle(resource); err != nil {
+		return err
+	}
+
+	rm.toggleMu.Lock()
+	if rm.disabled == nil {
+		rm.disabled = map[string]bool{}
+	}
+	if enabled {
+		delete(rm.disabled, resource)
+	} else {
+		rm.disabled[resource] = true
+	}
+	rm.toggleMu.Unlock()
+
+	if enabled {
+		log.Printf("Enabled %s", resource)
+	} else {
+		log.Printf("Disabled %s", resource)
+	}
+	rm.statusMu.Lock()
+	rm.resourceStatus.Disabled = rm.Disabled()
+	rm.statusMu.Unlock()
+	return nil
+}
+
+// checkToggle returns an error if resource can't be enabled or disabled
+func (rm *ResourceMock) checkToggle(resource string) error {
+	if _, consumer := rm.config.consumerTargets[resource]; !consumer && !slices.Contains(toggleResources, resource) {
+		return fmt.Errorf("unknown resource %q (supported: %s and registered consumers)", resource, strings.Join(toggleResources, ", "))
+	}
+	return nil
+}
+
+// SetPaused releases the load of every consumer while paused and takes it back
+// up at the current progress when resumed. The deadline keeps running.
+func (rm *ResourceMock) SetPaused(paused bool) {
+	rm.toggleMu.Lock()
+	rm.paused = paused
+	rm.toggleMu.Unlock()
+
+	if paused {
+		log.Printf("Paused")
+	} else {
+		log.Printf("Resumed")
+	}
+	rm.statusMu.Lock()
+	rm.resourceStatus.Paused = paused
+	rm.statusMu.Unlock()
+}
+
+// Disabled returns the consumers currently turned off, sorted
+func (rm *ResourceMock) Disabled() []string {
+	rm.toggleMu.Lock()
+	defer rm.toggleMu.Unlock()
+	disabled := []string{}
+	for name := range rm.disabled {
+		disabled = append(disabled, name)
+	}
+	sort.Strings(disabled)
+	return disabled
+}
+
+// isDisabled reports whether the status lists resource as disabled
+func (s ResourceStatus) isDisabled(resource string) bool {
+	return slices.Contains(s.Disabled, resource)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TreeSpec describes the directory tree created with -tree
+type TreeSpec struct {
+	Depth    int   // Levels of directories below the root
+	Fanout   int   // Subdirectories of every directory above the last level
+	Files    int   // Files in every directory
+	FileSize int64 // Size of every file in bytes
+}
+
+// parseTreeSpec parses a list like "depth=5,fanout=10,file-size=4K,files=10".
+// files defaults to fanout and file-size to 4K.
+func parseTreeSpec(s string) (TreeSpec, error) {
+	spec := TreeSpec{Files: -1, FileSize: 4096}
+	if s == "" {
+		return TreeSpec{}, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return TreeSpec{}, fmt.Errorf("invalid tree setting %q (expected key=value)", item)
+		}
+		var err error
+		switch key {
+		case "depth":
+			spec.Depth, err = strconv.Atoi(value)
+		case "fanout":
+			spec.Fanout, err = strconv.Atoi(value)
+		case "files":
+			spec.Files, err = strconv.Atoi(value)
+		case "file-size":
+			spec.FileSize, err = parseByteSize(value)
+		default:
+			return TreeSpec{}, fmt.Errorf("unknown tree setting %q (supported: depth, fanout, files, file-size)", key)
+		}
+		if err != nil {
+			return TreeSpec{}, fmt.Errorf("invalid tree setting %q: %v", item, err)
+		}
+	}
+	if spec.Files < 0 {
+		spec.Files = spec.Fanout
+	}
+	if spec.Depth < 0 || spec.Fanout < 1 || spec.FileSize < 0 {
+		return TreeSpec{}, fmt.Errorf("invalid tree %q: depth must be non-negative and fanout positive", s)
+	}
+	return spec, nil
+}
+
+// Counts returns the number of directories and files in the tree
+func (t TreeSpec) Counts() (dirs, files int64) {
+	level := int64(1)
+	for i := 0; i <= t.Depth; i++ {
+		dirs += level
+		level *= int64(t.Fanout)
+	}
+	return dirs, dirs * int64(t.Files)
+}
+
+// treeRoot returns the tree's root directory next to the work file. It carries
+// the safety suffix so the cleanup command finds it.
+func treeRoot(filePath string) string {
+	return strings.TrimSuffix(filePath, fileSuffix) + "_tree" + fileSuffix
+}
+
+// treeTarget returns how many tree entries should exist, growing linearly
+// during rampup and scaled by host protection and rampdown
+func (rm *ResourceMock) treeTarget(total int64) int64 {
+	return int64(float64(total) * rm.rampIntensity("tree"))
+}
+
+// consumeTree creates the directory tree of -tree depth first, pacing the
+// directories and files with the rampup. The tree is removed on cleanup.
+func (rm *ResourceMock) consumeTree() {
+	defer rm.wg.Done()
+	defer nameThread("om-tree")()
+
+	spec := rm.config.tree
+	dirs, files := spec.Counts()
+	total := dirs + files
+	root := treeRoot(rm.filePath)
+	log.Printf("Creating tree %s: %d directories, %d files of %d bytes", root, dirs, files, spec.FileSize)
+
+	content := rm.config.fileContent
+	buffer := make([]byte, spec.FileSize)
+	created := int64(0)
+
+	// create paces one entry with the rampup and creates it, retrying failures
+	create := func(what string, fn func() error) error {
+		for created >= rm.treeTarget(total) {
+			if !sleepCtx(rm.ctx, 50*time.Millisecond) {
+				return rm.ctx.Err()
+			}
+		}
+		if err := retryWithBackoff(rm.ctx, what, fn); err != nil {
+			return err
+		}
+		created++
+		rm.statusMu.Lock()
+		rm.resourceStatus.TreeEntries = created
+		rm.statusMu.Unlock()
+		return nil
+	}
+
+	var walk func(dir string, level int) error
+	walk = func(dir string, level int) error {
+		if err := create("create tree directory", func() error { return os.MkdirAll(dir, 0755) }); err != nil {
+			return err
+		}
+		for i := 0; i < spec.Files; i++ {
+			path := filepath.Join(dir, fmt.Sprintf("f%03d", i))
+			content.fill(buffer)
+			if err := create("create tree file", func() error { return os.WriteFile(path, buffer, 0644) }); err != nil {
+				return err
+			}
+		}
+		if level == spec.Depth {
+			return nil
+		}
+		for i := 0; i < spec.Fanout; i++ {
+			if err := walk(filepath.Join(dir, fmt.Sprintf("d%03d", i)), level+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root, 0); err != nil {
+		if rm.ctx.Err() == nil {
+			rm.markDegraded("tree", err)
+		}
+		return
+	}
+	log.Printf("Tree %s complete with %d entries", root, created)
+}
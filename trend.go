@@ -0,0 +1,104 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"time"
+)
+
+// trendSamples is how many status samples the sparklines cover, 2 minutes at
+// the status interval
+const trendSamples = 60
+
+// trendRowSamples is how many of the latest samples each resource shows in
+// the Trend column of the status table
+const trendRowSamples = 8
+
+// trendResources are the resources with sparklines, with their letter in the Trend column
+var trendResources = []struct {
+	name   string
+	letter string
+}{{"cpu", "C"}, {"memory", "M"}, {"file", "F"}}
+
+// sparkBars are the bar heights of sparklines, from empty to the target
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// asciiSparks replaces the sparkline bars with -ascii
+var asciiSparks = strings.NewReplacer("▁", "_", "▂", ".", "▃", ",", "▄", "-", "▅", "~", "▆", "=", "▇", "*", "█", "#")
+
+// trend is a ring buffer of the achieved load of the CPU, memory and file
+// as fractions of their targets, making under-delivery and oscillation
+// visible at a glance
+type trend struct {
+	samples map[string]*[trendSamples]float64
+	next    int
+	count   int
+	lastCPU time.Duration // Process CPU time at the last sample
+	lastAt  time.Time
+}
+
+// observe adds a sample of the achieved load. CPU is measured from the CPU
+// time of the process since the last sample.
+func (t *trend) observe(now time.Time, status ResourceStatus, cpuTarget float64, memoryTargetMB, fileTargetMB int64) {
+	cpuTime := processCPUTime()
+	defer func() { t.lastCPU, t.lastAt = cpuTime, now }()
+	if t.lastAt.IsZero() {
+		return
+	}
+	if t.samples == nil {
+		t.samples = map[string]*[trendSamples]float64{}
+		for _, r := range trendResources {
+			t.samples[r.name] = &[trendSamples]float64{}
+		}
+	}
+	ratio := func(achieved, target float64) float64 {
+		if target <= 0 {
+			return 0
+		}
+		return min(1, achieved/target)
+	}
+	cpu := 100 * float64(cpuTime-t.lastCPU) / float64(now.Sub(t.lastAt)) / float64(runtime.NumCPU())
+	t.samples["cpu"][t.next] = ratio(cpu, cpuTarget)
+	t.samples["memory"][t.next] = ratio(float64(status.MemoryActualMB), float64(memoryTargetMB))
+	t.samples["file"][t.next] = ratio(float64(status.FileActualMB), float64(fileTargetMB))
+	t.next = (t.next + 1) % trendSamples
+	t.count = min(t.count+1, trendSamples)
+}
+
+// sparkline renders the latest n samples of resource, oldest first
+func (t *trend) sparkline(resource string, n int) string {
+	n = min(n, t.count)
+	bars := make([]rune, n)
+	for i := range bars {
+		v := t.samples[resource][(t.next-n+i+trendSamples)%trendSamples]
+		bars[i] = sparkBars[int(v*float64(len(sparkBars)-1)+0.5)]
+	}
+	return string(bars)
+}
+
+// sparklines renders the whole trend of the resources the run consumes
+func (t *trend) sparklines(config *Config) map[string]string {
+	if t.count == 0 {
+		return nil
+	}
+	lines := map[string]string{}
+	for resource, on := range map[string]bool{"cpu": config.CPUPercent > 0, "memory": config.MemoryMB > 0, "file": config.FileSizeMB > 0} {
+		if on {
+			lines[resource] = t.sparkline(resource, trendSamples)
+		}
+	}
+	return lines
+}
+
+// trendCell formats the Trend column of the status table from the
+// sparklines of a status, e.g. "C▅▆▇█▇▆▅▆ M▂▃▄▅▆▇██"
+func trendCell(sparklines map[string]string) string {
+	var parts []string
+	for _, r := range trendResources {
+		if line, ok := sparklines[r.name]; ok {
+			bars := []rune(line)
+			parts = append(parts, r.letter+string(bars[max(0, len(bars)-trendRowSamples):]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,170 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UnixSpec is the number of unix IPC objects -unix-objects holds open, parsed
+// from e.g. sockets=50000,pairs=10000,fifos=20000
+type UnixSpec struct {
+	Sockets int64 // Listening unix sockets bound to paths in the directory
+	Pairs   int64 // Connected pairs of unnamed unix sockets
+	FIFOs   int64 // Named pipes in the directory, each held open
+}
+
+// parseUnixSpec parses -unix-objects
+func parseUnixSpec(s string) (UnixSpec, error) {
+	counts, err := parseCounts("-unix-objects", s, "sockets", "pairs", "fifos")
+	if err != nil {
+		return UnixSpec{}, err
+	}
+	return UnixSpec{Sockets: counts["sockets"], Pairs: counts["pairs"], FIFOs: counts["fifos"]}, nil
+}
+
+// sunPathMax is the size of sun_path in struct sockaddr_un, socket paths
+// must be shorter to leave room for the terminating zero
+const sunPathMax = 108
+
+// unixDir returns the directory -unix-objects creates its sockets and FIFOs
+// in, next to the work file
+func unixDir(filePath string) string {
+	return strings.TrimSuffix(filePath, fileSuffix) + "_unix"
+}
+
+// unixObject is an open IPC object and the path it is bound to, if any
+type unixObject struct {
+	fds  []int
+	path string
+}
+
+// close releases the object and removes its path
+func (o unixObject) close() {
+	for _, fd := range o.fds {
+		syscall.Close(fd)
+	}
+	if o.path != "" {
+		os.Remove(o.path)
+	}
+}
+
+// unixObjectKinds create one object of each -unix-objects kind in dir
+var unixObjectKinds = []struct {
+	name   string
+	count  func(UnixSpec) int64
+	create func(dir string, n int) (unixObject, error)
+}{
+	{"sockets", func(s UnixSpec) int64 { return s.Sockets }, func(dir string, n int) (unixObject, error) {
+		fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|sockCloexec, 0)
+		if err != nil {
+			return unixObject{}, err
+		}
+		object := unixObject{fds: []int{fd}, path: filepath.Join(dir, "s"+strconv.Itoa(n))}
+		if err = syscall.Bind(fd, &syscall.SockaddrUnix{Name: object.path}); err == nil {
+			err = syscall.Listen(fd, 1)
+		}
+		if err != nil {
+			object.close()
+			return unixObject{}, fmt.Errorf("bind %s: %w", object.path, err)
+		}
+		return object, nil
+	}},
+	{"pairs", func(s UnixSpec) int64 { return s.Pairs }, func(dir string, n int) (unixObject, error) {
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|sockCloexec, 0)
+		if err != nil {
+			return unixObject{}, err
+		}
+		return unixObject{fds: fds[:]}, nil
+	}},
+	{"fifos", func(s UnixSpec) int64 { return s.FIFOs }, func(dir string, n int) (unixObject, error) {
+		path := filepath.Join(dir, "f"+strconv.Itoa(n))
+		if err := syscall.Mkfifo(path, 0600); err != nil {
+			return unixObject{}, err
+		}
+		// Opening both ends at once doesn't wait for a peer
+		fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			os.Remove(path)
+			return unixObject{}, err
+		}
+		return unixObject{fds: []int{fd}, path: path}, nil
+	}},
+}
+
+// holdUnixObjects creates the unix sockets, socket pairs and FIFOs of
+// -unix-objects during rampup and holds them open, so tools enumerating /proc,
+// /proc/net/unix and ss face pathological counts. Everything is closed and the
+// directory removed at the end.
+func (rm *ResourceMock) holdUnixObjects() {
+	defer rm.wg.Done()
+	defer nameThread("om-unix")()
+
+	dir := unixDir(rm.filePath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		rm.markDegraded("unix", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	raiseNofile()
+
+	held := make([][]unixObject, len(unixObjectKinds))
+	defer func() {
+		for _, objects := range held {
+			for _, object := range objects {
+				object.close()
+			}
+		}
+	}()
+	next := make([]int, len(unixObjectKinds))    // Names are never reused while the old path may linger
+	failed := make([]bool, len(unixObjectKinds)) // Kinds that stopped, the others keep going
+
+	// A socket path too long for sun_path fails every bind, so don't start on them
+	if longest := filepath.Join(dir, "s"+strconv.Itoa(math.MaxInt32)); rm.config.unixObjects.Sockets > 0 && len(longest) >= sunPathMax {
+		failed[0] = true
+		rm.markDegraded("unix", fmt.Errorf("socket paths in %s are longer than the %d bytes of sun_path, move -fpath to a shorter directory", dir, sunPathMax-1))
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+			scale := rm.rampIntensity("unix")
+
+			for i, kind := range unixObjectKinds {
+				target := int(float64(kind.count(rm.config.unixObjects)) * scale)
+				for len(held[i]) > target {
+					held[i][len(held[i])-1].close()
+					held[i] = held[i][:len(held[i])-1]
+				}
+				for n := 0; len(held[i]) < target && n < kmemBatch && !failed[i]; n++ {
+					object, err := kind.create(dir, next[i])
+					if err != nil {
+						// Usually EMFILE or ENFILE, keep what was created
+						failed[i] = true
+						rm.markDegraded("unix", fmt.Errorf("creating %s stopped at %d: %w", kind.name, len(held[i]), err))
+						break
+					}
+					next[i]++
+					held[i] = append(held[i], object)
+				}
+			}
+
+			rm.statusMu.Lock()
+			rm.resourceStatus.UnixSockets = int64(len(held[0]))
+			rm.resourceStatus.UnixPairs = int64(len(held[1]))
+			rm.resourceStatus.FIFOs = int64(len(held[2]))
+			rm.statusMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+// UnixSpec is parsed from -unix-objects, which needs unix sockets and FIFOs
+type UnixSpec struct{}
+
+// parseUnixSpec rejects -unix-objects, Windows has no FIFOs and no /proc to enumerate them in
+func parseUnixSpec(s string) (UnixSpec, error) {
+	return UnixSpec{}, fmt.Errorf("-unix-objects is not supported on Windows, which has no FIFOs or /proc/net/unix")
+}
+
+// holdUnixObjects is never started, parseUnixSpec rejects -unix-objects
+func (rm *ResourceMock) holdUnixObjects() {
+	defer rm.wg.Done()
+}
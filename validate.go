@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// jsonNode is a parsed JSON value that remembers where it starts in the file
+type jsonNode struct {
+	offset  int          // Byte offset of the value
+	kind    byte         // '{' for objects, '[' for arrays, 0 for scalars
+	value   interface{}  // Scalars: string, json.Number, bool or nil
+	members []jsonMember // Object members in file order
+	items   []*jsonNode  // Array items
+}
+
+// jsonMember is a key of an object and its value
+type jsonMember struct {
+	key    string
+	offset int // Byte offset of the key
+	value  *jsonNode
+}
+
+// validationError is a problem found in a file at a byte offset, -1 for the whole file
+type validationError struct {
+	offset int
+	msg    string
+}
+
+func (e validationError) Error() string {
+	return e.msg
+}
+
+// parseJSONNodes parses data into a tree of positioned nodes
+func parseJSONNodes(data []byte) (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	// Offsets point past the previous token, the value starts after separators
+	start := func() int {
+		i := int(dec.InputOffset())
+		for i < len(data) && bytes.IndexByte([]byte(" \t\r\n,:"), data[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	var parse func() (*jsonNode, error)
+	parse = func() (*jsonNode, error) {
+		node := &jsonNode{offset: start()}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case json.Delim('{'):
+			node.kind = '{'
+			for dec.More() {
+				offset := start()
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := parse()
+				if err != nil {
+					return nil, err
+				}
+				node.members = append(node.members, jsonMember{key: key.(string), offset: offset, value: value})
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			node.kind = '['
+			for dec.More() {
+				item, err := parse()
+				if err != nil {
+					return nil, err
+				}
+				node.items = append(node.items, item)
+			}
+			_, err = dec.Token()
+		default:
+			node.value = tok
+		}
+		return node, err
+	}
+	node, err := parse()
+	if err == nil && start() < len(data) {
+		err = validationError{start(), fmt.Sprintf("invalid character %q after top-level value", data[start()])}
+	}
+	return node, err
+}
+
+// lineCol converts a byte offset of data to a 1-based line and column
+func lineCol(data []byte, offset int) (int, int) {
+	offset = min(offset, len(data))
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	return line, offset - bytes.LastIndexByte(data[:offset], '\n')
+}
+
+// checkSettings checks an object of flag values, reporting unknown keys and
+// invalid values at their position, and returns the values
+func checkSettings(node *jsonNode, distributions bool) (map[string]string, []validationError) {
+	if node.kind != '{' {
+		return nil, []validationError{{node.offset, "settings must be an object of flag values"}}
+	}
+	var config Config
+	fs := flag.NewFlagSet("settings", flag.ContinueOnError)
+	bindFlags(fs, &config)
+
+	var errs []validationError
+	values := map[string]string{}
+	for _, m := range node.members {
+		if _, dup := values[m.key]; dup {
+			errs = append(errs, validationError{m.offset, fmt.Sprintf("duplicate setting %q", m.key)})
+			continue
+		}
+		if fs.Lookup(m.key) == nil {
+			errs = append(errs, validationError{m.offset, fmt.Sprintf("unknown setting %q", m.key)})
+			continue
+		}
+		if m.value.kind != 0 || m.value.value == nil {
+			errs = append(errs, validationError{m.value.offset, fmt.Sprintf("%s must be a string, number or boolean", m.key)})
+			continue
+		}
+		value := fmt.Sprint(m.value.value)
+		if distributions && distributionPattern.MatchString(value) {
+			// Draws are clamped to the setting's range, so any draw checks the distribution
+			drawn, err := sampleSetting(m.key, value, rand.New(rand.NewSource(1)))
+			if err != nil {
+				errs = append(errs, validationError{m.value.offset, fmt.Sprintf("invalid value %q for %s: %v", value, m.key, err)})
+				continue
+			}
+			value = drawn
+		}
+		if err := fs.Set(m.key, value); err != nil {
+			errs = append(errs, validationError{m.value.offset, fmt.Sprintf("invalid value %q for %s: %v", value, m.key, err)})
+			continue
+		}
+		values[m.key] = value
+	}
+	return values, errs
+}
+
+// checkConfigFile checks a -config file, a flat object of flag values
+func checkConfigFile(root *jsonNode) []validationError {
+	values, errs := checkSettings(root, false)
+	if len(errs) == 0 {
+		if _, err := configFromSettings(values); err != nil {
+			errs = append(errs, validationError{root.offset, err.Error()})
+		}
+	}
+	return errs
+}
+
+// checkScenario checks a scenario file, see Scenario
+func checkScenario(path string, root *jsonNode, vars scenarioVars) []validationError {
+	var errs []validationError
+	var shared map[string]string
+	var lanes *jsonNode
+	sharedValid := true
+	for _, m := range root.members {
+		switch m.key {
+		case "settings":
+			var settingsErrs []validationError
+			shared, settingsErrs = checkSettings(m.value, true)
+			errs = append(errs, settingsErrs...)
+			sharedValid = len(settingsErrs) == 0
+		case "lanes":
+			lanes = m.value
+		case "assertions", "guardrails":
+			// Checked by loadScenario below
+		default:
+			errs = append(errs, validationError{m.offset, fmt.Sprintf("unknown scenario key %q (supported: settings, lanes, assertions, guardrails)", m.key)})
+		}
+	}
+	if lanes == nil || lanes.kind != '[' || len(lanes.items) == 0 {
+		offset := root.offset
+		if lanes != nil {
+			offset = lanes.offset
+		}
+		return append(errs, validationError{offset, "lanes must be a non-empty array"})
+	}
+
+	names := map[string]bool{}
+	for i, spec := range lanes.items {
+		if spec.kind != '{' {
+			errs = append(errs, validationError{spec.offset, fmt.Sprintf("lane %d must be an object", i+1)})
+			continue
+		}
+		name, own := "", map[string]string{}
+		at := spec.offset // Conflicts are reported at the lane's settings
+		laneErrs := 0
+		for _, m := range spec.members {
+			switch m.key {
+			case "name", "timeline":
+				s, ok := m.value.value.(string)
+				if !ok {
+					errs = append(errs, validationError{m.value.offset, fmt.Sprintf("%s must be a string", m.key)})
+					laneErrs++
+				} else if m.key == "name" {
+					name = s
+					if names[s] {
+						errs = append(errs, validationError{m.value.offset, fmt.Sprintf("duplicate lane %q", s)})
+						laneErrs++
+					}
+					names[s] = true
+				} else if !filepath.IsAbs(s) {
+					if _, err := os.Stat(filepath.Join(filepath.Dir(path), s)); err != nil {
+						errs = append(errs, validationError{m.value.offset, fmt.Sprintf("timeline: %v", err)})
+						laneErrs++
+					}
+				}
+			case "settings":
+				var settingsErrs []validationError
+				own, settingsErrs = checkSettings(m.value, true)
+				errs = append(errs, settingsErrs...)
+				laneErrs += len(settingsErrs)
+				at = m.value.offset
+			default:
+				errs = append(errs, validationError{m.offset, fmt.Sprintf("unknown lane key %q (supported: name, settings, timeline)", m.key)})
+				laneErrs++
+			}
+		}
+		if name == "" {
+			errs = append(errs, validationError{spec.offset, fmt.Sprintf("lane %d has no name", i+1)})
+			continue
+		}
+		if laneErrs > 0 || !sharedValid {
+			continue
+		}
+
+		values := map[string]string{"fpath": "outagemock_" + name}
+		for _, settings := range []map[string]string{shared, own} {
+			for key, value := range settings {
+				values[key] = value
+			}
+		}
+		if _, err := configFromSettings(values); err != nil {
+			errs = append(errs, validationError{at, fmt.Sprintf("lane %s: %v", name, err)})
+		}
+	}
+
+	// Checks across lanes and of the timelines themselves
+	if len(errs) == 0 {
+		if _, _, err := loadScenario(path, vars, rand.New(rand.NewSource(1))); err != nil {
+			errs = append(errs, validationError{-1, err.Error()})
+		}
+	}
+	return errs
+}
+
+// hasLanes reports whether data is a JSON object with lanes, a scenario
+func hasLanes(data []byte) bool {
+	root, err := parseJSONNodes(data)
+	if err != nil || root.kind != '{' {
+		return false
+	}
+	for _, m := range root.members {
+		if m.key == "lanes" {
+			return true
+		}
+	}
+	return false
+}
+
+// isConfigFile reports whether data is a JSON object without lanes, a -config file
+func isConfigFile(data []byte) bool {
+	root, err := parseJSONNodes(data)
+	return err == nil && root.kind == '{' && !hasLanes(data)
+}
+
+// jsonOnly rejects YAML, which is not read: scenario and -config files are JSON
+func jsonOnly(path string, data []byte) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return fmt.Errorf("YAML is not supported, files are JSON only (convert with e.g. yq -o=json)")
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		return fmt.Errorf("not a JSON object, files are JSON only (convert YAML with e.g. yq -o=json)")
+	}
+	return nil
+}
+
+// validateFile checks a scenario or -config file and writes every problem
+// found as path:line:col: message. It returns the number of problems.
+// Variables of scenarios are substituted first, which shifts the columns
+// after them on the same line; -config files are checked as they are read.
+func validateFile(w io.Writer, path string, vars scenarioVars) int {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = jsonOnly(path, data)
+	}
+	if err == nil {
+		var expanded []byte
+		if expanded, err = expandScenario(data, vars); err == nil && hasLanes(expanded) {
+			data = expanded
+		} else if err != nil && isConfigFile(data) {
+			err = nil
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(w, "%s: %v\n", path, err)
+		return 1
+	}
+
+	var errs []validationError
+	root, err := parseJSONNodes(data)
+	var syntax *json.SyntaxError
+	var trailing validationError
+	switch {
+	case errors.As(err, &syntax):
+		errs = []validationError{{int(syntax.Offset), err.Error()}}
+	case errors.As(err, &trailing):
+		errs = []validationError{trailing}
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		errs = []validationError{{len(data), "unexpected end of file"}}
+	case err != nil:
+		errs = []validationError{{len(data), err.Error()}}
+	case root.kind != '{':
+		errs = []validationError{{root.offset, "expected a JSON object"}}
+	default:
+		isScenario := false
+		for _, m := range root.members {
+			isScenario = isScenario || m.key == "lanes"
+		}
+		if isScenario {
+			errs = checkScenario(path, root, vars)
+		} else {
+			errs = checkConfigFile(root)
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].offset < errs[j].offset })
+	for _, e := range errs {
+		if e.offset < 0 {
+			fmt.Fprintf(w, "%s: %s\n", path, e.msg)
+			continue
+		}
+		line, col := lineCol(data, e.offset)
+		fmt.Fprintf(w, "%s:%d:%d: %s\n", path, line, col, e.msg)
+	}
+	return len(errs)
+}
+
+// settingsSchema describes every flag of Config as a JSON Schema property
+func settingsSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				add(field.Type)
+				continue
+			}
+			name := field.Tag.Get("flag")
+			if name == "" {
+				continue
+			}
+			// Values are parsed like flags, so strings are accepted everywhere
+			property := map[string]interface{}{"description": field.Tag.Get("usage")}
+			switch {
+			case field.Type == reflect.TypeOf(time.Duration(0)):
+				property["type"] = "string"
+			case field.Tag.Get("unit") == "size":
+				property["type"] = []string{"string", "integer"}
+			case field.Type.Kind() == reflect.Bool:
+				property["type"] = []string{"boolean", "string"}
+			case field.Type.Kind() == reflect.String:
+				property["type"] = "string"
+			default:
+				property["type"] = []string{"number", "string"}
+			}
+			if def, ok := field.Tag.Lookup("default"); ok {
+				property["default"] = def
+			}
+			properties[name] = property
+		}
+	}
+	add(reflect.TypeOf(Config{}))
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          "Flag values keyed by flag name, as in -config files",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// scenarioSchema returns the JSON Schema of scenario files; its settings
+// definition also describes -config files
+func scenarioSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "outagemock scenario",
+		"type":                 "object",
+		"required":             []string{"lanes"},
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"settings": map[string]interface{}{"$ref": "#/$defs/settings"},
+			"lanes": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"required":             []string{"name"},
+					"additionalProperties": false,
+					"properties": map[string]interface{}{
+						"name":     map[string]interface{}{"type": "string", "minLength": 1},
+						"settings": map[string]interface{}{"$ref": "#/$defs/settings"},
+						"timeline": map[string]interface{}{"type": "string", "description": "Timeline file relative to the scenario file"},
+					},
+				},
+			},
+			"assertions": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/$defs/check"}},
+			"guardrails": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/$defs/check"}},
+		},
+		"$defs": map[string]interface{}{
+			"settings": settingsSchema(),
+			"check": map[string]interface{}{
+				"type":                 "object",
+				"description":          "Compares a status metric of the lanes with a value, see the test command",
+				"required":             []string{"metric", "op", "value"},
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"name":   map[string]interface{}{"type": "string"},
+					"lane":   map[string]interface{}{"type": "string", "description": "Lane to check, every lane when empty"},
+					"metric": map[string]interface{}{"type": "string", "description": "Status field, nested ones joined with dots like canary.p99_ms"},
+					"op":     map[string]interface{}{"enum": []string{"<", "<=", ">", ">=", "==", "!="}},
+					"value":  map[string]interface{}{"type": "number"},
+					"when":   map[string]interface{}{"enum": []string{"end", "peak", "always"}, "default": "end"},
+				},
+			},
+		},
+	}
+}
+
+// validateCommand checks scenario and config files without running them
+func validateCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	schema := fs.Bool("schema", false, "Print the JSON Schema of scenario files instead; its $defs.settings describes -config files")
+	vars := scenarioVars{}
+	fs.Var(vars, "set", "Set the scenario variable ${NAME}, as NAME=value; repeatable, overrides the environment")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: outagemock validate [flags] FILE...\n\n%s\n\nFiles are JSON only, convert YAML with e.g. yq -o=json.\n\nFlags:\n", cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	if *schema {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(scenarioSchema())
+		return 0
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	problems := 0
+	for _, path := range fs.Args() {
+		problems += validateFile(os.Stderr, path, vars)
+	}
+	if problems > 0 {
+		return exitUsage
+	}
+	fmt.Printf("%d file(s) valid\n", fs.NArg())
+	return 0
+}
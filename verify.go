@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	fileBlockBytes   = 1 << 20 // Granularity of checksums and corruption
+	fileVerifyBlocks = 16      // Blocks read back per -verify-file check
+	fadvDontNeed     = 4       // POSIX_FADV_DONTNEED
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// fileSums holds the checksum of every block of the work file as written, so
+// reading it back can tell silent corruption from the data put there
+type fileSums struct {
+	mu        sync.Mutex
+	sums      []uint32
+	filled    []int64        // Bytes of each block checksummed so far, from its start
+	known     []bool         // Blocks written by this run; an adopted file's blocks are unknown
+	corrupted map[int64]bool // Blocks flipped by -corrupt-file
+}
+
+func newFileSums() *fileSums {
+	return &fileSums{corrupted: map[int64]bool{}}
+}
+
+// record adds data written at offset to the checksums. Writes paced below a
+// block per tick continue the checksum of their block where the last one
+// stopped; the block is known once it is complete. Writes leaving a gap or
+// overwriting part of a block make it unknown. A nil fileSums records nothing.
+func (fs *fileSums) record(offset int64, data []byte) {
+	if fs == nil {
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for pos := int64(0); pos < int64(len(data)); {
+		at := offset + pos
+		block := at / fileBlockBytes
+		start := block * fileBlockBytes
+		end := min(start+fileBlockBytes, offset+int64(len(data)))
+		piece := data[pos : end-offset]
+		fs.grow(block + 1)
+		switch at {
+		case start:
+			fs.sums[block] = crc32.Checksum(piece, castagnoli)
+			fs.filled[block] = int64(len(piece))
+		case start + fs.filled[block]:
+			fs.sums[block] = crc32.Update(fs.sums[block], castagnoli, piece)
+			fs.filled[block] += int64(len(piece))
+		default:
+			fs.filled[block] = 0
+		}
+		fs.known[block] = fs.filled[block] == fileBlockBytes
+		if fs.known[block] {
+			delete(fs.corrupted, block)
+		}
+		pos = end - offset
+	}
+}
+
+// truncate forgets the blocks from size on, a partial last block included
+func (fs *fileSums) truncate(size int64) {
+	if fs == nil {
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	blocks := size / fileBlockBytes
+	if blocks < int64(len(fs.sums)) {
+		fs.sums, fs.filled, fs.known = fs.sums[:blocks], fs.filled[:blocks], fs.known[:blocks]
+	}
+	for block := range fs.corrupted {
+		if block >= blocks {
+			delete(fs.corrupted, block)
+		}
+	}
+}
+
+func (fs *fileSums) grow(blocks int64) {
+	for int64(len(fs.sums)) < blocks {
+		fs.sums = append(fs.sums, 0)
+		fs.filled = append(fs.filled, 0)
+		fs.known = append(fs.known, false)
+	}
+}
+
+// knownBlocks returns the blocks with a checksum
+func (fs *fileSums) knownBlocks() []int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var blocks []int64
+	for block, known := range fs.known {
+		if known {
+			blocks = append(blocks, int64(block))
+		}
+	}
+	return blocks
+}
+
+// sum returns the checksum of a block and whether it is known
+func (fs *fileSums) sum(block int64) (uint32, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if block >= int64(len(fs.sums)) || !fs.known[block] {
+		return 0, false
+	}
+	return fs.sums[block], true
+}
+
+// verifyFile reads back a random run of blocks of the work file every
+// -verify-file and compares their checksums with what was written
+func (rm *ResourceMock) verifyFile() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(rm.config.VerifyFile)
+	defer ticker.Stop()
+	buf := make([]byte, fileBlockBytes)
+	found := map[int64]bool{} // Injected corruption already reported
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-rm.ctx.Done():
+			status := rm.Status()
+			log.Printf("Verified %d MB of %s, %d mismatched", status.FileVerifiedMB, rm.filePath, status.FileMismatches)
+			return
+		case <-ticker.C:
+		}
+		blocks := rm.fileSums.knownBlocks()
+		if len(blocks) == 0 {
+			continue
+		}
+		if file == nil {
+			var err error
+			if file, err = os.Open(rm.filePath); err != nil {
+				log.Printf("Failed to open file for verification: %v", err)
+				continue
+			}
+		}
+
+		first := rand.Intn(len(blocks))
+		for _, block := range blocks[first:min(first+fileVerifyBlocks, len(blocks))] {
+			want, ok := rm.fileSums.sum(block)
+			if !ok {
+				continue
+			}
+			offset := block * fileBlockBytes
+			dropCache(file, offset, fileBlockBytes)
+			if _, err := file.ReadAt(buf, offset); err != nil {
+				if err != io.EOF {
+					log.Printf("Failed to read back file at %d: %v", offset, err)
+				}
+				continue
+			}
+			got := crc32.Checksum(buf, castagnoli)
+			// The block may have been cut off and rewritten while it was read
+			if current, ok := rm.fileSums.sum(block); !ok || current != want {
+				continue
+			}
+
+			rm.statusMu.Lock()
+			rm.resourceStatus.FileVerifiedMB++
+			if got != want {
+				rm.resourceStatus.FileMismatches++
+			}
+			rm.statusMu.Unlock()
+			if got != want {
+				rm.fileSums.mu.Lock()
+				injected := rm.fileSums.corrupted[block]
+				rm.fileSums.mu.Unlock()
+				if injected && !found[block] {
+					log.Printf("Read back the corruption injected into the block at offset %d", offset)
+					found[block] = true
+				} else if !injected {
+					log.Printf("Silent corruption detected: block at offset %d has checksum %08x, wrote %08x", offset, got, want)
+				}
+			}
+		}
+	}
+}
+
+// corruptFile flips one byte in -corrupt-file of the written blocks once
+// rampup is over, leaving the checksums as written so -verify-file notices
+func (rm *ResourceMock) corruptFile() {
+	defer rm.wg.Done()
+
+	if !sleepCtx(rm.ctx, rm.clock.Wall(-rm.clock.Since(rm.rampupStart.Add(rm.config.RampupTime)))) {
+		return
+	}
+	blocks := rm.fileSums.knownBlocks()
+	count := int(float64(len(blocks))*rm.config.corruptFile + 0.5)
+	if count == 0 && len(blocks) > 0 {
+		count = 1
+	}
+	file, err := os.OpenFile(rm.filePath, os.O_RDWR, 0)
+	if err != nil {
+		rm.markDegraded("corrupt-file", fmt.Errorf("open file for corruption: %w", err))
+		return
+	}
+	defer file.Close()
+
+	corrupted := 0
+	for _, i := range rand.Perm(len(blocks))[:count] {
+		offset := blocks[i]*fileBlockBytes + rand.Int63n(fileBlockBytes)
+		if err := flipByte(file, offset); err != nil {
+			log.Printf("Failed to corrupt file at %d: %v", offset, err)
+			continue
+		}
+		rm.fileSums.mu.Lock()
+		rm.fileSums.corrupted[blocks[i]] = true
+		rm.fileSums.mu.Unlock()
+		corrupted++
+	}
+	file.Sync()
+	rm.statusMu.Lock()
+	rm.resourceStatus.FileCorrupted = int64(corrupted)
+	rm.statusMu.Unlock()
+	log.Printf("Corrupted %d of %d MB blocks of %s", corrupted, len(blocks), rm.filePath)
+}
+
+// flipByte inverts the byte at offset
+func flipByte(file *os.File, offset int64) error {
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		return err
+	}
+	b[0] ^= 0xff
+	if _, err := file.WriteAt(b, offset); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"outagemock/consumer"
+)
+
+// version is the release of the binary, set with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// buildInfo describes how the binary was built
+type buildInfo struct {
+	Version    string   `json:"version"`
+	Commit     string   `json:"commit,omitempty"`
+	CommitTime string   `json:"commit_time,omitempty"`
+	Modified   bool     `json:"modified,omitempty"` // Built from a tree with uncommitted changes
+	GoVersion  string   `json:"go_version"`
+	Platform   string   `json:"platform"`       // GOOS/GOARCH
+	Tags       []string `json:"tags,omitempty"` // Build tags, e.g. gpu
+}
+
+// readBuildInfo returns the version and the VCS and build settings the Go
+// toolchain recorded in the binary
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = s.Value
+		case "vcs.time":
+			b.CommitTime = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		case "-tags":
+			b.Tags = strings.Split(s.Value, ",")
+		}
+	}
+	return b
+}
+
+// linuxCapabilities are the capabilities some faults need, by bit
+var linuxCapabilities = []struct {
+	bit  uint
+	name string
+}{
+	{5, "CAP_KILL"},                // -signal-storm on processes of other users
+	{capNetAdmin, "CAP_NET_ADMIN"}, // tc and SO_BINDTODEVICE of -net-bind-iface
+	{14, "CAP_IPC_LOCK"},           // -mlock beyond RLIMIT_MEMLOCK
+	{21, "CAP_SYS_ADMIN"},          // -unshare, -netns and -fuse-mount
+	{24, "CAP_SYS_RESOURCE"},       // Raising RLIMIT_NOFILE for -kmem and -unix-objects
+}
+
+// builtinConsumerNames are the loads every build runs as consumer.Consumer
+var builtinConsumerNames = []string{"cpu", "memory", "file"}
+
+// loadModes are the flags that start a load or a fault besides the built-in consumers
+var loadModes = []string{
+	"clock-skew", "conn-count", "container-faults", "corrupt-file", "cow-fork", "dm-device", "emulate",
+	"fault-rate", "fill-all-mounts", "follow-query", "freeze-cgroup", "fuse-mount", "governor", "inject-log",
+	"io-throttle", "kmem", "llc-thrash", "loadavg", "log-flood", "markov", "memory-high", "psi", "runqueue",
+	"s3-endpoint", "signal-storm", "softirq", "thermal", "tlb-pressure", "tree", "unix-objects",
+}
+
+// capabilitiesReport tells orchestrators what the binary and the host
+// support, so they can check before dispatching a scenario
+type capabilitiesReport struct {
+	Build        buildInfo          `json:"build"`
+	Commands     []string           `json:"commands"`
+	Builtin      []string           `json:"builtin_consumers"` // Loads of -cpu, -memory and -fsize
+	Consumers    []string           `json:"consumers"`         // Registered -consumer names, gpu when built with -tags gpu
+	LoadModes    []string           `json:"load_modes"`        // Flags of the other loads and faults
+	CPUWorkloads []string           `json:"cpu_workloads"`     // Values of -cpu-workload
+	Host         string             `json:"host"`
+	Cores        int                `json:"cores"`
+	UID          int                `json:"uid"`
+	Privileges   []string           `json:"privileges"`   // Linux capabilities of linuxCapabilities this process holds
+	Capabilities []capabilityResult `json:"capabilities"` // Mechanisms usable on this host, as checked by selftest
+}
+
+// probeHost returns the build, the consumers and load modes, and the
+// privileges and mechanisms available on this host
+func probeHost(cgroupRoot string) capabilitiesReport {
+	host, _ := os.Hostname()
+	report := capabilitiesReport{
+		Build:        readBuildInfo(),
+		Commands:     commandNames(),
+		Builtin:      builtinConsumerNames,
+		Consumers:    consumer.Names(),
+		LoadModes:    loadModes,
+		CPUWorkloads: cpuWorkloadNames(),
+		Host:         host,
+		Cores:        runtime.NumCPU(),
+		UID:          os.Geteuid(),
+		Privileges:   []string{},
+		Capabilities: probeCapabilities(cgroupRoot),
+	}
+	for _, c := range linuxCapabilities {
+		if hasCapability(c.bit) {
+			report.Privileges = append(report.Privileges, c.name)
+		}
+	}
+	return report
+}
+
+// print writes the report for people
+func (r capabilitiesReport) print(w io.Writer) {
+	b := r.Build
+	fmt.Fprintf(w, "outagemock %s %s %s", b.Version, b.GoVersion, b.Platform)
+	if b.Commit != "" {
+		fmt.Fprintf(w, " commit %.12s", b.Commit)
+		if b.Modified {
+			fmt.Fprint(w, " (modified)")
+		}
+	}
+	if len(b.Tags) > 0 {
+		fmt.Fprintf(w, " tags %s", strings.Join(b.Tags, ","))
+	}
+	fmt.Fprintln(w)
+	registered := strings.Join(r.Consumers, ", ")
+	if registered == "" {
+		registered = "none"
+	}
+	fmt.Fprintf(w, "Consumers: %s, -consumer: %s\n", strings.Join(r.Builtin, ", "), registered)
+	fmt.Fprintf(w, "Load modes: %s\n", strings.Join(r.LoadModes, ", "))
+	fmt.Fprintf(w, "CPU workloads: %s\n", strings.Join(r.CPUWorkloads, ", "))
+	fmt.Fprintf(w, "Host: %s (%d cores), uid %d, privileges: %s\n", r.Host, r.Cores, r.UID, strings.Join(r.Privileges, ", "))
+	printCapabilities(w, r.Capabilities)
+}
+
+// versionCommand prints the version of the binary and what it can do on this host
+func versionCommand(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	asJSON := fs.Bool("json", false, "Print the build, consumers and host capabilities as JSON, as GET /capabilities of the agent does")
+	cgroupRoot := fs.String("cgroup-root", cgroupMount, "cgroup v2 directory to check creating cgroups in")
+	if err := fs.Parse(args); err != nil {
+		return exitCodeFor(err)
+	}
+	report := probeHost(*cgroupRoot)
+	if *asJSON {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return 0
+	}
+	report.print(os.Stdout)
+	return 0
+}
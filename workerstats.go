@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clockTicksPerSecond = 100 // USER_HZ used by /proc/*/stat
+	workerUnderRatio    = 0.8 // Share of the target below which a worker under-delivers
+	workerUnderSamples  = 3   // Consecutive low samples before a worker is flagged
+)
+
+// workerStats tracks the CPU time achieved by every CPU worker thread
+type workerStats struct {
+	mu       sync.Mutex
+	tids     []int           // OS thread of each worker, 0 until it started
+	prev     []time.Duration // CPU time of each worker at the last sample
+	low      []int           // Consecutive samples each worker stayed below target
+	sumPct   []float64       // Sum of sampled utilization, for the report
+	samples  int
+	flagged  map[int]bool
+	lastTime time.Time
+}
+
+// newWorkerStats creates stats for n workers
+func newWorkerStats(n int) *workerStats {
+	return &workerStats{
+		tids:    make([]int, n),
+		prev:    make([]time.Duration, n),
+		low:     make([]int, n),
+		sumPct:  make([]float64, n),
+		flagged: make(map[int]bool),
+	}
+}
+
+// register records the OS thread a worker is locked to
+func (ws *workerStats) register(worker, tid int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.tids[worker] = tid
+	ws.prev[worker], _ = threadCPUTime(tid)
+}
+
+// threadCPUTime returns the user and system CPU time of a thread of this process
+func threadCPUTime(tid int) (time.Duration, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/self/task/%d/stat", tid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces, fields are counted after its closing parenthesis
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("short stat for thread %d", tid)
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(utime+stime) * time.Second / clockTicksPerSecond, nil
+}
+
+// sample returns the utilization of every worker since the last sample in percent of
+// one core, and flags workers that under-deliver their target for several samples in a row
+func (ws *workerStats) sample(now time.Time, targets []float64) []float64 {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	elapsed := now.Sub(ws.lastTime)
+	first := ws.lastTime.IsZero()
+	ws.lastTime = now
+	utilization := make([]float64, len(ws.tids))
+	for i, tid := range ws.tids {
+		if tid == 0 {
+			continue
+		}
+		cpu, err := threadCPUTime(tid)
+		if err != nil {
+			continue
+		}
+		delta := cpu - ws.prev[i]
+		ws.prev[i] = cpu
+		if first {
+			continue
+		}
+		utilization[i] = 100 * float64(delta) / float64(elapsed)
+		ws.sumPct[i] += utilization[i]
+
+		target := 0.0
+		if i < len(targets) {
+			target = targets[i]
+		}
+		if target > 0 && utilization[i] < target*workerUnderRatio {
+			ws.low[i]++
+		} else {
+			ws.low[i] = 0
+		}
+		if ws.low[i] >= workerUnderSamples && !ws.flagged[i] {
+			ws.flagged[i] = true
+			log.Printf("CPU worker %d under-delivers: %.1f%% of a core for a %.1f%% target", i, utilization[i], target)
+		}
+	}
+	if !first {
+		ws.samples++
+	}
+	return utilization
+}
+
+// underDelivering returns the workers flagged so far
+func (ws *workerStats) underDelivering() []int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	var workers []int
+	for i := range ws.tids {
+		if ws.flagged[i] {
+			workers = append(workers, i)
+		}
+	}
+	return workers
+}
+
+// trackWorkerCPU samples the CPU time of every worker thread into the resource status
+func (rm *ResourceMock) trackWorkerCPU() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	rm.workerStats.sample(time.Now(), nil)
+
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			utilization := rm.workerStats.sample(now, rm.cpuPool.workerShares(rm.getCurrentCPUUsage()))
+			under := rm.workerStats.underDelivering()
+			rm.statusMu.Lock()
+			rm.resourceStatus.WorkerCPU = utilization
+			rm.resourceStatus.UnderDelivering = under
+			rm.statusMu.Unlock()
+		}
+	}
+}
+
+// printWorkerReport prints the average utilization of every CPU worker
+func (rm *ResourceMock) printWorkerReport() {
+	ws := rm.workerStats
+	if ws == nil {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.samples == 0 {
+		return
+	}
+	textf("CPU workers (average %% of one core):")
+	for i, sum := range ws.sumPct {
+		mark := ""
+		if ws.flagged[i] {
+			mark = "  under-delivering"
+		}
+		textf("  worker %-3d %6.1f%%%s\n", i, sum/float64(ws.samples), mark)
+	}
+}